@@ -5,7 +5,6 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"regexp"
 	"time"
 
@@ -13,7 +12,6 @@ import (
 	"github.com/jmoiron/sqlx"
 
 	"github.com/gabrieleangeletti/stride"
-	"github.com/gabrieleangeletti/stride/strava"
 	"github.com/gabrieleangeletti/vo2/database"
 	"github.com/gabrieleangeletti/vo2/internal/generated/models"
 	"github.com/gabrieleangeletti/vo2/provider"
@@ -39,77 +37,7 @@ type ProviderActivityRawData struct {
 	DeletedAt           sql.NullTime    `json:"deletedAt" db:"deleted_at"`
 }
 
-func (a *ProviderActivityRawData) ToEnduranceActivity(prov stride.Provider) (*EnduranceActivity, error) {
-	switch prov {
-	case "strava":
-		var act strava.ActivityDetailed
-		err := json.Unmarshal(a.Data, &act)
-		if err != nil {
-			return nil, err
-		}
-
-		isEndurance, err := act.IsEnduranceActivity()
-		if err != nil {
-			return nil, err
-		}
-
-		if !isEndurance {
-			return nil, stride.ErrActivityIsNotEndurance
-		}
-
-		sport, err := act.Sport()
-		if err != nil {
-			return nil, err
-		}
-
-		var utcOffset *int32
-		if a.UTCOffset.Valid {
-			utcOffset = &a.UTCOffset.Int32
-		}
-
-		var elevGain *int32
-		if act.TotalElevationGain > 0 {
-			gain := int32(act.TotalElevationGain)
-			elevGain = &gain
-		}
-
-		enduranceActivity := &EnduranceActivity{
-			ProviderID:            a.ProviderID,
-			AthleteID:             a.AthleteID,
-			ProviderRawActivityID: a.ID,
-			Name:                  act.Name,
-			Description:           act.Description,
-			Sport:                 sport,
-			StartTime:             a.StartTime,
-			EndTime:               a.StartTime.Add(time.Duration(a.ElapsedTime) * time.Second),
-			IanaTimezone:          a.IanaTimezone.String,
-			UTCOffset:             utcOffset,
-			ElapsedTime:           act.ElapsedTime,
-			MovingTime:            act.MovingTime,
-			Distance:              int(act.Distance),
-			AvgSpeed:              act.AverageSpeed,
-			ElevGain:              elevGain,
-		}
-
-		summaryPolyline := act.SummaryPolyline()
-		if summaryPolyline != "" {
-			enduranceActivity.SummaryPolyline = summaryPolyline
-
-			wkt, err := stride.PolylineToWKT(summaryPolyline)
-			if err != nil {
-				return nil, err
-			}
-
-			enduranceActivity.SummaryRoute = wkt
-		}
-
-		return enduranceActivity, nil
-	default:
-		return nil, fmt.Errorf("%w: %d", provider.ErrUnsupportedProvider, a.ProviderID)
-	}
-}
-
-func (a *ProviderActivityRawData) Save(ctx context.Context, db *sqlx.DB) error {
+func (a *ProviderActivityRawData) Save(ctx context.Context, db sqlx.ExecerContext) error {
 	_, err := db.ExecContext(ctx, `
 	INSERT INTO vo2.provider_activity_raw_data
 		(provider_id, athlete_id, provider_activity_id, start_time, elapsed_time, iana_timezone, utc_offset, data, detailed_activity_uri)
@@ -123,7 +51,8 @@ func (a *ProviderActivityRawData) Save(ctx context.Context, db *sqlx.DB) error {
 		iana_timezone = $6,
 		utc_offset = $7,
 		data = $8,
-		detailed_activity_uri = $9
+		detailed_activity_uri = $9,
+		deleted_at = NULL
 	`,
 		a.ProviderID, a.AthleteID, a.ProviderActivityID, a.StartTime, a.ElapsedTime, a.IanaTimezone, a.UTCOffset, a.Data, a.DetailedActivityURI,
 	)
@@ -187,6 +116,7 @@ type EnduranceActivity struct {
 	SummaryRoute          string       `json:"summaryRoute,omitzero"`
 	GpxFileURI            string       `json:"gpxFileURI,omitzero"`
 	FitFileURI            string       `json:"fitFileURI,omitzero"`
+	TimeseriesURI         string       `json:"timeseriesURI,omitzero"`
 	CreatedAt             time.Time    `json:"createdAt"`
 	UpdatedAt             time.Time    `json:"updatedAt,omitzero"`
 	DeletedAt             time.Time    `json:"deletedAt,omitzero"`
@@ -240,6 +170,7 @@ func NewEnduranceActivity(a models.Vo2ActivitiesEndurance) *EnduranceActivity {
 		SummaryRoute:          summaryRoute,
 		GpxFileURI:            a.GpxFileUri.String,
 		FitFileURI:            a.FitFileUri.String,
+		TimeseriesURI:         a.TimeseriesUri.String,
 	}
 }
 
@@ -285,8 +216,9 @@ func (a *EnduranceActivity) ToUpsertParams() models.UpsertActivityEnduranceParam
 		MaxHr:                 sql.NullInt32{Int32: int32(a.MaxHR), Valid: true},
 		SummaryPolyline:       sql.NullString{String: a.SummaryPolyline, Valid: true},
 		SummaryRoute:          a.SummaryRoute,
-		GpxFileUri:            sql.NullString{String: a.GpxFileURI, Valid: true},
-		FitFileUri:            sql.NullString{String: a.FitFileURI, Valid: true},
+		GpxFileUri:            database.ToNullString(a.GpxFileURI),
+		FitFileUri:            database.ToNullString(a.FitFileURI),
+		TimeseriesUri:         database.ToNullString(a.TimeseriesURI),
 	}
 }
 
@@ -319,3 +251,40 @@ func NewActivityThresholdAnalysis(a models.Vo2ActivitiesThresholdAnalysis) *Thre
 		RawAnalysis:         a.RawAnalysis,
 	}
 }
+
+// AnalyzeThresholds computes the time spent at the LT1 and LT2 heart rate thresholds during the activity.
+// Activities without track points get an empty analysis, so that they are marked as processed.
+func AnalyzeThresholds(act *EnduranceActivity, ts *stride.ActivityTimeseries, lt1, lt2 uint8) (*ThresholdAnalysis, error) {
+	if ts == nil || len(ts.Data) == 0 {
+		return &ThresholdAnalysis{
+			ActivityEnduranceID: act.ID,
+			RawAnalysis:         []byte("{}"),
+		}, nil
+	}
+
+	result, err := stride.AnalyzeHeartRateThresholds(ts, stride.HRThresholdAnalysisConfig{
+		LT1:                        lt1,
+		LT2:                        lt2,
+		BucketSizeSeconds:          40,
+		MinValidPointsPerBucket:    5,
+		ThresholdTolerancePercent:  0.05,
+		LT1OverlapTolerancePercent: 0.05,
+		MinConsecutiveBuckets:      6,
+		ConsecutivePeriodThreshold: 0.80,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rawAnalysis, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ThresholdAnalysis{
+		ActivityEnduranceID: act.ID,
+		TimeAtLt1Threshold:  int32(result.TimeAtLT1Seconds),
+		TimeAtLt2Threshold:  int32(result.TimeAtLT2Seconds),
+		RawAnalysis:         rawAnalysis,
+	}, nil
+}
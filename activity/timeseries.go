@@ -0,0 +1,286 @@
+package activity
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/gabrieleangeletti/stride"
+)
+
+var (
+	ErrInvalidTimeseriesData = errors.New("invalid timeseries data")
+)
+
+const (
+	timeseriesMagic   = "VO2T"
+	timeseriesVersion = 1
+
+	// Latitude and longitude are stored as fixed point integers with 7 decimal digits (~1cm precision).
+	latLngScale = 1e7
+)
+
+// EncodeTimeseries encodes an activity timeseries into the compact vo2 timeseries format.
+//
+// The format is a 4 bytes magic ("VO2T") and a version byte, followed by a gzip compressed, column oriented payload:
+//
+//   - start time, as unix nanoseconds (zigzag varint)
+//   - number of entries (uvarint)
+//   - offsets, delta encoded (zigzag varints)
+//   - one column per stream (heart rate, cadence, distance, altitude, velocity, latitude, longitude), each made of
+//     a presence bitmap followed by the delta encoded values (zigzag varints) of the entries where the stream is set.
+func EncodeTimeseries(ts *stride.ActivityTimeseries) ([]byte, error) {
+	n := len(ts.Data)
+
+	payload := make([]byte, 0, 16+n*8)
+	payload = binary.AppendVarint(payload, ts.StartTime.UnixNano())
+	payload = binary.AppendUvarint(payload, uint64(n))
+
+	var prevOffset int64
+	for _, entry := range ts.Data {
+		payload = binary.AppendVarint(payload, int64(entry.Offset)-prevOffset)
+		prevOffset = int64(entry.Offset)
+	}
+
+	for _, column := range timeseriesColumns {
+		payload = column.encode(payload, ts.Data)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(timeseriesMagic)
+	buf.WriteByte(timeseriesVersion)
+
+	zw := gzip.NewWriter(&buf)
+
+	_, err := zw.Write(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DecodeTimeseries decodes an activity timeseries encoded with EncodeTimeseries.
+func DecodeTimeseries(data []byte) (*stride.ActivityTimeseries, error) {
+	if len(data) < len(timeseriesMagic)+1 || string(data[:len(timeseriesMagic)]) != timeseriesMagic {
+		return nil, fmt.Errorf("%w: missing header", ErrInvalidTimeseriesData)
+	}
+
+	version := data[len(timeseriesMagic)]
+	if version != timeseriesVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidTimeseriesData, version)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data[len(timeseriesMagic)+1:]))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTimeseriesData, err)
+	}
+	defer zr.Close()
+
+	payload, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTimeseriesData, err)
+	}
+
+	r := &timeseriesReader{buf: payload}
+
+	startTime := r.varint()
+	n := r.uvarint()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	// Every entry takes at least one byte for its offset, which bounds the allocation below.
+	if n > uint64(len(payload)) {
+		return nil, fmt.Errorf("%w: too many entries", ErrInvalidTimeseriesData)
+	}
+
+	ts := &stride.ActivityTimeseries{
+		StartTime: time.Unix(0, startTime).UTC(),
+		Data:      make([]stride.ActivityTimeseriesEntry, n),
+	}
+
+	var offset int64
+	for i := range ts.Data {
+		offset += r.varint()
+		ts.Data[i].Offset = int(offset)
+	}
+
+	for _, column := range timeseriesColumns {
+		column.decode(r, ts.Data)
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return ts, nil
+}
+
+type timeseriesColumn struct {
+	get func(e *stride.ActivityTimeseriesEntry) (int64, bool)
+	set func(e *stride.ActivityTimeseriesEntry, v int64)
+}
+
+var timeseriesColumns = []timeseriesColumn{
+	{
+		get: func(e *stride.ActivityTimeseriesEntry) (int64, bool) {
+			return int64(e.HeartRate.Value), e.HeartRate.Valid
+		},
+		set: func(e *stride.ActivityTimeseriesEntry, v int64) {
+			e.HeartRate = stride.Optional[uint8]{Value: uint8(v), Valid: true}
+		},
+	},
+	{
+		get: func(e *stride.ActivityTimeseriesEntry) (int64, bool) {
+			return int64(e.Cadence.Value), e.Cadence.Valid
+		},
+		set: func(e *stride.ActivityTimeseriesEntry, v int64) {
+			e.Cadence = stride.Optional[uint8]{Value: uint8(v), Valid: true}
+		},
+	},
+	{
+		get: func(e *stride.ActivityTimeseriesEntry) (int64, bool) {
+			return int64(e.Distance.Value), e.Distance.Valid
+		},
+		set: func(e *stride.ActivityTimeseriesEntry, v int64) {
+			e.Distance = stride.Optional[uint32]{Value: uint32(v), Valid: true}
+		},
+	},
+	{
+		get: func(e *stride.ActivityTimeseriesEntry) (int64, bool) {
+			return int64(e.Altitude.Value), e.Altitude.Valid
+		},
+		set: func(e *stride.ActivityTimeseriesEntry, v int64) {
+			e.Altitude = stride.Optional[uint16]{Value: uint16(v), Valid: true}
+		},
+	},
+	{
+		get: func(e *stride.ActivityTimeseriesEntry) (int64, bool) {
+			return int64(e.Velocity.Value), e.Velocity.Valid
+		},
+		set: func(e *stride.ActivityTimeseriesEntry, v int64) {
+			e.Velocity = stride.Optional[uint16]{Value: uint16(v), Valid: true}
+		},
+	},
+	{
+		get: func(e *stride.ActivityTimeseriesEntry) (int64, bool) {
+			return int64(math.Round(e.Latitude.Value * latLngScale)), e.Latitude.Valid
+		},
+		set: func(e *stride.ActivityTimeseriesEntry, v int64) {
+			e.Latitude = stride.Optional[float64]{Value: float64(v) / latLngScale, Valid: true}
+		},
+	},
+	{
+		get: func(e *stride.ActivityTimeseriesEntry) (int64, bool) {
+			return int64(math.Round(e.Longitude.Value * latLngScale)), e.Longitude.Valid
+		},
+		set: func(e *stride.ActivityTimeseriesEntry, v int64) {
+			e.Longitude = stride.Optional[float64]{Value: float64(v) / latLngScale, Valid: true}
+		},
+	},
+}
+
+func (c timeseriesColumn) encode(buf []byte, data []stride.ActivityTimeseriesEntry) []byte {
+	bitmap := make([]byte, (len(data)+7)/8)
+	values := make([]byte, 0, len(data))
+
+	var prev int64
+	for i := range data {
+		v, ok := c.get(&data[i])
+		if !ok {
+			continue
+		}
+
+		bitmap[i/8] |= 1 << (i % 8)
+		values = binary.AppendVarint(values, v-prev)
+		prev = v
+	}
+
+	buf = append(buf, bitmap...)
+	buf = append(buf, values...)
+
+	return buf
+}
+
+func (c timeseriesColumn) decode(r *timeseriesReader, data []stride.ActivityTimeseriesEntry) {
+	bitmap := r.bytes((len(data) + 7) / 8)
+	if r.err != nil {
+		return
+	}
+
+	var prev int64
+	for i := range data {
+		if bitmap[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+
+		prev += r.varint()
+		c.set(&data[i], prev)
+	}
+}
+
+// timeseriesReader reads varints from a buffer, recording the first error encountered.
+type timeseriesReader struct {
+	buf []byte
+	err error
+}
+
+func (r *timeseriesReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.err = fmt.Errorf("%w: truncated payload", ErrInvalidTimeseriesData)
+		return 0
+	}
+
+	r.buf = r.buf[n:]
+
+	return v
+}
+
+func (r *timeseriesReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = fmt.Errorf("%w: truncated payload", ErrInvalidTimeseriesData)
+		return 0
+	}
+
+	r.buf = r.buf[n:]
+
+	return v
+}
+
+func (r *timeseriesReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if len(r.buf) < n {
+		r.err = fmt.Errorf("%w: truncated payload", ErrInvalidTimeseriesData)
+		return nil
+	}
+
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+
+	return b
+}
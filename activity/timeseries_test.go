@@ -0,0 +1,217 @@
+package activity
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gabrieleangeletti/stride"
+)
+
+func testTimeseries() *stride.ActivityTimeseries {
+	return &stride.ActivityTimeseries{
+		StartTime: time.Date(2025, 6, 1, 7, 30, 0, 0, time.UTC),
+		Data: []stride.ActivityTimeseriesEntry{
+			{
+				Offset:    0,
+				HeartRate: stride.Optional[uint8]{Value: 92, Valid: true},
+				Cadence:   stride.Optional[uint8]{Value: 80, Valid: true},
+				Distance:  stride.Optional[uint32]{Value: 0, Valid: true},
+				Altitude:  stride.Optional[uint16]{Value: 122, Valid: true},
+				Velocity:  stride.Optional[uint16]{Value: 0, Valid: true},
+				Latitude:  stride.Optional[float64]{Value: 45.4642035, Valid: true},
+				Longitude: stride.Optional[float64]{Value: 9.1899820, Valid: true},
+			},
+			{
+				Offset:    1,
+				HeartRate: stride.Optional[uint8]{Value: 95, Valid: true},
+				Distance:  stride.Optional[uint32]{Value: 3, Valid: true},
+				Altitude:  stride.Optional[uint16]{Value: 121, Valid: true},
+				Velocity:  stride.Optional[uint16]{Value: 2850, Valid: true},
+				Latitude:  stride.Optional[float64]{Value: 45.4642301, Valid: true},
+				Longitude: stride.Optional[float64]{Value: 9.1899511, Valid: true},
+			},
+			// A pause: no stream is set.
+			{Offset: 5},
+			// The offsets may go backwards, e.g. when the device clock is adjusted.
+			{
+				Offset:    3,
+				HeartRate: stride.Optional[uint8]{Value: 88, Valid: true},
+				Distance:  stride.Optional[uint32]{Value: 10, Valid: true},
+			},
+			{
+				Offset:    4,
+				HeartRate: stride.Optional[uint8]{Value: 255, Valid: true},
+				Cadence:   stride.Optional[uint8]{Value: 0, Valid: true},
+				Distance:  stride.Optional[uint32]{Value: 42195, Valid: true},
+				Altitude:  stride.Optional[uint16]{Value: 65535, Valid: true},
+				Latitude:  stride.Optional[float64]{Value: -33.8688197, Valid: true},
+				Longitude: stride.Optional[float64]{Value: -151.2092955, Valid: true},
+			},
+		},
+	}
+}
+
+// gzipTimeseriesPayload returns a payload with a valid header and gzip stream, to test the payload decoding.
+func gzipTimeseriesPayload(t *testing.T, payload []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	buf.WriteString(timeseriesMagic)
+	buf.WriteByte(timeseriesVersion)
+
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(payload); err != nil {
+		t.Fatalf("failed to compress payload: %v", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to compress payload: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestTimeseriesRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ts   *stride.ActivityTimeseries
+	}{
+		{name: "all streams", ts: testTimeseries()},
+		{
+			name: "empty",
+			ts: &stride.ActivityTimeseries{
+				StartTime: time.Date(2025, 6, 1, 7, 30, 0, 0, time.UTC),
+				Data:      []stride.ActivityTimeseriesEntry{},
+			},
+		},
+		{
+			name: "heart rate only",
+			ts: &stride.ActivityTimeseries{
+				StartTime: time.Date(2025, 6, 1, 7, 30, 0, 0, time.UTC),
+				Data: []stride.ActivityTimeseriesEntry{
+					{Offset: 0, HeartRate: stride.Optional[uint8]{Value: 120, Valid: true}},
+					{Offset: 1, HeartRate: stride.Optional[uint8]{Value: 118, Valid: true}},
+					{Offset: 2},
+					{Offset: 3, HeartRate: stride.Optional[uint8]{Value: 130, Valid: true}},
+				},
+			},
+		},
+		{
+			// More entries than a bitmap byte, with the streams set in different entries.
+			name: "sparse streams",
+			ts: func() *stride.ActivityTimeseries {
+				ts := &stride.ActivityTimeseries{StartTime: time.Date(2025, 6, 1, 7, 30, 0, 0, time.UTC)}
+
+				for i := range 20 {
+					entry := stride.ActivityTimeseriesEntry{Offset: i}
+
+					if i%3 == 0 {
+						entry.Cadence = stride.Optional[uint8]{Value: uint8(80 + i), Valid: true}
+					}
+
+					if i%7 == 0 {
+						entry.Velocity = stride.Optional[uint16]{Value: uint16(3000 - i*10), Valid: true}
+					}
+
+					ts.Data = append(ts.Data, entry)
+				}
+
+				return ts
+			}(),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := EncodeTimeseries(tc.ts)
+			if err != nil {
+				t.Fatalf("failed to encode timeseries: %v", err)
+			}
+
+			got, err := DecodeTimeseries(data)
+			if err != nil {
+				t.Fatalf("failed to decode timeseries: %v", err)
+			}
+
+			if !got.StartTime.Equal(tc.ts.StartTime) {
+				t.Errorf("got start time %s, want %s", got.StartTime, tc.ts.StartTime)
+			}
+
+			if !reflect.DeepEqual(got.Data, tc.ts.Data) {
+				t.Errorf("got entries\n%+v\nwant\n%+v", got.Data, tc.ts.Data)
+			}
+		})
+	}
+}
+
+func TestDecodeTimeseriesInvalid(t *testing.T) {
+	data, err := EncodeTimeseries(testTimeseries())
+	if err != nil {
+		t.Fatalf("failed to encode timeseries: %v", err)
+	}
+
+	badMagic := bytes.Clone(data)
+	copy(badMagic, "VO2X")
+
+	badVersion := bytes.Clone(data)
+	badVersion[len(timeseriesMagic)] = timeseriesVersion + 1
+
+	// The number of entries is larger than the payload.
+	tooManyEntries := binary.AppendVarint(nil, 0)
+	tooManyEntries = binary.AppendUvarint(tooManyEntries, 1000)
+
+	// The header says two entries, but only one offset follows.
+	truncatedPayload := binary.AppendVarint(nil, 0)
+	truncatedPayload = binary.AppendUvarint(truncatedPayload, 2)
+	truncatedPayload = binary.AppendVarint(truncatedPayload, 0)
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "magic only", data: []byte(timeseriesMagic)},
+		{name: "bad magic", data: badMagic},
+		{name: "bad version", data: badVersion},
+		{name: "not gzip", data: append([]byte(timeseriesMagic+"\x01"), "payload"...)},
+		{name: "truncated gzip", data: data[:len(data)-10]},
+		{name: "empty payload", data: gzipTimeseriesPayload(t, nil)},
+		{name: "too many entries", data: gzipTimeseriesPayload(t, tooManyEntries)},
+		{name: "truncated payload", data: gzipTimeseriesPayload(t, truncatedPayload)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := DecodeTimeseries(tc.data)
+			if !errors.Is(err, ErrInvalidTimeseriesData) {
+				t.Fatalf("got error %v, want %v", err, ErrInvalidTimeseriesData)
+			}
+		})
+	}
+}
+
+func TestDecodeTimeseriesTruncatedColumns(t *testing.T) {
+	data, err := EncodeTimeseries(testTimeseries())
+	if err != nil {
+		t.Fatalf("failed to encode timeseries: %v", err)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data[len(timeseriesMagic)+1:]))
+	if err != nil {
+		t.Fatalf("failed to decompress payload: %v", err)
+	}
+
+	var payload bytes.Buffer
+	if _, err := payload.ReadFrom(zr); err != nil {
+		t.Fatalf("failed to decompress payload: %v", err)
+	}
+
+	// Every prefix of the payload misses at least the last value of the longitude column.
+	for n := range payload.Len() {
+		_, err := DecodeTimeseries(gzipTimeseriesPayload(t, payload.Bytes()[:n]))
+		if !errors.Is(err, ErrInvalidTimeseriesData) {
+			t.Fatalf("payload truncated to %d bytes: got error %v, want %v", n, err, ErrInvalidTimeseriesData)
+		}
+	}
+}
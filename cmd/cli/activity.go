@@ -1,8 +1,8 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"slices"
 	"strconv"
@@ -12,8 +12,8 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/gabrieleangeletti/stride"
-	"github.com/gabrieleangeletti/stride/strava"
 	"github.com/gabrieleangeletti/vo2/activity"
+	"github.com/gabrieleangeletti/vo2/ingest"
 	"github.com/gabrieleangeletti/vo2/internal"
 	"github.com/gabrieleangeletti/vo2/provider"
 )
@@ -27,10 +27,34 @@ func newActivityCmd(cfg config) *cobra.Command {
 
 	cmd.AddCommand(normalizeActivityCmd(cfg))
 	cmd.AddCommand(analyzeActivityThresholdsCmd(cfg))
+	cmd.AddCommand(matchActivityDuplicatesCmd(cfg))
 
 	return cmd
 }
 
+func matchActivityDuplicatesCmd(cfg config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "match-duplicates <athleteID>",
+		Short: "Link the activities of an athlete received from more than one provider",
+		Long: `Link the activities of an athlete received from more than one provider, e.g. the ones stored before
+the duplicates were detected. New activities are matched when they are stored.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			athleteID, err := uuid.Parse(args[0])
+			if err != nil {
+				log.Fatal("Invalid athlete ID:\n", err)
+			}
+
+			count, err := cfg.store.MatchAthleteActivityDuplicates(cmd.Context(), athleteID)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Printf("%d activities linked as duplicates\n", count)
+		},
+	}
+}
+
 func normalizeActivityCmd(cfg config) *cobra.Command {
 	return &cobra.Command{
 		Use:   "normalize",
@@ -81,31 +105,33 @@ func normalizeActivityCmd(cfg config) *cobra.Command {
 					log.Fatalf("provider %d not found", raw.ProviderID)
 				}
 
-				var stravaActivity strava.ActivityDetailed
-				err = json.Unmarshal(raw.Data, &stravaActivity)
+				p, err := ingest.Get(prov.Slug)
 				if err != nil {
 					log.Fatal(err)
 				}
 
-				var streams *strava.ActivityStream = &strava.ActivityStream{}
+				rawActivity, err := p.DecodeActivity(raw)
+				if err != nil {
+					log.Fatal(err)
+				}
 
-				if !raw.DetailedActivityURI.Valid {
-					driver := internal.NewStravaDriver()
+				streams := p.NewActivityStreams()
 
-					credentials, err := internal.EnsureValidCredentials(ctx, cfg.DB, driver, &prov, athleteID)
+				if !raw.DetailedActivityURI.Valid {
+					credentials, err := internal.EnsureValidCredentials(ctx, cfg.DB, p, &prov, athleteID)
 					if err != nil {
 						log.Fatal(err)
 					}
 
-					client := driver.NewClient(credentials.AccessToken)
-
-					streams, err = client.GetActivityStreams(stravaActivity.ID)
+					fetched, err := p.FetchActivityStreams(ctx, credentials.AccessToken, raw.ProviderActivityID)
 					if err != nil {
 						log.Fatal(err)
 					}
 
-					if streams != nil {
-						err = cfg.store.UploadRawActivityDetails(ctx, stride.ProviderStrava, raw, streams)
+					if fetched != nil {
+						streams = fetched
+
+						err = cfg.store.UploadRawActivityDetails(ctx, stride.Provider(prov.Slug), raw, streams)
 						if err != nil {
 							log.Fatal(err)
 						}
@@ -117,18 +143,10 @@ func normalizeActivityCmd(cfg config) *cobra.Command {
 					}
 				}
 
-				act, err := cfg.store.StoreActivityEndurance(ctx, stride.Provider(prov.Slug), raw, stravaActivity, streams)
+				_, err = cfg.store.StoreActivityEndurance(ctx, stride.Provider(prov.Slug), raw, rawActivity, streams)
 				if err != nil {
 					log.Fatal(err)
 				}
-
-				tags := act.ExtractActivityTags()
-				if len(tags) > 0 {
-					err = cfg.store.UpsertTagsAndLinkActivity(ctx, act, tags)
-					if err != nil {
-						log.Fatal(err)
-					}
-				}
 			}
 
 			err = bar.Finish()
@@ -174,43 +192,18 @@ func analyzeActivityThresholdsCmd(cfg config) *cobra.Command {
 
 				ts, err := cfg.store.GetActivityTimeseries(ctx, act)
 				if err != nil {
-					if errors.Is(err, activity.ErrNoGPXFile) || errors.Is(err, stride.ErrNoTrackPoints) {
-						_, err = cfg.store.UpsertActivityThresholdAnalysis(ctx, &activity.ThresholdAnalysis{
-							ActivityEnduranceID: act.ID,
-							TimeAtLt1Threshold:  0,
-							TimeAtLt2Threshold:  0,
-							RawAnalysis:         []byte("{}"),
-						})
-						continue
+					if !errors.Is(err, activity.ErrNoGPXFile) && !errors.Is(err, stride.ErrNoTrackPoints) {
+						log.Fatal(err)
 					}
-					log.Fatal(err)
-				}
-
-				result, err := stride.AnalyzeHeartRateThresholds(ts, stride.HRThresholdAnalysisConfig{
-					LT1:                        uint8(measurements.Lt1Value),
-					LT2:                        uint8(measurements.Lt2Value),
-					BucketSizeSeconds:          40,
-					MinValidPointsPerBucket:    5,
-					ThresholdTolerancePercent:  0.05,
-					LT1OverlapTolerancePercent: 0.05,
-					MinConsecutiveBuckets:      6,
-					ConsecutivePeriodThreshold: 0.80,
-				})
-				if err != nil {
-					log.Fatal(err)
+					ts = nil
 				}
 
-				rawAnalysis, err := json.Marshal(result)
+				analysis, err := activity.AnalyzeThresholds(act, ts, uint8(measurements.Lt1Value), uint8(measurements.Lt2Value))
 				if err != nil {
 					log.Fatal(err)
 				}
 
-				_, err = cfg.store.UpsertActivityThresholdAnalysis(ctx, &activity.ThresholdAnalysis{
-					ActivityEnduranceID: act.ID,
-					TimeAtLt1Threshold:  int32(result.TimeAtLT1Seconds),
-					TimeAtLt2Threshold:  int32(result.TimeAtLT2Seconds),
-					RawAnalysis:         rawAnalysis,
-				})
+				_, err = cfg.store.UpsertActivityThresholdAnalysis(ctx, analysis)
 				if err != nil {
 					log.Fatal(err)
 				}
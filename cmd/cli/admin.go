@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+
+	"github.com/gabrieleangeletti/vo2/internal"
+	"github.com/gabrieleangeletti/vo2/provider"
+)
+
+func newAdminCmd(cfg config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "admin",
+		Short: "Admin cli",
+		Long:  `Admin cli`,
+	}
+
+	cmd.AddCommand(rotateTokenKeyCmd(cfg))
+	cmd.AddCommand(eraseCmd(cfg))
+
+	return cmd
+}
+
+func rotateTokenKeyCmd(cfg config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "rotate-token-key",
+		Short: "Encrypt the stored OAuth tokens with the current token key",
+		Long: `Encrypt the stored OAuth tokens with the current token key, the first one in OAUTH_TOKEN_KEYS.
+
+To rotate the key, add the new key at the start of OAUTH_TOKEN_KEYS, deploy, run this command, then remove
+the old key. Tokens stored in plaintext, before the encryption, or encrypted without being bound to their row
+are encrypted too.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			result, err := internal.RotateTokenKey(cmd.Context(), cfg.DB)
+			if err != nil {
+				log.Fatal("Error rotating token key:\n", err)
+			}
+
+			fmt.Printf("Encrypted %d OAuth2 and %d OAuth1 credentials with key %s\n", result.OAuth2Credentials, result.OAuth1Credentials, result.KeyID)
+		},
+	}
+}
+
+func eraseCmd(cfg config) *cobra.Command {
+	var providerSlug string
+
+	cmd := &cobra.Command{
+		Use:   "erase <athleteID>",
+		Short: "Delete an athlete with all its data, or the data of a provider",
+		Long: `Delete an athlete with all its data and objects, after revoking the access to its data in the connected
+providers. With --provider, only that provider is disconnected and the data the athlete has from it deleted.
+
+It runs in the foreground, the same as the background jobs of the API, and is recorded as an erasure request.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+
+			athleteID, err := uuid.Parse(args[0])
+			if err != nil {
+				log.Fatal("Invalid athlete ID:\n", err)
+			}
+
+			var providerID *int
+			if providerSlug != "" {
+				prov, err := provider.GetBySlug(cfg.DB, providerSlug)
+				if err != nil {
+					log.Fatal("Error getting provider:\n", err)
+				}
+
+				providerID = &prov.ID
+			}
+
+			req, err := internal.CreateErasureRequest(ctx, cfg.DB, athleteID, providerID)
+			if err != nil {
+				log.Fatal("Error creating erasure request:\n", err)
+			}
+
+			req, err = internal.ProcessErasureRequest(ctx, cfg.DB, cfg.rawStore, req.ID)
+			if err != nil {
+				log.Fatal("Error erasing athlete data:\n", err)
+			}
+
+			var rows map[string]int64
+			if err := json.Unmarshal(req.DeletedRows, &rows); err != nil {
+				log.Fatal("Error decoding deleted rows:\n", err)
+			}
+
+			fmt.Printf("Erasure request %s completed, deauthorized: %t\n", req.ID, req.Deauthorized)
+			for _, table := range slices.Sorted(maps.Keys(rows)) {
+				fmt.Printf("  %s: %d rows\n", table, rows[table])
+			}
+			fmt.Printf("  objects: %d\n", req.DeletedObjects)
+		},
+	}
+
+	cmd.Flags().StringVar(&providerSlug, "provider", "", "Only disconnect this provider and delete its data")
+
+	return cmd
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+func newCacheCmd(cfg config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Object cache cli",
+		Long:  `Object cache cli`,
+	}
+
+	cmd.AddCommand(cacheInfoCmd(cfg))
+	cmd.AddCommand(cacheClearCmd(cfg))
+
+	return cmd
+}
+
+func cacheInfoCmd(cfg config) *cobra.Command {
+	var verbose bool
+
+	cmd := &cobra.Command{
+		Use:   "info",
+		Short: "Show the content of the object cache",
+		Long:  `Show the content of the object cache`,
+		Run: func(cmd *cobra.Command, args []string) {
+			stats := cfg.cache.Stats()
+
+			fmt.Printf("Directory: %s\n", stats.Dir)
+			fmt.Printf("Objects:   %d\n", stats.Entries)
+			fmt.Printf("Size:      %d / %d bytes\n", stats.SizeBytes, stats.MaxBytes)
+
+			if !verbose {
+				return
+			}
+
+			tableData := [][]string{}
+			for _, e := range cfg.cache.Entries() {
+				tableData = append(tableData, []string{e.URI, e.ETag, fmt.Sprintf("%d", e.Size), e.LastAccess.Format(time.RFC3339)})
+			}
+
+			table := tablewriter.NewWriter(os.Stdout)
+			table.Header([]string{"URI", "ETag", "Size", "Last Access"})
+			table.Bulk(tableData)
+			table.Render()
+		},
+	}
+
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "List the cached objects")
+
+	return cmd
+}
+
+func cacheClearCmd(cfg config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "clear",
+		Short: "Remove every object from the object cache",
+		Long:  `Remove every object from the object cache`,
+		Run: func(cmd *cobra.Command, args []string) {
+			stats := cfg.cache.Stats()
+
+			err := cfg.cache.Clear()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Printf("Removed %d objects (%d bytes)\n", stats.Entries, stats.SizeBytes)
+		},
+	}
+}
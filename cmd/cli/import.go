@@ -0,0 +1,297 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/schollz/progressbar/v3"
+	"github.com/spf13/cobra"
+
+	"github.com/gabrieleangeletti/stride"
+	"github.com/gabrieleangeletti/vo2"
+	"github.com/gabrieleangeletti/vo2/ingest"
+	"github.com/gabrieleangeletti/vo2/provider"
+)
+
+func newImportCmd(cfg config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import activities from provider exports",
+		Long:  `Import activities from provider exports`,
+	}
+
+	cmd.AddCommand(importStravaArchiveCmd(cfg))
+	cmd.AddCommand(importAppleHealthCmd(cfg))
+
+	return cmd
+}
+
+func importStravaArchiveCmd(cfg config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "strava-archive <zip> <athleteID>",
+		Short: "Import a Strava bulk export archive",
+		Long: `Import the activities of a Strava "download your data" archive.
+
+The activities are stored under the Strava provider with their Strava IDs, so later webhook updates
+merge with the imported rows. Activity files (FIT, GPX, TCX) are converted to Strava streams.
+
+Activities already stored, by a previous import or by the Strava sync, are skipped so that the richer data
+from the API is never overwritten. Activities whose file is missing or unreadable are imported with the
+summary only.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			athleteID := uuid.MustParse(args[1])
+
+			ctx := cmd.Context()
+
+			archive, err := ingest.OpenStravaArchive(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer archive.Close()
+
+			activities, err := archive.Activities()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			prov, err := provider.GetBySlug(cfg.DB, string(stride.ProviderStrava))
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			p, err := ingest.Get(prov.Slug)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			bar := progressbar.Default(int64(len(activities)))
+
+			var imported, skipped, existing, fileErrors int
+
+			for _, act := range activities {
+				err := bar.Add(1)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				stored, err := cfg.store.GetProviderActivityRawByProviderActivityID(ctx, prov.ID, athleteID, strconv.FormatInt(act.ID, 10))
+				if err != nil && !errors.Is(err, sql.ErrNoRows) {
+					log.Fatal(err)
+				}
+
+				if stored != nil {
+					existing++
+					continue
+				}
+
+				var file *ingest.FileActivity
+
+				if act.Filename != "" {
+					data, err := archive.ReadFile(act.Filename)
+					if err == nil {
+						file, err = ingest.ParseActivityFile(act.Filename, data)
+					}
+
+					if err != nil {
+						// Files that are missing or without track points (e.g. some indoor activities) are imported
+						// with the summary only.
+						log.Printf("activity %d: failed to read %s: %v", act.ID, act.Filename, err)
+						file = nil
+						fileErrors++
+					}
+				}
+
+				rawActivity, streams, err := act.ToRawActivity(file)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				activityRaw := rawActivity.ToProviderActivityRawData(prov.ID, athleteID)
+
+				activityRaw.ID, err = cfg.store.SaveProviderActivityRawData(ctx, activityRaw)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				ts := p.NewActivityStreams()
+
+				if streams != nil {
+					err = cfg.store.UploadRawActivityDetails(ctx, stride.ProviderStrava, activityRaw, streams)
+					if err != nil {
+						log.Fatal(err)
+					}
+
+					ts = streams
+				}
+
+				decoded, err := p.DecodeActivity(activityRaw)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				enduranceActivity, err := cfg.store.StoreActivityEndurance(ctx, stride.ProviderStrava, activityRaw, decoded, ts)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				if enduranceActivity == nil {
+					skipped++
+					continue
+				}
+
+				imported++
+			}
+
+			err = bar.Finish()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Printf("Imported %d endurance activities, %d other activities stored as raw data only\n", imported, skipped)
+			fmt.Printf("Skipped %d activities already stored, %d activity files could not be read\n", existing, fileErrors)
+		},
+	}
+}
+
+func importAppleHealthCmd(cfg config) *cobra.Command {
+	var (
+		measurements bool
+		timezone     string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "apple-health <export.zip> <athleteID>",
+		Short: "Import an Apple Health export",
+		Long: `Import the workouts of an Apple Health export, with their heart rate and distance samples and routes.
+
+export.xml is streamed, so exports of several gigabytes can be imported. Workouts are identified by type
+and start time, importing a newer export updates the previously imported workouts.
+
+With --measurements, resting heart rate, VO2max and body mass samples are added to the athlete measurement
+history.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			athleteID := uuid.MustParse(args[1])
+
+			ctx := cmd.Context()
+
+			export, err := ingest.OpenAppleHealthExport(args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer export.Close()
+
+			workouts, err := export.Workouts(ctx)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			prov, err := provider.GetBySlug(cfg.DB, string(ingest.ProviderAppleHealth))
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			bar := progressbar.Default(int64(len(workouts)))
+
+			var imported, skipped int
+
+			for _, w := range workouts {
+				err := bar.Add(1)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				route, err := export.ReadRoute(w)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				ts, err := w.Timeseries(route)
+				if err != nil {
+					// Workouts with an unreadable route are imported with the samples only.
+					log.Printf("workout %s: failed to parse route %s: %v", w.ID(), w.RoutePath, err)
+
+					ts, err = w.Timeseries(nil)
+					if err != nil {
+						log.Fatal(err)
+					}
+				}
+
+				if w.IanaTimezone == "" {
+					w.IanaTimezone = timezone
+				}
+
+				rawActivity, err := w.ToRawActivity()
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				activityRaw := rawActivity.ToProviderActivityRawData(prov.ID, athleteID)
+
+				activityRaw.ID, err = cfg.store.SaveProviderActivityRawData(ctx, activityRaw)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				streams := &ingest.TimeseriesStreams{ActivityTimeseries: *ts}
+
+				if len(ts.Data) > 0 {
+					err = cfg.store.UploadRawActivityDetails(ctx, ingest.ProviderAppleHealth, activityRaw, streams)
+					if err != nil {
+						log.Fatal(err)
+					}
+				}
+
+				enduranceActivity, err := cfg.store.StoreActivityEndurance(ctx, ingest.ProviderAppleHealth, activityRaw, w, streams)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				if enduranceActivity == nil {
+					skipped++
+					continue
+				}
+
+				imported++
+			}
+
+			err = bar.Finish()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Printf("Imported %d endurance activities, %d other activities stored as raw data only\n", imported, skipped)
+
+			if !measurements {
+				return
+			}
+
+			samples, err := export.Measurements(ctx)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			athleteMeasurements := make([]*vo2.AthleteMeasurement, 0, len(samples))
+			for _, m := range samples {
+				athleteMeasurements = append(athleteMeasurements, m.ToAthleteMeasurement(athleteID, timezone))
+			}
+
+			err = cfg.store.UpsertAthleteMeasurements(ctx, athleteMeasurements)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Printf("Imported %d measurements\n", len(athleteMeasurements))
+		},
+	}
+
+	cmd.Flags().BoolVar(&measurements, "measurements", false, "Also import resting heart rate, VO2max and body mass samples")
+	cmd.Flags().StringVar(&timezone, "timezone", "UTC", "IANA timezone of the workouts and measurements without one")
+
+	return cmd
+}
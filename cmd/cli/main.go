@@ -16,6 +16,9 @@ import (
 type config struct {
 	DB    *sqlx.DB // For backward compatibility. Should be replaced with `dbStore`.
 	store store.Store
+	// rawStore doesn't go through the object cache, for the commands that rewrite or delete objects.
+	rawStore store.Store
+	cache    *store.ObjectCache
 }
 
 func newRootCmd(cfg config) *cobra.Command {
@@ -28,6 +31,10 @@ func newRootCmd(cfg config) *cobra.Command {
 	rootCmd.AddCommand(newProviderCmd(cfg))
 	rootCmd.AddCommand(newActivityCmd(cfg))
 	rootCmd.AddCommand(newAnalysisCmd(cfg))
+	rootCmd.AddCommand(newCacheCmd(cfg))
+	rootCmd.AddCommand(newStorageCmd(cfg))
+	rootCmd.AddCommand(newImportCmd(cfg))
+	rootCmd.AddCommand(newAdminCmd(cfg))
 
 	return rootCmd
 }
@@ -40,18 +47,42 @@ func main() {
 		log.Fatal(err)
 	}
 
-	s, err := store.NewStore(db)
+	cacheCfg, err := store.DefaultObjectCacheConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cache, err := store.NewObjectCache(cacheCfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s, err := store.NewStore(db, store.WithObjectCache(cache))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rawStore, err := store.NewStore(db)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	cfg := config{
-		DB:    db,
-		store: s,
+		DB:       db,
+		store:    s,
+		rawStore: rawStore,
+		cache:    cache,
 	}
 
 	rootCmd := newRootCmd(cfg)
 	err = rootCmd.Execute()
+
+	// The access times of the cached objects are saved on close, the commands exiting with log.Fatal lose them, which
+	// only makes the eviction less accurate.
+	if cerr := cache.Close(); cerr != nil {
+		slog.Warn("failed to save object cache index", "error", cerr)
+	}
+
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -4,14 +4,21 @@ import (
 	"bufio"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 
 	"github.com/gabrieleangeletti/stride/strava"
+	"github.com/gabrieleangeletti/vo2/ingest"
 	"github.com/gabrieleangeletti/vo2/internal"
+	"github.com/gabrieleangeletti/vo2/internal/garminfake"
+	"github.com/gabrieleangeletti/vo2/provider"
 	"github.com/gabrieleangeletti/vo2/util"
 )
 
@@ -24,27 +31,172 @@ func newProviderCmd(cfg config) *cobra.Command {
 
 	cmd.AddCommand(stravaCmd)
 	stravaCmd.AddCommand(stravaWebhookCmd)
-	stravaCmd.AddCommand(stravaAuthCmd())
+	stravaCmd.AddCommand(stravaAuthCmd(cfg))
 
 	stravaWebhookCmd.AddCommand(stravaCreateWebhookCmd(cfg))
-	stravaWebhookCmd.AddCommand(stravaGetWebhookSubscriptionsCmd())
-	stravaWebhookCmd.AddCommand(stravaDeleteWebhookSubscriptionCmd())
+	stravaWebhookCmd.AddCommand(stravaGetWebhookSubscriptionsCmd(cfg))
+	stravaWebhookCmd.AddCommand(stravaDeleteWebhookSubscriptionCmd(cfg))
+
+	cmd.AddCommand(garminCmd)
+	garminCmd.AddCommand(garminAuthCmd(cfg))
+	garminCmd.AddCommand(garminFakeServerCmd())
+
+	cmd.AddCommand(syncCmd(cfg))
+	cmd.AddCommand(syncStatusCmd(cfg))
+
+	return cmd
+}
+
+func syncCmd(cfg config) *cobra.Command {
+	var (
+		providerSlug string
+		since        string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "sync <athleteID>",
+		Short: "Ingest the activities missed by the webhook",
+		Long: `Ingest the activities of an athlete that are missing or that were changed in the provider, e.g. after a
+webhook outage. The activities started since --since (YYYY-MM-DD) are checked, by default the ones started up to a
+week before the newest stored activity. The ingested activities are post processed by the queued tasks.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+
+			athleteID, err := uuid.Parse(args[0])
+			if err != nil {
+				log.Fatal("Invalid athlete ID:\n", err)
+			}
+
+			prov, err := provider.GetBySlug(cfg.DB, providerSlug)
+			if err != nil {
+				log.Fatal("Error getting provider:\n", err)
+			}
+
+			task := internal.CatchUpSyncTask{
+				AthleteID:  athleteID,
+				ProviderID: prov.ID,
+			}
+
+			if since != "" {
+				task.Since, err = time.Parse("2006-01-02", since)
+				if err != nil {
+					log.Fatal("Invalid --since, expected YYYY-MM-DD:\n", err)
+				}
+			}
+
+			result, err := internal.CatchUpSync(ctx, cfg.DB, cfg.store, task)
+			if err != nil {
+				log.Fatal("Error syncing activities:\n", err)
+			}
+
+			fmt.Printf("Checked %d activities since %s: %d missing and %d changed activities queued\n",
+				result.Checked,
+				result.Since.Format(time.DateTime),
+				result.Missing,
+				result.Changed,
+			)
+
+			if result.Wait > 0 {
+				fmt.Printf("Stopped, the rate limit quota is low: run again with --since %s in %s\n",
+					result.Since.Format("2006-01-02"),
+					result.Wait.Round(time.Second),
+				)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&providerSlug, "provider", "strava", "Provider to sync")
+	cmd.Flags().StringVar(&since, "since", "", "Check the activities started since this date (YYYY-MM-DD)")
 
 	return cmd
 }
 
+func syncStatusCmd(cfg config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "sync-status <athleteID>",
+		Short: "Show the historical sync progress of an athlete",
+		Long:  `Show the historical sync progress of an athlete, per provider and per monthly window`,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+
+			athleteID, err := uuid.Parse(args[0])
+			if err != nil {
+				log.Fatal("Invalid athlete ID:\n", err)
+			}
+
+			windows, err := internal.ListSyncWindows(ctx, cfg.DB, athleteID)
+			if err != nil {
+				log.Fatal("Error listing sync windows:\n", err)
+			}
+
+			if len(windows) == 0 {
+				fmt.Println("No sync windows found")
+				return
+			}
+
+			for _, progress := range internal.NewSyncProgress(windows) {
+				prov, err := provider.GetByID(cfg.DB, progress.ProviderID)
+				if err != nil {
+					log.Fatal("Error getting provider:\n", err)
+				}
+
+				fmt.Printf("%s: %d/%d windows completed, %d/%d activities processed\n",
+					prov.Slug,
+					progress.Statuses[internal.SyncWindowStatusCompleted],
+					progress.Windows,
+					progress.ProcessedCount,
+					progress.TotalCount,
+				)
+
+				for _, w := range windows {
+					if w.ProviderID != progress.ProviderID {
+						continue
+					}
+
+					total := "?"
+					if w.TotalCount != nil {
+						total = strconv.Itoa(*w.TotalCount)
+					}
+
+					line := fmt.Sprintf("  %s  %-9s  %d/%s", w.StartTime.Format("2006-01"), w.Status, w.ProcessedCount, total)
+					if w.LastError != nil {
+						line += "  " + *w.LastError
+					}
+
+					fmt.Println(line)
+				}
+			}
+		},
+	}
+}
+
 var stravaCmd = &cobra.Command{
 	Use:   "strava",
 	Short: "Strava cli",
 	Long:  `Strava cli`,
 }
 
-func stravaAuthCmd() *cobra.Command {
-	return &cobra.Command{
+func stravaAuthCmd(cfg config) *cobra.Command {
+	var athlete string
+
+	cmd := &cobra.Command{
 		Use:   "auth",
 		Short: "Generate Strava authorization URL",
-		Long:  `Generate Strava authorization URL`,
+		Long: `Generate Strava authorization URL. The Strava athlete is connected to a new athlete, or to the one given
+with --athlete, e.g. to manage the athlete of another person.`,
 		Run: func(cmd *cobra.Command, args []string) {
+			athleteID := uuid.Nil
+			if athlete != "" {
+				var err error
+
+				athleteID, err = uuid.Parse(athlete)
+				if err != nil {
+					log.Fatal("Invalid athlete ID:\n", err)
+				}
+			}
+
 			clientID := util.GetSecret("STRAVA_CLIENT_ID", true)
 			clientSecret := util.GetSecret("STRAVA_CLIENT_SECRET", true)
 
@@ -56,6 +208,15 @@ func stravaAuthCmd() *cobra.Command {
 			redirectURL := fmt.Sprintf("%s/providers/strava/auth/callback", baseURL)
 			authURL := auth.GetAuthorizationUrl(redirectURL)
 
+			state, err := internal.NewOAuth2State(cmd.Context(), cfg.DB, "strava", athleteID)
+			if err != nil {
+				log.Fatal("Error creating OAuth2 state:\n", err)
+			}
+
+			query := authURL.Query()
+			query.Set("state", state)
+			authURL.RawQuery = query.Encode()
+
 			fmt.Println("Please visit the following URL to authenticate:")
 			fmt.Println(authURL)
 
@@ -73,6 +234,10 @@ func stravaAuthCmd() *cobra.Command {
 			os.Exit(0)
 		},
 	}
+
+	cmd.Flags().StringVar(&athlete, "athlete", "", "Athlete to connect Strava to, by default a new one")
+
+	return cmd
 }
 
 var stravaWebhookCmd = &cobra.Command{
@@ -114,17 +279,35 @@ func stravaCreateWebhookCmd(cfg config) *cobra.Command {
 				log.Fatal("Error registering webhook:\n", err)
 			}
 
-			fmt.Printf("Webhook successfully registered, id: %d", resp.ID)
+			prov, err := provider.GetBySlug(cfg.DB, "strava")
+			if err != nil {
+				log.Fatal("Error getting provider:\n", err)
+			}
+
+			err = internal.SaveWebhookSubscription(ctx, cfg.DB, &internal.WebhookSubscription{
+				ProviderID:     prov.ID,
+				SubscriptionID: strconv.Itoa(resp.ID),
+				CallbackURL:    callbackURL,
+			})
+			if err != nil {
+				log.Fatal("Error saving webhook subscription:\n", err)
+			}
+
+			fmt.Printf("Webhook successfully registered, id: %d\n", resp.ID)
 		},
 	}
 }
 
-func stravaGetWebhookSubscriptionsCmd() *cobra.Command {
+func stravaGetWebhookSubscriptionsCmd(cfg config) *cobra.Command {
 	return &cobra.Command{
 		Use:   "get-subscriptions",
 		Short: "Get Strava webhook subscriptions",
-		Long:  `Get Strava webhook subscriptions`,
+		Long: `Get Strava webhook subscriptions.
+
+The stored subscriptions, which incoming events are validated against, are reconciled with the ones registered with Strava.`,
 		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+
 			clientID := util.GetSecret("STRAVA_CLIENT_ID", true)
 			clientSecret := util.GetSecret("STRAVA_CLIENT_SECRET", true)
 
@@ -135,20 +318,42 @@ func stravaGetWebhookSubscriptionsCmd() *cobra.Command {
 				log.Fatal("Error getting webhook subscriptions:\n", err)
 			}
 
+			prov, err := provider.GetBySlug(cfg.DB, "strava")
+			if err != nil {
+				log.Fatal("Error getting provider:\n", err)
+			}
+
+			registered := make([]*internal.WebhookSubscription, len(subscriptions))
+			for i, sub := range subscriptions {
+				registered[i] = &internal.WebhookSubscription{
+					ProviderID:     prov.ID,
+					SubscriptionID: strconv.Itoa(sub.ID),
+					CallbackURL:    sub.CallbackURL,
+				}
+			}
+
+			err = internal.ReconcileWebhookSubscriptions(ctx, cfg.DB, prov.ID, registered)
+			if err != nil {
+				log.Fatal("Error reconciling webhook subscriptions:\n", err)
+			}
+
 			fmt.Printf("Found %d subscriptions:\n", len(subscriptions))
 			for _, sub := range subscriptions {
-				fmt.Printf("%d: %s", sub.ID, sub.CallbackURL)
+				fmt.Printf("%d: %s\n", sub.ID, sub.CallbackURL)
 			}
 		},
 	}
 }
 
-func stravaDeleteWebhookSubscriptionCmd() *cobra.Command {
+func stravaDeleteWebhookSubscriptionCmd(cfg config) *cobra.Command {
 	return &cobra.Command{
-		Use:   "delete-subscription",
+		Use:   "delete-subscription <subscriptionID>",
 		Short: "Delete Strava webhook subscription",
 		Long:  `Delete Strava webhook subscription`,
+		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+
 			subscriptionID, err := strconv.Atoi(args[0])
 			if err != nil {
 				log.Fatal("Invalid subscription ID:\n", err)
@@ -164,7 +369,135 @@ func stravaDeleteWebhookSubscriptionCmd() *cobra.Command {
 				log.Fatal("Error deleting webhook subscription:\n", err)
 			}
 
+			prov, err := provider.GetBySlug(cfg.DB, "strava")
+			if err != nil {
+				log.Fatal("Error getting provider:\n", err)
+			}
+
+			err = internal.DeleteWebhookSubscription(ctx, cfg.DB, prov.ID, strconv.Itoa(subscriptionID))
+			if err != nil {
+				log.Fatal("Error deleting stored webhook subscription:\n", err)
+			}
+
 			fmt.Printf("Successfully deleted webhook subscription: %d\n", subscriptionID)
 		},
 	}
 }
+
+var garminCmd = &cobra.Command{
+	Use:   "garmin",
+	Short: "Garmin cli",
+	Long:  `Garmin cli`,
+}
+
+func garminAuthCmd(cfg config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "auth",
+		Short: "Generate Garmin authorization URL",
+		Long: `Generate Garmin authorization URL.
+
+Garmin uses OAuth1: a request token is obtained first and stored until the athlete authorizes it,
+then the API exchanges it for an access token in the callback.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+
+			baseURL := util.GetSecret("API_BASE_URL", true)
+			baseURL = strings.TrimRight(baseURL, "/")
+
+			prov, err := provider.GetBySlug(cfg.DB, string(ingest.ProviderGarmin))
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			client := ingest.NewGarminClient()
+
+			callbackURL := fmt.Sprintf("%s/providers/garmin/auth/callback", baseURL)
+
+			requestToken, err := client.RequestToken(ctx, callbackURL)
+			if err != nil {
+				log.Fatal("Failed to get request token:\n", err)
+			}
+
+			_, err = internal.CreateOAuth1RequestToken(ctx, cfg.DB, prov.ID, requestToken)
+			if err != nil {
+				log.Fatal("Failed to store request token:\n", err)
+			}
+
+			authURL := client.AuthorizationURL(requestToken)
+
+			fmt.Println("Please visit the following URL to authenticate:")
+			fmt.Println(authURL)
+
+			fmt.Print("Would you like to open this URL in your browser? (y/n): ")
+			reader := bufio.NewReader(os.Stdin)
+			response, _ := reader.ReadString('\n')
+
+			if strings.TrimSpace(response) == "y" {
+				err := internal.OpenURLInBrowser(authURL)
+				if err != nil {
+					log.Fatalf("Failed to open the browser: %v", err)
+				}
+			}
+
+			os.Exit(0)
+		},
+	}
+}
+
+func garminFakeServerCmd() *cobra.Command {
+	var (
+		addr       string
+		push       bool
+		filesFirst bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "fake-server",
+		Short: "Run a stand-in for the Garmin APIs",
+		Long: `Run a stand-in for the Garmin APIs, serving recorded fixtures, to exercise the Garmin flow offline.
+
+Point the API and the CLI to it with GARMIN_AUTH_BASE_URL, GARMIN_CONNECT_BASE_URL and GARMIN_API_BASE_URL,
+connect with "vo2 provider garmin auth", then run it with --push to send the recorded notifications to
+API_BASE_URL.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+
+			server := garminfake.NewServer(
+				"http://"+addr,
+				util.GetSecret("GARMIN_CONSUMER_KEY", true),
+				util.GetSecret("GARMIN_CONSUMER_SECRET", true),
+			)
+
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if push {
+				go func() {
+					apiBaseURL := strings.TrimRight(util.GetSecret("API_BASE_URL", true), "/")
+
+					err := server.Push(ctx, apiBaseURL+"/providers/garmin/push", filesFirst)
+					if err != nil {
+						log.Fatal(err)
+					}
+
+					fmt.Println("Pushed the recorded notifications")
+				}()
+			}
+
+			fmt.Printf("Fake Garmin API listening on http://%s\n", addr)
+
+			err = http.Serve(ln, server)
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&addr, "addr", "localhost:8091", "Address to listen on")
+	cmd.Flags().BoolVar(&push, "push", false, "Push the recorded notifications to the API once listening")
+	cmd.Flags().BoolVar(&filesFirst, "files-first", false, "Push the activity files before the activity summaries")
+
+	return cmd
+}
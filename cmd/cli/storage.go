@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+
+	"github.com/spf13/cobra"
+)
+
+func newStorageCmd(cfg config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "storage",
+		Short: "Object storage cli",
+		Long:  `Object storage cli`,
+	}
+
+	cmd.AddCommand(storageGCCmd(cfg))
+	cmd.AddCommand(storageRecompressCmd(cfg))
+	cmd.AddCommand(storageReconcileCmd(cfg))
+
+	return cmd
+}
+
+func storageGCCmd(cfg config) *cobra.Command {
+	var (
+		prefix string
+		apply  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Find objects that no row references",
+		Long: `Compare the stored objects with the object URIs in the database.
+
+Orphaned objects (not referenced by any row) and dangling URIs (referenced objects that don't exist)
+are reported. Orphans are only deleted with --apply. Objects whose upload is still pending in the outbox
+are skipped, use the reconcile command to settle them, and so are the objects modified in the last hour.
+Still, don't run it with --apply while activities are being ingested, as objects are uploaded before their
+row is saved.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+
+			report, err := cfg.rawStore.CheckObjectReferences(ctx, prefix)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			for _, key := range report.Orphans {
+				fmt.Printf("orphan: %s\n", key)
+			}
+
+			for _, uri := range report.Dangling {
+				fmt.Printf("dangling: %s\n", uri)
+			}
+
+			fmt.Printf("Found %d orphaned objects and %d dangling URIs\n", len(report.Orphans), len(report.Dangling))
+
+			if !apply {
+				if len(report.Orphans) > 0 {
+					fmt.Println("Run with --apply to delete the orphaned objects")
+				}
+
+				return
+			}
+
+			deleted := 0
+			for _, key := range report.Orphans {
+				err := cfg.rawStore.DeleteObject(ctx, key)
+				if err != nil {
+					log.Printf("failed to delete %s: %v", key, err)
+					continue
+				}
+
+				deleted++
+			}
+
+			fmt.Printf("Deleted %d orphaned objects\n", deleted)
+		},
+	}
+
+	cmd.Flags().StringVar(&prefix, "prefix", "activity_details/", "Only consider objects under this prefix")
+	cmd.Flags().BoolVar(&apply, "apply", false, "Delete the orphaned objects")
+
+	return cmd
+}
+
+func storageRecompressCmd(cfg config) *cobra.Command {
+	var apply bool
+
+	cmd := &cobra.Command{
+		Use:   "recompress",
+		Short: "Gzip compress the raw activity details stored uncompressed",
+		Long: `Gzip compress the raw activity details stored uncompressed.
+
+Objects are rewritten in place, under the same key, so the URIs stored in the database stay valid.
+Without --apply the command only reports how much space would be saved.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+
+			keys, err := cfg.rawStore.ListObjects(ctx, "activity_details/")
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			rawKeys := []string{}
+			for _, key := range keys {
+				if strings.Contains(key, "/raw/") {
+					rawKeys = append(rawKeys, key)
+				}
+			}
+
+			var (
+				recompressed          int
+				sizeBefore, sizeAfter int64
+			)
+
+			bar := progressbar.Default(int64(len(rawKeys)))
+
+			for _, key := range rawKeys {
+				err := bar.Add(1)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				res, err := cfg.rawStore.RecompressRawActivityDetails(ctx, key, !apply)
+				if err != nil {
+					log.Printf("failed to recompress %s: %v", key, err)
+					continue
+				}
+
+				if res.Recompressed {
+					recompressed++
+				}
+
+				sizeBefore += res.SizeBefore
+				sizeAfter += res.SizeAfter
+			}
+
+			fmt.Printf("%d of %d raw objects need compression, %d bytes -> %d bytes\n", recompressed, len(rawKeys), sizeBefore, sizeAfter)
+
+			if !apply && recompressed > 0 {
+				fmt.Println("Run with --apply to rewrite the objects")
+			}
+		},
+	}
+
+	cmd.Flags().BoolVar(&apply, "apply", false, "Rewrite the objects")
+
+	return cmd
+}
+
+func storageReconcileCmd(cfg config) *cobra.Command {
+	var olderThan time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "reconcile",
+		Short: "Finalize the object uploads left pending in the outbox",
+		Long: `Finalize the object uploads left pending in the outbox, e.g. after a crash during ingestion.
+
+Uploads whose object is referenced by a row are committed, the others are rolled back by deleting the object.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+
+			res, err := cfg.rawStore.ReconcileObjectOutbox(ctx, olderThan)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			for _, key := range res.Committed {
+				fmt.Printf("committed: %s\n", key)
+			}
+
+			for _, key := range res.RolledBack {
+				fmt.Printf("rolled back: %s\n", key)
+			}
+
+			fmt.Printf("Committed %d and rolled back %d pending uploads\n", len(res.Committed), len(res.RolledBack))
+		},
+	}
+
+	cmd.Flags().DurationVar(&olderThan, "older-than", time.Hour, "Only reconcile uploads pending for longer than this")
+
+	return cmd
+}
@@ -26,6 +26,11 @@ func handleEvent(ctx context.Context, handler *internal.LambdaHandler, event jso
 		}
 	}
 
+	var scheduledEvent events.CloudWatchEvent
+	if err := json.Unmarshal(event, &scheduledEvent); err == nil && scheduledEvent.DetailType == "Scheduled Event" {
+		return nil, handler.HandleScheduledEvent(ctx, scheduledEvent)
+	}
+
 	var httpRequest events.APIGatewayV2HTTPRequest
 	if err := json.Unmarshal(event, &httpRequest); err == nil && httpRequest.RequestContext.HTTP.Method != "" {
 		return handler.HandleRequest(ctx, httpRequest)
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/jmoiron/sqlx"
@@ -104,3 +105,11 @@ func ToNullString(val string) sql.NullString {
 
 	return sql.NullString{String: val, Valid: true}
 }
+
+func ToNullTime(val time.Time) sql.NullTime {
+	if val.IsZero() {
+		return sql.NullTime{Time: time.Time{}, Valid: false}
+	}
+
+	return sql.NullTime{Time: val, Valid: true}
+}
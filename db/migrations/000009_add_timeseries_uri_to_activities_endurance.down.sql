@@ -0,0 +1 @@
+ALTER TABLE vo2.activities_endurance DROP COLUMN IF EXISTS timeseries_uri;
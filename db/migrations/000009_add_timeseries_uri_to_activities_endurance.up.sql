@@ -0,0 +1,3 @@
+ALTER TABLE vo2.activities_endurance ADD COLUMN timeseries_uri TEXT;
+
+COMMENT ON COLUMN vo2.activities_endurance.timeseries_uri IS 'URI of the compact timeseries file of the activity.';
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS vo2.object_outbox;
+
+DROP TYPE IF EXISTS vo2.object_outbox_status;
@@ -0,0 +1,24 @@
+CREATE TYPE vo2.object_outbox_status AS ENUM (
+    'pending',
+    'committed',
+    'rolled_back'
+);
+
+CREATE TABLE vo2.object_outbox (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    object_key TEXT NOT NULL,
+    status vo2.object_outbox_status NOT NULL DEFAULT 'pending',
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP,
+    deleted_at TIMESTAMP
+);
+
+CREATE INDEX idx_object_outbox_pending ON vo2.object_outbox (created_at) WHERE status = 'pending';
+
+CREATE TRIGGER set_object_outbox_updated_time BEFORE
+UPDATE
+    ON vo2.object_outbox FOR EACH ROW EXECUTE PROCEDURE vo2.set_updated_at_timestamp();
+
+COMMENT ON TABLE vo2.object_outbox IS 'Object uploads, recorded before the upload and committed in the same transaction as the rows referencing the object.';
+COMMENT ON COLUMN vo2.object_outbox.status IS 'pending until the referencing rows are committed. Stale pending entries are finalized or rolled back by the reconciler.';
@@ -0,0 +1 @@
+-- Values can't be removed from an enum type, 'manual' is left in place.
@@ -0,0 +1 @@
+DELETE FROM vo2.providers WHERE slug = 'upload';
@@ -0,0 +1,3 @@
+INSERT INTO vo2.providers ("name", slug, connection_type, "description") VALUES
+('Upload', 'upload', 'manual', 'Activities uploaded manually as GPX, FIT or TCX files') ON CONFLICT (slug)
+DO UPDATE SET name = EXCLUDED.name, slug = EXCLUDED.slug, connection_type = EXCLUDED.connection_type, description = EXCLUDED.description;
@@ -0,0 +1 @@
+-- Values can't be removed from an enum type, 'resting_hr' and 'device' are left in place.
@@ -0,0 +1,3 @@
+ALTER TYPE vo2.athlete_measurement_type ADD VALUE IF NOT EXISTS 'resting_hr';
+
+ALTER TYPE vo2.athlete_measurement_source ADD VALUE IF NOT EXISTS 'device';
@@ -0,0 +1,3 @@
+INSERT INTO vo2.providers ("name", slug, connection_type, "description") VALUES
+('Apple Health', 'apple-health', 'manual', 'Workouts imported from Apple Health exports') ON CONFLICT (slug)
+DO UPDATE SET name = EXCLUDED.name, slug = EXCLUDED.slug, connection_type = EXCLUDED.connection_type, description = EXCLUDED.description;
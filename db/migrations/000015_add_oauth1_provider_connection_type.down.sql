@@ -0,0 +1 @@
+-- Values can't be removed from an enum type, 'oauth1' is left in place.
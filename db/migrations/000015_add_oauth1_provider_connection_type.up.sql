@@ -0,0 +1 @@
+ALTER TYPE vo2.provider_connection_type ADD VALUE IF NOT EXISTS 'oauth1';
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS vo2.oauth1_request_tokens;
+
+DROP TABLE IF EXISTS vo2.provider_oauth1_credentials;
+
+DELETE FROM vo2.providers WHERE slug = 'garmin';
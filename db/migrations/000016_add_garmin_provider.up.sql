@@ -0,0 +1,40 @@
+INSERT INTO vo2.providers ("name", slug, connection_type, "description") VALUES
+('Garmin', 'garmin', 'oauth1', 'Garmin Connect | Activities pushed by the Garmin Health API') ON CONFLICT (slug)
+DO UPDATE SET name = EXCLUDED.name, slug = EXCLUDED.slug, connection_type = EXCLUDED.connection_type, description = EXCLUDED.description;
+
+CREATE TABLE vo2.provider_oauth1_credentials (
+    id SERIAL PRIMARY KEY,
+    provider_id INT NOT NULL,
+    user_id UUID NOT NULL,
+    token TEXT NOT NULL,
+    token_secret TEXT NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP,
+    deleted_at TIMESTAMP,
+
+    UNIQUE (provider_id, user_id),
+
+    FOREIGN KEY (provider_id) REFERENCES vo2.providers (id),
+    FOREIGN KEY (user_id) REFERENCES vo2.users (id)
+);
+
+CREATE TRIGGER set_provider_oauth1_credentials_updated_time BEFORE
+UPDATE
+    ON vo2.provider_oauth1_credentials FOR EACH ROW EXECUTE PROCEDURE vo2.set_updated_at_timestamp();
+
+CREATE TABLE vo2.oauth1_request_tokens (
+    id SERIAL PRIMARY KEY,
+    provider_id INT NOT NULL,
+    token VARCHAR(255) NOT NULL,
+    token_secret TEXT NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+
+    UNIQUE ("token"),
+
+    FOREIGN KEY (provider_id) REFERENCES vo2.providers (id)
+);
+
+COMMENT ON TABLE vo2.oauth1_request_tokens IS 'Request tokens of the OAuth1 flows in progress, whose secret is needed to obtain the access token.';
@@ -0,0 +1 @@
+ALTER TABLE vo2.activities_endurance_tags DROP COLUMN deleted_at;
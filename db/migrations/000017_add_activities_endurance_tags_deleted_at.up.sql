@@ -0,0 +1,2 @@
+-- Tag links are soft-deleted together with the activity they belong to.
+ALTER TABLE vo2.activities_endurance_tags ADD COLUMN deleted_at TIMESTAMP;
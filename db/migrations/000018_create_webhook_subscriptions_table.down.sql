@@ -0,0 +1 @@
+DROP TABLE IF EXISTS vo2.webhook_subscriptions;
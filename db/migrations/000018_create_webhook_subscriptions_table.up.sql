@@ -0,0 +1,20 @@
+CREATE TABLE vo2.webhook_subscriptions (
+    id SERIAL PRIMARY KEY,
+    provider_id INT NOT NULL,
+    subscription_id VARCHAR(255) NOT NULL,
+    callback_url TEXT NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP,
+    deleted_at TIMESTAMP,
+
+    UNIQUE (provider_id, subscription_id),
+
+    FOREIGN KEY (provider_id) REFERENCES vo2.providers (id)
+);
+
+CREATE TRIGGER set_webhook_subscriptions_updated_time BEFORE
+UPDATE
+    ON vo2.webhook_subscriptions FOR EACH ROW EXECUTE PROCEDURE vo2.set_updated_at_timestamp();
+
+COMMENT ON TABLE vo2.webhook_subscriptions IS 'Webhook subscriptions registered with the providers, the incoming events are validated against them.';
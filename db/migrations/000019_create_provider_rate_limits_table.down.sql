@@ -0,0 +1 @@
+DROP TABLE IF EXISTS vo2.provider_rate_limits;
@@ -0,0 +1,19 @@
+CREATE TABLE vo2.provider_rate_limits (
+    provider_id INT NOT NULL,
+    window_name VARCHAR(255) NOT NULL,
+    window_start TIMESTAMP NOT NULL,
+    used INT NOT NULL DEFAULT 0,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP,
+
+    PRIMARY KEY (provider_id, window_name),
+
+    FOREIGN KEY (provider_id) REFERENCES vo2.providers (id)
+);
+
+CREATE TRIGGER set_provider_rate_limits_updated_time BEFORE
+UPDATE
+    ON vo2.provider_rate_limits FOR EACH ROW EXECUTE PROCEDURE vo2.set_updated_at_timestamp();
+
+COMMENT ON TABLE vo2.provider_rate_limits IS 'Requests made to the provider APIs in the current rate limit windows, shared by all the API and task instances.';
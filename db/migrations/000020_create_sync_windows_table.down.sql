@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS vo2.sync_windows;
+
+DROP TYPE IF EXISTS vo2.sync_window_status;
@@ -0,0 +1,37 @@
+CREATE TYPE vo2.sync_window_status AS ENUM (
+    'pending',
+    'running',
+    'delayed',
+    'completed',
+    'failed'
+);
+
+CREATE TABLE vo2.sync_windows (
+    id SERIAL PRIMARY KEY,
+    athlete_id UUID NOT NULL,
+    provider_id INT NOT NULL,
+    start_time TIMESTAMP NOT NULL,
+    end_time TIMESTAMP NOT NULL,
+    status vo2.sync_window_status NOT NULL DEFAULT 'pending',
+    "cursor" VARCHAR(255),
+    total_count INT,
+    processed_count INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP,
+
+    UNIQUE (athlete_id, provider_id, start_time),
+
+    FOREIGN KEY (athlete_id) REFERENCES vo2.athletes (id),
+    FOREIGN KEY (provider_id) REFERENCES vo2.providers (id)
+);
+
+CREATE TRIGGER set_sync_windows_updated_time BEFORE
+UPDATE
+    ON vo2.sync_windows FOR EACH ROW EXECUTE PROCEDURE vo2.set_updated_at_timestamp();
+
+COMMENT ON TABLE vo2.sync_windows IS 'Progress of the historical data tasks, one row per athlete, provider and time window.';
+COMMENT ON COLUMN vo2.sync_windows.cursor IS 'Provider ID of the last activity processed in the window, the task resumes after it.';
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS vo2.activities_endurance_duplicates;
@@ -0,0 +1,32 @@
+CREATE TABLE vo2.activities_endurance_duplicates (
+    id SERIAL PRIMARY KEY,
+    athlete_id UUID NOT NULL,
+    canonical_activity_id UUID NOT NULL,
+    duplicate_activity_id UUID NOT NULL,
+    time_overlap FLOAT NOT NULL,
+    distance_diff FLOAT NOT NULL,
+    route_distance FLOAT,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP,
+    deleted_at TIMESTAMP,
+
+    UNIQUE (duplicate_activity_id),
+
+    FOREIGN KEY (athlete_id) REFERENCES vo2.athletes (id),
+    FOREIGN KEY (canonical_activity_id) REFERENCES vo2.activities_endurance (id),
+    FOREIGN KEY (duplicate_activity_id) REFERENCES vo2.activities_endurance (id),
+
+    CHECK (canonical_activity_id <> duplicate_activity_id)
+);
+
+CREATE TRIGGER set_activities_endurance_duplicates_updated_time BEFORE
+UPDATE
+    ON vo2.activities_endurance_duplicates FOR EACH ROW EXECUTE PROCEDURE vo2.set_updated_at_timestamp();
+
+CREATE INDEX idx_activities_endurance_duplicates_canonical_activity_id ON vo2.activities_endurance_duplicates(canonical_activity_id);
+
+COMMENT ON TABLE vo2.activities_endurance_duplicates IS 'Endurance activities recorded once and received from more than one provider. The duplicates are hidden from the volume across providers, in favor of the canonical activity.';
+COMMENT ON COLUMN vo2.activities_endurance_duplicates.time_overlap IS 'Overlap of the two activities, as a fraction of the shortest one.';
+COMMENT ON COLUMN vo2.activities_endurance_duplicates.distance_diff IS 'Difference between the distances of the two activities, as a fraction of the longest one.';
+COMMENT ON COLUMN vo2.activities_endurance_duplicates.route_distance IS 'Largest distance between the start points and between the end points of the two routes. Measured in meters. NULL if an activity has no route.';
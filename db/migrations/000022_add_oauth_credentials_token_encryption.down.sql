@@ -0,0 +1,7 @@
+-- The tokens encrypted until now can't be decrypted without these columns, their users must connect the providers again.
+
+ALTER TABLE vo2.provider_oauth1_credentials DROP COLUMN data_key;
+ALTER TABLE vo2.provider_oauth1_credentials DROP COLUMN token_key_id;
+
+ALTER TABLE vo2.provider_oauth2_credentials DROP COLUMN data_key;
+ALTER TABLE vo2.provider_oauth2_credentials DROP COLUMN token_key_id;
@@ -0,0 +1,10 @@
+ALTER TABLE vo2.provider_oauth2_credentials ADD COLUMN token_key_id VARCHAR(255);
+ALTER TABLE vo2.provider_oauth2_credentials ADD COLUMN data_key BYTEA;
+
+ALTER TABLE vo2.provider_oauth1_credentials ADD COLUMN token_key_id VARCHAR(255);
+ALTER TABLE vo2.provider_oauth1_credentials ADD COLUMN data_key BYTEA;
+
+COMMENT ON COLUMN vo2.provider_oauth2_credentials.token_key_id IS 'ID of the key encrypting data_key. NULL if the tokens are stored in plaintext.';
+COMMENT ON COLUMN vo2.provider_oauth2_credentials.data_key IS 'Random key encrypting the tokens, encrypted with the token key.';
+COMMENT ON COLUMN vo2.provider_oauth1_credentials.token_key_id IS 'ID of the key encrypting data_key. NULL if the tokens are stored in plaintext.';
+COMMENT ON COLUMN vo2.provider_oauth1_credentials.data_key IS 'Random key encrypting the tokens, encrypted with the token key.';
@@ -0,0 +1,17 @@
+-- The athletes with an incomplete profile must be completed or deleted before the columns can be NOT NULL again.
+
+ALTER TABLE vo2.athletes DROP CONSTRAINT athletes_user_id_key;
+CREATE INDEX idx_athletes_user_id ON vo2.athletes(user_id);
+
+ALTER TABLE vo2.athletes ADD COLUMN age SMALLINT;
+UPDATE vo2.athletes SET age = EXTRACT(YEAR FROM AGE(birth_date));
+ALTER TABLE vo2.athletes ALTER COLUMN age SET NOT NULL;
+ALTER TABLE vo2.athletes DROP COLUMN birth_date;
+
+ALTER TABLE vo2.athletes ALTER COLUMN email SET NOT NULL;
+ALTER TABLE vo2.athletes ALTER COLUMN display_name SET NOT NULL;
+ALTER TABLE vo2.athletes ALTER COLUMN last_name SET NOT NULL;
+ALTER TABLE vo2.athletes ALTER COLUMN first_name SET NOT NULL;
+ALTER TABLE vo2.athletes ALTER COLUMN gender SET NOT NULL;
+ALTER TABLE vo2.athletes ALTER COLUMN country SET NOT NULL;
+ALTER TABLE vo2.athletes ALTER COLUMN height_cm SET NOT NULL;
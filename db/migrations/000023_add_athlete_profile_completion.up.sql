@@ -0,0 +1,18 @@
+-- The athletes are created from the provider profile, the fields it doesn't have are completed by the athlete later.
+ALTER TABLE vo2.athletes ALTER COLUMN height_cm DROP NOT NULL;
+ALTER TABLE vo2.athletes ALTER COLUMN country DROP NOT NULL;
+ALTER TABLE vo2.athletes ALTER COLUMN gender DROP NOT NULL;
+ALTER TABLE vo2.athletes ALTER COLUMN first_name DROP NOT NULL;
+ALTER TABLE vo2.athletes ALTER COLUMN last_name DROP NOT NULL;
+ALTER TABLE vo2.athletes ALTER COLUMN display_name DROP NOT NULL;
+ALTER TABLE vo2.athletes ALTER COLUMN email DROP NOT NULL;
+
+-- The age goes stale, the birth date doesn't.
+ALTER TABLE vo2.athletes DROP COLUMN age;
+ALTER TABLE vo2.athletes ADD COLUMN birth_date DATE;
+
+-- The athletes are upserted by user.
+DROP INDEX vo2.idx_athletes_user_id;
+ALTER TABLE vo2.athletes ADD CONSTRAINT athletes_user_id_key UNIQUE (user_id);
+
+COMMENT ON COLUMN vo2.athletes.birth_date IS 'NULL until the athlete completes the profile.';
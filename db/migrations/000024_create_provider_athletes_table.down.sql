@@ -0,0 +1,6 @@
+-- The users with several athletes must keep only one before the constraint can be added again.
+
+DROP INDEX vo2.idx_athletes_user_id;
+ALTER TABLE vo2.athletes ADD CONSTRAINT athletes_user_id_key UNIQUE (user_id);
+
+DROP TABLE IF EXISTS vo2.provider_athletes;
@@ -0,0 +1,38 @@
+CREATE TABLE vo2.provider_athletes (
+    id SERIAL PRIMARY KEY,
+    provider_id INT NOT NULL,
+    provider_athlete_id VARCHAR(255) NOT NULL,
+    athlete_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP,
+
+    UNIQUE (provider_id, provider_athlete_id),
+    UNIQUE (provider_id, athlete_id),
+
+    FOREIGN KEY (provider_id) REFERENCES vo2.providers (id),
+    FOREIGN KEY (athlete_id) REFERENCES vo2.athletes (id),
+    FOREIGN KEY (user_id) REFERENCES vo2.users (id)
+);
+
+CREATE TRIGGER set_provider_athletes_updated_time BEFORE
+UPDATE
+    ON vo2.provider_athletes FOR EACH ROW EXECUTE PROCEDURE vo2.set_updated_at_timestamp();
+
+CREATE INDEX idx_provider_athletes_athlete_id ON vo2.provider_athletes(athlete_id);
+
+COMMENT ON TABLE vo2.provider_athletes IS 'Athletes of the providers, linked to the athletes. A user can manage several athletes.';
+COMMENT ON COLUMN vo2.provider_athletes.provider_athlete_id IS 'ID of the athlete in the provider, e.g. the Strava athlete ID or the Garmin user ID.';
+COMMENT ON COLUMN vo2.provider_athletes.user_id IS 'User that connected the provider athlete, whose credentials are used to fetch its data.';
+
+-- Until now each user had a single athlete, the one of its provider account.
+INSERT INTO vo2.provider_athletes (provider_id, provider_athlete_id, athlete_id, user_id)
+SELECT DISTINCT ON (u.id) u.provider_id, u.user_external_id, a.id, u.id
+FROM vo2.users u
+JOIN vo2.athletes a ON a.user_id = u.id
+WHERE u.deleted_at IS NULL AND a.deleted_at IS NULL
+ORDER BY u.id, a.created_at;
+
+ALTER TABLE vo2.athletes DROP CONSTRAINT athletes_user_id_key;
+CREATE INDEX idx_athletes_user_id ON vo2.athletes(user_id);
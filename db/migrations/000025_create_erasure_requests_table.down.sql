@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS vo2.erasure_requests;
+
+DROP TYPE IF EXISTS vo2.erasure_request_status;
+DROP TYPE IF EXISTS vo2.erasure_request_scope;
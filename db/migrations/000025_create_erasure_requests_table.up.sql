@@ -0,0 +1,45 @@
+CREATE TYPE vo2.erasure_request_scope AS ENUM (
+    'provider',
+    'athlete'
+);
+
+CREATE TYPE vo2.erasure_request_status AS ENUM (
+    'pending',
+    'running',
+    'completed',
+    'failed'
+);
+
+CREATE TABLE vo2.erasure_requests (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    -- The athlete is deleted by the athlete erasure, the request is kept as the audit record.
+    athlete_id UUID NOT NULL,
+    provider_id INT,
+    scope vo2.erasure_request_scope NOT NULL,
+    status vo2.erasure_request_status NOT NULL DEFAULT 'pending',
+    deauthorized BOOLEAN NOT NULL DEFAULT FALSE,
+    deleted_rows JSONB NOT NULL DEFAULT '{}',
+    deleted_objects INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP,
+
+    CHECK ((scope = 'provider') = (provider_id IS NOT NULL)),
+
+    FOREIGN KEY (provider_id) REFERENCES vo2.providers (id)
+);
+
+CREATE TRIGGER set_erasure_requests_updated_time BEFORE
+UPDATE
+    ON vo2.erasure_requests FOR EACH ROW EXECUTE PROCEDURE vo2.set_updated_at_timestamp();
+
+CREATE INDEX idx_erasure_requests_athlete_id ON vo2.erasure_requests(athlete_id);
+
+COMMENT ON TABLE vo2.erasure_requests IS 'Audit record of the provider disconnections and athlete erasures, processed in the background.';
+COMMENT ON COLUMN vo2.erasure_requests.provider_id IS 'Provider to disconnect. NULL for the erasure of all the data of the athlete.';
+COMMENT ON COLUMN vo2.erasure_requests.deauthorized IS 'Whether the access of the app was revoked in the provider.';
+COMMENT ON COLUMN vo2.erasure_requests.deleted_rows IS 'Number of rows deleted per table.';
+COMMENT ON COLUMN vo2.erasure_requests.deleted_objects IS 'Number of objects deleted from the object store.';
@@ -0,0 +1,7 @@
+COMMENT ON TABLE vo2.object_outbox IS 'Object uploads, recorded before the upload and committed in the same transaction as the rows referencing the object.';
+COMMENT ON COLUMN vo2.object_outbox.status IS 'pending until the referencing rows are committed. Stale pending entries are finalized or rolled back by the reconciler.';
+
+ALTER TABLE vo2.object_outbox
+    ALTER COLUMN created_at TYPE TIMESTAMP,
+    ALTER COLUMN updated_at TYPE TIMESTAMP,
+    ALTER COLUMN deleted_at TYPE TIMESTAMP;
@@ -0,0 +1,12 @@
+-- The reconciler compares created_at with cutoffs computed in UTC, which only matched a TIMESTAMP column on database
+-- sessions in UTC. The existing values were written in the session time zone, as the conversion reads them.
+ALTER TABLE vo2.object_outbox
+    ALTER COLUMN created_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN updated_at TYPE TIMESTAMPTZ,
+    ALTER COLUMN deleted_at TYPE TIMESTAMPTZ;
+
+-- Committed uploads are now deleted from the outbox, which only keeps the pending and rolled back ones.
+DELETE FROM vo2.object_outbox WHERE status = 'committed';
+
+COMMENT ON TABLE vo2.object_outbox IS 'Object uploads, recorded before the upload and deleted in the same transaction that commits the rows referencing the object.';
+COMMENT ON COLUMN vo2.object_outbox.status IS 'pending until the referencing rows are committed. Stale pending entries are deleted or rolled back by the reconciler.';
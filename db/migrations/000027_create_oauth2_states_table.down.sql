@@ -0,0 +1 @@
+DROP TABLE IF EXISTS vo2.oauth2_states;
@@ -0,0 +1,10 @@
+CREATE TABLE vo2.oauth2_states (
+    nonce TEXT PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_oauth2_states_expires_at ON vo2.oauth2_states(expires_at);
+
+COMMENT ON TABLE vo2.oauth2_states IS 'Nonces of the OAuth2 states not used yet. The callback deletes the nonce, so a state can only be used once.';
@@ -0,0 +1,16 @@
+DROP INDEX vo2.idx_object_outbox_pending;
+ALTER TABLE vo2.object_outbox ALTER COLUMN status DROP DEFAULT;
+
+ALTER TYPE vo2.object_outbox_status RENAME TO object_outbox_status_old;
+CREATE TYPE vo2.object_outbox_status AS ENUM (
+    'pending',
+    'committed',
+    'rolled_back'
+);
+
+ALTER TABLE vo2.object_outbox
+    ALTER COLUMN status TYPE vo2.object_outbox_status USING status::text::vo2.object_outbox_status;
+DROP TYPE vo2.object_outbox_status_old;
+
+ALTER TABLE vo2.object_outbox ALTER COLUMN status SET DEFAULT 'pending';
+CREATE INDEX idx_object_outbox_pending ON vo2.object_outbox (created_at) WHERE status = 'pending';
@@ -0,0 +1,19 @@
+-- Committed uploads are deleted from the outbox, the status has no use. An enum value can't be dropped, so the type
+-- is replaced.
+DELETE FROM vo2.object_outbox WHERE status = 'committed';
+
+DROP INDEX vo2.idx_object_outbox_pending;
+ALTER TABLE vo2.object_outbox ALTER COLUMN status DROP DEFAULT;
+
+ALTER TYPE vo2.object_outbox_status RENAME TO object_outbox_status_old;
+CREATE TYPE vo2.object_outbox_status AS ENUM (
+    'pending',
+    'rolled_back'
+);
+
+ALTER TABLE vo2.object_outbox
+    ALTER COLUMN status TYPE vo2.object_outbox_status USING status::text::vo2.object_outbox_status;
+DROP TYPE vo2.object_outbox_status_old;
+
+ALTER TABLE vo2.object_outbox ALTER COLUMN status SET DEFAULT 'pending';
+CREATE INDEX idx_object_outbox_pending ON vo2.object_outbox (created_at) WHERE status = 'pending';
@@ -1,6 +1,6 @@
 -- name: UpsertActivityEndurance :one
 INSERT INTO vo2.activities_endurance
-	(id, provider_id, athlete_id, provider_raw_activity_id, name, description, sport, start_time, end_time, iana_timezone, utc_offset, elapsed_time, moving_time, distance, elev_gain, elev_loss, avg_speed, avg_hr, max_hr, summary_polyline, summary_route, gpx_file_uri, fit_file_uri)
+	(id, provider_id, athlete_id, provider_raw_activity_id, name, description, sport, start_time, end_time, iana_timezone, utc_offset, elapsed_time, moving_time, distance, elev_gain, elev_loss, avg_speed, avg_hr, max_hr, summary_polyline, summary_route, gpx_file_uri, fit_file_uri, timeseries_uri)
 VALUES
 	(
 		@id,
@@ -25,7 +25,8 @@ VALUES
     	@summary_polyline,
     	NULLIF(@summary_route, ''),
     	@gpx_file_uri,
-    	@fit_file_uri
+    	@fit_file_uri,
+    	@timeseries_uri
 )
 ON CONFLICT
 	(provider_id, athlete_id, provider_raw_activity_id)
@@ -48,10 +49,13 @@ DO UPDATE SET
 	summary_polyline = @summary_polyline,
 	summary_route = NULLIF(@summary_route, ''),
 	gpx_file_uri = @gpx_file_uri,
-	fit_file_uri = @fit_file_uri
+	fit_file_uri = @fit_file_uri,
+	timeseries_uri = @timeseries_uri,
+	deleted_at = NULL
 RETURNING *;
 
 -- name: GetActivityEnduranceID :one
+-- Soft-deleted activities are included, so that an activity stored again keeps its ID.
 SELECT id FROM vo2.activities_endurance
 WHERE provider_id = @provider_id
   AND athlete_id = @athlete_id
@@ -76,7 +80,8 @@ ON CONFLICT
 DO UPDATE SET
 	time_at_lt1_threshold = @time_at_lt1_threshold,
 	time_at_lt2_threshold = @time_at_lt2_threshold,
-	raw_analysis = @raw_analysis
+	raw_analysis = @raw_analysis,
+	deleted_at = NULL
 RETURNING *;
 
 -- name: GetProviderActivityRaw :one
@@ -84,7 +89,50 @@ SELECT
     *
 FROM vo2.provider_activity_raw_data
 WHERE
-    id = $1;
+    id = $1
+    AND deleted_at IS NULL;
+
+-- name: GetProviderActivityRawByProviderActivityID :one
+SELECT
+    *
+FROM vo2.provider_activity_raw_data
+WHERE
+    provider_id = @provider_id
+    AND athlete_id = @athlete_id
+    AND provider_activity_id = @provider_activity_id
+    AND deleted_at IS NULL;
+
+-- name: SoftDeleteProviderActivityRaw :one
+UPDATE vo2.provider_activity_raw_data
+SET deleted_at = NOW()
+WHERE
+    provider_id = @provider_id
+    AND athlete_id = @athlete_id
+    AND provider_activity_id = @provider_activity_id
+    AND deleted_at IS NULL
+RETURNING id;
+
+-- name: SoftDeleteActivitiesEnduranceByRawActivity :many
+UPDATE vo2.activities_endurance
+SET deleted_at = NOW()
+WHERE
+    provider_raw_activity_id = @provider_raw_activity_id
+    AND deleted_at IS NULL
+RETURNING id;
+
+-- name: SoftDeleteActivitiesEnduranceTags :exec
+UPDATE vo2.activities_endurance_tags
+SET deleted_at = NOW()
+WHERE
+    activity_id = ANY(@activity_ids::uuid[])
+    AND deleted_at IS NULL;
+
+-- name: SoftDeleteActivitiesThresholdAnalysis :exec
+UPDATE vo2.activities_threshold_analysis
+SET deleted_at = NOW()
+WHERE
+    activity_endurance_id = ANY(@activity_endurance_ids::uuid[])
+    AND deleted_at IS NULL;
 
 -- name: GetAthleteCurrentMeasurements :one
 SELECT
@@ -94,12 +142,29 @@ FROM
 WHERE
     athlete_id = @athlete_id;
 
+-- name: UpsertAthleteMeasurement :exec
+INSERT INTO vo2.athlete_measurement_history
+    (athlete_id, measured_at, iana_timezone, metric_type, value, source, notes)
+VALUES (
+	@athlete_id,
+	@measured_at,
+	@iana_timezone,
+	@metric_type,
+	@value,
+	@source,
+	@notes)
+ON CONFLICT (athlete_id, measured_at, iana_timezone, metric_type) DO UPDATE SET
+	value = @value,
+	source = @source,
+	notes = @notes;
+
 -- name: GetActivityEndurance :one
 SELECT
 	a.*
 FROM vo2.activities_endurance a
 WHERE
-    a.id = $1;
+    a.id = $1
+    AND a.deleted_at IS NULL;
 
 -- name: ListAthleteActivitiesEndurance :many
 SELECT
@@ -107,7 +172,8 @@ SELECT
 FROM vo2.activities_endurance
 WHERE
 	provider_id = sqlc.arg(provider_id) AND
-	athlete_id = sqlc.arg(athlete_id)
+	athlete_id = sqlc.arg(athlete_id) AND
+	deleted_at IS NULL
 ORDER BY
     start_time DESC;
 
@@ -116,7 +182,8 @@ SELECT
 	*
 FROM vo2.activities_endurance
 WHERE
-    id = ANY(@ids::uuid[]);
+    id = ANY(@ids::uuid[])
+    AND deleted_at IS NULL;
 
 -- name: ListActivitiesEnduranceByTag :many
 SELECT
@@ -127,7 +194,10 @@ JOIN vo2.activity_tags t ON at.tag_id = t.id
 WHERE
 	a.provider_id = sqlc.arg(provider_id) AND
 	a.athlete_id = sqlc.arg(athlete_id) AND
-	lower(t.name) = lower(sqlc.arg(tag))
+	lower(t.name) = lower(sqlc.arg(tag)) AND
+	a.deleted_at IS NULL AND
+	at.deleted_at IS NULL AND
+	t.deleted_at IS NULL
 ORDER BY
     a.start_time DESC;
 
@@ -138,14 +208,28 @@ FROM
 vo2.activities_endurance_tags at
 JOIN vo2.activity_tags t ON at.tag_id = t.id
 WHERE
-    at.activity_id = $1;
+    at.activity_id = $1
+    AND at.deleted_at IS NULL
+    AND t.deleted_at IS NULL;
+
+-- name: ListActivityObjectURIs :many
+-- Soft-deleted rows are included, their objects are kept until the rows are purged. Empty URIs, stored by older
+-- versions for activities without a file, are skipped.
+SELECT detailed_activity_uri::text AS uri FROM vo2.provider_activity_raw_data WHERE detailed_activity_uri IS NOT NULL AND detailed_activity_uri <> ''
+UNION
+SELECT gpx_file_uri::text AS uri FROM vo2.activities_endurance WHERE gpx_file_uri IS NOT NULL AND gpx_file_uri <> ''
+UNION
+SELECT fit_file_uri::text AS uri FROM vo2.activities_endurance WHERE fit_file_uri IS NOT NULL AND fit_file_uri <> ''
+UNION
+SELECT timeseries_uri::text AS uri FROM vo2.activities_endurance WHERE timeseries_uri IS NOT NULL AND timeseries_uri <> '';
 
 -- name: UpsertAthlete :one
 INSERT INTO vo2.athletes
-    (user_id, age, height_cm, country, gender, first_name, last_name, display_name, email)
+    (id, user_id, birth_date, height_cm, country, gender, first_name, last_name, display_name, email)
 VALUES (
+	COALESCE(sqlc.narg(id), uuid_generate_v4()),
 	@user_id,
-	@age,
+	@birth_date,
 	@height_cm,
 	@country,
 	@gender,
@@ -153,15 +237,30 @@ VALUES (
 	@last_name,
 	@display_name,
 	@email)
-ON CONFLICT (user_id) DO UPDATE SET
-	age = @age,
-	height_cm = @height_cm,
-	country = @country,
-	gender = @gender,
-	first_name = @first_name,
-	last_name = @last_name,
-	display_name = @display_name,
-	email = @email
+ON CONFLICT (id) DO UPDATE SET
+	birth_date = COALESCE(EXCLUDED.birth_date, athletes.birth_date),
+	height_cm = COALESCE(EXCLUDED.height_cm, athletes.height_cm),
+	country = COALESCE(EXCLUDED.country, athletes.country),
+	gender = COALESCE(EXCLUDED.gender, athletes.gender),
+	first_name = COALESCE(EXCLUDED.first_name, athletes.first_name),
+	last_name = COALESCE(EXCLUDED.last_name, athletes.last_name),
+	display_name = COALESCE(EXCLUDED.display_name, athletes.display_name),
+	email = COALESCE(EXCLUDED.email, athletes.email)
+RETURNING *;
+
+-- name: UpdateAthleteProfile :one
+UPDATE vo2.athletes SET
+	birth_date = COALESCE(sqlc.narg(birth_date), birth_date),
+	height_cm = COALESCE(sqlc.narg(height_cm), height_cm),
+	country = COALESCE(sqlc.narg(country), country),
+	gender = COALESCE(sqlc.narg(gender), gender),
+	first_name = COALESCE(sqlc.narg(first_name), first_name),
+	last_name = COALESCE(sqlc.narg(last_name), last_name),
+	display_name = COALESCE(sqlc.narg(display_name), display_name),
+	email = COALESCE(sqlc.narg(email), email)
+WHERE
+	id = @id
+	AND deleted_at IS NULL
 RETURNING *;
 
 -- name: GetAthleteByID :one
@@ -170,7 +269,8 @@ SELECT
 FROM
     vo2.athletes
 WHERE
-    id = $1;
+    id = $1
+    AND deleted_at IS NULL;
 
 -- name: GetUserAthletes :many
 SELECT
@@ -178,7 +278,8 @@ SELECT
 FROM
     vo2.athletes
 WHERE
-    user_id = @user_id;
+    user_id = @user_id
+    AND deleted_at IS NULL;
 
 -- name: GetAthleteRunningYTDVolume :one
 SELECT
@@ -190,8 +291,19 @@ FROM vo2.activities_endurance a
 JOIN vo2.providers p ON a.provider_id = p.id
 WHERE
     a.athlete_id = @athlete_id
-    AND p.slug = @provider_slug
+    AND (
+        p.slug = @provider_slug
+        -- Across providers, an activity received from more than one provider counts once.
+        OR (
+            @provider_slug::text = 'all'
+            AND NOT EXISTS (
+                SELECT 1 FROM vo2.activities_endurance_duplicates d
+                WHERE d.duplicate_activity_id = a.id AND d.deleted_at IS NULL
+            )
+        )
+    )
     AND a.start_time >= date_trunc('year', NOW())
+    AND a.deleted_at IS NULL
     AND lower(a.sport) IN ('running', 'trail-running');
 
 -- name: GetAthleteVolume :many
@@ -240,8 +352,19 @@ period_data AS (
     JOIN selected_sports ss ON lower(a.sport) = ss.sport
     WHERE
         a.athlete_id = @athlete_id
-        AND p.slug = @provider_slug
+        AND (
+            p.slug = @provider_slug
+            -- Across providers, an activity received from more than one provider counts once.
+            OR (
+                @provider_slug::text = 'all'
+                AND NOT EXISTS (
+                    SELECT 1 FROM vo2.activities_endurance_duplicates d
+                    WHERE d.duplicate_activity_id = a.id AND d.deleted_at IS NULL
+                )
+            )
+        )
         AND a.start_time >= @start_date::timestamptz
+        AND a.deleted_at IS NULL
     GROUP BY period_ts, lower(a.sport)
 )
 SELECT
@@ -264,3 +387,31 @@ LEFT JOIN period_data
     ON period_sports.period_ts = period_data.period_ts
     AND period_sports.sport = period_data.sport
 ORDER BY period_sports.sport, period_sports.period_ts;
+
+-- name: CreateObjectOutboxEntry :one
+INSERT INTO vo2.object_outbox (object_key)
+VALUES (@object_key)
+RETURNING id;
+
+-- name: CommitObjectOutboxEntries :exec
+-- A committed upload needs no more tracking, its entry is deleted.
+DELETE FROM vo2.object_outbox
+WHERE
+    status = 'pending'
+    AND id = ANY(@ids::uuid[]);
+
+-- name: SetObjectOutboxStatus :exec
+UPDATE vo2.object_outbox
+SET status = @status
+WHERE id = ANY(@ids::uuid[]);
+
+-- name: ListPendingObjectOutboxEntries :many
+SELECT
+    *
+FROM vo2.object_outbox
+WHERE
+    status = 'pending'
+    AND created_at < @created_before::timestamptz
+    AND deleted_at IS NULL
+ORDER BY
+    created_at;
@@ -19,7 +19,7 @@ resource "aws_lambda_function" "vo2_lambda" {
     variables = {
       SECRETS_EXTENSION_ENABLED = "true"
       DOPPLER_SECRET_NAME       = var.doppler_secret_name
-      HISTORICAL_DATA_QUEUE_URL = aws_sqs_queue.historical_data_queue.url
+      HISTORICAL_DATA_QUEUE_URL = aws_sqs_queue.historical_data_task_queue.url
       POST_PROCESSING_QUEUE_URL = aws_sqs_queue.post_processing_queue.url
     }
   }
@@ -31,6 +31,37 @@ resource "aws_lambda_function_url" "vo2_lambda_url" {
 }
 
 # SQS Queue for historical data pulling jobs
+# A standard queue, since FIFO queues don't support the per-message delays of the tasks waiting for the rate limits.
+resource "aws_sqs_queue" "historical_data_task_queue" {
+  name                       = "vo2-historical-data-queue"
+  visibility_timeout_seconds = 300     # 5 minutes
+  message_retention_seconds  = 1209600 # 14 days
+  receive_wait_time_seconds  = 20      # long polling
+
+  redrive_policy = jsonencode({
+    deadLetterTargetArn = aws_sqs_queue.historical_data_task_dlq.arn
+    maxReceiveCount     = 3
+  })
+}
+
+# Dead Letter Queue for failed historical data jobs
+resource "aws_sqs_queue" "historical_data_task_dlq" {
+  name                      = "vo2-historical-data-dlq"
+  message_retention_seconds = 1209600 # 14 days
+}
+
+# Lambda trigger from SQS
+resource "aws_lambda_event_source_mapping" "historical_data_task_queue_trigger" {
+  event_source_arn = aws_sqs_queue.historical_data_task_queue.arn
+  function_name    = aws_lambda_function.vo2_lambda.function_name
+  batch_size       = 3
+}
+
+# Former FIFO queue for historical data pulling jobs, kept until it's drained.
+# A queue can't change from FIFO to standard, so the standard queue above is created alongside it and the lambda
+# sends the new tasks there, while it keeps consuming the tasks left here. Once the queue and its DLQ are empty
+# (ApproximateNumberOfMessages and ApproximateNumberOfMessagesNotVisible are 0, and the DLQ is redriven or
+# inspected), remove these three resources.
 resource "aws_sqs_queue" "historical_data_queue" {
   name                        = "vo2-historical-data-queue.fifo"
   fifo_queue                  = true
@@ -45,14 +76,12 @@ resource "aws_sqs_queue" "historical_data_queue" {
   })
 }
 
-# Dead Letter Queue for failed historical data jobs
 resource "aws_sqs_queue" "historical_data_dlq" {
   name                      = "vo2-historical-data-dlq.fifo"
   fifo_queue                = true
   message_retention_seconds = 1209600 # 14 days
 }
 
-# Lambda trigger from SQS
 resource "aws_lambda_event_source_mapping" "historical_data_queue_trigger" {
   event_source_arn = aws_sqs_queue.historical_data_queue.arn
   function_name    = aws_lambda_function.vo2_lambda.function_name
@@ -85,6 +114,25 @@ resource "aws_lambda_event_source_mapping" "post_processing_queue_trigger" {
   batch_size       = 3
 }
 
+# Scheduled catch-up sync, ingesting the activities missed by the Strava webhook
+resource "aws_cloudwatch_event_rule" "catch_up_sync_schedule" {
+  name                = "vo2-catch-up-sync"
+  schedule_expression = "rate(6 hours)"
+}
+
+resource "aws_cloudwatch_event_target" "catch_up_sync_target" {
+  rule = aws_cloudwatch_event_rule.catch_up_sync_schedule.name
+  arn  = aws_lambda_function.vo2_lambda.arn
+}
+
+resource "aws_lambda_permission" "catch_up_sync_schedule" {
+  statement_id  = "AllowCatchUpSyncSchedule"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.vo2_lambda.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.catch_up_sync_schedule.arn
+}
+
 # S3 bucket for storing raw activity data
 resource "aws_s3_bucket" "data" {
   bucket = var.s3_bucket_name
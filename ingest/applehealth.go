@@ -0,0 +1,750 @@
+package ingest
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrieleangeletti/stride"
+	"github.com/gabrieleangeletti/vo2"
+	"github.com/gabrieleangeletti/vo2/activity"
+)
+
+const (
+	// ProviderAppleHealth is the provider of the workouts imported from Apple Health exports.
+	ProviderAppleHealth stride.Provider = "apple-health"
+
+	appleHealthExportFile = "export.xml"
+	appleHealthDateLayout = "2006-01-02 15:04:05 -0700"
+
+	appleHealthHeartRate          = "HKQuantityTypeIdentifierHeartRate"
+	appleHealthRestingHeartRate   = "HKQuantityTypeIdentifierRestingHeartRate"
+	appleHealthVO2Max             = "HKQuantityTypeIdentifierVO2Max"
+	appleHealthBodyMass           = "HKQuantityTypeIdentifierBodyMass"
+	appleHealthDistanceRunning    = "HKQuantityTypeIdentifierDistanceWalkingRunning"
+	appleHealthDistanceCycling    = "HKQuantityTypeIdentifierDistanceCycling"
+	appleHealthDistanceSwimming   = "HKQuantityTypeIdentifierDistanceSwimming"
+	appleHealthMetadataTimeZone   = "HKTimeZone"
+	appleHealthMetadataElevation  = "HKElevationAscended"
+	appleHealthWorkoutTypePrefix  = "HKWorkoutActivityType"
+	appleHealthMeasurementsSource = "Apple Health"
+)
+
+var (
+	ErrInvalidAppleHealthExport = errors.New("invalid Apple Health export")
+)
+
+var appleHealthDistanceTypes = map[string]bool{
+	appleHealthDistanceRunning:  true,
+	appleHealthDistanceCycling:  true,
+	appleHealthDistanceSwimming: true,
+}
+
+var appleHealthMeasurementTypes = map[string]vo2.AthleteMeasurementType{
+	appleHealthRestingHeartRate: vo2.AthleteMeasurementTypeRestingHR,
+	appleHealthVO2Max:           vo2.AthleteMeasurementTypeVO2Max,
+	appleHealthBodyMass:         vo2.AthleteMeasurementTypeWeight,
+}
+
+func init() {
+	Register(&appleHealthProvider{})
+}
+
+// AppleHealthExport is the zip archive exported by the Health app.
+//
+// It contains export.xml, with every sample and workout recorded by the athlete, and the GPX routes of the
+// workouts in the workout-routes directory. export.xml is often several gigabytes, so it's streamed, never
+// loaded in memory.
+type AppleHealthExport struct {
+	zr     *zip.ReadCloser
+	export *zip.File
+	files  map[string]*zip.File
+}
+
+// AppleHealthWorkout is a workout of an Apple Health export.
+// It's stored as JSON in the raw data of the activity, the samples are stored as its raw timeseries.
+type AppleHealthWorkout struct {
+	ActivityType string `json:"activityType"`
+	SourceName   string `json:"sourceName"`
+	IanaTimezone string `json:"ianaTimezone,omitzero"`
+	// RoutePath is the path of the GPX route of the workout, relative to the export directory.
+	RoutePath string `json:"routePath,omitzero"`
+	activitySummary
+
+	EndTime          time.Time           `json:"-"`
+	HeartRateSamples []AppleHealthSample `json:"-"`
+	DistanceSamples  []AppleHealthSample `json:"-"`
+}
+
+// AppleHealthSample is a quantity sample. Distance samples are the distance covered between Start and End.
+type AppleHealthSample struct {
+	Start time.Time
+	End   time.Time
+	Value float64
+}
+
+// AppleHealthMeasurement is a resting heart rate, VO2max or body mass sample.
+type AppleHealthMeasurement struct {
+	Type       vo2.AthleteMeasurementType
+	MeasuredAt time.Time
+	Value      float64
+	SourceName string
+}
+
+// OpenAppleHealthExport opens an Apple Health export zip file.
+func OpenAppleHealthExport(name string) (*AppleHealthExport, error) {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidAppleHealthExport, err)
+	}
+
+	e := &AppleHealthExport{
+		zr:    zr,
+		files: make(map[string]*zip.File, len(zr.File)),
+	}
+
+	for _, f := range zr.File {
+		e.files[f.Name] = f
+
+		if path.Base(f.Name) == appleHealthExportFile {
+			e.export = f
+		}
+	}
+
+	if e.export == nil {
+		zr.Close()
+		return nil, fmt.Errorf("%w: missing %s", ErrInvalidAppleHealthExport, appleHealthExportFile)
+	}
+
+	return e, nil
+}
+
+// Close closes the export.
+func (e *AppleHealthExport) Close() error {
+	return e.zr.Close()
+}
+
+// Workouts returns the workouts of the export, sorted by start time, with their heart rate and distance samples.
+//
+// export.xml is read twice: first to find the workouts, then to assign the samples recorded during them.
+func (e *AppleHealthExport) Workouts(ctx context.Context) ([]*AppleHealthWorkout, error) {
+	var workouts []*AppleHealthWorkout
+
+	err := e.scan(ctx, "Workout", func(dec *xml.Decoder, start xml.StartElement) error {
+		var el appleHealthWorkoutElement
+		err := dec.DecodeElement(&el, &start)
+		if err != nil {
+			return err
+		}
+
+		w, err := el.toWorkout()
+		if err != nil {
+			return err
+		}
+
+		workouts = append(workouts, w)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(workouts) == 0 {
+		return workouts, nil
+	}
+
+	sort.Slice(workouts, func(i, j int) bool {
+		return workouts[i].StartTime.Before(workouts[j].StartTime)
+	})
+
+	err = e.scan(ctx, "Record", func(dec *xml.Decoder, start xml.StartElement) error {
+		recordType := xmlAttr(start, "type")
+		if recordType != appleHealthHeartRate && !appleHealthDistanceTypes[recordType] {
+			return nil
+		}
+
+		sample, err := parseAppleHealthSample(start)
+		if err != nil {
+			return err
+		}
+
+		w := findAppleHealthWorkout(workouts, sample.Start)
+		if w == nil {
+			return nil
+		}
+
+		if recordType == appleHealthHeartRate {
+			w.HeartRateSamples = append(w.HeartRateSamples, sample)
+			return nil
+		}
+
+		// Phone and watch both record distance, only the samples of the device that recorded the workout are kept.
+		if xmlAttr(start, "sourceName") != w.SourceName {
+			return nil
+		}
+
+		sample.Value, err = appleHealthMeters(sample.Value, xmlAttr(start, "unit"))
+		if err != nil {
+			return err
+		}
+
+		w.DistanceSamples = append(w.DistanceSamples, sample)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return workouts, nil
+}
+
+// Measurements returns the resting heart rate, VO2max and body mass samples of the export.
+func (e *AppleHealthExport) Measurements(ctx context.Context) ([]AppleHealthMeasurement, error) {
+	var measurements []AppleHealthMeasurement
+
+	err := e.scan(ctx, "Record", func(dec *xml.Decoder, start xml.StartElement) error {
+		metricType, ok := appleHealthMeasurementTypes[xmlAttr(start, "type")]
+		if !ok {
+			return nil
+		}
+
+		sample, err := parseAppleHealthSample(start)
+		if err != nil {
+			return err
+		}
+
+		if metricType == vo2.AthleteMeasurementTypeWeight && xmlAttr(start, "unit") == "lb" {
+			sample.Value *= 0.45359237
+		}
+
+		measurements = append(measurements, AppleHealthMeasurement{
+			Type:       metricType,
+			MeasuredAt: sample.Start,
+			Value:      sample.Value,
+			SourceName: xmlAttr(start, "sourceName"),
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return measurements, nil
+}
+
+// ReadRoute returns the GPX route of a workout, or nil if the workout has no route.
+func (e *AppleHealthExport) ReadRoute(w *AppleHealthWorkout) ([]byte, error) {
+	if w.RoutePath == "" {
+		return nil, nil
+	}
+
+	name := path.Join(path.Dir(e.export.Name), w.RoutePath)
+
+	f, ok := e.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing %s", ErrInvalidAppleHealthExport, name)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// scan streams export.xml and calls fn for every element with the given name.
+func (e *AppleHealthExport) scan(ctx context.Context, name string, fn func(dec *xml.Decoder, start xml.StartElement) error) error {
+	rc, err := e.export.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	dec := xml.NewDecoder(rc)
+
+	for i := 0; ; i++ {
+		// Checking the context on every token would dominate the time spent decoding.
+		if i%10000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		tok, err := dec.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return fmt.Errorf("%w: %w", ErrInvalidAppleHealthExport, err)
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != name {
+			continue
+		}
+
+		err = fn(dec, start)
+		if err != nil {
+			return fmt.Errorf("%w: %s element at offset %d: %w", ErrInvalidAppleHealthExport, name, dec.InputOffset(), err)
+		}
+	}
+}
+
+// ID returns the identifier of the workout. The export has no workout identifiers, workouts are identified by
+// type and start time, so that importing a newer export updates the previously imported workouts.
+func (w *AppleHealthWorkout) ID() string {
+	return fmt.Sprintf("%s-%d", strings.TrimPrefix(w.ActivityType, appleHealthWorkoutTypePrefix), w.StartTime.Unix())
+}
+
+// Timeseries merges the route and the samples of the workout into a timeseries.
+// It also fills the totals of the workout that the export doesn't provide.
+func (w *AppleHealthWorkout) Timeseries(route []byte) (*stride.ActivityTimeseries, error) {
+	entries := make(map[int]*stride.ActivityTimeseriesEntry)
+
+	entry := func(t time.Time) *stride.ActivityTimeseriesEntry {
+		offset := int(t.Sub(w.StartTime).Seconds())
+
+		e, ok := entries[offset]
+		if !ok {
+			e = &stride.ActivityTimeseriesEntry{Offset: offset}
+			entries[offset] = e
+		}
+
+		return e
+	}
+
+	inWorkout := func(t time.Time) bool {
+		return !t.Before(w.StartTime) && !t.After(w.EndTime)
+	}
+
+	if len(route) > 0 {
+		_, routeTs, err := stride.ParseGPXFileFromMemory(route)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range routeTs.Data {
+			t := routeTs.StartTime.Add(time.Duration(p.Offset) * time.Second)
+			if !inWorkout(t) {
+				continue
+			}
+
+			e := entry(t)
+			e.Latitude = p.Latitude
+			e.Longitude = p.Longitude
+			e.Altitude = p.Altitude
+		}
+	}
+
+	for _, s := range w.HeartRateSamples {
+		if !inWorkout(s.Start) || s.Value <= 0 {
+			continue
+		}
+
+		entry(s.Start).HeartRate = stride.Optional[uint8]{Value: uint8(min(s.Value, 255)), Valid: true}
+	}
+
+	sort.Slice(w.DistanceSamples, func(i, j int) bool {
+		return w.DistanceSamples[i].End.Before(w.DistanceSamples[j].End)
+	})
+
+	var distance float64
+	for _, s := range w.DistanceSamples {
+		if !inWorkout(s.End) {
+			continue
+		}
+
+		distance += s.Value
+		entry(s.End).Distance = stride.Optional[uint32]{Value: uint32(distance), Valid: true}
+	}
+
+	ts := &stride.ActivityTimeseries{
+		StartTime: w.StartTime,
+		Data:      make([]stride.ActivityTimeseriesEntry, 0, len(entries)),
+	}
+
+	for _, e := range entries {
+		ts.Data = append(ts.Data, *e)
+	}
+
+	sort.Slice(ts.Data, func(i, j int) bool {
+		return ts.Data[i].Offset < ts.Data[j].Offset
+	})
+
+	w.fillFromTimeseries(ts)
+
+	return ts, nil
+}
+
+// ToActivity implements stride.ActivityConvertible.
+func (w *AppleHealthWorkout) ToActivity() (*stride.Activity, error) {
+	return w.toActivity(ProviderAppleHealth), nil
+}
+
+// ToRawActivity returns the raw activity to store for the workout.
+func (w *AppleHealthWorkout) ToRawActivity() (*RawActivity, error) {
+	data, err := json.Marshal(w)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RawActivity{
+		ID:           w.ID(),
+		StartTime:    w.StartTime,
+		ElapsedTime:  w.ElapsedTime,
+		IanaTimezone: w.IanaTimezone,
+		Data:         data,
+	}, nil
+}
+
+// ToAthleteMeasurement returns the measurement history entry of the sample.
+// The export has no timezone for samples, ianaTimezone is the one of the athlete.
+func (m AppleHealthMeasurement) ToAthleteMeasurement(athleteID uuid.UUID, ianaTimezone string) *vo2.AthleteMeasurement {
+	return &vo2.AthleteMeasurement{
+		AthleteID:    athleteID,
+		MeasuredAt:   m.MeasuredAt,
+		IanaTimezone: ianaTimezone,
+		MetricType:   m.Type,
+		Value:        m.Value,
+		Source:       vo2.AthleteMeasurementSourceDevice,
+		Notes:        fmt.Sprintf("%s (%s)", appleHealthMeasurementsSource, m.SourceName),
+	}
+}
+
+type appleHealthWorkoutElement struct {
+	ActivityType      string `xml:"workoutActivityType,attr"`
+	Duration          string `xml:"duration,attr"`
+	DurationUnit      string `xml:"durationUnit,attr"`
+	TotalDistance     string `xml:"totalDistance,attr"`
+	TotalDistanceUnit string `xml:"totalDistanceUnit,attr"`
+	SourceName        string `xml:"sourceName,attr"`
+	StartDate         string `xml:"startDate,attr"`
+	EndDate           string `xml:"endDate,attr"`
+	Metadata          []struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:"value,attr"`
+	} `xml:"MetadataEntry"`
+	Statistics []struct {
+		Type string `xml:"type,attr"`
+		Sum  string `xml:"sum,attr"`
+		Unit string `xml:"unit,attr"`
+	} `xml:"WorkoutStatistics"`
+	Routes []struct {
+		FileReference struct {
+			Path string `xml:"path,attr"`
+		} `xml:"FileReference"`
+	} `xml:"WorkoutRoute"`
+}
+
+func (el appleHealthWorkoutElement) toWorkout() (*AppleHealthWorkout, error) {
+	startTime, err := time.Parse(appleHealthDateLayout, el.StartDate)
+	if err != nil {
+		return nil, err
+	}
+
+	endTime, err := time.Parse(appleHealthDateLayout, el.EndDate)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &AppleHealthWorkout{
+		ActivityType: el.ActivityType,
+		SourceName:   el.SourceName,
+		activitySummary: activitySummary{
+			Name:        appleHealthWorkoutName(el.ActivityType),
+			Sport:       appleHealthSport(el.ActivityType),
+			StartTime:   startTime.UTC(),
+			ElapsedTime: int(endTime.Sub(startTime).Seconds()),
+		},
+		EndTime: endTime.UTC(),
+	}
+
+	if el.Duration != "" {
+		duration, err := strconv.ParseFloat(el.Duration, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration: %w", err)
+		}
+
+		seconds, err := appleHealthSeconds(duration, el.DurationUnit)
+		if err != nil {
+			return nil, err
+		}
+
+		// The duration excludes pauses.
+		w.MovingTime = int(seconds)
+	}
+
+	if el.TotalDistance != "" {
+		distance, err := strconv.ParseFloat(el.TotalDistance, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid total distance: %w", err)
+		}
+
+		meters, err := appleHealthMeters(distance, el.TotalDistanceUnit)
+		if err != nil {
+			return nil, err
+		}
+
+		w.Distance = int(meters)
+	}
+
+	// Newer exports have the totals in the workout statistics only.
+	for _, stat := range el.Statistics {
+		if w.Distance > 0 || !appleHealthDistanceTypes[stat.Type] || stat.Sum == "" {
+			continue
+		}
+
+		distance, err := strconv.ParseFloat(stat.Sum, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid distance statistic: %w", err)
+		}
+
+		meters, err := appleHealthMeters(distance, stat.Unit)
+		if err != nil {
+			return nil, err
+		}
+
+		w.Distance = int(meters)
+	}
+
+	for _, m := range el.Metadata {
+		switch m.Key {
+		case appleHealthMetadataTimeZone:
+			w.IanaTimezone = m.Value
+		case appleHealthMetadataElevation:
+			// e.g. "4520 cm"
+			value, unit, _ := strings.Cut(m.Value, " ")
+
+			elevation, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+
+			meters, err := appleHealthMeters(elevation, unit)
+			if err != nil {
+				continue
+			}
+
+			w.ElevGain = int(meters)
+		}
+	}
+
+	for _, r := range el.Routes {
+		if r.FileReference.Path != "" {
+			w.RoutePath = strings.TrimPrefix(r.FileReference.Path, "/")
+		}
+	}
+
+	return w, nil
+}
+
+func parseAppleHealthSample(start xml.StartElement) (AppleHealthSample, error) {
+	startTime, err := time.Parse(appleHealthDateLayout, xmlAttr(start, "startDate"))
+	if err != nil {
+		return AppleHealthSample{}, err
+	}
+
+	endTime, err := time.Parse(appleHealthDateLayout, xmlAttr(start, "endDate"))
+	if err != nil {
+		return AppleHealthSample{}, err
+	}
+
+	value, err := strconv.ParseFloat(xmlAttr(start, "value"), 64)
+	if err != nil {
+		return AppleHealthSample{}, fmt.Errorf("invalid value: %w", err)
+	}
+
+	return AppleHealthSample{
+		Start: startTime.UTC(),
+		End:   endTime.UTC(),
+		Value: value,
+	}, nil
+}
+
+// findAppleHealthWorkout returns the workout in progress at t. workouts must be sorted by start time.
+func findAppleHealthWorkout(workouts []*AppleHealthWorkout, t time.Time) *AppleHealthWorkout {
+	i := sort.Search(len(workouts), func(i int) bool {
+		return workouts[i].StartTime.After(t)
+	})
+
+	if i == 0 {
+		return nil
+	}
+
+	w := workouts[i-1]
+	if t.After(w.EndTime) {
+		return nil
+	}
+
+	return w
+}
+
+func appleHealthSport(activityType string) stride.Sport {
+	switch strings.TrimPrefix(activityType, appleHealthWorkoutTypePrefix) {
+	case "Running":
+		return stride.SportRunning
+	case "Cycling":
+		return stride.SportCycling
+	case "Hiking":
+		return stride.SportHiking
+	case "Swimming":
+		return stride.SportSwimming
+	case "Elliptical":
+		return stride.SportElliptical
+	case "StairClimbing", "Stairs":
+		return stride.SportStairStepper
+	case "SkatingSports":
+		return stride.SportInlineSkating
+	case "PaddleSports":
+		return stride.SportKayaking
+	case "Climbing":
+		return stride.SportRockClimbing
+	case "SurfingSports":
+		return stride.SportSurfing
+	default:
+		return stride.SportUnknown
+	}
+}
+
+// appleHealthWorkoutName returns a name for the workout, which the export doesn't have, e.g. "Running".
+func appleHealthWorkoutName(activityType string) string {
+	name := strings.TrimPrefix(activityType, appleHealthWorkoutTypePrefix)
+
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteRune(' ')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+func appleHealthSeconds(v float64, unit string) (float64, error) {
+	switch unit {
+	case "s":
+		return v, nil
+	case "min":
+		return v * 60, nil
+	case "hr":
+		return v * 3600, nil
+	default:
+		return 0, fmt.Errorf("unsupported duration unit %q", unit)
+	}
+}
+
+func appleHealthMeters(v float64, unit string) (float64, error) {
+	switch unit {
+	case "m":
+		return v, nil
+	case "cm":
+		return v / 100, nil
+	case "km":
+		return v * 1000, nil
+	case "mi":
+		return v * 1609.344, nil
+	case "yd":
+		return v * 0.9144, nil
+	case "ft":
+		return v * 0.3048, nil
+	default:
+		return 0, fmt.Errorf("unsupported distance unit %q", unit)
+	}
+}
+
+func xmlAttr(start xml.StartElement, name string) string {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+
+	return ""
+}
+
+// appleHealthProvider is the provider of the workouts imported from Apple Health exports.
+// Like uploads, workouts only enter through imports, so the fetch methods are not supported.
+type appleHealthProvider struct{}
+
+func (p *appleHealthProvider) Slug() string {
+	return string(ProviderAppleHealth)
+}
+
+func (p *appleHealthProvider) RefreshToken(ctx context.Context, refreshToken string) (*OAuth2Token, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *appleHealthProvider) Deauthorize(ctx context.Context, accessToken string) error {
+	return ErrNotSupported
+}
+
+func (p *appleHealthProvider) FetchActivitySummaries(ctx context.Context, accessToken string, startTime, endTime time.Time) ([]ActivitySummary, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *appleHealthProvider) FetchActivity(ctx context.Context, accessToken string, activityID string) (*RawActivity, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *appleHealthProvider) ActivityChanged(summary ActivitySummary, raw *activity.ProviderActivityRawData) (bool, error) {
+	return false, ErrNotSupported
+}
+
+func (p *appleHealthProvider) FetchActivityStreams(ctx context.Context, accessToken string, activityID string) (stride.ActivityTimeseriesConvertible, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *appleHealthProvider) NewActivityStreams() stride.ActivityTimeseriesConvertible {
+	return &TimeseriesStreams{}
+}
+
+func (p *appleHealthProvider) DecodeActivity(raw *activity.ProviderActivityRawData) (stride.ActivityConvertible, error) {
+	var w AppleHealthWorkout
+	err := json.Unmarshal(raw.Data, &w)
+	if err != nil {
+		return nil, err
+	}
+
+	return &w, nil
+}
+
+func (p *appleHealthProvider) ToEnduranceActivity(raw *activity.ProviderActivityRawData) (*activity.EnduranceActivity, error) {
+	var w AppleHealthWorkout
+	err := json.Unmarshal(raw.Data, &w)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.toEnduranceActivity(raw)
+}
+
+// TimeseriesStreams are raw streams stored as a stride timeseries, for sources without a streams format of their own.
+type TimeseriesStreams struct {
+	stride.ActivityTimeseries
+}
+
+func (s *TimeseriesStreams) ToTimeseries(startTime time.Time) (*stride.ActivityTimeseries, error) {
+	if s.StartTime.IsZero() {
+		s.StartTime = startTime
+	}
+
+	return &s.ActivityTimeseries, nil
+}
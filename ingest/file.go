@@ -0,0 +1,462 @@
+package ingest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/muktihari/fit/decoder"
+	"github.com/muktihari/fit/profile/filedef"
+	"github.com/muktihari/fit/profile/typedef"
+
+	"github.com/gabrieleangeletti/stride"
+)
+
+var (
+	ErrUnsupportedFileFormat = errors.New("unsupported activity file format")
+	ErrEmptyActivityFile     = errors.New("activity file has no activity")
+	ErrActivityFileTooLarge  = errors.New("activity file is too large")
+)
+
+// MaxActivityFileSize is the maximum size of an activity file, compressed or not.
+const MaxActivityFileSize = 32 << 20 // 32 MiB
+
+// FileFormat is the format of an activity file.
+type FileFormat string
+
+const (
+	FileFormatGPX FileFormat = "gpx"
+	FileFormatFIT FileFormat = "fit"
+	FileFormatTCX FileFormat = "tcx"
+)
+
+// FileActivity is the summary of an activity parsed from a GPX, FIT or TCX file.
+// It's what is stored in the raw data of the activity, the file itself is kept in the object storage.
+type FileActivity struct {
+	// ID identifies the content of the file, so that uploading the same file twice updates the same activity.
+	ID       string     `json:"id"`
+	Format   FileFormat `json:"format"`
+	Filename string     `json:"filename"`
+	activitySummary
+
+	// Content is the uncompressed content of the file.
+	Content    []byte                     `json:"-"`
+	Timeseries *stride.ActivityTimeseries `json:"-"`
+}
+
+// ParseActivityFile parses a GPX, FIT or TCX file. The format is detected from the content, gzip compressed
+// files (e.g. activity.fit.gz) are decompressed first.
+func ParseActivityFile(filename string, data []byte) (*FileActivity, error) {
+	data, err := gunzipFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := DetectFileFormat(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var act *FileActivity
+
+	switch format {
+	case FileFormatGPX:
+		act, err = parseGPXFile(data)
+	case FileFormatFIT:
+		act, err = parseFITFile(data)
+	case FileFormatTCX:
+		act, err = parseTCXFile(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if act.Timeseries == nil || len(act.Timeseries.Data) == 0 {
+		return nil, fmt.Errorf("%w: %w", ErrEmptyActivityFile, stride.ErrNoTrackPoints)
+	}
+
+	sum := sha256.Sum256(data)
+
+	act.ID = hex.EncodeToString(sum[:16])
+	act.Format = format
+	act.Filename = filename
+	act.Content = data
+
+	if act.Name == "" {
+		act.Name = fileBaseName(filename)
+	}
+
+	act.fillFromTimeseries(act.Timeseries)
+
+	return act, nil
+}
+
+// DetectFileFormat detects the format of an uncompressed activity file from its content.
+func DetectFileFormat(data []byte) (FileFormat, error) {
+	// FIT files have a 12 or 14 bytes header, with the ".FIT" data type at offset 8.
+	if len(data) >= 12 && string(data[8:12]) == ".FIT" {
+		return FileFormatFIT, nil
+	}
+
+	// Only the beginning of the file is inspected, the root element always comes first.
+	head := data[:min(len(data), 1024)]
+
+	switch {
+	case bytes.Contains(head, []byte("<gpx")):
+		return FileFormatGPX, nil
+	case bytes.Contains(head, []byte("<TrainingCenterDatabase")):
+		return FileFormatTCX, nil
+	default:
+		return "", ErrUnsupportedFileFormat
+	}
+}
+
+// ToActivity implements stride.ActivityConvertible.
+func (a *FileActivity) ToActivity() (*stride.Activity, error) {
+	return a.toActivity(ProviderUpload), nil
+}
+
+// ToRawActivity returns the raw activity to store for the file.
+func (a *FileActivity) ToRawActivity() (*RawActivity, error) {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RawActivity{
+		ID:          a.ID,
+		StartTime:   a.StartTime,
+		ElapsedTime: a.ElapsedTime,
+		Data:        data,
+	}, nil
+}
+
+// Streams returns the timeseries of the activity.
+func (a *FileActivity) Streams() stride.ActivityTimeseriesConvertible {
+	return &fileStreams{ts: a.Timeseries}
+}
+
+// fileStreams is the timeseries of an activity file.
+//
+// It implements encoding.BinaryUnmarshaler so that the original file, as stored in the object storage, can be
+// read back as the raw timeseries of the activity.
+type fileStreams struct {
+	ts *stride.ActivityTimeseries
+}
+
+func (s *fileStreams) ToTimeseries(startTime time.Time) (*stride.ActivityTimeseries, error) {
+	if s.ts == nil {
+		return &stride.ActivityTimeseries{StartTime: startTime}, nil
+	}
+
+	return s.ts, nil
+}
+
+func (s *fileStreams) UnmarshalBinary(data []byte) error {
+	act, err := ParseActivityFile("", data)
+	if err != nil {
+		return err
+	}
+
+	s.ts = act.Timeseries
+
+	return nil
+}
+
+func parseGPXFile(data []byte) (*FileActivity, error) {
+	strideActivity, ts, err := stride.ParseGPXFileFromMemory(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Tracks []struct {
+			Name string `xml:"name"`
+		} `xml:"trk"`
+	}
+
+	err = xml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", stride.ErrFailedToParseGPXFile, err)
+	}
+
+	act := &FileActivity{
+		activitySummary: activitySummary{
+			Sport:       strideActivity.Sport,
+			StartTime:   strideActivity.StartTime.UTC(),
+			ElapsedTime: int(strideActivity.ElapsedTime),
+		},
+		Timeseries: ts,
+	}
+
+	if len(doc.Tracks) > 0 {
+		act.Name = strings.TrimSpace(doc.Tracks[0].Name)
+	}
+
+	return act, nil
+}
+
+func parseFITFile(data []byte) (*FileActivity, error) {
+	fit, err := decoder.New(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode FIT file: %w", err)
+	}
+
+	fitActivity := filedef.NewActivity(fit.Messages...)
+	if len(fitActivity.Sessions) == 0 {
+		return nil, ErrEmptyActivityFile
+	}
+
+	ts, err := stride.FITFileToActivityTimeseries(data)
+	if err != nil {
+		return nil, err
+	}
+
+	session := fitActivity.Sessions[0]
+
+	act := &FileActivity{
+		activitySummary: activitySummary{
+			Sport:     fitSportToSport(session.Sport, session.SubSport),
+			StartTime: session.StartTime.UTC(),
+			ElevGain:  int(session.TotalAscent),
+			ElevLoss:  int(session.TotalDescent),
+		},
+		Timeseries: ts,
+	}
+
+	// Invalid FIT values are NaN once scaled.
+	if v := session.TotalElapsedTimeScaled(); !math.IsNaN(v) {
+		act.ElapsedTime = int(v)
+	}
+
+	if v := session.TotalTimerTimeScaled(); !math.IsNaN(v) {
+		act.MovingTime = int(v)
+	}
+
+	if v := session.TotalDistanceScaled(); !math.IsNaN(v) {
+		act.Distance = int(v)
+	}
+
+	// The invalid value of ascent and descent is the max uint16.
+	if session.TotalAscent == math.MaxUint16 {
+		act.ElevGain = 0
+	}
+
+	if session.TotalDescent == math.MaxUint16 {
+		act.ElevLoss = 0
+	}
+
+	return act, nil
+}
+
+func fitSportToSport(sport typedef.Sport, subSport typedef.SubSport) stride.Sport {
+	switch sport {
+	case typedef.SportRunning:
+		if subSport == typedef.SubSportTrail {
+			return stride.SportTrailRunning
+		}
+		return stride.SportRunning
+
+	case typedef.SportCycling:
+		if subSport == typedef.SubSportGravelCycling {
+			return stride.SportGravelCycling
+		}
+		return stride.SportCycling
+
+	case typedef.SportFitnessEquipment:
+		switch subSport {
+		case typedef.SubSportElliptical:
+			return stride.SportElliptical
+		case typedef.SubSportStairClimbing:
+			return stride.SportStairStepper
+		default:
+			return stride.SportUnknown
+		}
+
+	case typedef.SportHiking:
+		return stride.SportHiking
+
+	case typedef.SportInlineSkating:
+		return stride.SportInlineSkating
+
+	case typedef.SportKayaking:
+		return stride.SportKayaking
+
+	case typedef.SportRockClimbing:
+		return stride.SportRockClimbing
+
+	case typedef.SportSurfing:
+		return stride.SportSurfing
+
+	case typedef.SportSwimming:
+		return stride.SportSwimming
+
+	default:
+		return stride.SportUnknown
+	}
+}
+
+type tcxDatabase struct {
+	Activities []tcxActivity `xml:"Activities>Activity"`
+}
+
+type tcxActivity struct {
+	Sport string   `xml:"Sport,attr"`
+	ID    string   `xml:"Id"`
+	Notes string   `xml:"Notes"`
+	Laps  []tcxLap `xml:"Lap"`
+}
+
+type tcxLap struct {
+	StartTime        time.Time       `xml:"StartTime,attr"`
+	TotalTimeSeconds float64         `xml:"TotalTimeSeconds"`
+	DistanceMeters   float64         `xml:"DistanceMeters"`
+	Trackpoints      []tcxTrackpoint `xml:"Track>Trackpoint"`
+}
+
+type tcxTrackpoint struct {
+	Time           time.Time `xml:"Time"`
+	LatitudeDeg    *float64  `xml:"Position>LatitudeDegrees"`
+	LongitudeDeg   *float64  `xml:"Position>LongitudeDegrees"`
+	AltitudeMeters *float64  `xml:"AltitudeMeters"`
+	DistanceMeters *float64  `xml:"DistanceMeters"`
+	HeartRateBpm   *uint8    `xml:"HeartRateBpm>Value"`
+	Cadence        *uint8    `xml:"Cadence"`
+	Speed          *float64  `xml:"Extensions>TPX>Speed"`
+}
+
+func parseTCXFile(data []byte) (*FileActivity, error) {
+	var doc tcxDatabase
+
+	err := xml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse TCX file: %w", err)
+	}
+
+	if len(doc.Activities) == 0 || len(doc.Activities[0].Laps) == 0 {
+		return nil, ErrEmptyActivityFile
+	}
+
+	tcxAct := doc.Activities[0]
+
+	startTime := tcxAct.Laps[0].StartTime.UTC()
+
+	ts := &stride.ActivityTimeseries{
+		StartTime: startTime,
+	}
+
+	act := &FileActivity{
+		activitySummary: activitySummary{
+			Sport:     tcxSportToSport(tcxAct.Sport),
+			Name:      strings.TrimSpace(tcxAct.Notes),
+			StartTime: startTime,
+		},
+		Timeseries: ts,
+	}
+
+	var movingTime, distance float64
+
+	for _, lap := range tcxAct.Laps {
+		movingTime += lap.TotalTimeSeconds
+		distance += lap.DistanceMeters
+
+		for _, tp := range lap.Trackpoints {
+			entry := stride.ActivityTimeseriesEntry{
+				Offset: int(tp.Time.Sub(startTime).Seconds()),
+			}
+
+			if tp.LatitudeDeg != nil && tp.LongitudeDeg != nil {
+				entry.Latitude = stride.Optional[float64]{Value: *tp.LatitudeDeg, Valid: *tp.LatitudeDeg != 0}
+				entry.Longitude = stride.Optional[float64]{Value: *tp.LongitudeDeg, Valid: *tp.LongitudeDeg != 0}
+			}
+
+			if tp.AltitudeMeters != nil {
+				entry.Altitude = stride.Optional[uint16]{Value: uint16(math.Max(*tp.AltitudeMeters, 0)), Valid: true}
+			}
+
+			if tp.DistanceMeters != nil {
+				entry.Distance = stride.Optional[uint32]{Value: uint32(*tp.DistanceMeters), Valid: true}
+			}
+
+			if tp.HeartRateBpm != nil {
+				entry.HeartRate = stride.Optional[uint8]{Value: *tp.HeartRateBpm, Valid: *tp.HeartRateBpm > 0}
+			}
+
+			if tp.Cadence != nil {
+				entry.Cadence = stride.Optional[uint8]{Value: *tp.Cadence, Valid: *tp.Cadence > 0}
+			}
+
+			if tp.Speed != nil {
+				entry.Velocity = stride.Optional[uint16]{Value: uint16(*tp.Speed), Valid: true}
+			}
+
+			if entry.IsEmpty() {
+				continue
+			}
+
+			ts.Data = append(ts.Data, entry)
+		}
+	}
+
+	act.MovingTime = int(movingTime)
+	act.Distance = int(distance)
+
+	return act, nil
+}
+
+func tcxSportToSport(sport string) stride.Sport {
+	switch sport {
+	case "Running":
+		return stride.SportRunning
+	case "Biking":
+		return stride.SportCycling
+	default:
+		return stride.SportUnknown
+	}
+}
+
+func gunzipFile(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// A small compressed file can expand to gigabytes, stop reading past the limit.
+	data, err = io.ReadAll(io.LimitReader(r, MaxActivityFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > MaxActivityFileSize {
+		return nil, fmt.Errorf("%w: more than %d bytes uncompressed", ErrActivityFileTooLarge, MaxActivityFileSize)
+	}
+
+	return data, nil
+}
+
+// fileBaseName returns the name of the file without directories and extensions, e.g. activity.fit.gz -> activity.
+func fileBaseName(filename string) string {
+	name := path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if name == "." || name == "/" {
+		return ""
+	}
+
+	name = strings.TrimSuffix(name, ".gz")
+
+	return strings.TrimSuffix(name, path.Ext(name))
+}
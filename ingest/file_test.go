@@ -0,0 +1,305 @@
+package ingest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gabrieleangeletti/stride"
+)
+
+// readTestdata reads a fixture of testdata: activity.gpx, activity.tcx and activity.fit are a short run, ride and
+// a 30 minutes run.
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", name, err)
+	}
+
+	return data
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("failed to compress data: %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to compress data: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestGunzipFile(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		size    int
+		wantErr error
+	}{
+		{name: "small", size: 1 << 10},
+		{name: "at the limit", size: MaxActivityFileSize},
+		{name: "over the limit", size: MaxActivityFileSize + 1, wantErr: ErrActivityFileTooLarge},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := gunzipFile(gzipData(t, make([]byte, tc.size)))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+
+			if err == nil && len(data) != tc.size {
+				t.Errorf("got %d bytes, want %d", len(data), tc.size)
+			}
+		})
+	}
+}
+
+func TestGunzipFilePlain(t *testing.T) {
+	data := []byte("<gpx></gpx>")
+
+	got, err := gunzipFile(data)
+	if err != nil {
+		t.Fatalf("failed to read plain file: %v", err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Errorf("got %q, want %q", got, data)
+	}
+}
+
+func TestDetectFileFormat(t *testing.T) {
+	fitData := readTestdata(t, "activity.fit")
+
+	for _, tc := range []struct {
+		name    string
+		data    []byte
+		want    FileFormat
+		wantErr error
+	}{
+		{name: "gpx", data: readTestdata(t, "activity.gpx"), want: FileFormatGPX},
+		{name: "tcx", data: readTestdata(t, "activity.tcx"), want: FileFormatTCX},
+		{name: "fit", data: fitData, want: FileFormatFIT},
+		{name: "fit header only", data: fitData[:14], want: FileFormatFIT},
+		{name: "gpx without declaration", data: []byte(`<gpx version="1.1"></gpx>`), want: FileFormatGPX},
+		{name: "empty", data: nil, wantErr: ErrUnsupportedFileFormat},
+		{name: "short", data: []byte(".FIT"), wantErr: ErrUnsupportedFileFormat},
+		{name: "other xml", data: []byte(`<?xml version="1.0"?><kml></kml>`), wantErr: ErrUnsupportedFileFormat},
+		{name: "json", data: []byte(`{"gpx": true}`), wantErr: ErrUnsupportedFileFormat},
+		// The root element is expected at the beginning of the file.
+		{name: "root element too far", data: append(bytes.Repeat([]byte(" "), 1024), "<gpx></gpx>"...), wantErr: ErrUnsupportedFileFormat},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DetectFileFormat(tc.data)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+
+			if got != tc.want {
+				t.Errorf("got format %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseGPXFile(t *testing.T) {
+	act, err := parseGPXFile(readTestdata(t, "activity.gpx"))
+	if err != nil {
+		t.Fatalf("failed to parse GPX file: %v", err)
+	}
+
+	if act.Name != "Morning Run" {
+		t.Errorf("got name %q, want %q", act.Name, "Morning Run")
+	}
+
+	if act.Sport != stride.SportRunning {
+		t.Errorf("got sport %q, want %q", act.Sport, stride.SportRunning)
+	}
+
+	wantStart := time.Date(2024, 5, 12, 6, 30, 0, 0, time.UTC)
+	if !act.StartTime.Equal(wantStart) {
+		t.Errorf("got start time %s, want %s", act.StartTime, wantStart)
+	}
+
+	if act.ElapsedTime != 20 {
+		t.Errorf("got elapsed time %d, want %d", act.ElapsedTime, 20)
+	}
+
+	if len(act.Timeseries.Data) != 3 {
+		t.Fatalf("got %d entries, want %d", len(act.Timeseries.Data), 3)
+	}
+
+	last := act.Timeseries.Data[2]
+	if last.Offset != 20 || last.HeartRate.Value != 150 || last.Cadence.Value != 84 || last.Altitude.Value != 122 {
+		t.Errorf("got last entry %+v, want offset 20, heart rate 150, cadence 84 and altitude 122", last)
+	}
+
+	_, err = parseGPXFile([]byte(`<gpx version="1.1"><trk><trkseg>`))
+	if err == nil {
+		t.Error("parsed a truncated GPX file")
+	}
+}
+
+func TestParseFITFile(t *testing.T) {
+	data := readTestdata(t, "activity.fit")
+
+	act, err := parseFITFile(data)
+	if err != nil {
+		t.Fatalf("failed to parse FIT file: %v", err)
+	}
+
+	if act.Sport != stride.SportRunning {
+		t.Errorf("got sport %q, want %q", act.Sport, stride.SportRunning)
+	}
+
+	wantStart := time.Date(2024, 5, 12, 6, 30, 0, 0, time.UTC)
+	if !act.StartTime.Equal(wantStart) {
+		t.Errorf("got start time %s, want %s", act.StartTime, wantStart)
+	}
+
+	if act.ElapsedTime != 1800 || act.MovingTime != 1800 {
+		t.Errorf("got elapsed time %d and moving time %d, want %d", act.ElapsedTime, act.MovingTime, 1800)
+	}
+
+	if act.Distance != 5400 {
+		t.Errorf("got distance %d, want %d", act.Distance, 5400)
+	}
+
+	if act.ElevGain != 48 || act.ElevLoss != 48 {
+		t.Errorf("got elevation gain %d and loss %d, want %d", act.ElevGain, act.ElevLoss, 48)
+	}
+
+	if len(act.Timeseries.Data) != 361 {
+		t.Fatalf("got %d entries, want %d", len(act.Timeseries.Data), 361)
+	}
+
+	_, err = parseFITFile(data[:len(data)/2])
+	if err == nil {
+		t.Error("parsed a truncated FIT file")
+	}
+}
+
+func TestParseTCXFile(t *testing.T) {
+	act, err := parseTCXFile(readTestdata(t, "activity.tcx"))
+	if err != nil {
+		t.Fatalf("failed to parse TCX file: %v", err)
+	}
+
+	if act.Name != "Evening Ride" {
+		t.Errorf("got name %q, want %q", act.Name, "Evening Ride")
+	}
+
+	if act.Sport != stride.SportCycling {
+		t.Errorf("got sport %q, want %q", act.Sport, stride.SportCycling)
+	}
+
+	// The moving time and distance are the sums of the laps.
+	if act.MovingTime != 30 || act.Distance != 200 {
+		t.Errorf("got moving time %d and distance %d, want %d and %d", act.MovingTime, act.Distance, 30, 200)
+	}
+
+	want := []stride.ActivityTimeseriesEntry{
+		{
+			Offset:    0,
+			HeartRate: stride.Optional[uint8]{Value: 120, Valid: true},
+			Cadence:   stride.Optional[uint8]{Value: 85, Valid: true},
+			Distance:  stride.Optional[uint32]{Value: 0, Valid: true},
+			Altitude:  stride.Optional[uint16]{Value: 120, Valid: true},
+			Velocity:  stride.Optional[uint16]{Value: 7, Valid: true},
+			Latitude:  stride.Optional[float64]{Value: 45.4642, Valid: true},
+			Longitude: stride.Optional[float64]{Value: 9.19, Valid: true},
+		},
+		// A zero heart rate is missing, a negative altitude is clamped to zero.
+		{
+			Offset:    10,
+			Distance:  stride.Optional[uint32]{Value: 75, Valid: true},
+			Altitude:  stride.Optional[uint16]{Value: 0, Valid: true},
+			Latitude:  stride.Optional[float64]{Value: 45.465, Valid: true},
+			Longitude: stride.Optional[float64]{Value: 9.191, Valid: true},
+		},
+		// The trackpoint without data is skipped, the offsets of the second lap are from the start of the first.
+		{
+			Offset:    20,
+			HeartRate: stride.Optional[uint8]{Value: 130, Valid: true},
+			Distance:  stride.Optional[uint32]{Value: 200, Valid: true},
+		},
+	}
+
+	if len(act.Timeseries.Data) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(act.Timeseries.Data), len(want))
+	}
+
+	for i := range want {
+		if act.Timeseries.Data[i] != want[i] {
+			t.Errorf("got entry %d %+v, want %+v", i, act.Timeseries.Data[i], want[i])
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		data    string
+		wantErr error
+	}{
+		{name: "no activities", data: `<TrainingCenterDatabase><Activities></Activities></TrainingCenterDatabase>`, wantErr: ErrEmptyActivityFile},
+		{name: "no laps", data: `<TrainingCenterDatabase><Activities><Activity Sport="Running"></Activity></Activities></TrainingCenterDatabase>`, wantErr: ErrEmptyActivityFile},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseTCXFile([]byte(tc.data))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseActivityFile(t *testing.T) {
+	for _, tc := range []struct {
+		filename string
+		data     []byte
+		format   FileFormat
+		name     string
+	}{
+		{filename: "run.gpx", data: readTestdata(t, "activity.gpx"), format: FileFormatGPX, name: "Morning Run"},
+		{filename: "ride.tcx", data: readTestdata(t, "activity.tcx"), format: FileFormatTCX, name: "Evening Ride"},
+		// FIT files have no name, it's the one of the file.
+		{filename: "uploads/Long Run.fit.gz", data: gzipData(t, readTestdata(t, "activity.fit")), format: FileFormatFIT, name: "Long Run"},
+	} {
+		t.Run(tc.filename, func(t *testing.T) {
+			act, err := ParseActivityFile(tc.filename, tc.data)
+			if err != nil {
+				t.Fatalf("failed to parse activity file: %v", err)
+			}
+
+			if act.Format != tc.format {
+				t.Errorf("got format %q, want %q", act.Format, tc.format)
+			}
+
+			if act.Name != tc.name {
+				t.Errorf("got name %q, want %q", act.Name, tc.name)
+			}
+
+			if act.ID == "" {
+				t.Error("got an empty ID")
+			}
+
+			// The same file, compressed or not, is the same activity.
+			again, err := ParseActivityFile(tc.filename, gzipData(t, act.Content))
+			if err != nil {
+				t.Fatalf("failed to parse compressed activity file: %v", err)
+			}
+
+			if again.ID != act.ID {
+				t.Errorf("got ID %q for the compressed file, want %q", again.ID, act.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,495 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabrieleangeletti/stride"
+	"github.com/gabrieleangeletti/vo2/activity"
+	"github.com/gabrieleangeletti/vo2/util"
+)
+
+const (
+	// ProviderGarmin is the provider of the activities pushed by the Garmin Health API.
+	ProviderGarmin stride.Provider = "garmin"
+
+	garminDefaultAuthBaseURL    = "https://connectapi.garmin.com"
+	garminDefaultConnectBaseURL = "https://connect.garmin.com"
+	garminDefaultAPIBaseURL     = "https://apis.garmin.com"
+
+	garminRequestTokenPath = "/oauth-service/oauth/request_token"
+	garminAccessTokenPath  = "/oauth-service/oauth/access_token"
+	garminAuthorizePath    = "/oauthConfirm"
+	garminUserIDPath       = "/wellness-api/rest/user/id"
+	// garminUserRegistrationPath deletes the registration of the user, Garmin answers with no content.
+	garminUserRegistrationPath = "/wellness-api/rest/user/registration"
+
+	garminRequestTimeout = 30 * time.Second
+)
+
+var (
+	ErrInvalidGarminCallbackURL = errors.New("invalid Garmin callback URL")
+	ErrGarminRequestFailed      = errors.New("request to Garmin failed")
+)
+
+func init() {
+	Register(&garminProvider{})
+}
+
+// GarminPush is the body of a push notification of the Garmin Health API.
+//
+// Garmin pushes the activity summaries and the activity files separately, in no particular order, both
+// identified by the activity ID.
+type GarminPush struct {
+	Activities    []GarminActivity     `json:"activities,omitempty"`
+	ActivityFiles []GarminActivityFile `json:"activityFiles,omitempty"`
+}
+
+// GarminActivity is an activity summary of the Garmin Health API. It's stored as JSON in the raw data of the
+// activity, without the user access token.
+type GarminActivity struct {
+	UserID                           string  `json:"userId"`
+	UserAccessToken                  string  `json:"userAccessToken,omitempty"`
+	SummaryID                        string  `json:"summaryId"`
+	ActivityID                       int64   `json:"activityId"`
+	ActivityName                     string  `json:"activityName"`
+	ActivityDescription              string  `json:"activityDescription,omitempty"`
+	ActivityType                     string  `json:"activityType"`
+	StartTimeInSeconds               int64   `json:"startTimeInSeconds"`
+	StartTimeOffsetInSeconds         int     `json:"startTimeOffsetInSeconds"`
+	DurationInSeconds                int     `json:"durationInSeconds"`
+	DistanceInMeters                 float64 `json:"distanceInMeters,omitempty"`
+	AverageSpeedInMetersPerSecond    float64 `json:"averageSpeedInMetersPerSecond,omitempty"`
+	MaxSpeedInMetersPerSecond        float64 `json:"maxSpeedInMetersPerSecond,omitempty"`
+	AverageHeartRateInBeatsPerMinute int     `json:"averageHeartRateInBeatsPerMinute,omitempty"`
+	MaxHeartRateInBeatsPerMinute     int     `json:"maxHeartRateInBeatsPerMinute,omitempty"`
+	TotalElevationGainInMeters       float64 `json:"totalElevationGainInMeters,omitempty"`
+	TotalElevationLossInMeters       float64 `json:"totalElevationLossInMeters,omitempty"`
+	DeviceName                       string  `json:"deviceName,omitempty"`
+	Manual                           bool    `json:"manual,omitempty"`
+
+	// File is the summary of the activity file, set once the file is downloaded. It's not part of the Garmin payload.
+	File *activitySummary `json:"file,omitempty"`
+}
+
+// GarminActivityFile is the notification that the file of an activity is available for download.
+type GarminActivityFile struct {
+	UserID             string `json:"userId"`
+	UserAccessToken    string `json:"userAccessToken"`
+	SummaryID          string `json:"summaryId"`
+	ActivityID         int64  `json:"activityId"`
+	ActivityName       string `json:"activityName"`
+	FileType           string `json:"fileType"`
+	CallbackURL        string `json:"callbackURL"`
+	StartTimeInSeconds int64  `json:"startTimeInSeconds"`
+	Manual             bool   `json:"manual,omitempty"`
+}
+
+// ID returns the provider activity ID of the activity.
+func (a *GarminActivity) ID() string {
+	return strconv.FormatInt(a.ActivityID, 10)
+}
+
+// SetFile merges the totals of the activity file with the ones of the summary.
+func (a *GarminActivity) SetFile(file *FileActivity) {
+	summary := file.activitySummary
+	a.File = &summary
+}
+
+// KeepFile keeps the file summary of the stored raw data of the activity, when the file was pushed before the summary.
+func (a *GarminActivity) KeepFile(stored *activity.ProviderActivityRawData) error {
+	var prev GarminActivity
+	err := json.Unmarshal(stored.Data, &prev)
+	if err != nil {
+		return err
+	}
+
+	if a.File == nil {
+		a.File = prev.File
+	}
+
+	return nil
+}
+
+// summary returns the totals of the activity. The values of the summary take precedence over the ones of the file.
+func (a *GarminActivity) summary() activitySummary {
+	s := activitySummary{
+		Name:        a.ActivityName,
+		Sport:       garminSport(a.ActivityType),
+		StartTime:   time.Unix(a.StartTimeInSeconds, 0).UTC(),
+		ElapsedTime: a.DurationInSeconds,
+		Distance:    int(a.DistanceInMeters),
+		ElevGain:    int(a.TotalElevationGainInMeters),
+		ElevLoss:    int(a.TotalElevationLossInMeters),
+	}
+
+	if a.File != nil {
+		if s.Sport == stride.SportUnknown {
+			s.Sport = a.File.Sport
+		}
+
+		if s.ElapsedTime == 0 {
+			s.ElapsedTime = a.File.ElapsedTime
+		}
+
+		if s.Distance == 0 {
+			s.Distance = a.File.Distance
+		}
+
+		if s.ElevGain == 0 {
+			s.ElevGain = a.File.ElevGain
+		}
+
+		if s.ElevLoss == 0 {
+			s.ElevLoss = a.File.ElevLoss
+		}
+
+		// The summary has no moving time and no route.
+		s.MovingTime = a.File.MovingTime
+		s.SummaryPolyline = a.File.SummaryPolyline
+	}
+
+	if s.MovingTime == 0 {
+		s.MovingTime = s.ElapsedTime
+	}
+
+	return s
+}
+
+// ToActivity implements stride.ActivityConvertible.
+func (a *GarminActivity) ToActivity() (*stride.Activity, error) {
+	s := a.summary()
+	act := s.toActivity(ProviderGarmin)
+
+	if a.AverageHeartRateInBeatsPerMinute > 0 {
+		act.AvgHR = stride.Optional[uint8]{Value: uint8(a.AverageHeartRateInBeatsPerMinute), Valid: true}
+	}
+
+	if a.MaxHeartRateInBeatsPerMinute > 0 {
+		act.MaxHR = stride.Optional[uint8]{Value: uint8(a.MaxHeartRateInBeatsPerMinute), Valid: true}
+	}
+
+	return act, nil
+}
+
+// ToRawActivity returns the raw activity to store for the summary.
+func (a *GarminActivity) ToRawActivity() (*RawActivity, error) {
+	stored := *a
+	stored.UserAccessToken = ""
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RawActivity{
+		ID:          a.ID(),
+		StartTime:   time.Unix(a.StartTimeInSeconds, 0).UTC(),
+		ElapsedTime: a.summary().ElapsedTime,
+		UTCOffset:   a.StartTimeOffsetInSeconds,
+		Data:        data,
+	}, nil
+}
+
+// ID returns the provider activity ID of the activity of the file.
+func (f *GarminActivityFile) ID() string {
+	return strconv.FormatInt(f.ActivityID, 10)
+}
+
+// Format returns the format of the file.
+func (f *GarminActivityFile) Format() (FileFormat, error) {
+	format := FileFormat(strings.ToLower(f.FileType))
+
+	switch format {
+	case FileFormatFIT, FileFormatGPX, FileFormatTCX:
+		return format, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedFileFormat, f.FileType)
+	}
+}
+
+// ToActivity returns the activity of a file whose summary wasn't pushed yet.
+// The summary replaces it when it arrives.
+func (f *GarminActivityFile) ToActivity(file *FileActivity) *GarminActivity {
+	act := &GarminActivity{
+		UserID:             f.UserID,
+		ActivityID:         f.ActivityID,
+		ActivityName:       f.ActivityName,
+		StartTimeInSeconds: f.StartTimeInSeconds,
+		Manual:             f.Manual,
+	}
+
+	if act.ActivityName == "" {
+		act.ActivityName = file.Name
+	}
+
+	act.SetFile(file)
+
+	return act
+}
+
+// GarminClient calls the Garmin OAuth1 and Health APIs.
+//
+// The base URLs can be overridden with the GARMIN_*_BASE_URL secrets, e.g. to point to the stand-in server of
+// `vo2 provider garmin fake-server`.
+type GarminClient struct {
+	signer         oauth1Signer
+	httpClient     *http.Client
+	authBaseURL    string
+	connectBaseURL string
+	apiBaseURL     string
+}
+
+func NewGarminClient() *GarminClient {
+	return &GarminClient{
+		signer: oauth1Signer{
+			consumerKey:    util.GetSecret("GARMIN_CONSUMER_KEY", true),
+			consumerSecret: util.GetSecret("GARMIN_CONSUMER_SECRET", true),
+		},
+		httpClient:     &http.Client{Timeout: garminRequestTimeout},
+		authBaseURL:    garminBaseURL("GARMIN_AUTH_BASE_URL", garminDefaultAuthBaseURL),
+		connectBaseURL: garminBaseURL("GARMIN_CONNECT_BASE_URL", garminDefaultConnectBaseURL),
+		apiBaseURL:     garminBaseURL("GARMIN_API_BASE_URL", garminDefaultAPIBaseURL),
+	}
+}
+
+func garminBaseURL(key, defaultURL string) string {
+	baseURL := util.GetSecret(key, false)
+	if baseURL == "" {
+		return defaultURL
+	}
+
+	return strings.TrimRight(baseURL, "/")
+}
+
+// RequestToken obtains a request token, the first step of the OAuth1 flow.
+// Garmin redirects the athlete to callbackURL once they authorize the application.
+func (c *GarminClient) RequestToken(ctx context.Context, callbackURL string) (*OAuth1Token, error) {
+	return c.token(ctx, c.authBaseURL+garminRequestTokenPath, nil, map[string]string{"oauth_callback": callbackURL})
+}
+
+// AuthorizationURL returns the URL where the athlete authorizes the request token.
+func (c *GarminClient) AuthorizationURL(requestToken *OAuth1Token) string {
+	return c.connectBaseURL + garminAuthorizePath + "?" + url.Values{"oauth_token": {requestToken.Token}}.Encode()
+}
+
+// AccessToken exchanges an authorized request token for an access token.
+func (c *GarminClient) AccessToken(ctx context.Context, requestToken *OAuth1Token, verifier string) (*OAuth1Token, error) {
+	return c.token(ctx, c.authBaseURL+garminAccessTokenPath, requestToken, map[string]string{"oauth_verifier": verifier})
+}
+
+// UserID returns the Garmin ID of the user of the token. It's the ID of the user in the push notifications.
+func (c *GarminClient) UserID(ctx context.Context, token *OAuth1Token) (string, error) {
+	body, err := c.do(ctx, http.MethodGet, c.apiBaseURL+garminUserIDPath, token, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		UserID string `json:"userId"`
+	}
+
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.UserID == "" {
+		return "", fmt.Errorf("%w: missing userId", ErrGarminRequestFailed)
+	}
+
+	return resp.UserID, nil
+}
+
+// DeleteUserRegistration deregisters the user of the token, revoking the access of the application to its data.
+// Garmin stops sending the push notifications of the user.
+func (c *GarminClient) DeleteUserRegistration(ctx context.Context, token *OAuth1Token) error {
+	_, err := c.do(ctx, http.MethodDelete, c.apiBaseURL+garminUserRegistrationPath, token, nil)
+	return err
+}
+
+// DownloadActivityFile downloads the file of an activity file notification.
+//
+// Only the callback URLs of the Garmin API are downloaded, since they are signed with the token of the user.
+func (c *GarminClient) DownloadActivityFile(ctx context.Context, token *OAuth1Token, callbackURL string) ([]byte, error) {
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidGarminCallbackURL, err)
+	}
+
+	apiURL, err := url.Parse(c.apiBaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != apiURL.Scheme || u.Host != apiURL.Host {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidGarminCallbackURL, callbackURL)
+	}
+
+	return c.do(ctx, http.MethodGet, callbackURL, token, nil)
+}
+
+func (c *GarminClient) token(ctx context.Context, endpoint string, token *OAuth1Token, params map[string]string) (*OAuth1Token, error) {
+	body, err := c.do(ctx, http.MethodPost, endpoint, token, params)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrGarminRequestFailed, err)
+	}
+
+	t := &OAuth1Token{
+		Token:       values.Get("oauth_token"),
+		TokenSecret: values.Get("oauth_token_secret"),
+	}
+
+	if t.Token == "" || t.TokenSecret == "" {
+		return nil, fmt.Errorf("%w: missing token in response", ErrGarminRequestFailed)
+	}
+
+	return t, nil
+}
+
+func (c *GarminClient) do(ctx context.Context, method, endpoint string, token *OAuth1Token, params map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.signer.sign(req, token, params)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return nil, fmt.Errorf("%w: %s %s: %d %s", ErrGarminRequestFailed, method, req.URL.Path, resp.StatusCode, body)
+	}
+
+	return body, nil
+}
+
+func garminSport(activityType string) stride.Sport {
+	switch activityType {
+	case "RUNNING", "INDOOR_RUNNING", "STREET_RUNNING", "TRACK_RUNNING", "TREADMILL_RUNNING", "VIRTUAL_RUN":
+		return stride.SportRunning
+	case "TRAIL_RUNNING", "ULTRA_RUN":
+		return stride.SportTrailRunning
+	case "CYCLING", "ROAD_BIKING", "INDOOR_CYCLING", "MOUNTAIN_BIKING", "CYCLOCROSS", "TRACK_CYCLING", "VIRTUAL_RIDE":
+		return stride.SportCycling
+	case "GRAVEL_CYCLING":
+		return stride.SportGravelCycling
+	case "HIKING":
+		return stride.SportHiking
+	case "LAP_SWIMMING", "OPEN_WATER_SWIMMING":
+		return stride.SportSwimming
+	case "ELLIPTICAL":
+		return stride.SportElliptical
+	case "STAIR_CLIMBING":
+		return stride.SportStairStepper
+	case "INLINE_SKATING":
+		return stride.SportInlineSkating
+	case "KAYAKING":
+		return stride.SportKayaking
+	case "ROCK_CLIMBING", "INDOOR_CLIMBING", "BOULDERING":
+		return stride.SportRockClimbing
+	case "SURFING":
+		return stride.SportSurfing
+	default:
+		return stride.SportUnknown
+	}
+}
+
+// garminProvider is the provider of the activities of the Garmin Health API.
+//
+// Garmin pushes activities to the API instead of being polled, and OAuth1 tokens don't expire, so the fetch
+// and refresh methods are not supported.
+type garminProvider struct{}
+
+func (p *garminProvider) Slug() string {
+	return string(ProviderGarmin)
+}
+
+func (p *garminProvider) RefreshToken(ctx context.Context, refreshToken string) (*OAuth2Token, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *garminProvider) Deauthorize(ctx context.Context, accessToken string) error {
+	return ErrNotSupported
+}
+
+func (p *garminProvider) FetchActivitySummaries(ctx context.Context, accessToken string, startTime, endTime time.Time) ([]ActivitySummary, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *garminProvider) FetchActivity(ctx context.Context, accessToken string, activityID string) (*RawActivity, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *garminProvider) ActivityChanged(summary ActivitySummary, raw *activity.ProviderActivityRawData) (bool, error) {
+	return false, ErrNotSupported
+}
+
+func (p *garminProvider) FetchActivityStreams(ctx context.Context, accessToken string, activityID string) (stride.ActivityTimeseriesConvertible, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *garminProvider) NewActivityStreams() stride.ActivityTimeseriesConvertible {
+	return &fileStreams{}
+}
+
+func (p *garminProvider) DecodeActivity(raw *activity.ProviderActivityRawData) (stride.ActivityConvertible, error) {
+	var act GarminActivity
+	err := json.Unmarshal(raw.Data, &act)
+	if err != nil {
+		return nil, err
+	}
+
+	return &act, nil
+}
+
+func (p *garminProvider) ToEnduranceActivity(raw *activity.ProviderActivityRawData) (*activity.EnduranceActivity, error) {
+	var act GarminActivity
+	err := json.Unmarshal(raw.Data, &act)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := act.summary()
+
+	enduranceActivity, err := summary.toEnduranceActivity(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	enduranceActivity.Description = act.ActivityDescription
+
+	if act.AverageSpeedInMetersPerSecond > 0 {
+		enduranceActivity.AvgSpeed = act.AverageSpeedInMetersPerSecond
+	}
+
+	enduranceActivity.AvgHR = int16(act.AverageHeartRateInBeatsPerMinute)
+	enduranceActivity.MaxHR = int16(act.MaxHeartRateInBeatsPerMinute)
+
+	return enduranceActivity, nil
+}
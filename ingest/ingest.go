@@ -0,0 +1,130 @@
+// Package ingest defines how activities are pulled from the providers.
+//
+// Every provider implements the Provider interface and registers itself by slug, the same slug stored in
+// provider.Provider.Slug, so that the ingestion pipeline doesn't depend on any specific provider.
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrieleangeletti/stride"
+	"github.com/gabrieleangeletti/vo2/activity"
+	"github.com/gabrieleangeletti/vo2/database"
+	"github.com/gabrieleangeletti/vo2/provider"
+)
+
+// Provider is a source of activities.
+type Provider interface {
+	// Slug returns the slug of the provider, as stored in provider.Provider.Slug.
+	Slug() string
+	// RefreshToken exchanges a refresh token for a new access token.
+	RefreshToken(ctx context.Context, refreshToken string) (*OAuth2Token, error)
+	// Deauthorize revokes the access of the application to the data of the athlete of an access token.
+	Deauthorize(ctx context.Context, accessToken string) error
+	// FetchActivitySummaries returns the activities started in the given time range.
+	FetchActivitySummaries(ctx context.Context, accessToken string, startTime, endTime time.Time) ([]ActivitySummary, error)
+	// FetchActivity returns the details of an activity, as they are stored in the raw data.
+	FetchActivity(ctx context.Context, accessToken string, activityID string) (*RawActivity, error)
+	// ActivityChanged reports whether an activity was changed in the provider after its raw data was stored.
+	ActivityChanged(summary ActivitySummary, raw *activity.ProviderActivityRawData) (bool, error)
+	// FetchActivityStreams returns the timeseries of an activity.
+	FetchActivityStreams(ctx context.Context, accessToken string, activityID string) (stride.ActivityTimeseriesConvertible, error)
+	// NewActivityStreams returns an empty streams value, to unmarshal stored raw streams into.
+	NewActivityStreams() stride.ActivityTimeseriesConvertible
+	// DecodeActivity decodes the details stored in the raw data.
+	DecodeActivity(raw *activity.ProviderActivityRawData) (stride.ActivityConvertible, error)
+	// ToEnduranceActivity converts the raw data to an endurance activity.
+	// It returns stride.ErrActivityIsNotEndurance for activities that are not endurance activities.
+	ToEnduranceActivity(raw *activity.ProviderActivityRawData) (*activity.EnduranceActivity, error)
+}
+
+type OAuth2Token struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// ActivitySummary identifies an activity in a provider.
+type ActivitySummary struct {
+	ID        string
+	StartTime time.Time
+	// Data is the summary as returned by the provider.
+	Data json.RawMessage
+}
+
+// RawActivity holds the details of an activity, as returned by the provider.
+type RawActivity struct {
+	ID           string
+	StartTime    time.Time
+	ElapsedTime  int
+	IanaTimezone string
+	// UTCOffset is the offset in seconds of the local time of the activity, for providers without timezones.
+	UTCOffset int
+	Data      json.RawMessage
+}
+
+// ToProviderActivityRawData returns the raw data row of the activity.
+func (a *RawActivity) ToProviderActivityRawData(providerID int, athleteID uuid.UUID) *activity.ProviderActivityRawData {
+	return &activity.ProviderActivityRawData{
+		ProviderID:         providerID,
+		AthleteID:          athleteID,
+		ProviderActivityID: a.ID,
+		StartTime:          a.StartTime,
+		ElapsedTime:        a.ElapsedTime,
+		IanaTimezone:       database.ToNullString(a.IanaTimezone),
+		UTCOffset:          database.ToNullInt32(a.UTCOffset),
+		Data:               a.Data,
+	}
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Provider)
+)
+
+// Register makes a provider available by its slug. It panics if a provider with the same slug is already registered.
+func Register(p Provider) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[p.Slug()]; ok {
+		panic(fmt.Sprintf("ingest: provider %s registered twice", p.Slug()))
+	}
+
+	registry[p.Slug()] = p
+}
+
+// Get returns the provider registered with the given slug.
+func Get(slug string) (Provider, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	p, ok := registry[slug]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", provider.ErrUnsupportedProvider, slug)
+	}
+
+	return p, nil
+}
+
+// Slugs returns the slugs of the registered providers.
+func Slugs() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	slugs := make([]string, 0, len(registry))
+	for slug := range registry {
+		slugs = append(slugs, slug)
+	}
+
+	sort.Strings(slugs)
+
+	return slugs
+}
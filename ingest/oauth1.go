@@ -0,0 +1,200 @@
+package ingest
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidOAuth1Signature = errors.New("invalid OAuth1 signature")
+)
+
+// OAuth1Token is an OAuth 1.0a token. Unlike OAuth2 tokens, OAuth1 tokens don't expire.
+type OAuth1Token struct {
+	Token       string
+	TokenSecret string
+}
+
+// oauth1Signer signs requests with OAuth 1.0a HMAC-SHA1.
+type oauth1Signer struct {
+	consumerKey    string
+	consumerSecret string
+}
+
+// sign sets the Authorization header of the request. params are additional oauth_* parameters, e.g. oauth_callback.
+// Request bodies are not signed, only the query parameters are.
+func (s *oauth1Signer) sign(req *http.Request, token *OAuth1Token, params map[string]string) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	oauthParams := map[string]string{
+		"oauth_consumer_key":     s.consumerKey,
+		"oauth_nonce":            hex.EncodeToString(nonce),
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
+		"oauth_version":          "1.0",
+	}
+
+	for k, v := range params {
+		oauthParams[k] = v
+	}
+
+	tokenSecret := ""
+	if token != nil {
+		oauthParams["oauth_token"] = token.Token
+		tokenSecret = token.TokenSecret
+	}
+
+	oauthParams["oauth_signature"] = oauth1Signature(req.Method, req.URL, oauthParams, s.consumerSecret, tokenSecret)
+
+	keys := make([]string, 0, len(oauthParams))
+	for k := range oauthParams {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, oauth1Escape(k), oauth1Escape(oauthParams[k])))
+	}
+
+	req.Header.Set("Authorization", "OAuth "+strings.Join(parts, ", "))
+
+	return nil
+}
+
+// ParseOAuth1Header returns the oauth_* parameters of the Authorization header of a request.
+func ParseOAuth1Header(req *http.Request) (map[string]string, error) {
+	header, ok := strings.CutPrefix(req.Header.Get("Authorization"), "OAuth ")
+	if !ok {
+		return nil, fmt.Errorf("%w: missing OAuth Authorization header", ErrInvalidOAuth1Signature)
+	}
+
+	params := make(map[string]string)
+
+	for part := range strings.SplitSeq(header, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: malformed parameter %q", ErrInvalidOAuth1Signature, part)
+		}
+
+		v, err := url.PathUnescape(strings.Trim(v, `"`))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidOAuth1Signature, err)
+		}
+
+		params[k] = v
+	}
+
+	return params, nil
+}
+
+// VerifyOAuth1Request checks the HMAC-SHA1 signature of a request signed with the given secrets.
+// tokenSecret is empty for the requests that obtain a request token.
+func VerifyOAuth1Request(req *http.Request, consumerSecret, tokenSecret string) error {
+	params, err := ParseOAuth1Header(req)
+	if err != nil {
+		return err
+	}
+
+	signature, ok := params["oauth_signature"]
+	if !ok {
+		return fmt.Errorf("%w: missing oauth_signature", ErrInvalidOAuth1Signature)
+	}
+	delete(params, "oauth_signature")
+
+	expected := oauth1Signature(req.Method, req.URL, params, consumerSecret, tokenSecret)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrInvalidOAuth1Signature
+	}
+
+	return nil
+}
+
+// oauth1Signature returns the signature of a request, as described in RFC 5849, section 3.4.
+func oauth1Signature(method string, u *url.URL, oauthParams map[string]string, consumerSecret, tokenSecret string) string {
+	mac := hmac.New(sha1.New, []byte(oauth1Escape(consumerSecret)+"&"+oauth1Escape(tokenSecret)))
+	mac.Write([]byte(oauth1BaseString(method, u, oauthParams)))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// oauth1BaseString returns the signature base string of a request, as described in RFC 5849, section 3.4.1.
+func oauth1BaseString(method string, u *url.URL, oauthParams map[string]string) string {
+	type param struct{ key, value string }
+
+	var params []param
+
+	for k, values := range u.Query() {
+		for _, v := range values {
+			params = append(params, param{oauth1Escape(k), oauth1Escape(v)})
+		}
+	}
+
+	for k, v := range oauthParams {
+		params = append(params, param{oauth1Escape(k), oauth1Escape(v)})
+	}
+
+	// Parameters are sorted by encoded name, then by encoded value. Sorting the joined pairs would put "a-b=1"
+	// before "a=1", as '-' sorts before '='.
+	sort.Slice(params, func(i, j int) bool {
+		if params[i].key != params[j].key {
+			return params[i].key < params[j].key
+		}
+		return params[i].value < params[j].value
+	})
+
+	pairs := make([]string, 0, len(params))
+	for _, p := range params {
+		pairs = append(pairs, p.key+"="+p.value)
+	}
+
+	baseURL := *u
+	baseURL.RawQuery = ""
+	baseURL.ForceQuery = false
+	baseURL.Fragment = ""
+	baseURL.User = nil
+	baseURL.Scheme = strings.ToLower(baseURL.Scheme)
+	baseURL.Host = strings.ToLower(baseURL.Host)
+
+	// Default ports are not part of the base string URI.
+	if port := baseURL.Port(); (baseURL.Scheme == "http" && port == "80") || (baseURL.Scheme == "https" && port == "443") {
+		baseURL.Host = strings.TrimSuffix(baseURL.Host, ":"+port)
+	}
+
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		oauth1Escape(baseURL.String()),
+		oauth1Escape(strings.Join(pairs, "&")),
+	}, "&")
+}
+
+// oauth1Escape percent-encodes a value as described in RFC 5849, section 3.6.
+func oauth1Escape(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') || c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,152 @@
+package ingest
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("failed to parse URL %q: %v", rawURL, err)
+	}
+
+	return u
+}
+
+// TestOAuth1BaseString checks the base string of RFC 5849, section 3.4.1.1. The signer doesn't sign request bodies,
+// so the body parameters of the example (c2 and a3=2 q) are in the query instead: the base string is the same.
+func TestOAuth1BaseString(t *testing.T) {
+	u := mustParseURL(t, "http://EXAMPLE.COM:80/request?b5=%3D%253D&a3=a&c%40=&a2=r%20b&c2&a3=2+q")
+
+	params := map[string]string{
+		"oauth_consumer_key":     "9djdj82h48djs9d2",
+		"oauth_token":            "kkk9d7dh3k39sjv7",
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        "137131201",
+		"oauth_nonce":            "7d8f3e4a",
+	}
+
+	want := "POST&http%3A%2F%2Fexample.com%2Frequest&a2%3Dr%2520b%26a3%3D2%2520q%26a3%3Da%26b5%3D%253D%25253D%26c%2540%3D%26c2%3D%26oauth_consumer_key%3D9djdj82h48djs9d2%26oauth_nonce%3D7d8f3e4a%26oauth_signature_method%3DHMAC-SHA1%26oauth_timestamp%3D137131201%26oauth_token%3Dkkk9d7dh3k39sjv7"
+
+	got := oauth1BaseString(http.MethodPost, u, params)
+	if got != want {
+		t.Errorf("got base string\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestOAuth1BaseStringNormalization(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		rawURL string
+		want   string
+	}{
+		{
+			name:   "names sort before values",
+			rawURL: "http://example.com/r?a-b=1&a=2",
+			want:   "GET&http%3A%2F%2Fexample.com%2Fr&a%3D2%26a-b%3D1",
+		},
+		{
+			name:   "same name sorts by value",
+			rawURL: "http://example.com/r?a=z&a=b",
+			want:   "GET&http%3A%2F%2Fexample.com%2Fr&a%3Db%26a%3Dz",
+		},
+		{
+			name:   "default http port",
+			rawURL: "http://example.com:80/r",
+			want:   "GET&http%3A%2F%2Fexample.com%2Fr&",
+		},
+		{
+			name:   "default https port",
+			rawURL: "HTTPS://Example.com:443/r",
+			want:   "GET&https%3A%2F%2Fexample.com%2Fr&",
+		},
+		{
+			name:   "other port",
+			rawURL: "https://example.com:8080/r",
+			want:   "GET&https%3A%2F%2Fexample.com%3A8080%2Fr&",
+		},
+		{
+			name:   "fragment",
+			rawURL: "http://example.com/r#section",
+			want:   "GET&http%3A%2F%2Fexample.com%2Fr&",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := oauth1BaseString(http.MethodGet, mustParseURL(t, tc.rawURL), nil)
+			if got != tc.want {
+				t.Errorf("got base string %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestOAuth1Signature checks the signatures of the examples of RFC 5849, section 1.2.
+func TestOAuth1Signature(t *testing.T) {
+	const (
+		consumerKey    = "dpf43f3p2l4k3l03"
+		consumerSecret = "kd94hf93k423kf44"
+	)
+
+	for _, tc := range []struct {
+		name        string
+		method      string
+		rawURL      string
+		params      map[string]string
+		tokenSecret string
+		want        string
+	}{
+		{
+			name:   "temporary credentials",
+			method: http.MethodPost,
+			rawURL: "https://photos.example.net/initiate",
+			params: map[string]string{
+				"oauth_consumer_key":     consumerKey,
+				"oauth_signature_method": "HMAC-SHA1",
+				"oauth_timestamp":        "137131200",
+				"oauth_nonce":            "wIjqoS",
+				"oauth_callback":         "http://printer.example.com/ready",
+			},
+			want: "74KNZJeDHnMBp0EMJ9ZHt/XKycU=",
+		},
+		{
+			name:   "token credentials",
+			method: http.MethodPost,
+			rawURL: "https://photos.example.net/token",
+			params: map[string]string{
+				"oauth_consumer_key":     consumerKey,
+				"oauth_token":            "hh5s93j4hdidpola",
+				"oauth_signature_method": "HMAC-SHA1",
+				"oauth_timestamp":        "137131201",
+				"oauth_nonce":            "walatlh",
+				"oauth_verifier":         "hfdp7dh39dks9884",
+			},
+			tokenSecret: "hdhd0244k9j7ao03",
+			want:        "gKgrFCywp7rO0OXSjdot/IHF7IU=",
+		},
+		{
+			name:   "protected resource",
+			method: http.MethodGet,
+			rawURL: "http://photos.example.net/photos?file=vacation.jpg&size=original",
+			params: map[string]string{
+				"oauth_consumer_key":     consumerKey,
+				"oauth_token":            "nnch734d00sl2jdk",
+				"oauth_signature_method": "HMAC-SHA1",
+				"oauth_timestamp":        "137131202",
+				"oauth_nonce":            "chapoH",
+			},
+			tokenSecret: "pfkkdhi9sl3r4s00",
+			want:        "MdpQcU8iPSUjWoN/UDMsK2sui9I=",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := oauth1Signature(tc.method, mustParseURL(t, tc.rawURL), tc.params, consumerSecret, tc.tokenSecret)
+			if got != tc.want {
+				t.Errorf("got signature %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabrieleangeletti/stride"
+	"github.com/gabrieleangeletti/stride/strava"
+	"github.com/gabrieleangeletti/vo2/activity"
+	"github.com/gabrieleangeletti/vo2/util"
+)
+
+const (
+	stravaSummariesPageSize = 200
+	stravaDeauthorizeURL    = "https://www.strava.com/oauth/deauthorize"
+)
+
+func init() {
+	Register(&stravaProvider{})
+}
+
+type stravaProvider struct{}
+
+func (p *stravaProvider) Slug() string {
+	return string(stride.ProviderStrava)
+}
+
+func (p *stravaProvider) auth() *strava.Auth {
+	return strava.NewAuth(
+		util.GetSecret("STRAVA_CLIENT_ID", true),
+		util.GetSecret("STRAVA_CLIENT_SECRET", true),
+	)
+}
+
+func (p *stravaProvider) RefreshToken(ctx context.Context, refreshToken string) (*OAuth2Token, error) {
+	tokenResponse, err := p.auth().RefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	token := OAuth2Token{
+		AccessToken:  tokenResponse.AccessToken,
+		RefreshToken: tokenResponse.RefreshToken,
+		ExpiresAt:    time.Unix(int64(tokenResponse.ExpiresAt), 0),
+	}
+
+	return &token, nil
+}
+
+// Deauthorize revokes the token, its refresh token and all the other tokens of the athlete. Strava sends an athlete
+// event with "authorized": "false" to the webhook afterwards. A token that was revoked already is not an error.
+func (p *stravaProvider) Deauthorize(ctx context.Context, accessToken string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, stravaDeauthorizeURL, strings.NewReader(url.Values{"access_token": {accessToken}}.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to deauthorize strava athlete: %d %s", resp.StatusCode, body)
+	}
+
+	return nil
+}
+
+func (p *stravaProvider) FetchActivitySummaries(ctx context.Context, accessToken string, startTime, endTime time.Time) ([]ActivitySummary, error) {
+	client := strava.NewClient(accessToken)
+
+	var summaries []ActivitySummary
+
+	for page := 1; ; page++ {
+		pageActivities, err := client.GetActivitySummaries(startTime, endTime, page)
+		if err != nil {
+			// A partial list would look complete to the caller, so the whole range fails, e.g. with
+			// stride.ErrRateLimitExceeded, and is fetched again later.
+			return nil, fmt.Errorf("failed to get activity summaries page %d: %w", page, err)
+		}
+
+		for _, act := range pageActivities {
+			data, err := json.Marshal(act)
+			if err != nil {
+				return nil, err
+			}
+
+			summaries = append(summaries, ActivitySummary{
+				ID:        strconv.FormatInt(act.ID, 10),
+				StartTime: act.StartDate,
+				Data:      data,
+			})
+		}
+
+		if len(pageActivities) < stravaSummariesPageSize {
+			break
+		}
+	}
+
+	return summaries, nil
+}
+
+func (p *stravaProvider) FetchActivity(ctx context.Context, accessToken string, activityID string) (*RawActivity, error) {
+	id, err := parseStravaActivityID(activityID)
+	if err != nil {
+		return nil, err
+	}
+
+	act, err := strava.NewClient(accessToken).GetActivity(id, false)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(act)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RawActivity{
+		ID:           strconv.FormatInt(act.ID, 10),
+		StartTime:    act.StartDate,
+		ElapsedTime:  act.ElapsedTime,
+		IanaTimezone: act.IanaTimezone(),
+		Data:         data,
+	}, nil
+}
+
+// stravaEditableFields are the fields of an activity that the athlete can change after uploading it. They are in both
+// the summaries and the detailed activities.
+type stravaEditableFields struct {
+	Name               string           `json:"name"`
+	SportType          strava.SportType `json:"sport_type"`
+	Distance           float64          `json:"distance"`
+	MovingTime         int              `json:"moving_time"`
+	ElapsedTime        int              `json:"elapsed_time"`
+	TotalElevationGain float64          `json:"total_elevation_gain"`
+	WorkoutType        int              `json:"workout_type"`
+	Trainer            bool             `json:"trainer"`
+	Commute            bool             `json:"commute"`
+	Private            bool             `json:"private"`
+	GearID             string           `json:"gear_id"`
+}
+
+// ActivityChanged compares the editable fields of the summary and of the stored activity, since Strava doesn't
+// report when an activity was last updated.
+func (p *stravaProvider) ActivityChanged(summary ActivitySummary, raw *activity.ProviderActivityRawData) (bool, error) {
+	var current, stored stravaEditableFields
+
+	err := json.Unmarshal(summary.Data, &current)
+	if err != nil {
+		return false, err
+	}
+
+	err = json.Unmarshal(raw.Data, &stored)
+	if err != nil {
+		return false, err
+	}
+
+	return current != stored, nil
+}
+
+func (p *stravaProvider) FetchActivityStreams(ctx context.Context, accessToken string, activityID string) (stride.ActivityTimeseriesConvertible, error) {
+	id, err := parseStravaActivityID(activityID)
+	if err != nil {
+		return nil, err
+	}
+
+	streams, err := strava.NewClient(accessToken).GetActivityStreams(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return streams, nil
+}
+
+func (p *stravaProvider) NewActivityStreams() stride.ActivityTimeseriesConvertible {
+	return &strava.ActivityStream{}
+}
+
+func (p *stravaProvider) DecodeActivity(raw *activity.ProviderActivityRawData) (stride.ActivityConvertible, error) {
+	var act strava.ActivityDetailed
+	err := json.Unmarshal(raw.Data, &act)
+	if err != nil {
+		return nil, err
+	}
+
+	return &act, nil
+}
+
+func (p *stravaProvider) ToEnduranceActivity(raw *activity.ProviderActivityRawData) (*activity.EnduranceActivity, error) {
+	var act strava.ActivityDetailed
+	err := json.Unmarshal(raw.Data, &act)
+	if err != nil {
+		return nil, err
+	}
+
+	isEndurance, err := act.IsEnduranceActivity()
+	if err != nil {
+		return nil, err
+	}
+
+	if !isEndurance {
+		return nil, stride.ErrActivityIsNotEndurance
+	}
+
+	sport, err := act.Sport()
+	if err != nil {
+		return nil, err
+	}
+
+	var utcOffset *int32
+	if raw.UTCOffset.Valid {
+		utcOffset = &raw.UTCOffset.Int32
+	}
+
+	var elevGain *int32
+	if act.TotalElevationGain > 0 {
+		gain := int32(act.TotalElevationGain)
+		elevGain = &gain
+	}
+
+	enduranceActivity := &activity.EnduranceActivity{
+		ProviderID:            raw.ProviderID,
+		AthleteID:             raw.AthleteID,
+		ProviderRawActivityID: raw.ID,
+		Name:                  act.Name,
+		Description:           act.Description,
+		Sport:                 sport,
+		StartTime:             raw.StartTime,
+		EndTime:               raw.StartTime.Add(time.Duration(raw.ElapsedTime) * time.Second),
+		IanaTimezone:          raw.IanaTimezone.String,
+		UTCOffset:             utcOffset,
+		ElapsedTime:           act.ElapsedTime,
+		MovingTime:            act.MovingTime,
+		Distance:              int(act.Distance),
+		AvgSpeed:              act.AverageSpeed,
+		ElevGain:              elevGain,
+	}
+
+	summaryPolyline := act.SummaryPolyline()
+	if summaryPolyline != "" {
+		enduranceActivity.SummaryPolyline = summaryPolyline
+
+		wkt, err := stride.PolylineToWKT(summaryPolyline)
+		if err != nil {
+			return nil, err
+		}
+
+		enduranceActivity.SummaryRoute = wkt
+	}
+
+	return enduranceActivity, nil
+
+}
+
+func parseStravaActivityID(activityID string) (int64, error) {
+	id, err := strconv.ParseInt(activityID, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Strava activity ID %s: %w", activityID, err)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,380 @@
+package ingest
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabrieleangeletti/stride"
+	"github.com/gabrieleangeletti/stride/strava"
+)
+
+const (
+	stravaArchiveActivitiesFile = "activities.csv"
+)
+
+var (
+	ErrInvalidStravaArchive = errors.New("invalid Strava archive")
+)
+
+// stravaArchiveDateLayouts are the layouts of the "Activity Date" column, which depend on the locale of the account.
+var stravaArchiveDateLayouts = []string{
+	"Jan 2, 2006, 3:04:05 PM",
+	"2 Jan 2006, 15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// StravaArchive is the archive produced by Strava's "download your data".
+//
+// It contains activities.csv, with a row per activity, and the files of the activities in the activities directory,
+// e.g. activities/1234567890.fit.gz.
+type StravaArchive struct {
+	zr    *zip.ReadCloser
+	files map[string]*zip.File
+}
+
+// StravaArchiveActivity is an activity listed in the activities.csv file of a Strava archive.
+type StravaArchiveActivity struct {
+	ID            int64
+	StartTime     time.Time
+	Name          string
+	Type          string
+	Description   string
+	ElapsedTime   int
+	MovingTime    int
+	Distance      float64
+	ElevationGain float64
+	MaxSpeed      float64
+	AverageSpeed  float64
+	Commute       bool
+	// Filename is the path of the activity file in the archive, empty for manual activities.
+	Filename string
+}
+
+// OpenStravaArchive opens a Strava archive zip file.
+func OpenStravaArchive(name string) (*StravaArchive, error) {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidStravaArchive, err)
+	}
+
+	a := &StravaArchive{
+		zr:    zr,
+		files: make(map[string]*zip.File, len(zr.File)),
+	}
+
+	for _, f := range zr.File {
+		a.files[f.Name] = f
+	}
+
+	return a, nil
+}
+
+// Close closes the archive.
+func (a *StravaArchive) Close() error {
+	return a.zr.Close()
+}
+
+// Activities returns the activities listed in activities.csv.
+func (a *StravaArchive) Activities() ([]StravaArchiveActivity, error) {
+	f, ok := a.files[stravaArchiveActivitiesFile]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing %s", ErrInvalidStravaArchive, stravaArchiveActivitiesFile)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	r := csv.NewReader(rc)
+	// Rows have a different number of fields depending on when the activity was recorded.
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidStravaArchive, err)
+	}
+
+	// Some columns appear twice: the first one is formatted in the units of the account, the last one is in
+	// seconds or meters. Keeping the last index of every column picks the latter.
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+
+	if _, ok := columns["Activity ID"]; !ok {
+		return nil, fmt.Errorf("%w: missing Activity ID column", ErrInvalidStravaArchive)
+	}
+
+	var activities []StravaArchiveActivity
+
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, fmt.Errorf("%w: line %d: %w", ErrInvalidStravaArchive, line, err)
+		}
+
+		row := stravaArchiveRow{columns: columns, record: record}
+
+		act, err := row.toActivity()
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrInvalidStravaArchive, line, err)
+		}
+
+		activities = append(activities, act)
+	}
+
+	return activities, nil
+}
+
+// ReadFile returns the content of a file of the archive.
+func (a *StravaArchive) ReadFile(name string) ([]byte, error) {
+	f, ok := a.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing %s", ErrInvalidStravaArchive, name)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+type stravaArchiveRow struct {
+	columns map[string]int
+	record  []string
+}
+
+func (r stravaArchiveRow) get(column string) string {
+	i, ok := r.columns[column]
+	if !ok || i >= len(r.record) {
+		return ""
+	}
+
+	return strings.TrimSpace(r.record[i])
+}
+
+func (r stravaArchiveRow) float(column string) (float64, error) {
+	v := r.get(column)
+	if v == "" {
+		return 0, nil
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", column, v, err)
+	}
+
+	return f, nil
+}
+
+func (r stravaArchiveRow) toActivity() (StravaArchiveActivity, error) {
+	id, err := strconv.ParseInt(r.get("Activity ID"), 10, 64)
+	if err != nil {
+		return StravaArchiveActivity{}, fmt.Errorf("invalid Activity ID: %w", err)
+	}
+
+	startTime, err := parseStravaArchiveDate(r.get("Activity Date"))
+	if err != nil {
+		return StravaArchiveActivity{}, err
+	}
+
+	act := StravaArchiveActivity{
+		ID:          id,
+		StartTime:   startTime,
+		Name:        r.get("Activity Name"),
+		Type:        r.get("Activity Type"),
+		Description: r.get("Activity Description"),
+		Commute:     r.get("Commute") == "true",
+		Filename:    r.get("Filename"),
+	}
+
+	floats := []struct {
+		column string
+		dst    *float64
+	}{
+		{"Distance", &act.Distance},
+		{"Elevation Gain", &act.ElevationGain},
+		{"Max Speed", &act.MaxSpeed},
+		{"Average Speed", &act.AverageSpeed},
+	}
+
+	for _, f := range floats {
+		*f.dst, err = r.float(f.column)
+		if err != nil {
+			return StravaArchiveActivity{}, err
+		}
+	}
+
+	elapsed, err := r.float("Elapsed Time")
+	if err != nil {
+		return StravaArchiveActivity{}, err
+	}
+	act.ElapsedTime = int(elapsed)
+
+	moving, err := r.float("Moving Time")
+	if err != nil {
+		return StravaArchiveActivity{}, err
+	}
+	act.MovingTime = int(moving)
+
+	return act, nil
+}
+
+func parseStravaArchiveDate(v string) (time.Time, error) {
+	for _, layout := range stravaArchiveDateLayouts {
+		t, err := time.Parse(layout, v)
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid Activity Date %q", v)
+}
+
+// SportType returns the Strava sport type of the activity. The archive has display names, e.g. "Trail Run" for
+// TrailRun and "E-Bike Ride" for EBikeRide.
+func (a StravaArchiveActivity) SportType() strava.SportType {
+	return strava.SportType(strings.NewReplacer(" ", "", "-", "").Replace(a.Type))
+}
+
+// ToRawActivity returns the raw activity, in the same format as the activities fetched from the Strava API,
+// and the streams of the activity file, if any.
+//
+// The rows use the Strava activity IDs, so webhook updates of imported activities replace the imported data
+// with the one from the API. The archive has no timezone, imported activities have none until then.
+func (a StravaArchiveActivity) ToRawActivity(file *FileActivity) (*RawActivity, *strava.ActivityStream, error) {
+	act := strava.ActivityDetailed{
+		ResourceState:      3,
+		ID:                 a.ID,
+		Name:               a.Name,
+		Description:        a.Description,
+		Distance:           a.Distance,
+		MovingTime:         a.MovingTime,
+		ElapsedTime:        a.ElapsedTime,
+		TotalElevationGain: a.ElevationGain,
+		Type:               string(a.SportType()),
+		SportType:          a.SportType(),
+		StartDate:          a.StartTime,
+		StartDateLocal:     a.StartTime,
+		Commute:            a.Commute,
+		Manual:             a.Filename == "",
+		AverageSpeed:       a.AverageSpeed,
+		MaxSpeed:           a.MaxSpeed,
+	}
+
+	var streams *strava.ActivityStream
+
+	if file != nil {
+		if act.Distance == 0 {
+			act.Distance = float64(file.Distance)
+		}
+
+		if act.MovingTime == 0 {
+			act.MovingTime = file.MovingTime
+		}
+
+		if act.ElapsedTime == 0 {
+			act.ElapsedTime = file.ElapsedTime
+		}
+
+		if act.TotalElevationGain == 0 {
+			act.TotalElevationGain = float64(file.ElevGain)
+		}
+
+		if act.AverageSpeed == 0 && act.MovingTime > 0 {
+			act.AverageSpeed = act.Distance / float64(act.MovingTime)
+		}
+
+		act.Map.SummaryPolyline = file.SummaryPolyline
+
+		streams = timeseriesToStravaStreams(file.Timeseries)
+
+		if n := len(streams.LatLng.Data); n > 0 {
+			act.StartLatLng = streams.LatLng.Data[0]
+			act.EndLatLng = streams.LatLng.Data[n-1]
+		}
+	}
+
+	data, err := json.Marshal(act)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw := &RawActivity{
+		ID:          strconv.FormatInt(a.ID, 10),
+		StartTime:   act.StartDate,
+		ElapsedTime: act.ElapsedTime,
+		Data:        data,
+	}
+
+	return raw, streams, nil
+}
+
+// timeseriesToStravaStreams converts a timeseries to Strava streams.
+// Streams without any valid value are left empty, the others are aligned with the time stream.
+func timeseriesToStravaStreams(ts *stride.ActivityTimeseries) *strava.ActivityStream {
+	streams := &strava.ActivityStream{}
+	if ts == nil {
+		return streams
+	}
+
+	var hasDistance, hasLatLng, hasAltitude, hasVelocity, hasHeartrate, hasCadence bool
+	for _, entry := range ts.Data {
+		hasDistance = hasDistance || entry.Distance.Valid
+		hasLatLng = hasLatLng || (entry.Latitude.Valid && entry.Longitude.Valid)
+		hasAltitude = hasAltitude || entry.Altitude.Valid
+		hasVelocity = hasVelocity || entry.Velocity.Valid
+		hasHeartrate = hasHeartrate || entry.HeartRate.Valid
+		hasCadence = hasCadence || entry.Cadence.Valid
+	}
+
+	for _, entry := range ts.Data {
+		streams.Time.Data = append(streams.Time.Data, entry.Offset)
+
+		if hasDistance {
+			streams.Distance.Data = append(streams.Distance.Data, float64(entry.Distance.Value))
+		}
+
+		if hasLatLng {
+			var latlng strava.LatLng
+			if entry.Latitude.Valid && entry.Longitude.Valid {
+				latlng = strava.LatLng{entry.Latitude.Value, entry.Longitude.Value}
+			}
+			streams.LatLng.Data = append(streams.LatLng.Data, latlng)
+		}
+
+		if hasAltitude {
+			streams.Altitude.Data = append(streams.Altitude.Data, float64(entry.Altitude.Value))
+		}
+
+		if hasVelocity {
+			streams.VelocitySmooth.Data = append(streams.VelocitySmooth.Data, float64(entry.Velocity.Value))
+		}
+
+		if hasHeartrate {
+			streams.Heartrate.Data = append(streams.Heartrate.Data, int(entry.HeartRate.Value))
+		}
+
+		if hasCadence {
+			streams.Cadence.Data = append(streams.Cadence.Data, int(entry.Cadence.Value))
+		}
+	}
+
+	return streams
+}
@@ -0,0 +1,246 @@
+package ingest
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gabrieleangeletti/stride"
+	"github.com/gabrieleangeletti/stride/strava"
+)
+
+// writeStravaArchive writes a Strava archive with the given files to a temporary directory.
+func writeStravaArchive(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	name := filepath.Join(t.TempDir(), "export.zip")
+
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+
+	for path, content := range files {
+		w, err := zw.Create(path)
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", path, err)
+		}
+
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+
+	return name
+}
+
+func readStravaArchiveActivities(t *testing.T, csv string) ([]StravaArchiveActivity, error) {
+	t.Helper()
+
+	archive, err := OpenStravaArchive(writeStravaArchive(t, map[string]string{stravaArchiveActivitiesFile: csv}))
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	t.Cleanup(func() { archive.Close() })
+
+	return archive.Activities()
+}
+
+func TestStravaArchiveActivities(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		csv  string
+		want []StravaArchiveActivity
+	}{
+		{
+			// The first Elapsed Time and Distance are in the units of the account, the last ones in seconds and meters.
+			name: "duplicate columns",
+			csv: "Activity ID,Activity Date,Activity Name,Activity Type,Elapsed Time,Distance,Commute,Filename,Elapsed Time,Moving Time,Distance,Elevation Gain,Max Speed,Average Speed\n" +
+				`1234,"Jun 1, 2025, 7:30:00 AM",Morning Run,Trail Run,0:30:00,"5,40 km",true,activities/1234.fit.gz,1800,1750.0,5400.5,48.0,4.2,3.1` + "\n",
+			want: []StravaArchiveActivity{{
+				ID:            1234,
+				StartTime:     time.Date(2025, 6, 1, 7, 30, 0, 0, time.UTC),
+				Name:          "Morning Run",
+				Type:          "Trail Run",
+				ElapsedTime:   1800,
+				MovingTime:    1750,
+				Distance:      5400.5,
+				ElevationGain: 48,
+				MaxSpeed:      4.2,
+				AverageSpeed:  3.1,
+				Commute:       true,
+				Filename:      "activities/1234.fit.gz",
+			}},
+		},
+		{
+			// Older rows have fewer fields, manual activities have no file, the header may have padded names.
+			name: "short rows",
+			csv: "Activity ID, Activity Date ,Activity Name,Activity Type,Activity Description,Elapsed Time,Filename,Distance\n" +
+				"5678,2025-06-02 18:00:00,Yoga,Yoga, Stretching ,3600,\n",
+			want: []StravaArchiveActivity{{
+				ID:          5678,
+				StartTime:   time.Date(2025, 6, 2, 18, 0, 0, 0, time.UTC),
+				Name:        "Yoga",
+				Type:        "Yoga",
+				Description: "Stretching",
+				ElapsedTime: 3600,
+			}},
+		},
+		{
+			name: "no activities",
+			csv:  "Activity ID,Activity Date\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := readStravaArchiveActivities(t, tc.csv)
+			if err != nil {
+				t.Fatalf("failed to read activities: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got activities\n%+v\nwant\n%+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStravaArchiveActivitiesInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		csv  string
+	}{
+		{name: "empty", csv: ""},
+		{name: "missing Activity ID column", csv: "Activity Date,Activity Name\n2025-06-02 18:00:00,Yoga\n"},
+		{name: "invalid Activity ID", csv: "Activity ID,Activity Date\nabc,2025-06-02 18:00:00\n"},
+		{name: "invalid date", csv: "Activity ID,Activity Date\n1,02/06/2025 18:00\n"},
+		{name: "invalid number", csv: "Activity ID,Activity Date,Distance\n1,2025-06-02 18:00:00,5.4km\n"},
+		{name: "unterminated quote", csv: "Activity ID,Activity Date\n1,\"2025-06-02 18:00:00\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := readStravaArchiveActivities(t, tc.csv)
+			if !errors.Is(err, ErrInvalidStravaArchive) {
+				t.Fatalf("got error %v, want %v", err, ErrInvalidStravaArchive)
+			}
+		})
+	}
+
+	archive, err := OpenStravaArchive(writeStravaArchive(t, map[string]string{"profile.csv": ""}))
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	defer archive.Close()
+
+	_, err = archive.Activities()
+	if !errors.Is(err, ErrInvalidStravaArchive) {
+		t.Fatalf("got error %v for an archive without activities, want %v", err, ErrInvalidStravaArchive)
+	}
+}
+
+func TestParseStravaArchiveDate(t *testing.T) {
+	want := time.Date(2025, 6, 1, 17, 5, 9, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name  string
+		value string
+		want  time.Time
+	}{
+		{name: "english", value: "Jun 1, 2025, 5:05:09 PM", want: want},
+		{name: "english morning", value: "Jun 1, 2025, 5:05:09 AM", want: want.Add(-12 * time.Hour)},
+		{name: "european", value: "1 Jun 2025, 17:05:09", want: want},
+		{name: "iso", value: "2025-06-01 17:05:09", want: want},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseStravaArchiveDate(tc.value)
+			if err != nil {
+				t.Fatalf("failed to parse date: %v", err)
+			}
+
+			if !got.Equal(tc.want) || got.Location() != time.UTC {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+
+	for _, value := range []string{"", "01/06/2025 17:05:09", "Jun 1, 2025", "1 Juni 2025, 17:05:09"} {
+		if _, err := parseStravaArchiveDate(value); err == nil {
+			t.Errorf("parsed invalid date %q", value)
+		}
+	}
+}
+
+func TestTimeseriesToStravaStreams(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ts   *stride.ActivityTimeseries
+		want *strava.ActivityStream
+	}{
+		{name: "nil", ts: nil, want: &strava.ActivityStream{}},
+		{name: "empty", ts: &stride.ActivityTimeseries{}, want: &strava.ActivityStream{}},
+		{
+			// The streams without values are left empty.
+			name: "heart rate only",
+			ts: &stride.ActivityTimeseries{Data: []stride.ActivityTimeseriesEntry{
+				{Offset: 0, HeartRate: stride.Optional[uint8]{Value: 120, Valid: true}},
+				{Offset: 1, HeartRate: stride.Optional[uint8]{Value: 125, Valid: true}},
+			}},
+			want: &strava.ActivityStream{
+				Time:      strava.StreamSet[int]{Data: []int{0, 1}},
+				Heartrate: strava.StreamSet[int]{Data: []int{120, 125}},
+			},
+		},
+		{
+			// The streams with values have an entry per time, zero where the entry has none.
+			name: "gaps",
+			ts: &stride.ActivityTimeseries{Data: []stride.ActivityTimeseriesEntry{
+				{
+					Offset:    0,
+					Distance:  stride.Optional[uint32]{Value: 0, Valid: true},
+					Altitude:  stride.Optional[uint16]{Value: 120, Valid: true},
+					Velocity:  stride.Optional[uint16]{Value: 3, Valid: true},
+					Cadence:   stride.Optional[uint8]{Value: 80, Valid: true},
+					Latitude:  stride.Optional[float64]{Value: 45.4642, Valid: true},
+					Longitude: stride.Optional[float64]{Value: 9.19, Valid: true},
+				},
+				// A latitude without longitude is no position.
+				{
+					Offset:   5,
+					Distance: stride.Optional[uint32]{Value: 15, Valid: true},
+					Latitude: stride.Optional[float64]{Value: 45.4643, Valid: true},
+				},
+				{
+					Offset:    10,
+					Distance:  stride.Optional[uint32]{Value: 30, Valid: true},
+					Altitude:  stride.Optional[uint16]{Value: 122, Valid: true},
+					Latitude:  stride.Optional[float64]{Value: 45.4644, Valid: true},
+					Longitude: stride.Optional[float64]{Value: 9.1902, Valid: true},
+				},
+			}},
+			want: &strava.ActivityStream{
+				Time:           strava.StreamSet[int]{Data: []int{0, 5, 10}},
+				Distance:       strava.StreamSet[float64]{Data: []float64{0, 15, 30}},
+				LatLng:         strava.StreamSet[[2]float64]{Data: [][2]float64{{45.4642, 9.19}, {}, {45.4644, 9.1902}}},
+				Altitude:       strava.StreamSet[float64]{Data: []float64{120, 0, 122}},
+				VelocitySmooth: strava.StreamSet[float64]{Data: []float64{3, 0, 0}},
+				Cadence:        strava.StreamSet[int]{Data: []int{80, 0, 0}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := timeseriesToStravaStreams(tc.ts)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got streams\n%+v\nwant\n%+v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package ingest
+
+import (
+	"math"
+	"time"
+
+	"github.com/twpayne/go-polyline"
+
+	"github.com/gabrieleangeletti/stride"
+	"github.com/gabrieleangeletti/vo2/activity"
+)
+
+const (
+	// movingSpeedThreshold is the speed, in m/s, under which the athlete is considered stopped.
+	movingSpeedThreshold = 0.5
+	// summaryPolylineMaxPoints bounds the number of points of the summary polyline of an activity.
+	summaryPolylineMaxPoints = 500
+)
+
+// activitySummary holds the totals of an activity that doesn't come from a provider API, e.g. an uploaded file.
+// It's stored as JSON in the raw data of the activity.
+type activitySummary struct {
+	Name            string       `json:"name"`
+	Sport           stride.Sport `json:"sport"`
+	StartTime       time.Time    `json:"startTime"`
+	ElapsedTime     int          `json:"elapsedTime"`
+	MovingTime      int          `json:"movingTime"`
+	Distance        int          `json:"distance"`
+	ElevGain        int          `json:"elevGain,omitzero"`
+	ElevLoss        int          `json:"elevLoss,omitzero"`
+	SummaryPolyline string       `json:"summaryPolyline,omitzero"`
+}
+
+// fillFromTimeseries fills the totals that the source doesn't provide from the timeseries.
+// Entries without distance get the cumulative distance between their positions.
+func (a *activitySummary) fillFromTimeseries(ts *stride.ActivityTimeseries) {
+	if ts == nil {
+		return
+	}
+
+	var distance, movingTime, elevGain, elevLoss float64
+	coords := make([][]float64, 0, len(ts.Data))
+
+	for i, entry := range ts.Data {
+		if entry.Latitude.Valid && entry.Longitude.Valid {
+			coords = append(coords, []float64{entry.Latitude.Value, entry.Longitude.Value})
+		}
+
+		if i == 0 {
+			continue
+		}
+
+		prev := ts.Data[i-1]
+
+		step := 0.0
+		if entry.Distance.Valid && prev.Distance.Valid {
+			step = float64(entry.Distance.Value) - float64(prev.Distance.Value)
+		} else if entry.Latitude.Valid && entry.Longitude.Valid && prev.Latitude.Valid && prev.Longitude.Valid {
+			step = haversineDistance(prev.Latitude.Value, prev.Longitude.Value, entry.Latitude.Value, entry.Longitude.Value)
+		}
+		step = math.Max(step, 0)
+
+		distance += step
+
+		// GPX files have no distance, fill it so that the generated files and the analysis can use it.
+		if !ts.Data[i].Distance.Valid && distance > 0 {
+			ts.Data[i].Distance = stride.Optional[uint32]{Value: uint32(distance), Valid: true}
+		}
+
+		dt := float64(entry.Offset - prev.Offset)
+		if dt > 0 && step/dt >= movingSpeedThreshold {
+			movingTime += dt
+		}
+
+		if entry.Altitude.Valid && prev.Altitude.Valid {
+			diff := float64(entry.Altitude.Value) - float64(prev.Altitude.Value)
+			if diff > 0 {
+				elevGain += diff
+			} else {
+				elevLoss -= diff
+			}
+		}
+	}
+
+	if a.ElapsedTime == 0 {
+		a.ElapsedTime = ts.MaxOffset()
+	}
+
+	if a.Distance == 0 {
+		a.Distance = int(distance)
+	}
+
+	if a.MovingTime == 0 {
+		a.MovingTime = int(movingTime)
+		// Indoor activities have no position, the athlete is considered always moving.
+		if a.MovingTime == 0 {
+			a.MovingTime = a.ElapsedTime
+		}
+	}
+
+	if a.ElevGain == 0 {
+		a.ElevGain = int(elevGain)
+	}
+
+	if a.ElevLoss == 0 {
+		a.ElevLoss = int(elevLoss)
+	}
+
+	if a.SummaryPolyline == "" && len(coords) > 1 {
+		a.SummaryPolyline = string(polyline.EncodeCoords(downsampleCoords(coords, summaryPolylineMaxPoints)))
+	}
+}
+
+func (a *activitySummary) avgSpeed() float64 {
+	if a.MovingTime <= 0 {
+		return 0
+	}
+
+	return float64(a.Distance) / float64(a.MovingTime)
+}
+
+func (a *activitySummary) toActivity(provider stride.Provider) *stride.Activity {
+	return &stride.Activity{
+		Provider:      provider,
+		Sport:         a.Sport,
+		StartTime:     a.StartTime,
+		ElapsedTime:   uint32(a.ElapsedTime),
+		MovingTime:    uint32(a.MovingTime),
+		Distance:      uint32(a.Distance),
+		AvgSpeed:      uint16(a.avgSpeed()),
+		ElevationGain: stride.Optional[uint16]{Value: uint16(a.ElevGain), Valid: a.ElevGain > 0},
+		ElevationLoss: stride.Optional[uint16]{Value: uint16(a.ElevLoss), Valid: a.ElevLoss > 0},
+	}
+}
+
+func (a *activitySummary) toEnduranceActivity(raw *activity.ProviderActivityRawData) (*activity.EnduranceActivity, error) {
+	if _, err := stride.ParseSport(string(a.Sport)); err != nil {
+		return nil, err
+	}
+
+	if !stride.IsEnduranceActivity(a.Sport) {
+		return nil, stride.ErrActivityIsNotEndurance
+	}
+
+	var utcOffset *int32
+	if raw.UTCOffset.Valid {
+		utcOffset = &raw.UTCOffset.Int32
+	}
+
+	var elevGain *int32
+	if a.ElevGain > 0 {
+		gain := int32(a.ElevGain)
+		elevGain = &gain
+	}
+
+	var elevLoss *int32
+	if a.ElevLoss > 0 {
+		loss := int32(a.ElevLoss)
+		elevLoss = &loss
+	}
+
+	enduranceActivity := &activity.EnduranceActivity{
+		ProviderID:            raw.ProviderID,
+		AthleteID:             raw.AthleteID,
+		ProviderRawActivityID: raw.ID,
+		Name:                  a.Name,
+		Sport:                 a.Sport,
+		StartTime:             raw.StartTime,
+		EndTime:               raw.StartTime.Add(time.Duration(raw.ElapsedTime) * time.Second),
+		IanaTimezone:          raw.IanaTimezone.String,
+		UTCOffset:             utcOffset,
+		ElapsedTime:           a.ElapsedTime,
+		MovingTime:            a.MovingTime,
+		Distance:              a.Distance,
+		AvgSpeed:              a.avgSpeed(),
+		ElevGain:              elevGain,
+		ElevLoss:              elevLoss,
+	}
+
+	if a.SummaryPolyline != "" {
+		enduranceActivity.SummaryPolyline = a.SummaryPolyline
+
+		wkt, err := stride.PolylineToWKT(a.SummaryPolyline)
+		if err != nil {
+			return nil, err
+		}
+
+		enduranceActivity.SummaryRoute = wkt
+	}
+
+	return enduranceActivity, nil
+}
+
+func downsampleCoords(coords [][]float64, maxPoints int) [][]float64 {
+	if len(coords) <= maxPoints {
+		return coords
+	}
+
+	step := float64(len(coords)-1) / float64(maxPoints-1)
+
+	sampled := make([][]float64, 0, maxPoints)
+	for i := range maxPoints {
+		sampled = append(sampled, coords[int(math.Round(float64(i)*step))])
+	}
+
+	return sampled
+}
+
+// haversineDistance returns the distance in meters between two points.
+func haversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371000
+
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return earthRadius * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
@@ -0,0 +1,81 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gabrieleangeletti/stride"
+	"github.com/gabrieleangeletti/vo2/activity"
+)
+
+const (
+	// ProviderUpload is the provider of the activities uploaded manually as files.
+	ProviderUpload stride.Provider = "upload"
+)
+
+var (
+	ErrNotSupported = errors.New("operation not supported by the provider")
+)
+
+func init() {
+	Register(&uploadProvider{})
+}
+
+// uploadProvider is the provider of the activities uploaded as GPX, FIT or TCX files.
+//
+// There's no remote API to pull from, activities only enter through uploads, so the fetch methods are not supported.
+type uploadProvider struct{}
+
+func (p *uploadProvider) Slug() string {
+	return string(ProviderUpload)
+}
+
+func (p *uploadProvider) RefreshToken(ctx context.Context, refreshToken string) (*OAuth2Token, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *uploadProvider) Deauthorize(ctx context.Context, accessToken string) error {
+	return ErrNotSupported
+}
+
+func (p *uploadProvider) FetchActivitySummaries(ctx context.Context, accessToken string, startTime, endTime time.Time) ([]ActivitySummary, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *uploadProvider) FetchActivity(ctx context.Context, accessToken string, activityID string) (*RawActivity, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *uploadProvider) ActivityChanged(summary ActivitySummary, raw *activity.ProviderActivityRawData) (bool, error) {
+	return false, ErrNotSupported
+}
+
+func (p *uploadProvider) FetchActivityStreams(ctx context.Context, accessToken string, activityID string) (stride.ActivityTimeseriesConvertible, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *uploadProvider) NewActivityStreams() stride.ActivityTimeseriesConvertible {
+	return &fileStreams{}
+}
+
+func (p *uploadProvider) DecodeActivity(raw *activity.ProviderActivityRawData) (stride.ActivityConvertible, error) {
+	var act FileActivity
+	err := json.Unmarshal(raw.Data, &act)
+	if err != nil {
+		return nil, err
+	}
+
+	return &act, nil
+}
+
+func (p *uploadProvider) ToEnduranceActivity(raw *activity.ProviderActivityRawData) (*activity.EnduranceActivity, error) {
+	var act FileActivity
+	err := json.Unmarshal(raw.Data, &act)
+	if err != nil {
+		return nil, err
+	}
+
+	return act.toEnduranceActivity(raw)
+}
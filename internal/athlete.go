@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/gabrieleangeletti/vo2"
+	"github.com/gabrieleangeletti/vo2/provider"
+	"github.com/gabrieleangeletti/vo2/store"
+)
+
+var (
+	ErrAthleteNotLinked = errors.New("no athlete linked to the provider athlete")
+)
+
+// ProviderAthlete links the athlete of a provider, e.g. a Strava athlete or a Garmin user, to an athlete. A user can
+// manage several athletes, e.g. a parent and a child, each one with its own provider athletes.
+type ProviderAthlete struct {
+	ID                int       `json:"id" db:"id"`
+	ProviderID        int       `json:"providerId" db:"provider_id"`
+	ProviderAthleteID string    `json:"providerAthleteId" db:"provider_athlete_id"`
+	AthleteID         uuid.UUID `json:"athleteId" db:"athlete_id"`
+	// UserID is the user that connected the provider athlete, its credentials are used to fetch the athlete data.
+	UserID    uuid.UUID    `json:"userId" db:"user_id"`
+	CreatedAt time.Time    `json:"createdAt" db:"created_at"`
+	UpdatedAt sql.NullTime `json:"updatedAt" db:"updated_at"`
+}
+
+// GetProviderAthlete returns the link of a provider athlete, ErrAthleteNotLinked if there is none.
+func GetProviderAthlete(ctx context.Context, db sqlx.QueryerContext, providerID int, providerAthleteID string) (*ProviderAthlete, error) {
+	var link ProviderAthlete
+
+	err := sqlx.GetContext(ctx, db, &link, `
+	SELECT * FROM vo2.provider_athletes
+	WHERE provider_id = $1 AND provider_athlete_id = $2
+	`, providerID, providerAthleteID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: (provider: %d, providerAthleteID: %s)", ErrAthleteNotLinked, providerID, providerAthleteID)
+		}
+
+		return nil, err
+	}
+
+	return &link, nil
+}
+
+// GetAthleteProviderLink returns the provider athlete linked to an athlete, ErrProviderNotConnected if there is none.
+func GetAthleteProviderLink(ctx context.Context, db sqlx.QueryerContext, providerID int, athleteID uuid.UUID) (*ProviderAthlete, error) {
+	var link ProviderAthlete
+
+	err := sqlx.GetContext(ctx, db, &link, `
+	SELECT * FROM vo2.provider_athletes
+	WHERE provider_id = $1 AND athlete_id = $2
+	`, providerID, athleteID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrProviderNotConnected
+		}
+
+		return nil, err
+	}
+
+	return &link, nil
+}
+
+// LinkProviderAthlete links a provider athlete to an athlete, replacing its previous link. An athlete has a single
+// provider athlete per provider, so the one linked to the athlete before is unlinked.
+func LinkProviderAthlete(ctx context.Context, db *sqlx.DB, link *ProviderAthlete) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+	DELETE FROM vo2.provider_athletes
+	WHERE provider_id = $1 AND athlete_id = $2 AND provider_athlete_id <> $3
+	`, link.ProviderID, link.AthleteID, link.ProviderAthleteID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.GetContext(ctx, link, `
+	INSERT INTO vo2.provider_athletes (provider_id, provider_athlete_id, athlete_id, user_id)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT
+		(provider_id, provider_athlete_id)
+	DO UPDATE SET
+		athlete_id = $3,
+		user_id = $4
+	RETURNING *
+	`, link.ProviderID, link.ProviderAthleteID, link.AthleteID, link.UserID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// connectProviderAthlete creates or updates the athlete of a provider athlete connected by a user, and links them.
+// The athlete is the given one if athleteID is set, e.g. a user connecting the provider of another athlete they
+// manage, otherwise the one linked before or a new one. The profile fields the provider has overwrite the stored ones.
+func connectProviderAthlete(ctx context.Context, db *sqlx.DB, dbStore store.Store, prov *provider.Provider, user *User, providerAthleteID string, athleteID uuid.UUID, profile *vo2.Athlete) (*vo2.Athlete, error) {
+	profile.UserID = user.ID
+
+	if athleteID != uuid.Nil {
+		existing, err := dbStore.GetAthlete(ctx, athleteID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get athlete %s: %w", athleteID, err)
+		}
+
+		profile.ID = existing.ID
+		profile.UserID = existing.UserID
+	} else {
+		link, err := GetProviderAthlete(ctx, db, prov.ID, providerAthleteID)
+		if err != nil && !errors.Is(err, ErrAthleteNotLinked) {
+			return nil, err
+		}
+
+		if link != nil {
+			profile.ID = link.AthleteID
+		}
+	}
+
+	athlete, err := dbStore.UpsertAthlete(ctx, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	err = LinkProviderAthlete(ctx, db, &ProviderAthlete{
+		ProviderID:        prov.ID,
+		ProviderAthleteID: providerAthleteID,
+		AthleteID:         athlete.ID,
+		UserID:            user.ID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to link athlete: %w", err)
+	}
+
+	return athlete, nil
+}
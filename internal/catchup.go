@@ -0,0 +1,238 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/gabrieleangeletti/stride"
+	"github.com/gabrieleangeletti/vo2/activity"
+	"github.com/gabrieleangeletti/vo2/ingest"
+	"github.com/gabrieleangeletti/vo2/provider"
+	"github.com/gabrieleangeletti/vo2/store"
+)
+
+const (
+	// catchUpLookback is how long before the newest stored activity a catch-up sync starts, so that the activities
+	// changed while the webhook events were missed are checked too.
+	catchUpLookback = 7 * 24 * time.Hour
+)
+
+// CatchUpSyncResult is the outcome of a catch-up sync.
+type CatchUpSyncResult struct {
+	Since time.Time
+	// Checked is the number of activities listed by the provider.
+	Checked int
+	Missing int
+	Changed int
+	// Wait is set if the sync stopped because the rate limit quota ran low, it's how long to wait before resuming.
+	Wait time.Duration
+}
+
+// CatchUpSync fills the gaps left by missed webhook events. It lists the activities of the athlete started since
+// task.Since, or since the newest stored activity, and ingests the ones that are missing or that were changed in the
+// provider. Their post processing is queued, as for the webhook events.
+//
+// If the rate limit quota runs low the sync stops, and the result tells how long to wait. Running it again with the
+// same since skips the activities ingested already.
+func CatchUpSync(ctx context.Context, db *sqlx.DB, dbStore store.Store, task CatchUpSyncTask) (*CatchUpSyncResult, error) {
+	prov, err := provider.GetByID(db, task.ProviderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get provider: %w", err)
+	}
+
+	p, err := ingest.Get(prov.Slug)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := EnsureValidCredentials(ctx, db, p, prov, task.AthleteID)
+	if err != nil {
+		return nil, err
+	}
+
+	since := task.Since
+	if since.IsZero() {
+		since, err = catchUpSince(ctx, db, prov.ID, task.AthleteID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get newest activity: %w", err)
+		}
+	}
+
+	result := &CatchUpSyncResult{Since: since}
+
+	limiter, err := NewRateLimiter(db, prov)
+	if err != nil {
+		return nil, err
+	}
+
+	// acquire reserves n requests, it returns false if the sync must stop.
+	acquire := func(n int) (bool, error) {
+		wait, err := limiter.Acquire(ctx, n)
+		if err != nil {
+			return false, fmt.Errorf("failed to acquire rate limit quota: %w", err)
+		}
+
+		result.Wait = wait
+
+		return wait == 0, nil
+	}
+
+	// exhausted stops the sync after the provider rejected a request for exceeding its quota.
+	exhausted := func() (*CatchUpSyncResult, error) {
+		wait, err := limiter.Exhaust(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Wait = wait
+
+		return result, nil
+	}
+
+	ok, err := acquire(1)
+	if err != nil || !ok {
+		return result, err
+	}
+
+	summaries, err := p.FetchActivitySummaries(ctx, credentials.AccessToken, since, time.Now())
+	if err != nil {
+		if errors.Is(err, stride.ErrRateLimitExceeded) {
+			return exhausted()
+		}
+
+		return nil, fmt.Errorf("failed to get %s activities: %w", prov.Slug, err)
+	}
+
+	result.Checked = len(summaries)
+
+	existingActivities, err := activity.GetProviderActivityRawData(ctx, db, prov.ID, task.AthleteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get existing activities: %w", err)
+	}
+
+	existingActivitiesMap := make(map[string]*activity.ProviderActivityRawData)
+	for _, a := range existingActivities {
+		existingActivitiesMap[a.ProviderActivityID] = a
+	}
+
+	for _, summary := range summaries {
+		existing, exists := existingActivitiesMap[summary.ID]
+
+		if exists {
+			// Deleted activities that are still listed are deleted by a pending webhook event.
+			if existing.DeletedAt.Valid {
+				continue
+			}
+
+			changed, err := p.ActivityChanged(summary, existing)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compare activity %s: %w", summary.ID, err)
+			}
+
+			if !changed {
+				continue
+			}
+		}
+
+		ok, err := acquire(1)
+		if err != nil || !ok {
+			return result, err
+		}
+
+		_, err = ingestProviderActivity(ctx, dbStore, p, prov, task.AthleteID, credentials.AccessToken, summary.ID)
+		if err != nil {
+			if errors.Is(err, stride.ErrRateLimitExceeded) {
+				return exhausted()
+			}
+
+			return nil, fmt.Errorf("failed to ingest activity %s: %w", summary.ID, err)
+		}
+
+		if exists {
+			result.Changed++
+		} else {
+			result.Missing++
+		}
+	}
+
+	return result, nil
+}
+
+// catchUpSince returns the start of a catch-up sync: catchUpLookback before the newest stored activity of the athlete,
+// or the start of the historical backfill if there is none.
+func catchUpSince(ctx context.Context, db *sqlx.DB, providerID int, athleteID uuid.UUID) (time.Time, error) {
+	var newest sql.NullTime
+
+	err := db.GetContext(ctx, &newest, `
+	SELECT MAX(start_time) FROM vo2.provider_activity_raw_data
+	WHERE provider_id = $1 AND athlete_id = $2 AND deleted_at IS NULL
+	`, providerID, athleteID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if !newest.Valid {
+		return time.Now().AddDate(0, -fourYearsInMonths, 0), nil
+	}
+
+	return newest.Time.Add(-catchUpLookback), nil
+}
+
+// QueueCatchUpSyncTasks queues a catch-up sync task for each athlete with a connected OAuth2 provider.
+func QueueCatchUpSyncTasks(ctx context.Context, db *sqlx.DB) error {
+	var connections []struct {
+		AthleteID  uuid.UUID `db:"athlete_id"`
+		ProviderID int       `db:"provider_id"`
+	}
+
+	err := db.SelectContext(ctx, &connections, `
+	SELECT a.id AS athlete_id, c.provider_id FROM vo2.provider_oauth2_credentials c
+	JOIN vo2.provider_athletes pa ON pa.provider_id = c.provider_id AND pa.user_id = c.user_id
+	JOIN vo2.athletes a ON a.id = pa.athlete_id
+	WHERE c.deleted_at IS NULL AND a.deleted_at IS NULL
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to list connected athletes: %w", err)
+	}
+
+	sqsClient, err := NewSQSClient()
+	if err != nil {
+		return fmt.Errorf("failed to create SQS client: %w", err)
+	}
+
+	for _, c := range connections {
+		task := CatchUpSyncTask{
+			AthleteID:  c.AthleteID,
+			ProviderID: c.ProviderID,
+		}
+
+		if err := sqsClient.SendCatchUpSyncTask(ctx, task, 0); err != nil {
+			return fmt.Errorf("failed to send catch-up sync task: %w", err)
+		}
+
+		slog.Info("Queued catch-up sync task", "athleteId", c.AthleteID, "providerId", c.ProviderID)
+	}
+
+	return nil
+}
+
+// requeueCatchUpSyncTask queues a catch-up sync task again, to be processed after the given delay.
+func requeueCatchUpSyncTask(ctx context.Context, task CatchUpSyncTask, delay time.Duration) error {
+	sqsClient, err := NewSQSClient()
+	if err != nil {
+		return fmt.Errorf("failed to create SQS client: %w", err)
+	}
+
+	if err := sqsClient.SendCatchUpSyncTask(ctx, task, delay); err != nil {
+		return fmt.Errorf("failed to send catch-up sync task: %w", err)
+	}
+
+	return nil
+}
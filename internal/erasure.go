@@ -0,0 +1,441 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/gabrieleangeletti/vo2/ingest"
+	"github.com/gabrieleangeletti/vo2/provider"
+	"github.com/gabrieleangeletti/vo2/store"
+)
+
+type ErasureScope string
+
+const (
+	// ErasureScopeProvider disconnects a provider from an athlete and deletes the data it has from the provider.
+	ErasureScopeProvider ErasureScope = "provider"
+	// ErasureScopeAthlete disconnects all the providers of an athlete and deletes the athlete with all its data.
+	ErasureScopeAthlete ErasureScope = "athlete"
+)
+
+type ErasureStatus string
+
+const (
+	ErasureStatusPending   ErasureStatus = "pending"
+	ErasureStatusRunning   ErasureStatus = "running"
+	ErasureStatusCompleted ErasureStatus = "completed"
+	ErasureStatusFailed    ErasureStatus = "failed"
+)
+
+// ErasureRequest is the audit record of a provider disconnection or of an athlete erasure, which run in the
+// background. It's kept after the athlete is deleted.
+type ErasureRequest struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	AthleteID uuid.UUID `json:"athleteId" db:"athlete_id"`
+	// ProviderID is the provider to disconnect, nil for the erasure of the athlete.
+	ProviderID *int          `json:"providerId" db:"provider_id"`
+	Scope      ErasureScope  `json:"scope" db:"scope"`
+	Status     ErasureStatus `json:"status" db:"status"`
+	// Deauthorized is true when none of the providers disconnected gives access to the athlete data anymore.
+	Deauthorized bool `json:"deauthorized" db:"deauthorized"`
+	// DeletedRows is the number of rows deleted per table.
+	DeletedRows    json.RawMessage `json:"deletedRows" db:"deleted_rows"`
+	DeletedObjects int             `json:"deletedObjects" db:"deleted_objects"`
+	LastError      *string         `json:"lastError" db:"last_error"`
+	StartedAt      *time.Time      `json:"startedAt" db:"started_at"`
+	CompletedAt    *time.Time      `json:"completedAt" db:"completed_at"`
+	CreatedAt      time.Time       `json:"createdAt" db:"created_at"`
+	UpdatedAt      *time.Time      `json:"updatedAt" db:"updated_at"`
+}
+
+// CreateErasureRequest records the erasure of the data an athlete has from a provider, or of the athlete if providerID
+// is nil. It's processed by ProcessErasureRequest.
+func CreateErasureRequest(ctx context.Context, db sqlx.QueryerContext, athleteID uuid.UUID, providerID *int) (*ErasureRequest, error) {
+	scope := ErasureScopeAthlete
+	if providerID != nil {
+		scope = ErasureScopeProvider
+	}
+
+	var req ErasureRequest
+
+	err := sqlx.GetContext(ctx, db, &req, `
+	INSERT INTO vo2.erasure_requests (athlete_id, provider_id, scope)
+	VALUES ($1, $2, $3)
+	RETURNING *
+	`, athleteID, providerID, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+// queueErasureRequest records an erasure request and queues its task. The request fails if the task can't be queued.
+func queueErasureRequest(ctx context.Context, db *sqlx.DB, athleteID uuid.UUID, providerID *int) (*ErasureRequest, error) {
+	req, err := CreateErasureRequest(ctx, db, athleteID, providerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create erasure request: %w", err)
+	}
+
+	err = sendErasureTask(ctx, ErasureTask{RequestID: req.ID})
+	if err != nil {
+		if failErr := req.setStatus(ctx, db, ErasureStatusFailed, err); failErr != nil {
+			slog.Error("Failed to update erasure request", "error", failErr, "erasureRequestId", req.ID)
+		}
+
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func sendErasureTask(ctx context.Context, task ErasureTask) error {
+	sqsClient, err := NewSQSClient()
+	if err != nil {
+		return fmt.Errorf("failed to create SQS client: %w", err)
+	}
+
+	if err := sqsClient.SendErasureTask(ctx, task); err != nil {
+		return fmt.Errorf("failed to send erasure task: %w", err)
+	}
+
+	return nil
+}
+
+// GetErasureRequest returns an erasure request, sql.ErrNoRows if there is none.
+func GetErasureRequest(ctx context.Context, db sqlx.QueryerContext, requestID uuid.UUID) (*ErasureRequest, error) {
+	var req ErasureRequest
+
+	err := sqlx.GetContext(ctx, db, &req, `
+	SELECT * FROM vo2.erasure_requests WHERE id = $1
+	`, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+// ProcessErasureRequest runs an erasure request: it revokes the access to the athlete data in the providers, deletes
+// their credentials and links, and the data of the athlete with its objects. A failed request can be processed again,
+// the data deleted already is skipped. Completed requests are returned as they are, since the task was delivered
+// twice.
+func ProcessErasureRequest(ctx context.Context, db *sqlx.DB, dbStore store.Store, requestID uuid.UUID) (*ErasureRequest, error) {
+	req, err := GetErasureRequest(ctx, db, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get erasure request: %w", err)
+	}
+
+	if req.Status == ErasureStatusCompleted {
+		return req, nil
+	}
+
+	_, err = db.ExecContext(ctx, `
+	UPDATE vo2.erasure_requests
+	SET status = 'running', last_error = NULL, started_at = COALESCE(started_at, NOW())
+	WHERE id = $1
+	`, req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := req.erase(ctx, db, dbStore)
+	if err != nil {
+		if failErr := req.setStatus(ctx, db, ErasureStatusFailed, err); failErr != nil {
+			slog.Error("Failed to update erasure request", "error", failErr, "erasureRequestId", req.ID)
+		}
+
+		return nil, err
+	}
+
+	deletedRows, err := json.Marshal(result.Rows)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.ExecContext(ctx, `
+	UPDATE vo2.erasure_requests SET deleted_rows = $2, deleted_objects = $3 WHERE id = $1
+	`, req.ID, deletedRows, result.Objects)
+	if err != nil {
+		return nil, err
+	}
+
+	req.DeletedRows = deletedRows
+	req.DeletedObjects = result.Objects
+
+	err = req.setStatus(ctx, db, ErasureStatusCompleted, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return GetErasureRequest(ctx, db, req.ID)
+}
+
+// erase disconnects the providers and deletes the data of the request.
+func (e *ErasureRequest) erase(ctx context.Context, db *sqlx.DB, dbStore store.Store) (*store.ErasureResult, error) {
+	rows := map[string]int64{}
+
+	var providerIDs []int
+
+	if e.Scope == ErasureScopeProvider {
+		providerIDs = []int{*e.ProviderID}
+	} else {
+		err := db.SelectContext(ctx, &providerIDs, `
+		SELECT provider_id FROM vo2.provider_athletes WHERE athlete_id = $1 ORDER BY provider_id
+		`, e.AthleteID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// A request processed again keeps the outcome of the revocations of its previous runs.
+	deauthorized := e.StartedAt == nil || e.Deauthorized
+
+	var userIDs []uuid.UUID
+
+	for _, providerID := range providerIDs {
+		prov, err := provider.GetByID(db, providerID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get provider %d: %w", providerID, err)
+		}
+
+		userID, revoked, err := disconnectProvider(ctx, db, prov, e.AthleteID, rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to disconnect %s: %w", prov.Slug, err)
+		}
+
+		deauthorized = deauthorized && revoked
+
+		if userID != uuid.Nil {
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	_, err := db.ExecContext(ctx, `
+	UPDATE vo2.erasure_requests SET deauthorized = $2 WHERE id = $1
+	`, e.ID, deauthorized)
+	if err != nil {
+		return nil, err
+	}
+
+	e.Deauthorized = deauthorized
+
+	var result *store.ErasureResult
+
+	if e.Scope == ErasureScopeProvider {
+		result, err = dbStore.DeleteAthleteProviderData(ctx, e.AthleteID, *e.ProviderID)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		athlete, err := dbStore.GetAthlete(ctx, e.AthleteID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		if athlete != nil {
+			userIDs = append(userIDs, athlete.UserID)
+		}
+
+		result, err = dbStore.DeleteAthlete(ctx, e.AthleteID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = deleteUnusedUsers(ctx, db, userIDs, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for table, n := range rows {
+		result.Rows[table] += n
+	}
+
+	return result, nil
+}
+
+func (e *ErasureRequest) setStatus(ctx context.Context, db *sqlx.DB, status ErasureStatus, cause error) error {
+	var lastError sql.NullString
+	if cause != nil {
+		lastError = sql.NullString{String: cause.Error(), Valid: true}
+	}
+
+	_, err := db.ExecContext(ctx, `
+	UPDATE vo2.erasure_requests
+	SET
+		status = $2,
+		last_error = $3,
+		completed_at = CASE WHEN $2 = 'completed' THEN NOW() END
+	WHERE id = $1
+	`, e.ID, status, lastError)
+	if err != nil {
+		return err
+	}
+
+	e.Status = status
+
+	return nil
+}
+
+// disconnectProvider revokes the access to the data of the athlete in the provider, and deletes the link of the
+// athlete and the credentials of the user that connected it. It returns the user, and whether the access was revoked.
+// A revocation that fails, e.g. because the athlete revoked it in the provider already, doesn't stop the erasure,
+// since the credentials are deleted anyway.
+func disconnectProvider(ctx context.Context, db *sqlx.DB, prov *provider.Provider, athleteID uuid.UUID, rows map[string]int64) (uuid.UUID, bool, error) {
+	link, err := GetAthleteProviderLink(ctx, db, prov.ID, athleteID)
+	if err != nil {
+		if errors.Is(err, ErrProviderNotConnected) {
+			return uuid.Nil, true, nil
+		}
+
+		return uuid.Nil, false, err
+	}
+
+	deauthorized, err := deauthorizeProvider(ctx, db, prov, link)
+	if err != nil {
+		slog.Warn("Failed to deauthorize provider", "error", err, "athleteId", athleteID, "provider", prov.Slug)
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	defer tx.Rollback()
+
+	err = deleteRows(ctx, tx, rows, "provider_athletes", `
+	DELETE FROM vo2.provider_athletes WHERE id = $1
+	`, link.ID)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+
+	// The credentials are the ones of the provider athlete, unless the user linked another one of the provider.
+	for _, table := range []string{"provider_oauth2_credentials", "provider_oauth1_credentials"} {
+		err = deleteRows(ctx, tx, rows, table, `
+		DELETE FROM vo2.`+table+` c
+		WHERE
+			c.provider_id = $1
+			AND c.user_id = $2
+			AND NOT EXISTS (SELECT 1 FROM vo2.provider_athletes pa WHERE pa.provider_id = $1 AND pa.user_id = $2)
+		`, prov.ID, link.UserID)
+		if err != nil {
+			return uuid.Nil, false, err
+		}
+	}
+
+	return link.UserID, deauthorized, tx.Commit()
+}
+
+// deauthorizeProvider revokes the access to the data of the provider athlete, with the credentials of the user that
+// connected it. Providers without credentials, e.g. the uploads, have nothing to revoke.
+func deauthorizeProvider(ctx context.Context, db *sqlx.DB, prov *provider.Provider, link *ProviderAthlete) (bool, error) {
+	switch prov.ConnectionType {
+	case provider.OAuth2ConnectionType:
+		p, err := ingest.Get(prov.Slug)
+		if err != nil {
+			return false, err
+		}
+
+		credentials, err := EnsureValidCredentials(ctx, db, p, prov, link.AthleteID)
+		if err != nil {
+			// The credentials were revoked already, e.g. by a deauthorization event.
+			if errors.Is(err, ErrProviderNotConnected) {
+				return true, nil
+			}
+
+			return false, err
+		}
+
+		err = p.Deauthorize(ctx, credentials.AccessToken)
+		if err != nil {
+			if errors.Is(err, ingest.ErrNotSupported) {
+				return false, nil
+			}
+
+			return false, err
+		}
+
+		return true, nil
+	case provider.OAuth1ConnectionType:
+		credentials, err := GetProviderOAuth1Credentials(db, prov.ID, link.UserID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return true, nil
+			}
+
+			return false, err
+		}
+
+		err = ingest.NewGarminClient().DeleteUserRegistration(ctx, credentials.OAuth1Token())
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	default:
+		return true, nil
+	}
+}
+
+// deleteUnusedUsers deletes the users that no athlete or provider athlete references anymore, with their credentials.
+func deleteUnusedUsers(ctx context.Context, db *sqlx.DB, userIDs []uuid.UUID, rows map[string]int64) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	const unused = `
+		u.id = ANY($1)
+		AND NOT EXISTS (SELECT 1 FROM vo2.athletes a WHERE a.user_id = u.id)
+		AND NOT EXISTS (SELECT 1 FROM vo2.provider_athletes pa WHERE pa.user_id = u.id)`
+
+	for _, table := range []string{"provider_oauth2_credentials", "provider_oauth1_credentials"} {
+		err = deleteRows(ctx, tx, rows, table, `
+		DELETE FROM vo2.`+table+` c
+		USING vo2.users u
+		WHERE c.user_id = u.id AND`+unused, userIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = deleteRows(ctx, tx, rows, "users", `
+	DELETE FROM vo2.users u
+	WHERE`+unused, userIDs)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deleteRows runs a delete query and adds the number of rows deleted to the ones of the table.
+func deleteRows(ctx context.Context, db sqlx.ExecerContext, rows map[string]int64, table, query string, args ...any) error {
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to delete from %s: %w", table, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	rows[table] += n
+
+	return nil
+}
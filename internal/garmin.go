@@ -0,0 +1,407 @@
+package internal
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/gabrieleangeletti/vo2"
+	"github.com/gabrieleangeletti/vo2/ingest"
+	"github.com/gabrieleangeletti/vo2/provider"
+	"github.com/gabrieleangeletti/vo2/store"
+)
+
+const (
+	oauth1RequestTokenTTL = 15 * time.Minute
+
+	// maxGarminPushSize is the maximum size of the body of a Garmin push notification.
+	maxGarminPushSize = 10 << 20 // 10 MiB
+)
+
+var (
+	ErrOAuth1RequestTokenNotFound = errors.New("OAuth1 request token not found")
+	ErrOAuth1RequestTokenExpired  = errors.New("OAuth1 request token expired")
+	ErrInvalidGarminAccessToken   = errors.New("invalid Garmin user access token")
+)
+
+// OAuth1RequestToken is the request token of an OAuth1 flow in progress.
+// Its secret is needed to exchange it for an access token once the athlete authorizes it.
+type OAuth1RequestToken struct {
+	ID          int       `json:"id" db:"id"`
+	ProviderID  int       `json:"providerId" db:"provider_id"`
+	Token       string    `json:"token" db:"token"`
+	TokenSecret string    `json:"tokenSecret" db:"token_secret"`
+	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
+	ExpiresAt   time.Time `json:"expiresAt" db:"expires_at"`
+}
+
+func CreateOAuth1RequestToken(ctx context.Context, db *sqlx.DB, providerID int, token *ingest.OAuth1Token) (*OAuth1RequestToken, error) {
+	now := time.Now()
+
+	requestToken := &OAuth1RequestToken{
+		ProviderID:  providerID,
+		Token:       token.Token,
+		TokenSecret: token.TokenSecret,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(oauth1RequestTokenTTL),
+	}
+
+	_, err := db.ExecContext(ctx, `
+	INSERT INTO vo2.oauth1_request_tokens (provider_id, token, token_secret, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5)
+	`, requestToken.ProviderID, requestToken.Token, requestToken.TokenSecret, requestToken.CreatedAt, requestToken.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return requestToken, nil
+}
+
+// consumeOAuth1RequestToken returns the request token and deletes it, request tokens can only be used once.
+func consumeOAuth1RequestToken(ctx context.Context, db *sqlx.DB, providerID int, token string) (*ingest.OAuth1Token, error) {
+	var requestToken OAuth1RequestToken
+
+	err := db.GetContext(ctx, &requestToken, `
+	DELETE FROM vo2.oauth1_request_tokens WHERE provider_id = $1 AND token = $2 RETURNING *
+	`, providerID, token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOAuth1RequestTokenNotFound
+		}
+
+		return nil, err
+	}
+
+	if time.Now().After(requestToken.ExpiresAt) {
+		return nil, ErrOAuth1RequestTokenExpired
+	}
+
+	return &ingest.OAuth1Token{
+		Token:       requestToken.Token,
+		TokenSecret: requestToken.TokenSecret,
+	}, nil
+}
+
+func garminAuthHandler(db *sqlx.DB, dbStore store.Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		token := r.URL.Query().Get("oauth_token")
+		verifier := r.URL.Query().Get("oauth_verifier")
+		if token == "" || verifier == "" {
+			http.Error(w, "No oauth_token or oauth_verifier provided", http.StatusBadRequest)
+			return
+		}
+
+		prov, err := provider.GetBySlug(db, string(ingest.ProviderGarmin))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		requestToken, err := consumeOAuth1RequestToken(ctx, db, prov.ID, token)
+		if err != nil {
+			if errors.Is(err, ErrOAuth1RequestTokenNotFound) || errors.Is(err, ErrOAuth1RequestTokenExpired) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		client := ingest.NewGarminClient()
+
+		accessToken, err := client.AccessToken(ctx, requestToken, verifier)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		garminUserID, err := client.UserID(ctx, accessToken)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		tx, err := db.Beginx()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		user, err := CreateUser(tx, prov.ID, garminUserID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		credentials := ProviderOAuth1Credentials{
+			ProviderID:  prov.ID,
+			UserID:      user.ID,
+			Token:       accessToken.Token,
+			TokenSecret: accessToken.TokenSecret,
+		}
+
+		err = credentials.SaveTx(ctx, tx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			slog.Error(err.Error())
+			http.Error(w, ErrGeneric.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// The athlete references the user, so it's created once the user is committed. Garmin doesn't share the
+		// profile, so it's left to the profile completion.
+		athlete, err := connectProviderAthlete(ctx, db, dbStore, prov, user, garminUserID, uuid.Nil, &vo2.Athlete{})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		resp := map[string]any{
+			"success":              true,
+			"athleteId":            athlete.ID,
+			"missingProfileFields": athlete.MissingProfileFields(),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// garminPushHandler receives the push notifications of the Garmin Health API.
+//
+// Garmin retries the notifications that don't get a 200 response, so the ones that failed because of a transient
+// error are answered with a 500. Notifications of unknown users or with an invalid access token are skipped.
+func garminPushHandler(db *sqlx.DB, dbStore store.Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		slog.Info("Received Garmin push notification")
+
+		ctx := r.Context()
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxGarminPushSize)
+
+		var push ingest.GarminPush
+		err := json.NewDecoder(r.Body).Decode(&push)
+		if err != nil {
+			slog.Error(err.Error())
+			http.Error(w, ErrGeneric.Error(), http.StatusBadRequest)
+			return
+		}
+
+		prov, err := provider.GetBySlug(db, string(ingest.ProviderGarmin))
+		if err != nil {
+			slog.Error(err.Error())
+			http.Error(w, ErrGeneric.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		failed := false
+
+		for _, act := range push.Activities {
+			err := processGarminActivity(ctx, db, dbStore, prov, &act)
+			if err != nil {
+				failed = failed || !isGarminPushRejected(err)
+				slog.Error("Failed to process Garmin activity", "error", err, "activityId", act.ActivityID, "userId", act.UserID)
+			}
+		}
+
+		for _, file := range push.ActivityFiles {
+			err := processGarminActivityFile(ctx, db, dbStore, prov, &file)
+			if err != nil {
+				failed = failed || !isGarminPushRejected(err)
+				slog.Error("Failed to process Garmin activity file", "error", err, "activityId", file.ActivityID, "userId", file.UserID)
+			}
+		}
+
+		if failed {
+			http.Error(w, ErrGeneric.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// isGarminPushRejected reports whether the notification failed for a reason that retrying doesn't fix.
+func isGarminPushRejected(err error) bool {
+	return errors.Is(err, ErrAthleteNotLinked) ||
+		errors.Is(err, ErrInvalidGarminAccessToken) ||
+		errors.Is(err, ingest.ErrUnsupportedFileFormat) ||
+		errors.Is(err, ingest.ErrInvalidGarminCallbackURL)
+}
+
+// garminAthlete returns the athlete linked to a Garmin user and the credentials of the user that connected it.
+// The access token of the notification must match the one of the user, Garmin notifications are not signed.
+func garminAthlete(ctx context.Context, db *sqlx.DB, dbStore store.Store, prov *provider.Provider, garminUserID, accessToken string) (*vo2.Athlete, *ProviderOAuth1Credentials, error) {
+	link, err := GetProviderAthlete(ctx, db, prov.ID, garminUserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	credentials, err := GetProviderOAuth1Credentials(db, prov.ID, link.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrInvalidGarminAccessToken
+		}
+
+		return nil, nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(credentials.Token), []byte(accessToken)) != 1 {
+		return nil, nil, ErrInvalidGarminAccessToken
+	}
+
+	athlete, err := dbStore.GetAthlete(ctx, link.AthleteID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, fmt.Errorf("%w: athlete %s not found", ErrAthleteNotLinked, link.AthleteID)
+		}
+
+		return nil, nil, err
+	}
+
+	return athlete, credentials, nil
+}
+
+// processGarminActivity stores an activity summary. If the file of the activity was already pushed, the endurance
+// activity is stored with its timeseries, otherwise with the summary only until the file arrives.
+func processGarminActivity(ctx context.Context, db *sqlx.DB, dbStore store.Store, prov *provider.Provider, act *ingest.GarminActivity) error {
+	athlete, _, err := garminAthlete(ctx, db, dbStore, prov, act.UserID, act.UserAccessToken)
+	if err != nil {
+		return err
+	}
+
+	p, err := ingest.Get(prov.Slug)
+	if err != nil {
+		return err
+	}
+
+	existing, err := dbStore.GetProviderActivityRawByProviderActivityID(ctx, prov.ID, athlete.ID, act.ID())
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if existing != nil {
+		err = act.KeepFile(existing)
+		if err != nil {
+			return err
+		}
+	}
+
+	rawActivity, err := act.ToRawActivity()
+	if err != nil {
+		return err
+	}
+
+	activityRaw := rawActivity.ToProviderActivityRawData(prov.ID, athlete.ID)
+
+	if existing != nil {
+		activityRaw.DetailedActivityURI = existing.DetailedActivityURI
+	}
+
+	activityRaw.ID, err = dbStore.SaveProviderActivityRawData(ctx, activityRaw)
+	if err != nil {
+		return fmt.Errorf("failed to save activity: %w", err)
+	}
+
+	ts := p.NewActivityStreams()
+
+	if activityRaw.DetailedActivityURI.Valid {
+		err = dbStore.GetActivityRawTimeseries(ctx, activityRaw, ts)
+		if err != nil {
+			return fmt.Errorf("failed to get activity file: %w", err)
+		}
+	}
+
+	_, err = dbStore.StoreActivityEndurance(ctx, ingest.ProviderGarmin, activityRaw, act, ts)
+	if err != nil {
+		return fmt.Errorf("failed to store activity: %w", err)
+	}
+
+	return nil
+}
+
+// processGarminActivityFile downloads the file of an activity and stores it as the raw details of the activity.
+func processGarminActivityFile(ctx context.Context, db *sqlx.DB, dbStore store.Store, prov *provider.Provider, notification *ingest.GarminActivityFile) error {
+	athlete, credentials, err := garminAthlete(ctx, db, dbStore, prov, notification.UserID, notification.UserAccessToken)
+	if err != nil {
+		return err
+	}
+
+	format, err := notification.Format()
+	if err != nil {
+		return err
+	}
+
+	data, err := ingest.NewGarminClient().DownloadActivityFile(ctx, credentials.OAuth1Token(), notification.CallbackURL)
+	if err != nil {
+		return fmt.Errorf("failed to download activity file: %w", err)
+	}
+
+	file, err := ingest.ParseActivityFile(fmt.Sprintf("%s.%s", notification.ID(), format), data)
+	if err != nil {
+		return err
+	}
+
+	existing, err := dbStore.GetProviderActivityRawByProviderActivityID(ctx, prov.ID, athlete.ID, notification.ID())
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	var act *ingest.GarminActivity
+
+	if existing != nil {
+		var stored ingest.GarminActivity
+		err = json.Unmarshal(existing.Data, &stored)
+		if err != nil {
+			return err
+		}
+
+		stored.SetFile(file)
+		act = &stored
+	} else {
+		act = notification.ToActivity(file)
+	}
+
+	rawActivity, err := act.ToRawActivity()
+	if err != nil {
+		return err
+	}
+
+	activityRaw := rawActivity.ToProviderActivityRawData(prov.ID, athlete.ID)
+
+	activityRaw.ID, err = dbStore.SaveProviderActivityRawData(ctx, activityRaw)
+	if err != nil {
+		return fmt.Errorf("failed to save activity: %w", err)
+	}
+
+	err = dbStore.UploadRawActivityFile(ctx, ingest.ProviderGarmin, activityRaw, file.Format, file.Content)
+	if err != nil {
+		return fmt.Errorf("failed to upload activity file: %w", err)
+	}
+
+	_, err = dbStore.StoreActivityEndurance(ctx, ingest.ProviderGarmin, activityRaw, act, file.Streams())
+	if err != nil {
+		return fmt.Errorf("failed to store activity: %w", err)
+	}
+
+	return nil
+}
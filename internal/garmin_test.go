@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/gabrieleangeletti/vo2/ingest"
+	"github.com/gabrieleangeletti/vo2/internal/garminfake"
+	"github.com/gabrieleangeletti/vo2/provider"
+	"github.com/gabrieleangeletti/vo2/store"
+)
+
+// testDB connects to the database of VO2_TEST_DATABASE_URL, which must have the migrations applied. The tests that
+// need a database are skipped when it's not set.
+func testDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	dsn := os.Getenv("VO2_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("VO2_TEST_DATABASE_URL not set")
+	}
+
+	db, err := sqlx.Connect("pgx", dsn)
+	if err != nil {
+		t.Fatalf("failed to connect to the test database: %v", err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// newFakeGarmin starts the fake Garmin API and points the Garmin client to it.
+func newFakeGarmin(t *testing.T) *garminfake.Server {
+	t.Helper()
+
+	const (
+		consumerKey    = "test-consumer-key"
+		consumerSecret = "test-consumer-secret"
+	)
+
+	srv := httptest.NewUnstartedServer(nil)
+
+	baseURL := "http://" + srv.Listener.Addr().String()
+
+	fake := garminfake.NewServer(baseURL, consumerKey, consumerSecret)
+
+	srv.Config.Handler = fake
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	t.Setenv("GARMIN_CONSUMER_KEY", consumerKey)
+	t.Setenv("GARMIN_CONSUMER_SECRET", consumerSecret)
+	t.Setenv("GARMIN_AUTH_BASE_URL", baseURL)
+	t.Setenv("GARMIN_CONNECT_BASE_URL", baseURL)
+	t.Setenv("GARMIN_API_BASE_URL", baseURL)
+
+	return fake
+}
+
+func TestGarminAuthAndPush(t *testing.T) {
+	ctx := context.Background()
+
+	db := testDB(t)
+
+	t.Setenv("OAUTH_TOKEN_KEYS", "test:"+base64.StdEncoding.EncodeToString(make([]byte, 32)))
+
+	fake := newFakeGarmin(t)
+
+	dbStore, err := store.NewStore(db, store.WithObjectStore(store.NewMemoryObjectStore()))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /providers/garmin/auth/callback", garminAuthHandler(db, dbStore))
+	mux.HandleFunc("POST /providers/garmin/push", garminPushHandler(db, dbStore))
+
+	api := httptest.NewServer(mux)
+	t.Cleanup(api.Close)
+
+	prov, err := provider.GetBySlug(db, string(ingest.ProviderGarmin))
+	if err != nil {
+		t.Fatalf("failed to get Garmin provider: %v", err)
+	}
+
+	// The first leg of the handshake, as `vo2 provider garmin auth` does it.
+	client := ingest.NewGarminClient()
+
+	requestToken, err := client.RequestToken(ctx, api.URL+"/providers/garmin/auth/callback")
+	if err != nil {
+		t.Fatalf("failed to get request token: %v", err)
+	}
+
+	_, err = CreateOAuth1RequestToken(ctx, db, prov.ID, requestToken)
+	if err != nil {
+		t.Fatalf("failed to store request token: %v", err)
+	}
+
+	// The fake authorizes right away and redirects to the callback, which exchanges the request token.
+	resp, err := http.Get(client.AuthorizationURL(requestToken))
+	if err != nil {
+		t.Fatalf("failed to authorize request token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got callback status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var callback struct {
+		AthleteID uuid.UUID `json:"athleteId"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&callback)
+	if err != nil {
+		t.Fatalf("failed to decode callback response: %v", err)
+	}
+
+	t.Cleanup(func() {
+		req, err := CreateErasureRequest(ctx, db, callback.AthleteID, nil)
+		if err != nil {
+			t.Errorf("failed to create erasure request: %v", err)
+			return
+		}
+
+		_, err = ProcessErasureRequest(ctx, db, dbStore, req.ID)
+		if err != nil {
+			t.Errorf("failed to erase athlete: %v", err)
+		}
+
+		if _, err := db.ExecContext(ctx, `DELETE FROM vo2.erasure_requests WHERE id = $1`, req.ID); err != nil {
+			t.Errorf("failed to delete erasure request: %v", err)
+		}
+	})
+
+	link, err := GetProviderAthlete(ctx, db, prov.ID, garminfake.UserID)
+	if err != nil {
+		t.Fatalf("failed to get provider athlete: %v", err)
+	}
+
+	if link.AthleteID != callback.AthleteID {
+		t.Errorf("Garmin user linked to athlete %s, want %s", link.AthleteID, callback.AthleteID)
+	}
+
+	err = fake.Push(ctx, api.URL+"/providers/garmin/push", false)
+	if err != nil {
+		t.Fatalf("failed to push notifications: %v", err)
+	}
+
+	// The run has a summary and a file, the strength training a summary only.
+	run, err := dbStore.GetProviderActivityRawByProviderActivityID(ctx, prov.ID, callback.AthleteID, "15720937215")
+	if err != nil {
+		t.Fatalf("failed to get run: %v", err)
+	}
+
+	if !strings.HasPrefix(run.DetailedActivityURI.String, "mem://activity_details/garmin/raw/") {
+		t.Errorf("got run file URI %q, want the FIT file in the memory object store", run.DetailedActivityURI.String)
+	}
+
+	var stored ingest.GarminActivity
+
+	err = json.Unmarshal(run.Data, &stored)
+	if err != nil {
+		t.Fatalf("failed to decode run: %v", err)
+	}
+
+	if stored.ActivityName != "Milano Running" {
+		t.Errorf("got run name %q, want %q: the file replaced the summary", stored.ActivityName, "Milano Running")
+	}
+
+	strength, err := dbStore.GetProviderActivityRawByProviderActivityID(ctx, prov.ID, callback.AthleteID, "15720937216")
+	if err != nil {
+		t.Fatalf("failed to get strength training: %v", err)
+	}
+
+	if strength.DetailedActivityURI.Valid {
+		t.Errorf("got strength training file URI %q, want none", strength.DetailedActivityURI.String)
+	}
+}
@@ -0,0 +1,281 @@
+// Package garminfake is a stand-in for the Garmin OAuth1 and Health APIs.
+//
+// It serves recorded fixtures and pushes recorded notifications, so that the Garmin flow (authorization, activity
+// summaries and activity files) can be exercised offline. Requests are checked against the consumer secret, like
+// the real APIs do, so that signing errors surface locally too.
+package garminfake
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gabrieleangeletti/vo2/ingest"
+)
+
+const (
+	// UserID is the Garmin ID of the fake user.
+	UserID = "fake-garmin-user"
+
+	// AccessToken is the access token of the fake user, the one in the pushed notifications.
+	AccessToken = "fake-access-token"
+
+	accessTokenSecret  = "fake-access-token-secret"
+	requestToken       = "fake-request-token"
+	requestTokenSecret = "fake-request-token-secret"
+	verifier           = "fake-verifier"
+)
+
+var (
+	ErrPushFailed = errors.New("push notification failed")
+)
+
+//go:embed fixtures
+var fixtures embed.FS
+
+// Server is a fake Garmin API. It serves the OAuth1 endpoints, the user ID endpoint and the activity files.
+type Server struct {
+	baseURL        string
+	consumerKey    string
+	consumerSecret string
+	handler        http.Handler
+
+	mu sync.Mutex
+	// callbacks are the callback URLs of the request tokens, where the authorization redirects to.
+	callbacks map[string]string
+}
+
+// NewServer returns a fake Garmin API reachable at baseURL, accepting requests signed with the given consumer.
+func NewServer(baseURL, consumerKey, consumerSecret string) *Server {
+	s := &Server{
+		baseURL:        strings.TrimRight(baseURL, "/"),
+		consumerKey:    consumerKey,
+		consumerSecret: consumerSecret,
+		callbacks:      make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /oauth-service/oauth/request_token", s.requestTokenHandler)
+	mux.HandleFunc("GET /oauthConfirm", s.authorizeHandler)
+	mux.HandleFunc("POST /oauth-service/oauth/access_token", s.accessTokenHandler)
+	mux.HandleFunc("GET /wellness-api/rest/user/id", s.userIDHandler)
+	mux.HandleFunc("DELETE /wellness-api/rest/user/registration", s.userRegistrationHandler)
+	mux.HandleFunc("GET /wellness-api/rest/activityFile", s.activityFileHandler)
+
+	s.handler = mux
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Fake Garmin request", "method", r.Method, "path", r.URL.Path)
+
+	s.handler.ServeHTTP(w, r)
+}
+
+// Push sends the recorded notifications to the push endpoint of the API: the activity summaries, then the activity
+// files, or the other way around with filesFirst, since Garmin doesn't guarantee any order.
+func (s *Server) Push(ctx context.Context, pushURL string, filesFirst bool) error {
+	names := []string{"fixtures/activities.json", "fixtures/activity_files.json"}
+	if filesFirst {
+		names[0], names[1] = names[1], names[0]
+	}
+
+	replacer := strings.NewReplacer(
+		"$USER_ID", UserID,
+		"$USER_ACCESS_TOKEN", AccessToken,
+		"$BASE_URL", s.baseURL,
+	)
+
+	for _, name := range names {
+		data, err := fixtures.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		body := replacer.Replace(string(data))
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, pushURL, strings.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%w: %s: %d %s", ErrPushFailed, name, resp.StatusCode, respBody)
+		}
+
+		slog.Info("Pushed fake Garmin notification", "fixture", name)
+	}
+
+	return nil
+}
+
+func (s *Server) requestTokenHandler(w http.ResponseWriter, r *http.Request) {
+	params, ok := s.verify(w, r, "")
+	if !ok {
+		return
+	}
+
+	callback := params["oauth_callback"]
+	if callback == "" {
+		http.Error(w, "missing oauth_callback", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.callbacks[requestToken] = callback
+	s.mu.Unlock()
+
+	writeForm(w, url.Values{
+		"oauth_token":              {requestToken},
+		"oauth_token_secret":       {requestTokenSecret},
+		"oauth_callback_confirmed": {"true"},
+	})
+}
+
+// authorizeHandler authorizes the request token right away, as if the athlete accepted.
+func (s *Server) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("oauth_token")
+
+	s.mu.Lock()
+	callback, ok := s.callbacks[token]
+	s.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "unknown oauth_token", http.StatusBadRequest)
+		return
+	}
+
+	u, err := url.Parse(callback)
+	if err != nil {
+		http.Error(w, "invalid oauth_callback", http.StatusBadRequest)
+		return
+	}
+
+	query := u.Query()
+	query.Set("oauth_token", token)
+	query.Set("oauth_verifier", verifier)
+	u.RawQuery = query.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func (s *Server) accessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	params, ok := s.verify(w, r, requestTokenSecret)
+	if !ok {
+		return
+	}
+
+	if params["oauth_token"] != requestToken || params["oauth_verifier"] != verifier {
+		http.Error(w, "invalid request token or verifier", http.StatusUnauthorized)
+		return
+	}
+
+	writeForm(w, url.Values{
+		"oauth_token":        {AccessToken},
+		"oauth_token_secret": {accessTokenSecret},
+	})
+}
+
+func (s *Server) userIDHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.verifyAccessToken(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"userId": %q}`, UserID)
+}
+
+func (s *Server) userRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.verifyAccessToken(w, r) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) activityFileHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.verifyAccessToken(w, r) {
+		return
+	}
+
+	data, err := fixtures.ReadFile("fixtures/activity.fit")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "activity.fit", time.Time{}, bytes.NewReader(data))
+}
+
+func (s *Server) verifyAccessToken(w http.ResponseWriter, r *http.Request) bool {
+	params, ok := s.verify(w, r, accessTokenSecret)
+	if !ok {
+		return false
+	}
+
+	if params["oauth_token"] != AccessToken {
+		http.Error(w, "invalid access token", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// verify checks the consumer key and the signature of a request, and returns its OAuth1 parameters.
+func (s *Server) verify(w http.ResponseWriter, r *http.Request, tokenSecret string) (map[string]string, bool) {
+	params, err := ingest.ParseOAuth1Header(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	if params["oauth_consumer_key"] != s.consumerKey {
+		http.Error(w, "invalid consumer key", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	// The signature covers the URL the client requested, not the one the server sees.
+	base, err := url.Parse(s.baseURL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	signed := r.Clone(r.Context())
+	signed.URL.Scheme = base.Scheme
+	signed.URL.Host = base.Host
+
+	err = ingest.VerifyOAuth1Request(signed, s.consumerSecret, tokenSecret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return params, true
+}
+
+func writeForm(w http.ResponseWriter, values url.Values) {
+	w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+	io.WriteString(w, values.Encode())
+}
@@ -0,0 +1,123 @@
+package garminfake
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gabrieleangeletti/vo2/ingest"
+)
+
+const (
+	testConsumerKey    = "test-consumer-key"
+	testConsumerSecret = "test-consumer-secret"
+	testCallbackURL    = "https://vo2.example.com/providers/garmin/auth/callback"
+)
+
+// newTestServer starts the fake and points the Garmin client to it, signing with consumerSecret.
+func newTestServer(t *testing.T, consumerSecret string) string {
+	t.Helper()
+
+	srv := httptest.NewUnstartedServer(nil)
+
+	baseURL := "http://" + srv.Listener.Addr().String()
+
+	srv.Config.Handler = NewServer(baseURL, testConsumerKey, testConsumerSecret)
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	t.Setenv("GARMIN_CONSUMER_KEY", testConsumerKey)
+	t.Setenv("GARMIN_CONSUMER_SECRET", consumerSecret)
+	t.Setenv("GARMIN_AUTH_BASE_URL", baseURL)
+	t.Setenv("GARMIN_CONNECT_BASE_URL", baseURL)
+	t.Setenv("GARMIN_API_BASE_URL", baseURL)
+
+	return baseURL
+}
+
+func TestHandshake(t *testing.T) {
+	ctx := context.Background()
+
+	baseURL := newTestServer(t, testConsumerSecret)
+
+	client := ingest.NewGarminClient()
+
+	reqToken, err := client.RequestToken(ctx, testCallbackURL)
+	if err != nil {
+		t.Fatalf("failed to get request token: %v", err)
+	}
+
+	// The callback isn't served, so stop at the redirect and read the verifier from it.
+	httpClient := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := httpClient.Get(client.AuthorizationURL(reqToken))
+	if err != nil {
+		t.Fatalf("failed to authorize request token: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("got authorization status %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("failed to parse callback URL: %v", err)
+	}
+
+	if got := callback.Scheme + "://" + callback.Host + callback.Path; got != testCallbackURL {
+		t.Fatalf("redirected to %q, want %q", got, testCallbackURL)
+	}
+
+	if got := callback.Query().Get("oauth_token"); got != reqToken.Token {
+		t.Errorf("got callback oauth_token %q, want %q", got, reqToken.Token)
+	}
+
+	accessToken, err := client.AccessToken(ctx, reqToken, callback.Query().Get("oauth_verifier"))
+	if err != nil {
+		t.Fatalf("failed to get access token: %v", err)
+	}
+
+	if accessToken.Token != AccessToken {
+		t.Errorf("got access token %q, want %q", accessToken.Token, AccessToken)
+	}
+
+	userID, err := client.UserID(ctx, accessToken)
+	if err != nil {
+		t.Fatalf("failed to get user ID: %v", err)
+	}
+
+	if userID != UserID {
+		t.Errorf("got user ID %q, want %q", userID, UserID)
+	}
+
+	data, err := client.DownloadActivityFile(ctx, accessToken, baseURL+"/wellness-api/rest/activityFile?id=15720937215")
+	if err != nil {
+		t.Fatalf("failed to download activity file: %v", err)
+	}
+
+	if len(data) == 0 {
+		t.Error("got an empty activity file")
+	}
+
+	err = client.DeleteUserRegistration(ctx, accessToken)
+	if err != nil {
+		t.Errorf("failed to delete user registration: %v", err)
+	}
+}
+
+func TestHandshakeWrongSecret(t *testing.T) {
+	newTestServer(t, "wrong-consumer-secret")
+
+	_, err := ingest.NewGarminClient().RequestToken(context.Background(), testCallbackURL)
+	if !errors.Is(err, ingest.ErrGarminRequestFailed) {
+		t.Fatalf("got error %v, want %v", err, ingest.ErrGarminRequestFailed)
+	}
+}
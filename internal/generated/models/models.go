@@ -63,6 +63,7 @@ const (
 	Vo2AthleteMeasurementSourceLabTest   Vo2AthleteMeasurementSource = "lab_test"
 	Vo2AthleteMeasurementSourceFieldTest Vo2AthleteMeasurementSource = "field_test"
 	Vo2AthleteMeasurementSourceManual    Vo2AthleteMeasurementSource = "manual"
+	Vo2AthleteMeasurementSourceDevice    Vo2AthleteMeasurementSource = "device"
 )
 
 func (e *Vo2AthleteMeasurementSource) Scan(src interface{}) error {
@@ -103,10 +104,11 @@ func (ns NullVo2AthleteMeasurementSource) Value() (driver.Value, error) {
 type Vo2AthleteMeasurementType string
 
 const (
-	Vo2AthleteMeasurementTypeLt1    Vo2AthleteMeasurementType = "lt1"
-	Vo2AthleteMeasurementTypeLt2    Vo2AthleteMeasurementType = "lt2"
-	Vo2AthleteMeasurementTypeVo2max Vo2AthleteMeasurementType = "vo2max"
-	Vo2AthleteMeasurementTypeWeight Vo2AthleteMeasurementType = "weight"
+	Vo2AthleteMeasurementTypeLt1       Vo2AthleteMeasurementType = "lt1"
+	Vo2AthleteMeasurementTypeLt2       Vo2AthleteMeasurementType = "lt2"
+	Vo2AthleteMeasurementTypeVo2max    Vo2AthleteMeasurementType = "vo2max"
+	Vo2AthleteMeasurementTypeWeight    Vo2AthleteMeasurementType = "weight"
+	Vo2AthleteMeasurementTypeRestingHr Vo2AthleteMeasurementType = "resting_hr"
 )
 
 func (e *Vo2AthleteMeasurementType) Scan(src interface{}) error {
@@ -144,10 +146,140 @@ func (ns NullVo2AthleteMeasurementType) Value() (driver.Value, error) {
 	return string(ns.Vo2AthleteMeasurementType), nil
 }
 
+type Vo2ErasureRequestScope string
+
+const (
+	Vo2ErasureRequestScopeProvider Vo2ErasureRequestScope = "provider"
+	Vo2ErasureRequestScopeAthlete  Vo2ErasureRequestScope = "athlete"
+)
+
+func (e *Vo2ErasureRequestScope) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = Vo2ErasureRequestScope(s)
+	case string:
+		*e = Vo2ErasureRequestScope(s)
+	default:
+		return fmt.Errorf("unsupported scan type for Vo2ErasureRequestScope: %T", src)
+	}
+	return nil
+}
+
+type NullVo2ErasureRequestScope struct {
+	Vo2ErasureRequestScope Vo2ErasureRequestScope
+	Valid                  bool // Valid is true if Vo2ErasureRequestScope is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullVo2ErasureRequestScope) Scan(value interface{}) error {
+	if value == nil {
+		ns.Vo2ErasureRequestScope, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.Vo2ErasureRequestScope.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullVo2ErasureRequestScope) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.Vo2ErasureRequestScope), nil
+}
+
+type Vo2ErasureRequestStatus string
+
+const (
+	Vo2ErasureRequestStatusPending   Vo2ErasureRequestStatus = "pending"
+	Vo2ErasureRequestStatusRunning   Vo2ErasureRequestStatus = "running"
+	Vo2ErasureRequestStatusCompleted Vo2ErasureRequestStatus = "completed"
+	Vo2ErasureRequestStatusFailed    Vo2ErasureRequestStatus = "failed"
+)
+
+func (e *Vo2ErasureRequestStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = Vo2ErasureRequestStatus(s)
+	case string:
+		*e = Vo2ErasureRequestStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for Vo2ErasureRequestStatus: %T", src)
+	}
+	return nil
+}
+
+type NullVo2ErasureRequestStatus struct {
+	Vo2ErasureRequestStatus Vo2ErasureRequestStatus
+	Valid                   bool // Valid is true if Vo2ErasureRequestStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullVo2ErasureRequestStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.Vo2ErasureRequestStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.Vo2ErasureRequestStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullVo2ErasureRequestStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.Vo2ErasureRequestStatus), nil
+}
+
+type Vo2ObjectOutboxStatus string
+
+const (
+	Vo2ObjectOutboxStatusPending    Vo2ObjectOutboxStatus = "pending"
+	Vo2ObjectOutboxStatusRolledBack Vo2ObjectOutboxStatus = "rolled_back"
+)
+
+func (e *Vo2ObjectOutboxStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = Vo2ObjectOutboxStatus(s)
+	case string:
+		*e = Vo2ObjectOutboxStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for Vo2ObjectOutboxStatus: %T", src)
+	}
+	return nil
+}
+
+type NullVo2ObjectOutboxStatus struct {
+	Vo2ObjectOutboxStatus Vo2ObjectOutboxStatus
+	Valid                 bool // Valid is true if Vo2ObjectOutboxStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullVo2ObjectOutboxStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.Vo2ObjectOutboxStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.Vo2ObjectOutboxStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullVo2ObjectOutboxStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.Vo2ObjectOutboxStatus), nil
+}
+
 type Vo2ProviderConnectionType string
 
 const (
 	Vo2ProviderConnectionTypeOauth2 Vo2ProviderConnectionType = "oauth2"
+	Vo2ProviderConnectionTypeManual Vo2ProviderConnectionType = "manual"
+	Vo2ProviderConnectionTypeOauth1 Vo2ProviderConnectionType = "oauth1"
 )
 
 func (e *Vo2ProviderConnectionType) Scan(src interface{}) error {
@@ -185,6 +317,51 @@ func (ns NullVo2ProviderConnectionType) Value() (driver.Value, error) {
 	return string(ns.Vo2ProviderConnectionType), nil
 }
 
+type Vo2SyncWindowStatus string
+
+const (
+	Vo2SyncWindowStatusPending   Vo2SyncWindowStatus = "pending"
+	Vo2SyncWindowStatusRunning   Vo2SyncWindowStatus = "running"
+	Vo2SyncWindowStatusDelayed   Vo2SyncWindowStatus = "delayed"
+	Vo2SyncWindowStatusCompleted Vo2SyncWindowStatus = "completed"
+	Vo2SyncWindowStatusFailed    Vo2SyncWindowStatus = "failed"
+)
+
+func (e *Vo2SyncWindowStatus) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case []byte:
+		*e = Vo2SyncWindowStatus(s)
+	case string:
+		*e = Vo2SyncWindowStatus(s)
+	default:
+		return fmt.Errorf("unsupported scan type for Vo2SyncWindowStatus: %T", src)
+	}
+	return nil
+}
+
+type NullVo2SyncWindowStatus struct {
+	Vo2SyncWindowStatus Vo2SyncWindowStatus
+	Valid               bool // Valid is true if Vo2SyncWindowStatus is not NULL
+}
+
+// Scan implements the Scanner interface.
+func (ns *NullVo2SyncWindowStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.Vo2SyncWindowStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.Vo2SyncWindowStatus.Scan(value)
+}
+
+// Value implements the driver Valuer interface.
+func (ns NullVo2SyncWindowStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
+	}
+	return string(ns.Vo2SyncWindowStatus), nil
+}
+
 // Endurance activities
 type Vo2ActivitiesEndurance struct {
 	ID                    uuid.UUID
@@ -232,11 +409,31 @@ type Vo2ActivitiesEndurance struct {
 	CreatedAt  sql.NullTime
 	UpdatedAt  sql.NullTime
 	DeletedAt  sql.NullTime
+	// URI of the compact timeseries file of the activity.
+	TimeseriesUri sql.NullString
+}
+
+// Endurance activities recorded once and received from more than one provider. The duplicates are hidden from the volume across providers, in favor of the canonical activity.
+type Vo2ActivitiesEnduranceDuplicate struct {
+	ID                  int32
+	AthleteID           uuid.UUID
+	CanonicalActivityID uuid.UUID
+	DuplicateActivityID uuid.UUID
+	// Overlap of the two activities, as a fraction of the shortest one.
+	TimeOverlap float64
+	// Difference between the distances of the two activities, as a fraction of the longest one.
+	DistanceDiff float64
+	// Largest distance between the start points and between the end points of the two routes. Measured in meters. NULL if an activity has no route.
+	RouteDistance sql.NullFloat64
+	CreatedAt     sql.NullTime
+	UpdatedAt     sql.NullTime
+	DeletedAt     sql.NullTime
 }
 
 type Vo2ActivitiesEnduranceTag struct {
 	ActivityID uuid.UUID
 	TagID      int32
+	DeletedAt  sql.NullTime
 }
 
 type Vo2ActivitiesThresholdAnalysis struct {
@@ -262,17 +459,18 @@ type Vo2ActivityTag struct {
 type Vo2Athlete struct {
 	ID          uuid.UUID
 	UserID      uuid.UUID
-	Age         int16
-	HeightCm    int16
-	Country     string
-	Gender      Gender
-	FirstName   string
-	LastName    string
-	DisplayName string
-	Email       string
+	HeightCm    sql.NullInt16
+	Country     sql.NullString
+	Gender      NullGender
+	FirstName   sql.NullString
+	LastName    sql.NullString
+	DisplayName sql.NullString
+	Email       sql.NullString
 	CreatedAt   sql.NullTime
 	UpdatedAt   sql.NullTime
 	DeletedAt   sql.NullTime
+	// NULL until the athlete completes the profile.
+	BirthDate sql.NullTime
 }
 
 type Vo2AthleteCurrentMeasurement struct {
@@ -313,6 +511,47 @@ type Vo2AthleteMeasurementHistory struct {
 	DeletedAt    sql.NullTime
 }
 
+// Audit record of the provider disconnections and athlete erasures, processed in the background.
+type Vo2ErasureRequest struct {
+	ID        uuid.UUID
+	AthleteID uuid.UUID
+	// Provider to disconnect. NULL for the erasure of all the data of the athlete.
+	ProviderID sql.NullInt32
+	Scope      Vo2ErasureRequestScope
+	Status     Vo2ErasureRequestStatus
+	// Whether the access of the app was revoked in the provider.
+	Deauthorized bool
+	// Number of rows deleted per table.
+	DeletedRows json.RawMessage
+	// Number of objects deleted from the object store.
+	DeletedObjects int32
+	LastError      sql.NullString
+	StartedAt      sql.NullTime
+	CompletedAt    sql.NullTime
+	CreatedAt      sql.NullTime
+	UpdatedAt      sql.NullTime
+}
+
+type Vo2Oauth1RequestToken struct {
+	ID          int32
+	ProviderID  int32
+	Token       string
+	TokenSecret string
+	CreatedAt   sql.NullTime
+	ExpiresAt   time.Time
+}
+
+// Object uploads, recorded before the upload and committed in the same transaction as the rows referencing the object.
+type Vo2ObjectOutbox struct {
+	ID        uuid.UUID
+	ObjectKey string
+	// pending until the referencing rows are committed. Stale pending entries are deleted or rolled back by the reconciler.
+	Status    Vo2ObjectOutboxStatus
+	CreatedAt sql.NullTime
+	UpdatedAt sql.NullTime
+	DeletedAt sql.NullTime
+}
+
 type Vo2Provider struct {
 	ID             int32
 	Name           string
@@ -340,6 +579,34 @@ type Vo2ProviderActivityRawDatum struct {
 	DeletedAt           sql.NullTime
 }
 
+// Athletes of the providers, linked to the athletes. A user can manage several athletes.
+type Vo2ProviderAthlete struct {
+	ID         int32
+	ProviderID int32
+	// ID of the athlete in the provider, e.g. the Strava athlete ID or the Garmin user ID.
+	ProviderAthleteID string
+	AthleteID         uuid.UUID
+	// User that connected the provider athlete, whose credentials are used to fetch its data.
+	UserID    uuid.UUID
+	CreatedAt sql.NullTime
+	UpdatedAt sql.NullTime
+}
+
+type Vo2ProviderOauth1Credential struct {
+	ID          int32
+	ProviderID  int32
+	UserID      uuid.UUID
+	Token       string
+	TokenSecret string
+	CreatedAt   sql.NullTime
+	UpdatedAt   sql.NullTime
+	DeletedAt   sql.NullTime
+	// ID of the key encrypting data_key. NULL if the tokens are stored in plaintext.
+	TokenKeyID sql.NullString
+	// Random key encrypting the tokens, encrypted with the token key.
+	DataKey []byte
+}
+
 type Vo2ProviderOauth2Credential struct {
 	ID           int32
 	ProviderID   int32
@@ -350,6 +617,38 @@ type Vo2ProviderOauth2Credential struct {
 	CreatedAt    sql.NullTime
 	UpdatedAt    sql.NullTime
 	DeletedAt    sql.NullTime
+	// ID of the key encrypting data_key. NULL if the tokens are stored in plaintext.
+	TokenKeyID sql.NullString
+	// Random key encrypting the tokens, encrypted with the token key.
+	DataKey []byte
+}
+
+type Vo2ProviderRateLimit struct {
+	ProviderID  int32
+	WindowName  string
+	WindowStart time.Time
+	Used        int32
+	CreatedAt   sql.NullTime
+	UpdatedAt   sql.NullTime
+}
+
+// Progress of the historical data tasks, one row per athlete, provider and time window.
+type Vo2SyncWindow struct {
+	ID         int32
+	AthleteID  uuid.UUID
+	ProviderID int32
+	StartTime  time.Time
+	EndTime    time.Time
+	Status     Vo2SyncWindowStatus
+	// Provider ID of the last activity processed in the window, the task resumes after it.
+	Cursor         sql.NullString
+	TotalCount     sql.NullInt32
+	ProcessedCount int32
+	LastError      sql.NullString
+	StartedAt      sql.NullTime
+	CompletedAt    sql.NullTime
+	CreatedAt      sql.NullTime
+	UpdatedAt      sql.NullTime
 }
 
 type Vo2User struct {
@@ -361,6 +660,16 @@ type Vo2User struct {
 	DeletedAt      sql.NullTime
 }
 
+type Vo2WebhookSubscription struct {
+	ID             int32
+	ProviderID     int32
+	SubscriptionID string
+	CallbackUrl    string
+	CreatedAt      sql.NullTime
+	UpdatedAt      sql.NullTime
+	DeletedAt      sql.NullTime
+}
+
 type Vo2WebhookVerification struct {
 	ID        int32
 	Token     string
@@ -15,12 +15,39 @@ import (
 	"github.com/lib/pq"
 )
 
+const commitObjectOutboxEntries = `-- name: CommitObjectOutboxEntries :exec
+DELETE FROM vo2.object_outbox
+WHERE
+    status = 'pending'
+    AND id = ANY($1::uuid[])
+`
+
+// A committed upload needs no more tracking, its entry is deleted.
+func (q *Queries) CommitObjectOutboxEntries(ctx context.Context, ids []uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, commitObjectOutboxEntries, pq.Array(ids))
+	return err
+}
+
+const createObjectOutboxEntry = `-- name: CreateObjectOutboxEntry :one
+INSERT INTO vo2.object_outbox (object_key)
+VALUES ($1)
+RETURNING id
+`
+
+func (q *Queries) CreateObjectOutboxEntry(ctx context.Context, objectKey string) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, createObjectOutboxEntry, objectKey)
+	var id uuid.UUID
+	err := row.Scan(&id)
+	return id, err
+}
+
 const getActivityEndurance = `-- name: GetActivityEndurance :one
 SELECT
-	a.id, a.provider_id, a.athlete_id, a.provider_raw_activity_id, a.name, a.description, a.sport, a.start_time, a.end_time, a.iana_timezone, a.utc_offset, a.elapsed_time, a.moving_time, a.distance, a.elev_gain, a.elev_loss, a.avg_speed, a.avg_hr, a.max_hr, a.summary_polyline, a.summary_route, a.gpx_file_uri, a.fit_file_uri, a.created_at, a.updated_at, a.deleted_at
+	a.id, a.provider_id, a.athlete_id, a.provider_raw_activity_id, a.name, a.description, a.sport, a.start_time, a.end_time, a.iana_timezone, a.utc_offset, a.elapsed_time, a.moving_time, a.distance, a.elev_gain, a.elev_loss, a.avg_speed, a.avg_hr, a.max_hr, a.summary_polyline, a.summary_route, a.gpx_file_uri, a.fit_file_uri, a.created_at, a.updated_at, a.deleted_at, a.timeseries_uri
 FROM vo2.activities_endurance a
 WHERE
     a.id = $1
+    AND a.deleted_at IS NULL
 `
 
 func (q *Queries) GetActivityEndurance(ctx context.Context, id uuid.UUID) (Vo2ActivitiesEndurance, error) {
@@ -53,6 +80,7 @@ func (q *Queries) GetActivityEndurance(ctx context.Context, id uuid.UUID) (Vo2Ac
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.DeletedAt,
+		&i.TimeseriesUri,
 	)
 	return i, err
 }
@@ -70,6 +98,7 @@ type GetActivityEnduranceIDParams struct {
 	ProviderRawActivityID uuid.UUID
 }
 
+// Soft-deleted activities are included, so that an activity stored again keeps its ID.
 func (q *Queries) GetActivityEnduranceID(ctx context.Context, arg GetActivityEnduranceIDParams) (uuid.UUID, error) {
 	row := q.db.QueryRowContext(ctx, getActivityEnduranceID, arg.ProviderID, arg.AthleteID, arg.ProviderRawActivityID)
 	var id uuid.UUID
@@ -85,6 +114,8 @@ vo2.activities_endurance_tags at
 JOIN vo2.activity_tags t ON at.tag_id = t.id
 WHERE
     at.activity_id = $1
+    AND at.deleted_at IS NULL
+    AND t.deleted_at IS NULL
 `
 
 func (q *Queries) GetActivityTags(ctx context.Context, activityID uuid.UUID) ([]Vo2ActivityTag, error) {
@@ -119,11 +150,12 @@ func (q *Queries) GetActivityTags(ctx context.Context, activityID uuid.UUID) ([]
 
 const getAthleteByID = `-- name: GetAthleteByID :one
 SELECT
-    id, user_id, age, height_cm, country, gender, first_name, last_name, display_name, email, created_at, updated_at, deleted_at
+    id, user_id, height_cm, country, gender, first_name, last_name, display_name, email, created_at, updated_at, deleted_at, birth_date
 FROM
     vo2.athletes
 WHERE
     id = $1
+    AND deleted_at IS NULL
 `
 
 func (q *Queries) GetAthleteByID(ctx context.Context, id uuid.UUID) (Vo2Athlete, error) {
@@ -132,7 +164,6 @@ func (q *Queries) GetAthleteByID(ctx context.Context, id uuid.UUID) (Vo2Athlete,
 	err := row.Scan(
 		&i.ID,
 		&i.UserID,
-		&i.Age,
 		&i.HeightCm,
 		&i.Country,
 		&i.Gender,
@@ -143,6 +174,7 @@ func (q *Queries) GetAthleteByID(ctx context.Context, id uuid.UUID) (Vo2Athlete,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.DeletedAt,
+		&i.BirthDate,
 	)
 	return i, err
 }
@@ -195,8 +227,19 @@ FROM vo2.activities_endurance a
 JOIN vo2.providers p ON a.provider_id = p.id
 WHERE
     a.athlete_id = $1
-    AND p.slug = $2
+    AND (
+        p.slug = $2
+        -- Across providers, an activity received from more than one provider counts once.
+        OR (
+            $2::text = 'all'
+            AND NOT EXISTS (
+                SELECT 1 FROM vo2.activities_endurance_duplicates d
+                WHERE d.duplicate_activity_id = a.id AND d.deleted_at IS NULL
+            )
+        )
+    )
     AND a.start_time >= date_trunc('year', NOW())
+    AND a.deleted_at IS NULL
     AND lower(a.sport) IN ('running', 'trail-running')
 `
 
@@ -270,8 +313,19 @@ period_data AS (
     JOIN selected_sports ss ON lower(a.sport) = ss.sport
     WHERE
         a.athlete_id = $4
-        AND p.slug = $5
+        AND (
+            p.slug = $5
+            -- Across providers, an activity received from more than one provider counts once.
+            OR (
+                $5::text = 'all'
+                AND NOT EXISTS (
+                    SELECT 1 FROM vo2.activities_endurance_duplicates d
+                    WHERE d.duplicate_activity_id = a.id AND d.deleted_at IS NULL
+                )
+            )
+        )
         AND a.start_time >= $3::timestamptz
+        AND a.deleted_at IS NULL
     GROUP BY period_ts, lower(a.sport)
 )
 SELECT
@@ -365,6 +419,7 @@ SELECT
 FROM vo2.provider_activity_raw_data
 WHERE
     id = $1
+    AND deleted_at IS NULL
 `
 
 func (q *Queries) GetProviderActivityRaw(ctx context.Context, id uuid.UUID) (Vo2ProviderActivityRawDatum, error) {
@@ -388,13 +443,52 @@ func (q *Queries) GetProviderActivityRaw(ctx context.Context, id uuid.UUID) (Vo2
 	return i, err
 }
 
+const getProviderActivityRawByProviderActivityID = `-- name: GetProviderActivityRawByProviderActivityID :one
+SELECT
+    id, provider_id, athlete_id, provider_activity_id, start_time, elapsed_time, iana_timezone, utc_offset, data, detailed_activity_uri, created_at, updated_at, deleted_at
+FROM vo2.provider_activity_raw_data
+WHERE
+    provider_id = $1
+    AND athlete_id = $2
+    AND provider_activity_id = $3
+    AND deleted_at IS NULL
+`
+
+type GetProviderActivityRawByProviderActivityIDParams struct {
+	ProviderID         int32
+	AthleteID          uuid.UUID
+	ProviderActivityID string
+}
+
+func (q *Queries) GetProviderActivityRawByProviderActivityID(ctx context.Context, arg GetProviderActivityRawByProviderActivityIDParams) (Vo2ProviderActivityRawDatum, error) {
+	row := q.db.QueryRowContext(ctx, getProviderActivityRawByProviderActivityID, arg.ProviderID, arg.AthleteID, arg.ProviderActivityID)
+	var i Vo2ProviderActivityRawDatum
+	err := row.Scan(
+		&i.ID,
+		&i.ProviderID,
+		&i.AthleteID,
+		&i.ProviderActivityID,
+		&i.StartTime,
+		&i.ElapsedTime,
+		&i.IanaTimezone,
+		&i.UtcOffset,
+		&i.Data,
+		&i.DetailedActivityUri,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
 const getUserAthletes = `-- name: GetUserAthletes :many
 SELECT
-    id, user_id, age, height_cm, country, gender, first_name, last_name, display_name, email, created_at, updated_at, deleted_at
+    id, user_id, height_cm, country, gender, first_name, last_name, display_name, email, created_at, updated_at, deleted_at, birth_date
 FROM
     vo2.athletes
 WHERE
     user_id = $1
+    AND deleted_at IS NULL
 `
 
 func (q *Queries) GetUserAthletes(ctx context.Context, userID uuid.UUID) ([]Vo2Athlete, error) {
@@ -409,7 +503,6 @@ func (q *Queries) GetUserAthletes(ctx context.Context, userID uuid.UUID) ([]Vo2A
 		if err := rows.Scan(
 			&i.ID,
 			&i.UserID,
-			&i.Age,
 			&i.HeightCm,
 			&i.Country,
 			&i.Gender,
@@ -420,6 +513,7 @@ func (q *Queries) GetUserAthletes(ctx context.Context, userID uuid.UUID) ([]Vo2A
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.DeletedAt,
+			&i.BirthDate,
 		); err != nil {
 			return nil, err
 		}
@@ -436,10 +530,11 @@ func (q *Queries) GetUserAthletes(ctx context.Context, userID uuid.UUID) ([]Vo2A
 
 const listActivitiesEnduranceById = `-- name: ListActivitiesEnduranceById :many
 SELECT
-	id, provider_id, athlete_id, provider_raw_activity_id, name, description, sport, start_time, end_time, iana_timezone, utc_offset, elapsed_time, moving_time, distance, elev_gain, elev_loss, avg_speed, avg_hr, max_hr, summary_polyline, summary_route, gpx_file_uri, fit_file_uri, created_at, updated_at, deleted_at
+	id, provider_id, athlete_id, provider_raw_activity_id, name, description, sport, start_time, end_time, iana_timezone, utc_offset, elapsed_time, moving_time, distance, elev_gain, elev_loss, avg_speed, avg_hr, max_hr, summary_polyline, summary_route, gpx_file_uri, fit_file_uri, created_at, updated_at, deleted_at, timeseries_uri
 FROM vo2.activities_endurance
 WHERE
     id = ANY($1::uuid[])
+    AND deleted_at IS NULL
 `
 
 func (q *Queries) ListActivitiesEnduranceById(ctx context.Context, ids []uuid.UUID) ([]Vo2ActivitiesEndurance, error) {
@@ -478,6 +573,7 @@ func (q *Queries) ListActivitiesEnduranceById(ctx context.Context, ids []uuid.UU
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.DeletedAt,
+			&i.TimeseriesUri,
 		); err != nil {
 			return nil, err
 		}
@@ -494,14 +590,17 @@ func (q *Queries) ListActivitiesEnduranceById(ctx context.Context, ids []uuid.UU
 
 const listActivitiesEnduranceByTag = `-- name: ListActivitiesEnduranceByTag :many
 SELECT
-	a.id, a.provider_id, a.athlete_id, a.provider_raw_activity_id, a.name, a.description, a.sport, a.start_time, a.end_time, a.iana_timezone, a.utc_offset, a.elapsed_time, a.moving_time, a.distance, a.elev_gain, a.elev_loss, a.avg_speed, a.avg_hr, a.max_hr, a.summary_polyline, a.summary_route, a.gpx_file_uri, a.fit_file_uri, a.created_at, a.updated_at, a.deleted_at
+	a.id, a.provider_id, a.athlete_id, a.provider_raw_activity_id, a.name, a.description, a.sport, a.start_time, a.end_time, a.iana_timezone, a.utc_offset, a.elapsed_time, a.moving_time, a.distance, a.elev_gain, a.elev_loss, a.avg_speed, a.avg_hr, a.max_hr, a.summary_polyline, a.summary_route, a.gpx_file_uri, a.fit_file_uri, a.created_at, a.updated_at, a.deleted_at, a.timeseries_uri
 FROM vo2.activities_endurance a
 JOIN vo2.activities_endurance_tags at ON at.activity_id = a.id
 JOIN vo2.activity_tags t ON at.tag_id = t.id
 WHERE
 	a.provider_id = $1 AND
 	a.athlete_id = $2 AND
-	lower(t.name) = lower($3)
+	lower(t.name) = lower($3) AND
+	a.deleted_at IS NULL AND
+	at.deleted_at IS NULL AND
+	t.deleted_at IS NULL
 ORDER BY
     a.start_time DESC
 `
@@ -548,6 +647,7 @@ func (q *Queries) ListActivitiesEnduranceByTag(ctx context.Context, arg ListActi
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.DeletedAt,
+			&i.TimeseriesUri,
 		); err != nil {
 			return nil, err
 		}
@@ -562,13 +662,49 @@ func (q *Queries) ListActivitiesEnduranceByTag(ctx context.Context, arg ListActi
 	return items, nil
 }
 
+const listActivityObjectURIs = `-- name: ListActivityObjectURIs :many
+SELECT detailed_activity_uri::text AS uri FROM vo2.provider_activity_raw_data WHERE detailed_activity_uri IS NOT NULL AND detailed_activity_uri <> ''
+UNION
+SELECT gpx_file_uri::text AS uri FROM vo2.activities_endurance WHERE gpx_file_uri IS NOT NULL AND gpx_file_uri <> ''
+UNION
+SELECT fit_file_uri::text AS uri FROM vo2.activities_endurance WHERE fit_file_uri IS NOT NULL AND fit_file_uri <> ''
+UNION
+SELECT timeseries_uri::text AS uri FROM vo2.activities_endurance WHERE timeseries_uri IS NOT NULL AND timeseries_uri <> ''
+`
+
+// Soft-deleted rows are included, their objects are kept until the rows are purged. Empty URIs, stored by older
+// versions for activities without a file, are skipped.
+func (q *Queries) ListActivityObjectURIs(ctx context.Context) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listActivityObjectURIs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var uri string
+		if err := rows.Scan(&uri); err != nil {
+			return nil, err
+		}
+		items = append(items, uri)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listAthleteActivitiesEndurance = `-- name: ListAthleteActivitiesEndurance :many
 SELECT
-	id, provider_id, athlete_id, provider_raw_activity_id, name, description, sport, start_time, end_time, iana_timezone, utc_offset, elapsed_time, moving_time, distance, elev_gain, elev_loss, avg_speed, avg_hr, max_hr, summary_polyline, summary_route, gpx_file_uri, fit_file_uri, created_at, updated_at, deleted_at
+	id, provider_id, athlete_id, provider_raw_activity_id, name, description, sport, start_time, end_time, iana_timezone, utc_offset, elapsed_time, moving_time, distance, elev_gain, elev_loss, avg_speed, avg_hr, max_hr, summary_polyline, summary_route, gpx_file_uri, fit_file_uri, created_at, updated_at, deleted_at, timeseries_uri
 FROM vo2.activities_endurance
 WHERE
 	provider_id = $1 AND
-	athlete_id = $2
+	athlete_id = $2 AND
+	deleted_at IS NULL
 ORDER BY
     start_time DESC
 `
@@ -614,6 +750,49 @@ func (q *Queries) ListAthleteActivitiesEndurance(ctx context.Context, arg ListAt
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.DeletedAt,
+			&i.TimeseriesUri,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPendingObjectOutboxEntries = `-- name: ListPendingObjectOutboxEntries :many
+SELECT
+    id, object_key, status, created_at, updated_at, deleted_at
+FROM vo2.object_outbox
+WHERE
+    status = 'pending'
+    AND created_at < $1::timestamptz
+    AND deleted_at IS NULL
+ORDER BY
+    created_at
+`
+
+func (q *Queries) ListPendingObjectOutboxEntries(ctx context.Context, createdBefore time.Time) ([]Vo2ObjectOutbox, error) {
+	rows, err := q.db.QueryContext(ctx, listPendingObjectOutboxEntries, createdBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Vo2ObjectOutbox
+	for rows.Next() {
+		var i Vo2ObjectOutbox
+		if err := rows.Scan(
+			&i.ID,
+			&i.ObjectKey,
+			&i.Status,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -628,9 +807,166 @@ func (q *Queries) ListAthleteActivitiesEndurance(ctx context.Context, arg ListAt
 	return items, nil
 }
 
+const setObjectOutboxStatus = `-- name: SetObjectOutboxStatus :exec
+UPDATE vo2.object_outbox
+SET status = $1
+WHERE id = ANY($2::uuid[])
+`
+
+type SetObjectOutboxStatusParams struct {
+	Status Vo2ObjectOutboxStatus
+	Ids    []uuid.UUID
+}
+
+func (q *Queries) SetObjectOutboxStatus(ctx context.Context, arg SetObjectOutboxStatusParams) error {
+	_, err := q.db.ExecContext(ctx, setObjectOutboxStatus, arg.Status, pq.Array(arg.Ids))
+	return err
+}
+
+const softDeleteActivitiesEnduranceByRawActivity = `-- name: SoftDeleteActivitiesEnduranceByRawActivity :many
+UPDATE vo2.activities_endurance
+SET deleted_at = NOW()
+WHERE
+    provider_raw_activity_id = $1
+    AND deleted_at IS NULL
+RETURNING id
+`
+
+func (q *Queries) SoftDeleteActivitiesEnduranceByRawActivity(ctx context.Context, providerRawActivityID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, softDeleteActivitiesEnduranceByRawActivity, providerRawActivityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const softDeleteActivitiesEnduranceTags = `-- name: SoftDeleteActivitiesEnduranceTags :exec
+UPDATE vo2.activities_endurance_tags
+SET deleted_at = NOW()
+WHERE
+    activity_id = ANY($1::uuid[])
+    AND deleted_at IS NULL
+`
+
+func (q *Queries) SoftDeleteActivitiesEnduranceTags(ctx context.Context, activityIds []uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, softDeleteActivitiesEnduranceTags, pq.Array(activityIds))
+	return err
+}
+
+const softDeleteActivitiesThresholdAnalysis = `-- name: SoftDeleteActivitiesThresholdAnalysis :exec
+UPDATE vo2.activities_threshold_analysis
+SET deleted_at = NOW()
+WHERE
+    activity_endurance_id = ANY($1::uuid[])
+    AND deleted_at IS NULL
+`
+
+func (q *Queries) SoftDeleteActivitiesThresholdAnalysis(ctx context.Context, activityEnduranceIds []uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, softDeleteActivitiesThresholdAnalysis, pq.Array(activityEnduranceIds))
+	return err
+}
+
+const softDeleteProviderActivityRaw = `-- name: SoftDeleteProviderActivityRaw :one
+UPDATE vo2.provider_activity_raw_data
+SET deleted_at = NOW()
+WHERE
+    provider_id = $1
+    AND athlete_id = $2
+    AND provider_activity_id = $3
+    AND deleted_at IS NULL
+RETURNING id
+`
+
+type SoftDeleteProviderActivityRawParams struct {
+	ProviderID         int32
+	AthleteID          uuid.UUID
+	ProviderActivityID string
+}
+
+func (q *Queries) SoftDeleteProviderActivityRaw(ctx context.Context, arg SoftDeleteProviderActivityRawParams) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, softDeleteProviderActivityRaw, arg.ProviderID, arg.AthleteID, arg.ProviderActivityID)
+	var id uuid.UUID
+	err := row.Scan(&id)
+	return id, err
+}
+
+const updateAthleteProfile = `-- name: UpdateAthleteProfile :one
+UPDATE vo2.athletes SET
+	birth_date = COALESCE($1, birth_date),
+	height_cm = COALESCE($2, height_cm),
+	country = COALESCE($3, country),
+	gender = COALESCE($4, gender),
+	first_name = COALESCE($5, first_name),
+	last_name = COALESCE($6, last_name),
+	display_name = COALESCE($7, display_name),
+	email = COALESCE($8, email)
+WHERE
+	id = $9
+	AND deleted_at IS NULL
+RETURNING id, user_id, height_cm, country, gender, first_name, last_name, display_name, email, created_at, updated_at, deleted_at, birth_date
+`
+
+type UpdateAthleteProfileParams struct {
+	BirthDate   sql.NullTime
+	HeightCm    sql.NullInt16
+	Country     sql.NullString
+	Gender      NullGender
+	FirstName   sql.NullString
+	LastName    sql.NullString
+	DisplayName sql.NullString
+	Email       sql.NullString
+	ID          uuid.UUID
+}
+
+func (q *Queries) UpdateAthleteProfile(ctx context.Context, arg UpdateAthleteProfileParams) (Vo2Athlete, error) {
+	row := q.db.QueryRowContext(ctx, updateAthleteProfile,
+		arg.BirthDate,
+		arg.HeightCm,
+		arg.Country,
+		arg.Gender,
+		arg.FirstName,
+		arg.LastName,
+		arg.DisplayName,
+		arg.Email,
+		arg.ID,
+	)
+	var i Vo2Athlete
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.HeightCm,
+		&i.Country,
+		&i.Gender,
+		&i.FirstName,
+		&i.LastName,
+		&i.DisplayName,
+		&i.Email,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.BirthDate,
+	)
+	return i, err
+}
+
 const upsertActivityEndurance = `-- name: UpsertActivityEndurance :one
 INSERT INTO vo2.activities_endurance
-	(id, provider_id, athlete_id, provider_raw_activity_id, name, description, sport, start_time, end_time, iana_timezone, utc_offset, elapsed_time, moving_time, distance, elev_gain, elev_loss, avg_speed, avg_hr, max_hr, summary_polyline, summary_route, gpx_file_uri, fit_file_uri)
+	(id, provider_id, athlete_id, provider_raw_activity_id, name, description, sport, start_time, end_time, iana_timezone, utc_offset, elapsed_time, moving_time, distance, elev_gain, elev_loss, avg_speed, avg_hr, max_hr, summary_polyline, summary_route, gpx_file_uri, fit_file_uri, timeseries_uri)
 VALUES
 	(
 		$1,
@@ -655,7 +991,8 @@ VALUES
     	$20,
     	NULLIF($21, ''),
     	$22,
-    	$23
+    	$23,
+    	$24
 )
 ON CONFLICT
 	(provider_id, athlete_id, provider_raw_activity_id)
@@ -678,8 +1015,10 @@ DO UPDATE SET
 	summary_polyline = $20,
 	summary_route = NULLIF($21, ''),
 	gpx_file_uri = $22,
-	fit_file_uri = $23
-RETURNING id, provider_id, athlete_id, provider_raw_activity_id, name, description, sport, start_time, end_time, iana_timezone, utc_offset, elapsed_time, moving_time, distance, elev_gain, elev_loss, avg_speed, avg_hr, max_hr, summary_polyline, summary_route, gpx_file_uri, fit_file_uri, created_at, updated_at, deleted_at
+	fit_file_uri = $23,
+	timeseries_uri = $24,
+	deleted_at = NULL
+RETURNING id, provider_id, athlete_id, provider_raw_activity_id, name, description, sport, start_time, end_time, iana_timezone, utc_offset, elapsed_time, moving_time, distance, elev_gain, elev_loss, avg_speed, avg_hr, max_hr, summary_polyline, summary_route, gpx_file_uri, fit_file_uri, created_at, updated_at, deleted_at, timeseries_uri
 `
 
 type UpsertActivityEnduranceParams struct {
@@ -706,6 +1045,7 @@ type UpsertActivityEnduranceParams struct {
 	SummaryRoute          interface{}
 	GpxFileUri            sql.NullString
 	FitFileUri            sql.NullString
+	TimeseriesUri         sql.NullString
 }
 
 func (q *Queries) UpsertActivityEndurance(ctx context.Context, arg UpsertActivityEnduranceParams) (Vo2ActivitiesEndurance, error) {
@@ -733,6 +1073,7 @@ func (q *Queries) UpsertActivityEndurance(ctx context.Context, arg UpsertActivit
 		arg.SummaryRoute,
 		arg.GpxFileUri,
 		arg.FitFileUri,
+		arg.TimeseriesUri,
 	)
 	var i Vo2ActivitiesEndurance
 	err := row.Scan(
@@ -762,6 +1103,7 @@ func (q *Queries) UpsertActivityEndurance(ctx context.Context, arg UpsertActivit
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.DeletedAt,
+		&i.TimeseriesUri,
 	)
 	return i, err
 }
@@ -784,7 +1126,8 @@ ON CONFLICT
 DO UPDATE SET
 	time_at_lt1_threshold = $2,
 	time_at_lt2_threshold = $3,
-	raw_analysis = $4
+	raw_analysis = $4,
+	deleted_at = NULL
 RETURNING id, activity_endurance_id, time_at_lt1_threshold, time_at_lt2_threshold, raw_analysis, created_at, updated_at, deleted_at
 `
 
@@ -818,9 +1161,9 @@ func (q *Queries) UpsertActivityThresholdAnalysis(ctx context.Context, arg Upser
 
 const upsertAthlete = `-- name: UpsertAthlete :one
 INSERT INTO vo2.athletes
-    (user_id, age, height_cm, country, gender, first_name, last_name, display_name, email)
+    (id, user_id, birth_date, height_cm, country, gender, first_name, last_name, display_name, email)
 VALUES (
-	$1,
+	COALESCE($1, uuid_generate_v4()),
 	$2,
 	$3,
 	$4,
@@ -828,35 +1171,38 @@ VALUES (
 	$6,
 	$7,
 	$8,
-	$9)
-ON CONFLICT (user_id) DO UPDATE SET
-	age = $2,
-	height_cm = $3,
-	country = $4,
-	gender = $5,
-	first_name = $6,
-	last_name = $7,
-	display_name = $8,
-	email = $9
-RETURNING id, user_id, age, height_cm, country, gender, first_name, last_name, display_name, email, created_at, updated_at, deleted_at
+	$9,
+	$10)
+ON CONFLICT (id) DO UPDATE SET
+	birth_date = COALESCE(EXCLUDED.birth_date, athletes.birth_date),
+	height_cm = COALESCE(EXCLUDED.height_cm, athletes.height_cm),
+	country = COALESCE(EXCLUDED.country, athletes.country),
+	gender = COALESCE(EXCLUDED.gender, athletes.gender),
+	first_name = COALESCE(EXCLUDED.first_name, athletes.first_name),
+	last_name = COALESCE(EXCLUDED.last_name, athletes.last_name),
+	display_name = COALESCE(EXCLUDED.display_name, athletes.display_name),
+	email = COALESCE(EXCLUDED.email, athletes.email)
+RETURNING id, user_id, height_cm, country, gender, first_name, last_name, display_name, email, created_at, updated_at, deleted_at, birth_date
 `
 
 type UpsertAthleteParams struct {
+	ID          uuid.NullUUID
 	UserID      uuid.UUID
-	Age         int16
-	HeightCm    int16
-	Country     string
-	Gender      Gender
-	FirstName   string
-	LastName    string
-	DisplayName string
-	Email       string
+	BirthDate   sql.NullTime
+	HeightCm    sql.NullInt16
+	Country     sql.NullString
+	Gender      NullGender
+	FirstName   sql.NullString
+	LastName    sql.NullString
+	DisplayName sql.NullString
+	Email       sql.NullString
 }
 
 func (q *Queries) UpsertAthlete(ctx context.Context, arg UpsertAthleteParams) (Vo2Athlete, error) {
 	row := q.db.QueryRowContext(ctx, upsertAthlete,
+		arg.ID,
 		arg.UserID,
-		arg.Age,
+		arg.BirthDate,
 		arg.HeightCm,
 		arg.Country,
 		arg.Gender,
@@ -869,7 +1215,6 @@ func (q *Queries) UpsertAthlete(ctx context.Context, arg UpsertAthleteParams) (V
 	err := row.Scan(
 		&i.ID,
 		&i.UserID,
-		&i.Age,
 		&i.HeightCm,
 		&i.Country,
 		&i.Gender,
@@ -880,6 +1225,47 @@ func (q *Queries) UpsertAthlete(ctx context.Context, arg UpsertAthleteParams) (V
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.DeletedAt,
+		&i.BirthDate,
 	)
 	return i, err
 }
+
+const upsertAthleteMeasurement = `-- name: UpsertAthleteMeasurement :exec
+INSERT INTO vo2.athlete_measurement_history
+    (athlete_id, measured_at, iana_timezone, metric_type, value, source, notes)
+VALUES (
+	$1,
+	$2,
+	$3,
+	$4,
+	$5,
+	$6,
+	$7)
+ON CONFLICT (athlete_id, measured_at, iana_timezone, metric_type) DO UPDATE SET
+	value = $5,
+	source = $6,
+	notes = $7
+`
+
+type UpsertAthleteMeasurementParams struct {
+	AthleteID    uuid.UUID
+	MeasuredAt   time.Time
+	IanaTimezone string
+	MetricType   Vo2AthleteMeasurementType
+	Value        float64
+	Source       Vo2AthleteMeasurementSource
+	Notes        sql.NullString
+}
+
+func (q *Queries) UpsertAthleteMeasurement(ctx context.Context, arg UpsertAthleteMeasurementParams) error {
+	_, err := q.db.ExecContext(ctx, upsertAthleteMeasurement,
+		arg.AthleteID,
+		arg.MeasuredAt,
+		arg.IanaTimezone,
+		arg.MetricType,
+		arg.Value,
+		arg.Source,
+		arg.Notes,
+	)
+	return err
+}
@@ -6,11 +6,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
+	"net/mail"
 	"os"
-	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -23,7 +26,7 @@ import (
 	"github.com/gabrieleangeletti/stride/strava"
 	"github.com/gabrieleangeletti/vo2"
 	"github.com/gabrieleangeletti/vo2/activity"
-	"github.com/gabrieleangeletti/vo2/database"
+	"github.com/gabrieleangeletti/vo2/ingest"
 	"github.com/gabrieleangeletti/vo2/provider"
 	"github.com/gabrieleangeletti/vo2/store"
 	"github.com/gabrieleangeletti/vo2/util"
@@ -49,6 +52,11 @@ func NewHandler(db *sqlx.DB, options ...func(*Handler)) *Handler {
 		log.Fatal(err)
 	}
 
+	err = LoadTokenKeys()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	h := &Handler{
 		db:    db,
 		store: s,
@@ -63,9 +71,19 @@ func NewHandler(db *sqlx.DB, options ...func(*Handler)) *Handler {
 	mux.HandleFunc("GET /providers/strava/auth/callback", stravaAuthHandler(h.db, h.store))
 	mux.HandleFunc("GET /providers/strava/webhook", stravaRegisterWebhookHandler(h.db))
 	mux.HandleFunc("POST /providers/strava/webhook", stravaWebhookHandler(h.db, h.store))
+	mux.HandleFunc("GET /providers/garmin/auth/callback", garminAuthHandler(h.db, h.store))
+	mux.HandleFunc("POST /providers/garmin/push", garminPushHandler(h.db, h.store))
 
+	mux.HandleFunc("GET /athletes/{athleteID}/profile", athleteProfileHandler(h.store))
+	mux.HandleFunc("PATCH /athletes/{athleteID}/profile", athleteProfileUpdateHandler(h.store))
+	mux.HandleFunc("GET /athletes/{athleteID}/sync", athleteSyncStatusHandler(h.db, h.store))
 	mux.HandleFunc("GET /athletes/{athleteID}/metrics/volume", athleteVolumeHandler(h.store))
 	mux.HandleFunc("GET /athletes/{athleteID}/metrics/running-ytd-volume", athleteRunningYTDVolumeHandler(h.store))
+	mux.HandleFunc("POST /athletes/{athleteID}/activities/upload", athleteActivityUploadHandler(h.db, h.store))
+	mux.HandleFunc("GET /athletes/{athleteID}/activities/{activityID}/files/{kind}", athleteActivityFileHandler(h.store))
+	mux.HandleFunc("DELETE /athletes/{athleteID}/providers/{slug}", athleteProviderDisconnectHandler(h.db, h.store))
+	mux.HandleFunc("DELETE /athletes/{athleteID}", athleteEraseHandler(h.db, h.store))
+	mux.HandleFunc("GET /erasure-requests/{requestID}", erasureRequestHandler(h.db))
 
 	h.handler = h.chain(mux)
 
@@ -125,153 +143,312 @@ func (h *Handler) ProcessHistoricalDataTask(ctx context.Context, task Historical
 		return fmt.Errorf("failed to get provider: %w", err)
 	}
 
-	// TODO: make provider agnostic
-	driver := NewStravaDriver()
-
-	credentials, err := EnsureValidCredentials(ctx, h.db, driver, prov, task.AthleteID)
+	p, err := ingest.Get(prov.Slug)
 	if err != nil {
-		return fmt.Errorf("failed to get valid credentials: %w", err)
+		return err
 	}
 
-	client := driver.NewClient(credentials.AccessToken)
-
-	activities, err := GetStravaActivitySummaries(client, task.StartTime, task.EndTime)
+	window, err := StartSyncWindow(ctx, h.db, task)
 	if err != nil {
-		return fmt.Errorf("failed to get Strava activities: %w", err)
+		return fmt.Errorf("failed to start sync window: %w", err)
 	}
 
-	if len(activities) == 0 {
-		slog.Info("No activities found for time range", "athleteId", task.AthleteID, "startTime", task.StartTime, "endTime", task.EndTime)
+	if window.Status == SyncWindowStatusCompleted {
+		slog.Info("Skipping historical data task, window already synced", "athleteId", task.AthleteID, "startTime", task.StartTime)
 		return nil
 	}
 
-	existingActivities, err := activity.GetProviderActivityRawData(ctx, h.db, prov.ID, task.AthleteID)
+	// fail records the error in the window, the task is retried from its cursor.
+	fail := func(err error) error {
+		if failErr := window.Fail(ctx, h.db, err); failErr != nil {
+			slog.Error("Failed to update sync window", "error", failErr, "syncWindowId", window.ID)
+		}
+
+		return err
+	}
+
+	credentials, err := EnsureValidCredentials(ctx, h.db, p, prov, task.AthleteID)
 	if err != nil {
-		return fmt.Errorf("failed to get existing activities: %w", err)
+		if errors.Is(err, ErrProviderNotConnected) {
+			slog.Info("Skipping historical data task, provider not connected", "athleteId", task.AthleteID, "provider", prov.Slug)
+			fail(err)
+			return nil
+		}
+
+		return fail(fmt.Errorf("failed to get valid credentials: %w", err))
 	}
 
-	existingActivitiesMap := make(map[int64]*activity.ProviderActivityRawData)
-	for _, a := range existingActivities {
-		id, err := strconv.ParseInt(a.ProviderActivityID, 10, 64)
-		if err != nil {
-			return fmt.Errorf("failed to parse activity ID: %w", err)
+	limiter, err := NewRateLimiter(h.db, prov)
+	if err != nil {
+		return fail(err)
+	}
+
+	// delay queues the task again once there is quota. It resumes from the cursor of the window.
+	delay := func(wait time.Duration) error {
+		slog.Info("Rate limit quota low, delaying historical data task", "athleteId", task.AthleteID, "startTime", task.StartTime, "delay", wait)
+
+		if err := window.Delay(ctx, h.db); err != nil {
+			return fmt.Errorf("failed to update sync window: %w", err)
 		}
 
-		existingActivitiesMap[id] = a
+		return requeueHistoricalDataTask(ctx, task, wait)
 	}
 
-	slog.Info("Processing historical activities", "athleteId", task.AthleteID, "activityCount", len(activities), "startTime", task.StartTime, "endTime", task.EndTime)
+	// exhausted delays the task after the provider rejected a request for exceeding its quota.
+	exhausted := func() error {
+		wait, err := limiter.Exhaust(ctx)
+		if err != nil {
+			return fail(err)
+		}
 
-	for _, act := range activities {
-		// Note: this is the most basic way to handle rate limits. We basically go until we hit the limit.
-		// Then we manually retry and ignore the activities that were already processed.
-		// TODO: implement proper solution.
-		if existing, ok := existingActivitiesMap[act.ID]; ok {
-			if !existing.DetailedActivityURI.Valid {
-				streams, err := client.GetActivityStreams(act.ID)
-				if err != nil {
-					return fmt.Errorf("failed to get activity streams: %w", err)
-				}
+		return delay(wait)
+	}
 
-				err = h.store.UploadRawActivityDetails(ctx, stride.Provider(prov.Slug), existing, streams)
-				if err != nil {
-					return fmt.Errorf("failed to upload raw activity details: %w", err)
-				}
-			}
+	// Listing the activities takes a single request, unless there are more than a page of them.
+	wait, err := limiter.Acquire(ctx, 1)
+	if err != nil {
+		return fail(fmt.Errorf("failed to acquire rate limit quota: %w", err))
+	}
+
+	if wait > 0 {
+		return delay(wait)
+	}
 
-			continue
+	activities, err := p.FetchActivitySummaries(ctx, credentials.AccessToken, task.StartTime, task.EndTime)
+	if err != nil {
+		if errors.Is(err, stride.ErrRateLimitExceeded) {
+			return exhausted()
 		}
 
-		detailedActivity, err := client.GetActivity(act.ID, false)
-		if err != nil {
-			return fmt.Errorf("failed to get detailed activity: %w", err)
+		return fail(fmt.Errorf("failed to get %s activities: %w", prov.Slug, err))
+	}
+
+	// The cursor is only meaningful if the activities are always processed in the same order.
+	sort.Slice(activities, func(i, j int) bool {
+		if activities[i].StartTime.Equal(activities[j].StartTime) {
+			return activities[i].ID < activities[j].ID
 		}
 
-		data, err := json.Marshal(detailedActivity)
-		if err != nil {
-			return fmt.Errorf("failed to marshal activity: %w", err)
+		return activities[i].StartTime.Before(activities[j].StartTime)
+	})
+
+	// Resume after the cursor. If the cursor activity was deleted in the meantime, start over: the activities stored
+	// already are skipped without requests.
+	start := 0
+	if window.Cursor != nil {
+		for i, act := range activities {
+			if act.ID == *window.Cursor {
+				start = i + 1
+				break
+			}
 		}
+	}
+
+	err = window.SetTotal(ctx, h.db, len(activities), start)
+	if err != nil {
+		return fail(fmt.Errorf("failed to update sync window: %w", err))
+	}
+
+	existingActivities, err := activity.GetProviderActivityRawData(ctx, h.db, prov.ID, task.AthleteID)
+	if err != nil {
+		return fail(fmt.Errorf("failed to get existing activities: %w", err))
+	}
+
+	existingActivitiesMap := make(map[string]*activity.ProviderActivityRawData)
+	for _, a := range existingActivities {
+		existingActivitiesMap[a.ProviderActivityID] = a
+	}
+
+	slog.Info("Processing historical activities", "athleteId", task.AthleteID, "activityCount", len(activities), "resumeFrom", start, "startTime", task.StartTime, "endTime", task.EndTime)
+
+	for _, act := range activities[start:] {
+		existing, ok := existingActivitiesMap[act.ID]
+
+		if !ok || !existing.DetailedActivityURI.Valid {
+			// The activity and its streams, or only the streams if the activity is already stored.
+			requests := 2
+			if ok {
+				requests = 1
+			}
+
+			wait, err := limiter.Acquire(ctx, requests)
+			if err != nil {
+				return fail(fmt.Errorf("failed to acquire rate limit quota: %w", err))
+			}
+
+			if wait > 0 {
+				return delay(wait)
+			}
+
+			err = h.syncHistoricalActivity(ctx, p, prov, task.AthleteID, credentials.AccessToken, act.ID, existing)
+			if err != nil {
+				if errors.Is(err, stride.ErrRateLimitExceeded) {
+					return exhausted()
+				}
 
-		activityRaw := activity.ProviderActivityRawData{
-			ProviderID:         prov.ID,
-			AthleteID:          task.AthleteID,
-			ProviderActivityID: strconv.FormatInt(detailedActivity.ID, 10),
-			StartTime:          act.StartDate,
-			ElapsedTime:        act.ElapsedTime,
-			IanaTimezone:       database.ToNullString(detailedActivity.IanaTimezone()),
-			Data:               data,
+				return fail(err)
+			}
 		}
 
-		activityRawID, err := h.store.SaveProviderActivityRawData(ctx, &activityRaw)
+		err = window.Advance(ctx, h.db, act.ID)
 		if err != nil {
-			return fmt.Errorf("failed to save activity: %w", err)
+			return fail(fmt.Errorf("failed to update sync window: %w", err))
 		}
+	}
+
+	err = window.Complete(ctx, h.db)
+	if err != nil {
+		return fmt.Errorf("failed to update sync window: %w", err)
+	}
+
+	slog.Info("Successfully processed historical activities", "athleteId", task.AthleteID, "processedCount", len(activities)-start)
+
+	return nil
+}
 
-		activityRaw.ID = activityRawID
+// syncHistoricalActivity stores an activity and uploads its streams. If the activity is already stored (existing is
+// not nil), only its streams are uploaded.
+func (h *Handler) syncHistoricalActivity(ctx context.Context, p ingest.Provider, prov *provider.Provider, athleteID uuid.UUID, accessToken, providerActivityID string, existing *activity.ProviderActivityRawData) error {
+	activityRaw := existing
 
-		streams, err := client.GetActivityStreams(detailedActivity.ID)
+	if activityRaw == nil {
+		rawActivity, err := p.FetchActivity(ctx, accessToken, providerActivityID)
 		if err != nil {
-			return fmt.Errorf("failed to get activity streams: %w", err)
+			return fmt.Errorf("failed to get detailed activity: %w", err)
 		}
 
-		err = h.store.UploadRawActivityDetails(ctx, stride.Provider(prov.Slug), &activityRaw, streams)
+		activityRaw = rawActivity.ToProviderActivityRawData(prov.ID, athleteID)
+
+		activityRaw.ID, err = h.store.SaveProviderActivityRawData(ctx, activityRaw)
 		if err != nil {
-			return fmt.Errorf("failed to upload raw activity details: %w", err)
+			return fmt.Errorf("failed to save activity: %w", err)
 		}
+	}
 
-		return nil
+	streams, err := p.FetchActivityStreams(ctx, accessToken, providerActivityID)
+	if err != nil {
+		return fmt.Errorf("failed to get activity streams: %w", err)
 	}
 
-	slog.Info("Successfully processed historical activities", "athleteId", task.AthleteID, "processedCount", len(activities))
+	err = h.store.UploadRawActivityDetails(ctx, stride.Provider(prov.Slug), activityRaw, streams)
+	if err != nil {
+		return fmt.Errorf("failed to upload raw activity details: %w", err)
+	}
 
 	return nil
 }
 
 func (h *Handler) PostProcessActivityTask(ctx context.Context, task PostProcessActivityTask) error {
-	driver := NewStravaDriver()
-
-	credentials, err := EnsureValidCredentials(ctx, h.db, driver, &task.Provider, task.AthleteID)
+	p, err := ingest.Get(task.Provider.Slug)
 	if err != nil {
 		return err
 	}
 
-	client := driver.NewClient(credentials.AccessToken)
+	credentials, err := EnsureValidCredentials(ctx, h.db, p, &task.Provider, task.AthleteID)
+	if err != nil {
+		if errors.Is(err, ErrProviderNotConnected) {
+			slog.Info("Skipping post process activity task, provider not connected", "athleteId", task.AthleteID, "provider", task.Provider.Slug)
+			return nil
+		}
+
+		return err
+	}
 
 	activityRaw, err := h.store.GetProviderActivityRaw(ctx, task.RawActivityID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			slog.Info("Skipping post process activity task, activity deleted", "rawActivityId", task.RawActivityID)
+			return nil
+		}
+
+		return err
+	}
+
+	rawActivity, err := p.DecodeActivity(activityRaw)
+	if err != nil {
+		return err
+	}
+
+	limiter, err := NewRateLimiter(h.db, &task.Provider)
 	if err != nil {
 		return err
 	}
 
-	var stravaActivity *strava.ActivityDetailed
-	err = json.Unmarshal(activityRaw.Data, &stravaActivity)
+	wait, err := limiter.Acquire(ctx, 1)
+	if err != nil {
+		return fmt.Errorf("failed to acquire rate limit quota: %w", err)
+	}
+
+	if wait > 0 {
+		slog.Info("Rate limit quota low, delaying post process activity task", "rawActivityId", task.RawActivityID, "delay", wait)
+		return requeuePostProcessActivityTask(ctx, task, wait)
+	}
+
+	streams, err := p.FetchActivityStreams(ctx, credentials.AccessToken, activityRaw.ProviderActivityID)
 	if err != nil {
+		if errors.Is(err, stride.ErrRateLimitExceeded) {
+			wait, err := limiter.Exhaust(ctx)
+			if err != nil {
+				return err
+			}
+
+			slog.Info("Rate limit exceeded, delaying post process activity task", "rawActivityId", task.RawActivityID, "delay", wait)
+			return requeuePostProcessActivityTask(ctx, task, wait)
+		}
+
 		return err
 	}
 
-	streams, err := client.GetActivityStreams(stravaActivity.ID)
+	err = h.store.UploadRawActivityDetails(ctx, stride.Provider(task.Provider.Slug), activityRaw, streams)
 	if err != nil {
 		return err
 	}
 
-	err = h.store.UploadRawActivityDetails(ctx, stride.ProviderStrava, activityRaw, streams)
+	_, err = h.store.StoreActivityEndurance(ctx, stride.Provider(task.Provider.Slug), activityRaw, rawActivity, streams)
 	if err != nil {
 		return err
 	}
 
-	act, err := h.store.StoreActivityEndurance(ctx, stride.ProviderStrava, activityRaw, stravaActivity, streams)
+	return nil
+}
+
+func (h *Handler) ProcessCatchUpSyncTask(ctx context.Context, task CatchUpSyncTask) error {
+	result, err := CatchUpSync(ctx, h.db, h.store, task)
 	if err != nil {
+		if errors.Is(err, ErrProviderNotConnected) {
+			slog.Info("Skipping catch-up sync task, provider not connected", "athleteId", task.AthleteID, "providerId", task.ProviderID)
+			return nil
+		}
+
 		return err
 	}
 
-	tags := act.ExtractActivityTags()
+	slog.Info("Catch-up sync", "athleteId", task.AthleteID, "since", result.Since, "checked", result.Checked, "missing", result.Missing, "changed", result.Changed)
 
-	if len(tags) > 0 {
-		err = h.store.UpsertTagsAndLinkActivity(ctx, act, tags)
-		if err != nil {
-			return err
+	if result.Wait > 0 {
+		// Resume from the same time, the activities ingested until now are skipped.
+		task.Since = result.Since
+
+		slog.Info("Rate limit quota low, delaying catch-up sync task", "athleteId", task.AthleteID, "delay", result.Wait)
+		return requeueCatchUpSyncTask(ctx, task, result.Wait)
+	}
+
+	return nil
+}
+
+func (h *Handler) ProcessErasureTask(ctx context.Context, task ErasureTask) error {
+	req, err := ProcessErasureRequest(ctx, h.db, h.store, task.RequestID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			slog.Info("Skipping erasure task, request not found", "erasureRequestId", task.RequestID)
+			return nil
 		}
+
+		return err
 	}
 
+	slog.Info("Erasure", "erasureRequestId", req.ID, "athleteId", req.AthleteID, "scope", req.Scope, "deauthorized", req.Deauthorized, "deletedObjects", req.DeletedObjects)
+
 	return nil
 }
 
@@ -291,6 +468,17 @@ func stravaAuthHandler(db *sqlx.DB, dbStore store.Store) func(http.ResponseWrite
 			return
 		}
 
+		athleteID, err := verifyOAuth2State(ctx, db, "strava", r.URL.Query().Get("state"))
+		if err != nil {
+			if errors.Is(err, ErrInvalidOAuth2State) || errors.Is(err, ErrOAuth2StateExpired) || errors.Is(err, ErrOAuth2StateUsed) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		auth := strava.NewAuth(
 			util.GetSecret("STRAVA_CLIENT_ID", true),
 			util.GetSecret("STRAVA_CLIENT_SECRET", true),
@@ -321,23 +509,6 @@ func stravaAuthHandler(db *sqlx.DB, dbStore store.Store) func(http.ResponseWrite
 			return
 		}
 
-		// TODO: hardcoded for now.
-		athlete, err := dbStore.UpsertAthlete(ctx, &vo2.Athlete{
-			UserID:      user.ID,
-			Age:         33,
-			HeightCm:    180,
-			Country:     "it",
-			Gender:      vo2.GenderMale,
-			FirstName:   "Gabriele",
-			LastName:    "Angeletti",
-			DisplayName: "Gabriele Angeletti",
-			Email:       "angeletti.gabriele@gmail.com",
-		})
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
 		credentials := ProviderOAuth2Credentials{
 			ProviderID:   prov.ID,
 			UserID:       user.ID,
@@ -358,14 +529,25 @@ func stravaAuthHandler(db *sqlx.DB, dbStore store.Store) func(http.ResponseWrite
 			return
 		}
 
-		if err := queueHistoricalDataTasks(r.Context(), athlete.ID, prov.ID, HistoricalDataTaskTypeActivity); err != nil {
+		// The athlete references the user, so it's created once the user is committed.
+		athlete, err := connectProviderAthlete(ctx, db, dbStore, prov, user, user.UserExternalID, athleteID, newStravaAthlete(tokenResponse.Athlete))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := queueHistoricalDataTasks(r.Context(), db, athlete.ID, prov.ID, HistoricalDataTaskTypeActivity); err != nil {
 			slog.Error("Failed to queue historical data tasks", "error", err, "athleteId", athlete.ID)
 			// Queue historical data tasks synchronously to ensure completion in case we are serverless (e.g. Lambda).
 			// Don't fail the entire auth flow if queuing fails - user is still authenticated.
 			// TODO: we should rearchitect this to run async even in serverless environments.
 		}
 
-		resp := map[string]bool{"success": true}
+		resp := map[string]any{
+			"success":              true,
+			"athleteId":            athlete.ID,
+			"missingProfileFields": athlete.MissingProfileFields(),
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -374,6 +556,25 @@ func stravaAuthHandler(db *sqlx.DB, dbStore store.Store) func(http.ResponseWrite
 	}
 }
 
+// newStravaAthlete returns the profile of an athlete connecting Strava, from the Strava one. Strava has no birth date
+// and height, and the country is a free text, so they are left to the profile completion.
+func newStravaAthlete(profile strava.Athlete) *vo2.Athlete {
+	athlete := &vo2.Athlete{
+		FirstName:   strings.TrimSpace(profile.Firstname),
+		LastName:    strings.TrimSpace(profile.Lastname),
+		DisplayName: strings.TrimSpace(profile.Firstname + " " + profile.Lastname),
+	}
+
+	switch profile.Sex {
+	case "M":
+		athlete.Gender = vo2.GenderMale
+	case "F":
+		athlete.Gender = vo2.GenderFemale
+	}
+
+	return athlete
+}
+
 func stravaRegisterWebhookHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		mode := r.URL.Query().Get("hub.mode")
@@ -436,11 +637,11 @@ func stravaWebhookHandler(db *sqlx.DB, dbStore store.Store) func(http.ResponseWr
 			return
 		}
 
-		user, err := GetUser(db, prov.ID, strconv.Itoa(event.OwnerID))
+		link, err := GetProviderAthlete(ctx, db, prov.ID, strconv.Itoa(event.OwnerID))
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				slog.Error(fmt.Sprintf("%s: (provider: %d, userExternalID: %d)", ErrUserNotFound, prov.ID, event.OwnerID))
-				http.Error(w, ErrGeneric.Error(), http.StatusBadRequest)
+			// The events of a disconnected athlete, e.g. the deauthorization that follows the disconnection.
+			if errors.Is(err, ErrAthleteNotLinked) {
+				slog.Info("Ignoring event, athlete not linked", "ownerId", event.OwnerID, "objectType", event.ObjectType)
 				return
 			}
 
@@ -449,92 +650,99 @@ func stravaWebhookHandler(db *sqlx.DB, dbStore store.Store) func(http.ResponseWr
 			return
 		}
 
-		athletes, err := dbStore.GetUserAthletes(ctx, user.ID)
+		p, err := ingest.Get(prov.Slug)
 		if err != nil {
 			slog.Error(err.Error())
 			http.Error(w, ErrGeneric.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		// TODO: for now, we assume that one user has only one athlete.
-		athlete := athletes[0]
-
-		driver := NewStravaDriver()
-
-		credentials, err := EnsureValidCredentials(r.Context(), db, driver, prov, athlete.ID)
-		if err != nil {
-			slog.Error(err.Error())
-			http.Error(w, ErrGeneric.Error(), http.StatusBadRequest)
-			return
-		}
-
-		client := driver.NewClient(credentials.AccessToken)
-
-		auth := driver.NewAuth()
-
-		// TODO: we should store subscriptions in the database to avoid unnecessary API calls.
-		subs, err := auth.GetWebhookSubscriptions()
+		isValidSubscription, err := isValidWebhookSubscription(ctx, db, prov.ID, strconv.Itoa(event.SubscriptionID))
 		if err != nil {
 			slog.Error(err.Error())
-			http.Error(w, ErrGeneric.Error(), http.StatusBadRequest)
+			http.Error(w, ErrGeneric.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		isValidSubscription := slices.ContainsFunc(subs, func(s strava.WebhookSubscription) bool {
-			return s.ID == event.SubscriptionID
-		})
-
 		if !isValidSubscription {
 			slog.Error("invalid subscription")
 			http.Error(w, "invalid event payload", http.StatusBadRequest)
 			return
 		}
 
-		if event.ObjectType == strava.WebhookActivity {
-			if event.AspectType == strava.WebhookCreate || event.AspectType == strava.WebhookUpdate {
-				stravaActivity, err := client.GetActivity(event.ObjectID, false)
+		switch event.ObjectType {
+		case strava.WebhookActivity:
+			providerActivityID := strconv.FormatInt(event.ObjectID, 10)
+
+			if event.AspectType == strava.WebhookDelete {
+				err = dbStore.DeleteProviderActivity(ctx, prov.ID, link.AthleteID, providerActivityID)
 				if err != nil {
+					if errors.Is(err, sql.ErrNoRows) {
+						slog.Info("Deleted activity not found", "athleteId", link.AthleteID, "providerActivityId", providerActivityID)
+						return
+					}
+
 					slog.Error(err.Error())
 					http.Error(w, ErrGeneric.Error(), http.StatusInternalServerError)
 					return
 				}
 
-				data, err := json.Marshal(stravaActivity)
+				slog.Info("Deleted activity", "athleteId", link.AthleteID, "providerActivityId", providerActivityID)
+				return
+			}
+
+			if event.AspectType == strava.WebhookCreate || event.AspectType == strava.WebhookUpdate {
+				credentials, err := EnsureValidCredentials(ctx, db, p, prov, link.AthleteID)
 				if err != nil {
+					if errors.Is(err, ErrProviderNotConnected) {
+						slog.Info("Ignoring activity event, provider not connected", "athleteId", link.AthleteID)
+						return
+					}
+
 					slog.Error(err.Error())
-					http.Error(w, ErrGeneric.Error(), http.StatusInternalServerError)
+					http.Error(w, ErrGeneric.Error(), http.StatusBadRequest)
 					return
 				}
 
-				activityRaw := activity.ProviderActivityRawData{
-					ProviderID:         prov.ID,
-					AthleteID:          athlete.ID,
-					ProviderActivityID: strconv.FormatInt(event.ObjectID, 10),
-					StartTime:          stravaActivity.StartDate,
-					ElapsedTime:        stravaActivity.ElapsedTime,
-					IanaTimezone:       database.ToNullString(stravaActivity.IanaTimezone()),
-					Data:               data,
+				// Webhook events are handled regardless of the quota, which the background tasks leave some of.
+				limiter, err := NewRateLimiter(db, prov)
+				if err != nil {
+					slog.Error(err.Error())
+					http.Error(w, ErrGeneric.Error(), http.StatusInternalServerError)
+					return
 				}
 
-				activityRawID, err := dbStore.SaveProviderActivityRawData(ctx, &activityRaw)
+				err = limiter.Record(ctx, 1)
 				if err != nil {
 					slog.Error(err.Error())
 					http.Error(w, ErrGeneric.Error(), http.StatusInternalServerError)
 					return
 				}
 
-				err = queuePostProcessActivityTask(ctx, athlete.ID, prov, activityRawID)
+				_, err = ingestProviderActivity(ctx, dbStore, p, prov, link.AthleteID, credentials.AccessToken, providerActivityID)
 				if err != nil {
-					slog.Error("Failed to queue post process tasks", "error", err, "rawActivityId", activityRawID)
+					slog.Error("Failed to ingest activity", "error", err, "providerActivityId", providerActivityID)
 					http.Error(w, ErrGeneric.Error(), http.StatusInternalServerError)
 					return
 				}
 			}
+		case strava.WebhookAthlete:
+			// Deauthorization events are the only athlete events, they always have "authorized": "false".
+			if authorized, ok := event.Updates["authorized"]; ok && fmt.Sprint(authorized) == "false" {
+				err = RevokeProviderOAuth2Credentials(ctx, db, prov.ID, link.UserID)
+				if err != nil {
+					slog.Error(err.Error())
+					http.Error(w, ErrGeneric.Error(), http.StatusInternalServerError)
+					return
+				}
+
+				slog.Info("Athlete deauthorized, credentials revoked", "athleteId", link.AthleteID)
+			}
 		}
 	}
 }
 
-func queueHistoricalDataTasks(ctx context.Context, athleteID uuid.UUID, providerID int, taskType HistoricalDataTaskType) error {
+func queueHistoricalDataTasks(ctx context.Context, db *sqlx.DB, athleteID uuid.UUID, providerID int, taskType HistoricalDataTaskType) error {
 	sqsClient, err := NewSQSClient()
 	if err != nil {
 		return fmt.Errorf("failed to create SQS client: %w", err)
@@ -560,7 +768,11 @@ func queueHistoricalDataTasks(ctx context.Context, athleteID uuid.UUID, provider
 			EndTime:    monthEnd,
 		}
 
-		if err := sqsClient.SendHistoricalDataTask(ctx, task); err != nil {
+		if err := QueueSyncWindow(ctx, db, task); err != nil {
+			return fmt.Errorf("failed to queue sync window: %w", err)
+		}
+
+		if err := sqsClient.SendHistoricalDataTask(ctx, task, 0); err != nil {
 			return fmt.Errorf("failed to send historical data task: %w", err)
 		}
 
@@ -570,6 +782,43 @@ func queueHistoricalDataTasks(ctx context.Context, athleteID uuid.UUID, provider
 	return nil
 }
 
+// requeueHistoricalDataTask queues a historical data task again, to be processed after the given delay.
+func requeueHistoricalDataTask(ctx context.Context, task HistoricalDataTask, delay time.Duration) error {
+	sqsClient, err := NewSQSClient()
+	if err != nil {
+		return fmt.Errorf("failed to create SQS client: %w", err)
+	}
+
+	if err := sqsClient.SendHistoricalDataTask(ctx, task, delay); err != nil {
+		return fmt.Errorf("failed to send historical data task: %w", err)
+	}
+
+	return nil
+}
+
+// ingestProviderActivity fetches an activity from the provider, stores its raw data and queues its post processing.
+// It returns the ID of the raw data.
+func ingestProviderActivity(ctx context.Context, dbStore store.Store, p ingest.Provider, prov *provider.Provider, athleteID uuid.UUID, accessToken, providerActivityID string) (uuid.UUID, error) {
+	rawActivity, err := p.FetchActivity(ctx, accessToken, providerActivityID)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to get detailed activity: %w", err)
+	}
+
+	activityRaw := rawActivity.ToProviderActivityRawData(prov.ID, athleteID)
+
+	activityRawID, err := dbStore.SaveProviderActivityRawData(ctx, activityRaw)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to save activity: %w", err)
+	}
+
+	err = queuePostProcessActivityTask(ctx, athleteID, prov, activityRawID)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to queue post process task: %w", err)
+	}
+
+	return activityRawID, nil
+}
+
 func queuePostProcessActivityTask(ctx context.Context, athleteID uuid.UUID, prov *provider.Provider, rawActivityID uuid.UUID) error {
 	sqsClient, err := NewSQSClient()
 	if err != nil {
@@ -582,7 +831,7 @@ func queuePostProcessActivityTask(ctx context.Context, athleteID uuid.UUID, prov
 		RawActivityID: rawActivityID,
 	}
 
-	if err := sqsClient.SendPostProcessActivityTask(ctx, task); err != nil {
+	if err := sqsClient.SendPostProcessActivityTask(ctx, task, 0); err != nil {
 		return fmt.Errorf("failed to send post process activity task: %w", err)
 	}
 
@@ -591,6 +840,20 @@ func queuePostProcessActivityTask(ctx context.Context, athleteID uuid.UUID, prov
 	return nil
 }
 
+// requeuePostProcessActivityTask queues a post process activity task again, to be processed after the given delay.
+func requeuePostProcessActivityTask(ctx context.Context, task PostProcessActivityTask, delay time.Duration) error {
+	sqsClient, err := NewSQSClient()
+	if err != nil {
+		return fmt.Errorf("failed to create SQS client: %w", err)
+	}
+
+	if err := sqsClient.SendPostProcessActivityTask(ctx, task, delay); err != nil {
+		return fmt.Errorf("failed to send post process activity task: %w", err)
+	}
+
+	return nil
+}
+
 func athleteVolumeHandler(dbStore store.Store) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		apiKey := util.GetSecret("VO2_API_KEY", true)
@@ -771,3 +1034,562 @@ func athleteRunningYTDVolumeHandler(dbStore store.Store) func(http.ResponseWrite
 		json.NewEncoder(w).Encode(response)
 	}
 }
+
+// athleteSyncStatusHandler returns the progress of the historical sync of an athlete, per provider and per window.
+func athleteProfileHandler(dbStore store.Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKey := util.GetSecret("VO2_API_KEY", true)
+		if r.Header.Get("x-vo2-api-key") != apiKey {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := r.Context()
+
+		athleteID, err := uuid.Parse(r.PathValue("athleteID"))
+		if err != nil {
+			http.Error(w, "Invalid athlete ID", http.StatusBadRequest)
+			return
+		}
+
+		athlete, err := dbStore.GetAthlete(ctx, athleteID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Athlete not found", http.StatusNotFound)
+				return
+			}
+
+			slog.Error("Failed to get athlete", "error", err, "athleteID", athleteID)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeAthleteProfile(w, athlete)
+	}
+}
+
+// athleteProfileUpdateHandler completes the profile of an athlete, with the fields the provider didn't have. Only the
+// fields in the request are updated.
+func athleteProfileUpdateHandler(dbStore store.Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKey := util.GetSecret("VO2_API_KEY", true)
+		if r.Header.Get("x-vo2-api-key") != apiKey {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := r.Context()
+
+		athleteID, err := uuid.Parse(r.PathValue("athleteID"))
+		if err != nil {
+			http.Error(w, "Invalid athlete ID", http.StatusBadRequest)
+			return
+		}
+
+		var req athleteProfileRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		profile, err := req.toProfile()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		athlete, err := dbStore.UpdateAthleteProfile(ctx, athleteID, profile)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Athlete not found", http.StatusNotFound)
+				return
+			}
+
+			slog.Error("Failed to update athlete profile", "error", err, "athleteID", athleteID)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeAthleteProfile(w, athlete)
+	}
+}
+
+func writeAthleteProfile(w http.ResponseWriter, athlete *vo2.Athlete) {
+	response := map[string]any{
+		"athlete":              athlete,
+		"missingProfileFields": athlete.MissingProfileFields(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// athleteProfileRequest is the body of a profile update, the birth date is formatted as YYYY-MM-DD.
+type athleteProfileRequest struct {
+	BirthDate   string     `json:"birthDate"`
+	HeightCm    int16      `json:"heightCm"`
+	Country     string     `json:"country"`
+	Gender      vo2.Gender `json:"gender"`
+	FirstName   string     `json:"firstName"`
+	LastName    string     `json:"lastName"`
+	DisplayName string     `json:"displayName"`
+	Email       string     `json:"email"`
+}
+
+func (req *athleteProfileRequest) toProfile() (*vo2.AthleteProfile, error) {
+	profile := &vo2.AthleteProfile{
+		HeightCm:    req.HeightCm,
+		Country:     strings.ToLower(strings.TrimSpace(req.Country)),
+		Gender:      req.Gender,
+		FirstName:   strings.TrimSpace(req.FirstName),
+		LastName:    strings.TrimSpace(req.LastName),
+		DisplayName: strings.TrimSpace(req.DisplayName),
+		Email:       strings.TrimSpace(req.Email),
+	}
+
+	if req.BirthDate != "" {
+		birthDate, err := time.Parse(time.DateOnly, req.BirthDate)
+		if err != nil {
+			return nil, errors.New("invalid birthDate, expected YYYY-MM-DD")
+		}
+
+		if birthDate.After(time.Now()) {
+			return nil, errors.New("invalid birthDate, must be in the past")
+		}
+
+		profile.BirthDate = birthDate
+	}
+
+	if profile.HeightCm < 0 || profile.HeightCm > 300 {
+		return nil, errors.New("invalid heightCm, must be between 0 and 300")
+	}
+
+	if profile.Country != "" && len(profile.Country) != 2 {
+		return nil, errors.New("invalid country, expected an ISO 3166-1 alpha-2 code")
+	}
+
+	switch profile.Gender {
+	case "", vo2.GenderMale, vo2.GenderFemale, vo2.GenderOther:
+	default:
+		return nil, fmt.Errorf("invalid gender %q", profile.Gender)
+	}
+
+	if profile.Email != "" {
+		if _, err := mail.ParseAddress(profile.Email); err != nil {
+			return nil, errors.New("invalid email")
+		}
+	}
+
+	return profile, nil
+}
+
+func athleteSyncStatusHandler(db *sqlx.DB, dbStore store.Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKey := util.GetSecret("VO2_API_KEY", true)
+		if r.Header.Get("x-vo2-api-key") != apiKey {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := r.Context()
+
+		athleteID, err := uuid.Parse(r.PathValue("athleteID"))
+		if err != nil {
+			http.Error(w, "Invalid athlete ID", http.StatusBadRequest)
+			return
+		}
+
+		athlete, err := dbStore.GetAthlete(ctx, athleteID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Athlete not found", http.StatusNotFound)
+				return
+			}
+
+			slog.Error("Failed to get athlete", "error", err, "athleteID", athleteID)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		windows, err := ListSyncWindows(ctx, db, athlete.ID)
+		if err != nil {
+			slog.Error("Failed to list sync windows", "error", err, "athleteID", athleteID)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if windows == nil {
+			windows = []*SyncWindow{}
+		}
+
+		response := map[string]any{
+			"athleteId": athlete.ID,
+			"progress":  NewSyncProgress(windows),
+			"windows":   windows,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func athleteActivityFileHandler(dbStore store.Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKey := util.GetSecret("VO2_API_KEY", true)
+		if r.Header.Get("x-vo2-api-key") != apiKey {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := r.Context()
+
+		athleteID, err := uuid.Parse(r.PathValue("athleteID"))
+		if err != nil {
+			http.Error(w, "Invalid athlete ID", http.StatusBadRequest)
+			return
+		}
+
+		activityID, err := uuid.Parse(r.PathValue("activityID"))
+		if err != nil {
+			http.Error(w, "Invalid activity ID", http.StatusBadRequest)
+			return
+		}
+
+		kind := store.ActivityFileKind(r.PathValue("kind"))
+		if kind != store.ActivityFileKindGPX && kind != store.ActivityFileKindFIT && kind != store.ActivityFileKindRaw {
+			http.Error(w, "Invalid file kind, must be one of: gpx, fit, raw", http.StatusBadRequest)
+			return
+		}
+
+		act, err := dbStore.GetActivityEndurance(ctx, activityID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Activity not found", http.StatusNotFound)
+				return
+			}
+
+			slog.Error("Failed to get activity", "error", err, "activityID", activityID)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// Don't reveal the existence of activities of other athletes.
+		if act.AthleteID != athleteID {
+			http.Error(w, "Activity not found", http.StatusNotFound)
+			return
+		}
+
+		file, err := dbStore.GetActivityFile(ctx, act, kind, activityFileURLTTL)
+		if err != nil {
+			if errors.Is(err, store.ErrActivityFileNotFound) {
+				http.Error(w, "File not found", http.StatusNotFound)
+				return
+			}
+
+			slog.Error("Failed to get activity file", "error", err, "activityID", activityID, "kind", kind)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if file.URL != "" {
+			response := map[string]any{
+				"url":       file.URL,
+				"expiresAt": file.ExpiresAt,
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		w.Header().Set("Content-Type", file.ContentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Filename))
+		w.WriteHeader(http.StatusOK)
+		w.Write(file.Data)
+	}
+}
+
+// athleteActivityUploadHandler ingests an activity uploaded as a GPX, FIT or TCX file (optionally gzip compressed).
+//
+// The multipart form has the file in the "file" field, and optionally a "name" and a "sport" overriding the ones
+// found in the file, e.g. for GPX files that don't tell the sport.
+func athleteActivityUploadHandler(db *sqlx.DB, dbStore store.Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKey := util.GetSecret("VO2_API_KEY", true)
+		if r.Header.Get("x-vo2-api-key") != apiKey {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := r.Context()
+
+		athleteID, err := uuid.Parse(r.PathValue("athleteID"))
+		if err != nil {
+			http.Error(w, "Invalid athlete ID", http.StatusBadRequest)
+			return
+		}
+
+		athlete, err := dbStore.GetAthlete(ctx, athleteID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Athlete not found", http.StatusNotFound)
+				return
+			}
+
+			slog.Error("Failed to get athlete", "error", err, "athleteID", athleteID)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		header, data, err := readActivityUpload(w, r)
+		if err != nil {
+			if errors.Is(err, ingest.ErrActivityFileTooLarge) {
+				http.Error(w, "Activity file too large, it must be at most 32 MiB", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			http.Error(w, "Invalid multipart form, the file is missing", http.StatusBadRequest)
+			return
+		}
+
+		fileActivity, err := ingest.ParseActivityFile(header.Filename, data)
+		if err != nil {
+			if errors.Is(err, ingest.ErrActivityFileTooLarge) {
+				http.Error(w, "Activity file too large, it must be at most 32 MiB uncompressed", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			slog.Warn("Failed to parse activity file", "error", err, "athleteID", athleteID, "filename", header.Filename)
+			http.Error(w, "Invalid activity file, must be a GPX, FIT or TCX file", http.StatusBadRequest)
+			return
+		}
+
+		if name := strings.TrimSpace(r.FormValue("name")); name != "" {
+			fileActivity.Name = name
+		}
+
+		if sportParam := r.FormValue("sport"); sportParam != "" {
+			sport, err := stride.ParseSport(sportParam)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid sport: %s", sportParam), http.StatusBadRequest)
+				return
+			}
+
+			fileActivity.Sport = sport
+		}
+
+		prov, err := provider.GetBySlug(db, string(ingest.ProviderUpload))
+		if err != nil {
+			slog.Error("Failed to get upload provider", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		rawActivity, err := fileActivity.ToRawActivity()
+		if err != nil {
+			slog.Error("Failed to encode activity file", "error", err, "athleteID", athleteID)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		activityRaw := rawActivity.ToProviderActivityRawData(prov.ID, athlete.ID)
+
+		activityRaw.ID, err = dbStore.SaveProviderActivityRawData(ctx, activityRaw)
+		if err != nil {
+			slog.Error("Failed to save raw activity", "error", err, "athleteID", athleteID)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		err = dbStore.UploadRawActivityFile(ctx, ingest.ProviderUpload, activityRaw, fileActivity.Format, fileActivity.Content)
+		if err != nil {
+			slog.Error("Failed to upload activity file", "error", err, "rawActivityId", activityRaw.ID)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		act, err := dbStore.StoreActivityEndurance(ctx, ingest.ProviderUpload, activityRaw, fileActivity, fileActivity.Streams())
+		if err != nil {
+			slog.Error("Failed to store activity", "error", err, "rawActivityId", activityRaw.ID)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if act == nil {
+			http.Error(w, fmt.Sprintf("Not an endurance activity (sport: %s)", fileActivity.Sport), http.StatusUnprocessableEntity)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(act)
+	}
+}
+
+// athleteProviderDisconnectHandler disconnects a provider from an athlete and deletes the data the athlete has from it.
+// It runs in the background, the response is the erasure request to follow it.
+// readActivityUpload reads the file of an activity upload. The body may exceed maxActivityUploadSize by the
+// multipart envelope, the file itself may not.
+func readActivityUpload(w http.ResponseWriter, r *http.Request) (*multipart.FileHeader, []byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxActivityUploadSize+maxMultipartOverhead)
+
+	err := r.ParseMultipartForm(maxActivityUploadSize)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, nil, fmt.Errorf("%w: %w", ingest.ErrActivityFileTooLarge, err)
+		}
+
+		return nil, nil, err
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	if header.Size > maxActivityUploadSize {
+		return nil, nil, fmt.Errorf("%w: %d bytes", ingest.ErrActivityFileTooLarge, header.Size)
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return header, data, nil
+}
+
+func athleteProviderDisconnectHandler(db *sqlx.DB, dbStore store.Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKey := util.GetSecret("VO2_API_KEY", true)
+		if r.Header.Get("x-vo2-api-key") != apiKey {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := r.Context()
+
+		athleteID, err := uuid.Parse(r.PathValue("athleteID"))
+		if err != nil {
+			http.Error(w, "Invalid athlete ID", http.StatusBadRequest)
+			return
+		}
+
+		athlete, err := dbStore.GetAthlete(ctx, athleteID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Athlete not found", http.StatusNotFound)
+				return
+			}
+
+			slog.Error("Failed to get athlete", "error", err, "athleteID", athleteID)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		prov, err := provider.GetBySlug(db, r.PathValue("slug"))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Provider not found", http.StatusNotFound)
+				return
+			}
+
+			slog.Error("Failed to get provider", "error", err, "slug", r.PathValue("slug"))
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		req, err := queueErasureRequest(ctx, db, athlete.ID, &prov.ID)
+		if err != nil {
+			slog.Error("Failed to queue erasure request", "error", err, "athleteID", athleteID, "provider", prov.Slug)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeErasureRequest(w, http.StatusAccepted, req)
+	}
+}
+
+// athleteEraseHandler deletes an athlete with all its data, after disconnecting its providers. It runs in the
+// background, the response is the erasure request to follow it.
+func athleteEraseHandler(db *sqlx.DB, dbStore store.Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKey := util.GetSecret("VO2_API_KEY", true)
+		if r.Header.Get("x-vo2-api-key") != apiKey {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := r.Context()
+
+		athleteID, err := uuid.Parse(r.PathValue("athleteID"))
+		if err != nil {
+			http.Error(w, "Invalid athlete ID", http.StatusBadRequest)
+			return
+		}
+
+		athlete, err := dbStore.GetAthlete(ctx, athleteID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Athlete not found", http.StatusNotFound)
+				return
+			}
+
+			slog.Error("Failed to get athlete", "error", err, "athleteID", athleteID)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		req, err := queueErasureRequest(ctx, db, athlete.ID, nil)
+		if err != nil {
+			slog.Error("Failed to queue erasure request", "error", err, "athleteID", athleteID)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeErasureRequest(w, http.StatusAccepted, req)
+	}
+}
+
+func erasureRequestHandler(db *sqlx.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKey := util.GetSecret("VO2_API_KEY", true)
+		if r.Header.Get("x-vo2-api-key") != apiKey {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := r.Context()
+
+		requestID, err := uuid.Parse(r.PathValue("requestID"))
+		if err != nil {
+			http.Error(w, "Invalid erasure request ID", http.StatusBadRequest)
+			return
+		}
+
+		req, err := GetErasureRequest(ctx, db, requestID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Erasure request not found", http.StatusNotFound)
+				return
+			}
+
+			slog.Error("Failed to get erasure request", "error", err, "requestID", requestID)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		writeErasureRequest(w, http.StatusOK, req)
+	}
+}
+
+func writeErasureRequest(w http.ResponseWriter, status int, req *ErasureRequest) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(req)
+}
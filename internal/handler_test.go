@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gabrieleangeletti/vo2/ingest"
+)
+
+func newActivityUploadRequest(t *testing.T, fileSize int) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+
+	mw := multipart.NewWriter(&body)
+
+	if err := mw.WriteField("name", "Morning Run"); err != nil {
+		t.Fatalf("failed to write field: %v", err)
+	}
+
+	if fileSize >= 0 {
+		part, err := mw.CreateFormFile("file", "activity.fit")
+		if err != nil {
+			t.Fatalf("failed to create file part: %v", err)
+		}
+
+		if _, err := part.Write(make([]byte, fileSize)); err != nil {
+			t.Fatalf("failed to write file part: %v", err)
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		t.Fatalf("failed to close multipart writer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/athletes/1/activities/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	return req
+}
+
+func TestReadActivityUpload(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		fileSize  int
+		wantErr   bool
+		tooLarge  bool
+		wantBytes int
+	}{
+		{name: "small", fileSize: 1 << 10, wantBytes: 1 << 10},
+		// The body is larger than the limit, with the envelope, the file is not.
+		{name: "at the limit", fileSize: maxActivityUploadSize, wantBytes: maxActivityUploadSize},
+		{name: "over the limit", fileSize: maxActivityUploadSize + 1, wantErr: true, tooLarge: true},
+		{name: "over the body limit", fileSize: maxActivityUploadSize + maxMultipartOverhead, wantErr: true, tooLarge: true},
+		{name: "missing file", fileSize: -1, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newActivityUploadRequest(t, tc.fileSize)
+
+			header, data, err := readActivityUpload(httptest.NewRecorder(), req)
+
+			// The server removes the files of the form spilled to disk after the handler, here there is no server.
+			if req.MultipartForm != nil {
+				t.Cleanup(func() { req.MultipartForm.RemoveAll() })
+			}
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tc.wantErr)
+			}
+
+			if errors.Is(err, ingest.ErrActivityFileTooLarge) != tc.tooLarge {
+				t.Fatalf("got error %v, want too large %t", err, tc.tooLarge)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if header.Filename != "activity.fit" {
+				t.Errorf("got filename %q, want %q", header.Filename, "activity.fit")
+			}
+
+			if len(data) != tc.wantBytes {
+				t.Errorf("got %d bytes, want %d", len(data), tc.wantBytes)
+			}
+
+			if name := req.FormValue("name"); name != "Morning Run" {
+				t.Errorf("got name %q, want %q", name, "Morning Run")
+			}
+		})
+	}
+}
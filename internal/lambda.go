@@ -123,6 +123,30 @@ func (l *LambdaHandler) HandleSQSRequest(ctx context.Context, sqsEvent events.SQ
 			}
 			slog.Info("Successfully post processed activity", "messageId", record.MessageId, "rawActivityId", task.RawActivityID)
 
+		case TaskTypeCatchUpSync:
+			var task CatchUpSyncTask
+			if err := json.Unmarshal(message.Data, &task); err != nil {
+				slog.Error("Failed to unmarshal catch-up sync task", "error", err, "messageId", record.MessageId)
+				continue
+			}
+			if err := l.handler.ProcessCatchUpSyncTask(ctx, task); err != nil {
+				slog.Error("Failed to process catch-up sync task", "error", err, "messageId", record.MessageId, "athleteId", task.AthleteID)
+				return err
+			}
+			slog.Info("Successfully processed catch-up sync", "messageId", record.MessageId, "athleteId", task.AthleteID)
+
+		case TaskTypeErasure:
+			var task ErasureTask
+			if err := json.Unmarshal(message.Data, &task); err != nil {
+				slog.Error("Failed to unmarshal erasure task", "error", err, "messageId", record.MessageId)
+				continue
+			}
+			if err := l.handler.ProcessErasureTask(ctx, task); err != nil {
+				slog.Error("Failed to process erasure task", "error", err, "messageId", record.MessageId, "erasureRequestId", task.RequestID)
+				return err
+			}
+			slog.Info("Successfully processed erasure", "messageId", record.MessageId, "erasureRequestId", task.RequestID)
+
 		default:
 			slog.Error("Unknown task type", "type", message.Type, "messageId", record.MessageId)
 			continue // Skip unknown types gracefully
@@ -132,6 +156,19 @@ func (l *LambdaHandler) HandleSQSRequest(ctx context.Context, sqsEvent events.SQ
 	return nil
 }
 
+// HandleScheduledEvent queues a catch-up sync task for each athlete with a connected provider.
+func (l *LambdaHandler) HandleScheduledEvent(ctx context.Context, event events.CloudWatchEvent) error {
+	l.init()
+	if l.initErr != nil {
+		slog.Error("failed to initialize lambda handler: " + l.initErr.Error())
+		return l.initErr
+	}
+
+	slog.Info("Processing scheduled event", "id", event.ID, "resources", event.Resources)
+
+	return QueueCatchUpSyncTasks(ctx, l.db)
+}
+
 func (l *LambdaHandler) convertToHTTPRequest(request events.APIGatewayV2HTTPRequest) (*http.Request, error) {
 	u, err := url.Parse(request.RawPath)
 	if err != nil {
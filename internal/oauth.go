@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/gabrieleangeletti/vo2/util"
+)
+
+const (
+	oauth2StateTTL = 15 * time.Minute
+	// oauth2StatePayloadSize is the size of the nonce, the expiration and the athlete ID of a state.
+	oauth2StatePayloadSize = 16 + 8 + 16
+)
+
+var (
+	ErrInvalidOAuth2State = errors.New("invalid OAuth2 state")
+	ErrOAuth2StateExpired = errors.New("OAuth2 state expired")
+	ErrOAuth2StateUsed    = errors.New("OAuth2 state already used")
+)
+
+// NewOAuth2State returns the state parameter of an OAuth2 authorization URL of the provider. It's a random nonce, an
+// expiration and the athlete to connect the provider to, signed with OAUTH_STATE_SECRET, so the callback only accepts
+// the authorizations started by us. If athleteID is uuid.Nil, the provider is connected to a new athlete.
+//
+// The nonce is recorded until the callback uses it, so a state can't be replayed.
+func NewOAuth2State(ctx context.Context, db sqlx.ExecerContext, providerSlug string, athleteID uuid.UUID) (string, error) {
+	payload := make([]byte, oauth2StatePayloadSize)
+	if _, err := rand.Read(payload[:16]); err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().Add(oauth2StateTTL)
+
+	binary.BigEndian.PutUint64(payload[16:24], uint64(expiresAt.Unix()))
+	copy(payload[24:], athleteID[:])
+
+	// The states never used are deleted once expired.
+	_, err := db.ExecContext(ctx, `DELETE FROM vo2.oauth2_states WHERE expires_at < NOW()`)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.ExecContext(ctx, `
+	INSERT INTO vo2.oauth2_states (nonce, expires_at) VALUES ($1, $2)
+	`, hex.EncodeToString(payload[:16]), expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	encoding := base64.RawURLEncoding
+
+	return encoding.EncodeToString(payload) + "." + encoding.EncodeToString(signOAuth2State(providerSlug, payload)), nil
+}
+
+// verifyOAuth2State checks that the state was returned by NewOAuth2State for the provider, and that it's neither expired
+// nor used already. It uses the state up and returns the athlete to connect the provider to.
+func verifyOAuth2State(ctx context.Context, db sqlx.ExecerContext, providerSlug, state string) (uuid.UUID, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(state, ".")
+	if !ok {
+		return uuid.Nil, ErrInvalidOAuth2State
+	}
+
+	encoding := base64.RawURLEncoding
+
+	payload, err := encoding.DecodeString(encodedPayload)
+	if err != nil || len(payload) != oauth2StatePayloadSize {
+		return uuid.Nil, ErrInvalidOAuth2State
+	}
+
+	signature, err := encoding.DecodeString(encodedSignature)
+	if err != nil {
+		return uuid.Nil, ErrInvalidOAuth2State
+	}
+
+	if !hmac.Equal(signature, signOAuth2State(providerSlug, payload)) {
+		return uuid.Nil, ErrInvalidOAuth2State
+	}
+
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint64(payload[16:24])), 0)
+	if time.Now().After(expiresAt) {
+		return uuid.Nil, ErrOAuth2StateExpired
+	}
+
+	res, err := db.ExecContext(ctx, `
+	DELETE FROM vo2.oauth2_states WHERE nonce = $1
+	`, hex.EncodeToString(payload[:16]))
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if n == 0 {
+		return uuid.Nil, ErrOAuth2StateUsed
+	}
+
+	return uuid.UUID(payload[24:]), nil
+}
+
+// signOAuth2State signs the payload for the provider, so a state can't be used in the callback of another one.
+func signOAuth2State(providerSlug string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(util.GetSecret("OAUTH_STATE_SECRET", true)))
+	mac.Write([]byte(providerSlug))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// fakeOAuth2States records the nonces of the states like vo2.oauth2_states, for the queries of the OAuth2 states.
+type fakeOAuth2States struct {
+	nonces map[string]bool
+}
+
+func (f *fakeOAuth2States) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query = strings.Join(strings.Fields(query), " ")
+
+	switch {
+	case strings.HasPrefix(query, "INSERT INTO vo2.oauth2_states"):
+		f.nonces[args[0].(string)] = true
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(query, "DELETE FROM vo2.oauth2_states WHERE nonce = $1"):
+		nonce := args[0].(string)
+		if !f.nonces[nonce] {
+			return driver.RowsAffected(0), nil
+		}
+
+		delete(f.nonces, nonce)
+
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(query, "DELETE FROM vo2.oauth2_states WHERE expires_at < NOW()"):
+		return driver.RowsAffected(0), nil
+	}
+
+	return nil, errors.New("unexpected query: " + query)
+}
+
+func newFakeOAuth2States(t *testing.T) *fakeOAuth2States {
+	t.Helper()
+
+	t.Setenv("OAUTH_STATE_SECRET", "test-state-secret")
+
+	return &fakeOAuth2States{nonces: make(map[string]bool)}
+}
+
+func TestOAuth2State(t *testing.T) {
+	ctx := context.Background()
+
+	db := newFakeOAuth2States(t)
+
+	for _, athleteID := range []uuid.UUID{uuid.New(), uuid.Nil} {
+		state, err := NewOAuth2State(ctx, db, "strava", athleteID)
+		if err != nil {
+			t.Fatalf("failed to create state: %v", err)
+		}
+
+		got, err := verifyOAuth2State(ctx, db, "strava", state)
+		if err != nil {
+			t.Fatalf("failed to verify state: %v", err)
+		}
+
+		if got != athleteID {
+			t.Errorf("got athlete %s, want %s", got, athleteID)
+		}
+	}
+}
+
+func TestOAuth2StateReplay(t *testing.T) {
+	ctx := context.Background()
+
+	db := newFakeOAuth2States(t)
+
+	state, err := NewOAuth2State(ctx, db, "strava", uuid.New())
+	if err != nil {
+		t.Fatalf("failed to create state: %v", err)
+	}
+
+	_, err = verifyOAuth2State(ctx, db, "strava", state)
+	if err != nil {
+		t.Fatalf("failed to verify state: %v", err)
+	}
+
+	_, err = verifyOAuth2State(ctx, db, "strava", state)
+	if !errors.Is(err, ErrOAuth2StateUsed) {
+		t.Fatalf("got error %v, want %v", err, ErrOAuth2StateUsed)
+	}
+}
+
+func TestOAuth2StateInvalid(t *testing.T) {
+	ctx := context.Background()
+
+	db := newFakeOAuth2States(t)
+
+	state, err := NewOAuth2State(ctx, db, "strava", uuid.New())
+	if err != nil {
+		t.Fatalf("failed to create state: %v", err)
+	}
+
+	encodedPayload, encodedSignature, _ := strings.Cut(state, ".")
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		t.Fatalf("failed to decode signature: %v", err)
+	}
+
+	signature[0] ^= 1
+
+	// A state signed with the secret, whose nonce was recorded, but expired a minute ago.
+	expired := make([]byte, oauth2StatePayloadSize)
+	if _, err := rand.Read(expired[:16]); err != nil {
+		t.Fatalf("failed to generate nonce: %v", err)
+	}
+
+	binary.BigEndian.PutUint64(expired[16:24], uint64(time.Now().Add(-time.Minute).Unix()))
+	db.nonces[hex.EncodeToString(expired[:16])] = true
+
+	expiredState := base64.RawURLEncoding.EncodeToString(expired) + "." +
+		base64.RawURLEncoding.EncodeToString(signOAuth2State("strava", expired))
+
+	for _, tc := range []struct {
+		name     string
+		provider string
+		state    string
+		wantErr  error
+	}{
+		{name: "empty", provider: "strava", state: "", wantErr: ErrInvalidOAuth2State},
+		{name: "unsigned", provider: "strava", state: encodedPayload, wantErr: ErrInvalidOAuth2State},
+		{name: "bad HMAC", provider: "strava", state: encodedPayload + "." + base64.RawURLEncoding.EncodeToString(signature), wantErr: ErrInvalidOAuth2State},
+		{name: "bad payload", provider: "strava", state: "not-base64!." + encodedSignature, wantErr: ErrInvalidOAuth2State},
+		{name: "short payload", provider: "strava", state: encodedPayload[:10] + "." + encodedSignature, wantErr: ErrInvalidOAuth2State},
+		{name: "wrong provider", provider: "garmin", state: state, wantErr: ErrInvalidOAuth2State},
+		{name: "expired", provider: "strava", state: expiredState, wantErr: ErrOAuth2StateExpired},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := verifyOAuth2State(ctx, db, tc.provider, tc.state)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+
+	// The failed attempts didn't use the state up.
+	_, err = verifyOAuth2State(ctx, db, "strava", state)
+	if err != nil {
+		t.Fatalf("failed to verify state: %v", err)
+	}
+}
+
+func TestOAuth2StateReplayDB(t *testing.T) {
+	ctx := context.Background()
+
+	db := testDB(t)
+
+	t.Setenv("OAUTH_STATE_SECRET", "test-state-secret")
+
+	state, err := NewOAuth2State(ctx, db, "strava", uuid.New())
+	if err != nil {
+		t.Fatalf("failed to create state: %v", err)
+	}
+
+	_, err = verifyOAuth2State(ctx, db, "strava", state)
+	if err != nil {
+		t.Fatalf("failed to verify state: %v", err)
+	}
+
+	_, err = verifyOAuth2State(ctx, db, "strava", state)
+	if !errors.Is(err, ErrOAuth2StateUsed) {
+		t.Fatalf("got error %v, want %v", err, ErrOAuth2StateUsed)
+	}
+}
@@ -3,77 +3,42 @@ package internal
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 
-	"github.com/gabrieleangeletti/stride/strava"
+	"github.com/gabrieleangeletti/vo2/ingest"
 	"github.com/gabrieleangeletti/vo2/provider"
-	"github.com/gabrieleangeletti/vo2/util"
 )
 
 const (
 	refreshTokenBuffer = 5 * time.Minute
 )
 
-type OAuth2Token struct {
-	AccessToken  string
-	RefreshToken string
-	ExpiresAt    time.Time
-}
-
-type ProviderDriver[C any, A any] interface {
-	NewClient(accessToken string) C
-	NewAuth() A
-	RefreshToken(ctx context.Context, refreshToken string) (*OAuth2Token, error)
-}
-
-type StravaDriver struct {
-	clientID     string
-	clientSecret string
-}
-
-func NewStravaDriver() *StravaDriver {
-	return &StravaDriver{
-		clientID:     util.GetSecret("STRAVA_CLIENT_ID", true),
-		clientSecret: util.GetSecret("STRAVA_CLIENT_SECRET", true),
-	}
-}
-
-func (d *StravaDriver) NewClient(accessToken string) *strava.Client {
-	return strava.NewClient(accessToken)
-}
+var (
+	ErrProviderNotConnected = errors.New("provider not connected")
+)
 
-func (d *StravaDriver) NewAuth() *strava.Auth {
-	return strava.NewAuth(d.clientID, d.clientSecret)
+// TokenRefresher exchanges a refresh token for a new access token. It's implemented by ingest.Provider.
+type TokenRefresher interface {
+	RefreshToken(ctx context.Context, refreshToken string) (*ingest.OAuth2Token, error)
 }
 
-func (d *StravaDriver) RefreshToken(ctx context.Context, refreshToken string) (*OAuth2Token, error) {
-	auth := strava.NewAuth(d.clientID, d.clientSecret)
-
-	tokenResponse, err := auth.RefreshToken(refreshToken)
+func EnsureValidCredentials(ctx context.Context, db *sqlx.DB, refresher TokenRefresher, prov *provider.Provider, athleteID uuid.UUID) (*ProviderOAuth2Credentials, error) {
+	link, err := GetAthleteProviderLink(ctx, db, prov.ID, athleteID)
 	if err != nil {
 		return nil, err
 	}
 
-	token := OAuth2Token{
-		AccessToken:  tokenResponse.AccessToken,
-		RefreshToken: tokenResponse.RefreshToken,
-		ExpiresAt:    time.Unix(int64(tokenResponse.ExpiresAt), 0),
-	}
-
-	return &token, nil
-}
-
-func EnsureValidCredentials[C any, A any](ctx context.Context, db *sqlx.DB, driver ProviderDriver[C, A], prov *provider.Provider, athleteID uuid.UUID) (*ProviderOAuth2Credentials, error) {
-	user, err := GetAthleteUser(ctx, db, athleteID)
+	credentials, err := GetProviderOAuth2Credentials(db, prov.ID, link.UserID)
 	if err != nil {
-		return nil, err
-	}
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrProviderNotConnected
+		}
 
-	credentials, err := GetProviderOAuth2Credentials(db, prov.ID, user.ID)
-	if err != nil {
 		return nil, err
 	}
 
@@ -84,12 +49,21 @@ func EnsureValidCredentials[C any, A any](ctx context.Context, db *sqlx.DB, driv
 		}
 		defer tx.Rollback()
 
-		err = tx.Get(credentials, "SELECT * FROM vo2.provider_oauth2_credentials WHERE provider_id = $1 AND user_id = $2 FOR UPDATE", prov.ID, user.ID)
+		err = tx.Get(credentials, "SELECT * FROM vo2.provider_oauth2_credentials WHERE provider_id = $1 AND user_id = $2 AND deleted_at IS NULL FOR UPDATE", prov.ID, link.UserID)
 		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, ErrProviderNotConnected
+			}
+
 			return nil, err
 		}
 
-		refreshed, err := refreshIfExpired(ctx, driver, credentials)
+		_, err = credentials.decrypt()
+		if err != nil {
+			return nil, err
+		}
+
+		refreshed, err := refreshIfExpired(ctx, refresher, credentials)
 		if err != nil {
 			return nil, err
 		}
@@ -118,6 +92,9 @@ type ProviderOAuth2Credentials struct {
 	CreatedAt    time.Time    `json:"createdAt" db:"created_at"`
 	UpdatedAt    sql.NullTime `json:"updatedAt" db:"updated_at"`
 	DeletedAt    sql.NullTime `json:"deletedAt" db:"deleted_at"`
+	// The tokens are stored encrypted, see encryptTokens. They are decrypted when the credentials are read.
+	TokenKeyID sql.NullString `json:"-" db:"token_key_id"`
+	DataKey    []byte         `json:"-" db:"data_key"`
 }
 
 func (c *ProviderOAuth2Credentials) Expired(buffer time.Duration) bool {
@@ -125,16 +102,30 @@ func (c *ProviderOAuth2Credentials) Expired(buffer time.Duration) bool {
 }
 
 func (c *ProviderOAuth2Credentials) Save(ctx context.Context, db *sqlx.DB) error {
-	_, err := db.ExecContext(ctx, `
+	return c.save(ctx, db)
+}
+
+func (c *ProviderOAuth2Credentials) SaveTx(ctx context.Context, tx *sqlx.Tx) error {
+	return c.save(ctx, tx)
+}
+
+// save stores the credentials with the tokens encrypted.
+func (c *ProviderOAuth2Credentials) save(ctx context.Context, db sqlx.ExecerContext) error {
+	encrypted, err := encryptTokens(c.aad(), c.AccessToken, c.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt tokens: %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, `
 	INSERT INTO vo2.provider_oauth2_credentials
-		(provider_id, user_id, access_token, refresh_token, expires_at)
+		(provider_id, user_id, access_token, refresh_token, expires_at, token_key_id, data_key)
 	VALUES
-		($1, $2, $3, $4, $5)
+		($1, $2, $3, $4, $5, $6, $7)
 	ON CONFLICT
 		(provider_id, user_id)
 	DO UPDATE SET
-		access_token = $3, refresh_token = $4, expires_at = $5
-	`, c.ProviderID, c.UserID, c.AccessToken, c.RefreshToken, c.ExpiresAt)
+		access_token = $3, refresh_token = $4, expires_at = $5, token_key_id = $6, data_key = $7, deleted_at = NULL
+	`, c.ProviderID, c.UserID, encrypted.Tokens[0], encrypted.Tokens[1], c.ExpiresAt, encrypted.KeyID, encrypted.DataKey)
 	if err != nil {
 		return err
 	}
@@ -142,28 +133,32 @@ func (c *ProviderOAuth2Credentials) Save(ctx context.Context, db *sqlx.DB) error
 	return nil
 }
 
-func (c *ProviderOAuth2Credentials) SaveTx(ctx context.Context, tx *sqlx.Tx) error {
-	_, err := tx.ExecContext(ctx, `
-	INSERT INTO vo2.provider_oauth2_credentials
-		(provider_id, user_id, access_token, refresh_token, expires_at)
-	VALUES
-		($1, $2, $3, $4, $5)
-	ON CONFLICT
-		(provider_id, user_id)
-	DO UPDATE SET
-		access_token = $3, refresh_token = $4, expires_at = $5
-	`, c.ProviderID, c.UserID, c.AccessToken, c.RefreshToken, c.ExpiresAt)
+func (c *ProviderOAuth2Credentials) aad() []byte {
+	return tokenAAD("provider_oauth2_credentials", c.ProviderID, c.UserID)
+}
+
+// decrypt decrypts the tokens in place, stale reports whether they should be encrypted again.
+func (c *ProviderOAuth2Credentials) decrypt() (stale bool, err error) {
+	stale, err = decryptTokens(c.TokenKeyID, c.DataKey, c.aad(), &c.AccessToken, &c.RefreshToken)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return nil
+	c.TokenKeyID = sql.NullString{}
+	c.DataKey = nil
+
+	return stale, nil
 }
 
 func GetProviderOAuth2Credentials(db *sqlx.DB, providerID int, userID uuid.UUID) (*ProviderOAuth2Credentials, error) {
 	var credentials ProviderOAuth2Credentials
 
-	err := db.Get(&credentials, "SELECT * FROM vo2.provider_oauth2_credentials WHERE provider_id = $1 AND user_id = $2", providerID, userID)
+	err := db.Get(&credentials, "SELECT * FROM vo2.provider_oauth2_credentials WHERE provider_id = $1 AND user_id = $2 AND deleted_at IS NULL", providerID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = credentials.decrypt()
 	if err != nil {
 		return nil, err
 	}
@@ -171,9 +166,24 @@ func GetProviderOAuth2Credentials(db *sqlx.DB, providerID int, userID uuid.UUID)
 	return &credentials, nil
 }
 
-func refreshIfExpired[C any, A any](ctx context.Context, provider ProviderDriver[C, A], credentials *ProviderOAuth2Credentials) (bool, error) {
+// RevokeProviderOAuth2Credentials soft-deletes the credentials of a user, e.g. when the user deauthorizes the app.
+// Tasks that need the credentials stop with ErrProviderNotConnected, until the user connects the provider again.
+func RevokeProviderOAuth2Credentials(ctx context.Context, db *sqlx.DB, providerID int, userID uuid.UUID) error {
+	_, err := db.ExecContext(ctx, `
+	UPDATE vo2.provider_oauth2_credentials
+	SET deleted_at = NOW()
+	WHERE provider_id = $1 AND user_id = $2 AND deleted_at IS NULL
+	`, providerID, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func refreshIfExpired(ctx context.Context, refresher TokenRefresher, credentials *ProviderOAuth2Credentials) (bool, error) {
 	if credentials.Expired(refreshTokenBuffer) {
-		newToken, err := provider.RefreshToken(ctx, credentials.RefreshToken)
+		newToken, err := refresher.RefreshToken(ctx, credentials.RefreshToken)
 		if err != nil {
 			return false, err
 		}
@@ -187,3 +197,82 @@ func refreshIfExpired[C any, A any](ctx context.Context, provider ProviderDriver
 
 	return false, nil
 }
+
+// ProviderOAuth1Credentials are the credentials of the providers with an OAuth1 connection. The tokens don't expire.
+type ProviderOAuth1Credentials struct {
+	ID          int          `json:"id" db:"id"`
+	ProviderID  int          `json:"providerId" db:"provider_id"`
+	UserID      uuid.UUID    `json:"userId" db:"user_id"`
+	Token       string       `json:"token" db:"token"`
+	TokenSecret string       `json:"tokenSecret" db:"token_secret"`
+	CreatedAt   time.Time    `json:"createdAt" db:"created_at"`
+	UpdatedAt   sql.NullTime `json:"updatedAt" db:"updated_at"`
+	DeletedAt   sql.NullTime `json:"deletedAt" db:"deleted_at"`
+	// The tokens are stored encrypted, see encryptTokens. They are decrypted when the credentials are read.
+	TokenKeyID sql.NullString `json:"-" db:"token_key_id"`
+	DataKey    []byte         `json:"-" db:"data_key"`
+}
+
+func (c *ProviderOAuth1Credentials) OAuth1Token() *ingest.OAuth1Token {
+	return &ingest.OAuth1Token{
+		Token:       c.Token,
+		TokenSecret: c.TokenSecret,
+	}
+}
+
+// SaveTx stores the credentials with the tokens encrypted.
+func (c *ProviderOAuth1Credentials) SaveTx(ctx context.Context, tx *sqlx.Tx) error {
+	encrypted, err := encryptTokens(c.aad(), c.Token, c.TokenSecret)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt tokens: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO vo2.provider_oauth1_credentials
+		(provider_id, user_id, token, token_secret, token_key_id, data_key)
+	VALUES
+		($1, $2, $3, $4, $5, $6)
+	ON CONFLICT
+		(provider_id, user_id)
+	DO UPDATE SET
+		token = $3, token_secret = $4, token_key_id = $5, data_key = $6
+	`, c.ProviderID, c.UserID, encrypted.Tokens[0], encrypted.Tokens[1], encrypted.KeyID, encrypted.DataKey)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *ProviderOAuth1Credentials) aad() []byte {
+	return tokenAAD("provider_oauth1_credentials", c.ProviderID, c.UserID)
+}
+
+// decrypt decrypts the tokens in place, stale reports whether they should be encrypted again.
+func (c *ProviderOAuth1Credentials) decrypt() (stale bool, err error) {
+	stale, err = decryptTokens(c.TokenKeyID, c.DataKey, c.aad(), &c.Token, &c.TokenSecret)
+	if err != nil {
+		return false, err
+	}
+
+	c.TokenKeyID = sql.NullString{}
+	c.DataKey = nil
+
+	return stale, nil
+}
+
+func GetProviderOAuth1Credentials(db *sqlx.DB, providerID int, userID uuid.UUID) (*ProviderOAuth1Credentials, error) {
+	var credentials ProviderOAuth1Credentials
+
+	err := db.Get(&credentials, "SELECT * FROM vo2.provider_oauth1_credentials WHERE provider_id = $1 AND user_id = $2", providerID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = credentials.decrypt()
+	if err != nil {
+		return nil, err
+	}
+
+	return &credentials, nil
+}
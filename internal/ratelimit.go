@@ -0,0 +1,254 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/gabrieleangeletti/vo2/provider"
+	"github.com/gabrieleangeletti/vo2/util"
+)
+
+const (
+	// rateLimitHeadroom is the fraction of each window that background tasks leave to the webhook events.
+	rateLimitHeadroom = 0.1
+
+	defaultStravaRateLimit15Min = 100
+	defaultStravaRateLimitDaily = 1000
+)
+
+// RateLimitWindow is a provider quota: at most Limit requests in each window of the given duration.
+// Windows are aligned to UTC, e.g. Strava's 15-minute windows start at 0, 15, 30 and 45 past the hour and its daily
+// window at midnight UTC.
+type RateLimitWindow struct {
+	Name     string
+	Duration time.Duration
+	Limit    int
+}
+
+func (w RateLimitWindow) start(now time.Time) time.Time {
+	return now.UTC().Truncate(w.Duration)
+}
+
+// RateLimiter tracks the requests made to a provider API against its quotas. The usage is stored in Postgres, so
+// that it's shared by all the API and task instances.
+type RateLimiter struct {
+	db         *sqlx.DB
+	providerID int
+	windows    []RateLimitWindow
+}
+
+// NewRateLimiter returns the rate limiter of a provider. Providers without known quotas are never limited.
+func NewRateLimiter(db *sqlx.DB, prov *provider.Provider) (*RateLimiter, error) {
+	windows, err := rateLimitWindows(prov.Slug)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RateLimiter{
+		db:         db,
+		providerID: prov.ID,
+		windows:    windows,
+	}, nil
+}
+
+// rateLimitWindows returns the read quotas of a provider. The Strava ones default to the quotas of a new Strava app
+// and can be set with STRAVA_READ_RATE_LIMIT_15MIN and STRAVA_READ_RATE_LIMIT_DAILY.
+func rateLimitWindows(slug string) ([]RateLimitWindow, error) {
+	switch slug {
+	case "strava":
+		limit15Min, err := rateLimitSecret("STRAVA_READ_RATE_LIMIT_15MIN", defaultStravaRateLimit15Min)
+		if err != nil {
+			return nil, err
+		}
+
+		limitDaily, err := rateLimitSecret("STRAVA_READ_RATE_LIMIT_DAILY", defaultStravaRateLimitDaily)
+		if err != nil {
+			return nil, err
+		}
+
+		return []RateLimitWindow{
+			{Name: "15min", Duration: 15 * time.Minute, Limit: limit15Min},
+			{Name: "daily", Duration: 24 * time.Hour, Limit: limitDaily},
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func rateLimitSecret(key string, defaultLimit int) (int, error) {
+	value := util.GetSecret(key, false)
+	if value == "" {
+		return defaultLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+
+	return limit, nil
+}
+
+// Acquire reserves n requests for a background task. Background tasks leave some headroom in each window, so that
+// the webhook events can still be handled. If the quota is too low nothing is reserved, and the returned duration is
+// how long to wait before trying again.
+func (l *RateLimiter) Acquire(ctx context.Context, n int) (time.Duration, error) {
+	return l.acquire(ctx, n, rateLimitHeadroom, false)
+}
+
+// Record counts n requests that are made regardless of the quota, e.g. the ones that handle webhook events.
+func (l *RateLimiter) Record(ctx context.Context, n int) error {
+	_, err := l.acquire(ctx, n, 0, true)
+	return err
+}
+
+// Exhaust marks a window as used up, after the provider rejected a request for exceeding its quota, and returns how
+// long to wait before it resets. The provider doesn't tell which quota was exceeded, so the window is the one with the
+// highest recorded usage relative to its limit, the shortest one on a tie. E.g. a request rejected right after the
+// 15-minute window reset exhausts the daily window, which is the one still close to its limit.
+func (l *RateLimiter) Exhaust(ctx context.Context) (time.Duration, error) {
+	if len(l.windows) == 0 {
+		return 0, nil
+	}
+
+	tx, err := l.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	used, err := l.usage(ctx, tx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	w := exhaustedWindow(l.windows, used)
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE vo2.provider_rate_limits
+	SET window_start = $3, used = $4
+	WHERE provider_id = $1 AND window_name = $2
+	`, l.providerID, w.Name, w.start(now), w.Limit)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return w.start(now).Add(w.Duration).Sub(now), nil
+}
+
+// exhaustedWindow returns the window with the highest usage relative to its limit, the shortest one on a tie.
+func exhaustedWindow(windows []RateLimitWindow, used map[string]int) RateLimitWindow {
+	w := windows[0]
+	for _, other := range windows[1:] {
+		// Compare used/limit without dividing.
+		lhs := used[other.Name] * w.Limit
+		rhs := used[w.Name] * other.Limit
+
+		if lhs > rhs || (lhs == rhs && other.Duration < w.Duration) {
+			w = other
+		}
+	}
+
+	return w
+}
+
+func (l *RateLimiter) acquire(ctx context.Context, n int, headroom float64, force bool) (time.Duration, error) {
+	if len(l.windows) == 0 {
+		return 0, nil
+	}
+
+	tx, err := l.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	used, err := l.usage(ctx, tx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+
+	for _, w := range l.windows {
+		start := w.start(now)
+
+		available := w.Limit - int(float64(w.Limit)*headroom) - used[w.Name]
+		if !force && available < n {
+			wait = max(wait, start.Add(w.Duration).Sub(now))
+		}
+	}
+
+	if wait > 0 {
+		return wait, nil
+	}
+
+	for _, w := range l.windows {
+		_, err = tx.ExecContext(ctx, `
+		UPDATE vo2.provider_rate_limits
+		SET window_start = $3, used = $4
+		WHERE provider_id = $1 AND window_name = $2
+		`, l.providerID, w.Name, w.start(now), used[w.Name]+n)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return 0, tx.Commit()
+}
+
+// usage locks the stored windows of the provider and returns the requests used in each current window. The windows
+// that are over count as unused.
+func (l *RateLimiter) usage(ctx context.Context, tx *sqlx.Tx, now time.Time) (map[string]int, error) {
+	for _, w := range l.windows {
+		_, err := tx.ExecContext(ctx, `
+		INSERT INTO vo2.provider_rate_limits (provider_id, window_name, window_start)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+		`, l.providerID, w.Name, w.start(now))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var rows []struct {
+		WindowName  string    `db:"window_name"`
+		WindowStart time.Time `db:"window_start"`
+		Used        int       `db:"used"`
+	}
+
+	err := tx.SelectContext(ctx, &rows, `
+	SELECT window_name, window_start, used FROM vo2.provider_rate_limits
+	WHERE provider_id = $1
+	FOR UPDATE
+	`, l.providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]int, len(rows))
+
+	for _, w := range l.windows {
+		for _, r := range rows {
+			// A stored window that is over doesn't count anymore.
+			if r.WindowName == w.Name && r.WindowStart.Equal(w.start(now)) {
+				used[w.Name] = r.Used
+			}
+		}
+	}
+
+	return used, nil
+}
@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExhaustedWindow(t *testing.T) {
+	windows := []RateLimitWindow{
+		{Name: "15min", Duration: 15 * time.Minute, Limit: 100},
+		{Name: "daily", Duration: 24 * time.Hour, Limit: 1000},
+	}
+
+	tests := []struct {
+		name string
+		used map[string]int
+		want string
+	}{
+		{"no usage", map[string]int{}, "15min"},
+		{"short window full", map[string]int{"15min": 100, "daily": 400}, "15min"},
+		{"daily window full", map[string]int{"15min": 3, "daily": 1000}, "daily"},
+		{"daily window closer to its limit", map[string]int{"15min": 50, "daily": 900}, "daily"},
+		{"tie", map[string]int{"15min": 50, "daily": 500}, "15min"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := exhaustedWindow(windows, tt.used)
+			if got.Name != tt.want {
+				t.Errorf("got window %q, want %q", got.Name, tt.want)
+			}
+		})
+	}
+}
@@ -14,11 +14,18 @@ import (
 	"github.com/gabrieleangeletti/vo2/util"
 )
 
+const (
+	// maxSQSDelay is the longest delay SQS supports. Tasks that must wait longer are delayed again when they run.
+	maxSQSDelay = 15 * time.Minute
+)
+
 type SQSTaskType string
 
 const (
 	TaskTypeHistoricalData      SQSTaskType = "historical_data"
 	TaskTypePostProcessActivity SQSTaskType = "post_process_activity"
+	TaskTypeCatchUpSync         SQSTaskType = "catch_up_sync"
+	TaskTypeErasure             SQSTaskType = "erasure"
 )
 
 type SQSTaskMessage struct {
@@ -46,6 +53,19 @@ type PostProcessActivityTask struct {
 	RawActivityID uuid.UUID         `json:"rawActivityId"`
 }
 
+// CatchUpSyncTask ingests the activities of an athlete that are missing or changed since a time, see CatchUpSync.
+type CatchUpSyncTask struct {
+	AthleteID  uuid.UUID `json:"athleteId"`
+	ProviderID int       `json:"providerId"`
+	// Since is the start of the activities to check, zero to start from the newest stored activity.
+	Since time.Time `json:"since,omitzero"`
+}
+
+// ErasureTask processes an erasure request, see ProcessErasureRequest.
+type ErasureTask struct {
+	RequestID uuid.UUID `json:"requestId"`
+}
+
 type SQSClient struct {
 	client                 *sqs.Client
 	historicalQueueURL     string
@@ -70,42 +90,35 @@ func NewSQSClient() (*SQSClient, error) {
 	}, nil
 }
 
-func (s *SQSClient) SendHistoricalDataTask(ctx context.Context, task HistoricalDataTask) error {
-	taskJSON, err := json.Marshal(task)
-	if err != nil {
-		return err
-	}
-
-	message := SQSTaskMessage{
-		Type: TaskTypeHistoricalData,
-		Data: taskJSON,
-	}
+// SendHistoricalDataTask queues a historical data task. delay postpones its processing, up to maxSQSDelay.
+func (s *SQSClient) SendHistoricalDataTask(ctx context.Context, task HistoricalDataTask, delay time.Duration) error {
+	return s.sendTask(ctx, s.historicalQueueURL, TaskTypeHistoricalData, task, delay)
+}
 
-	messageJSON, err := json.Marshal(message)
-	if err != nil {
-		return err
-	}
+// SendPostProcessActivityTask queues a post process activity task. delay postpones its processing, up to maxSQSDelay.
+func (s *SQSClient) SendPostProcessActivityTask(ctx context.Context, task PostProcessActivityTask, delay time.Duration) error {
+	return s.sendTask(ctx, s.postProcessingQueueURL, TaskTypePostProcessActivity, task, delay)
+}
 
-	_, err = s.client.SendMessage(ctx, &sqs.SendMessageInput{
-		QueueUrl:       aws.String(s.historicalQueueURL),
-		MessageBody:    aws.String(string(messageJSON)),
-		MessageGroupId: aws.String(task.AthleteID.String()),
-	})
-	if err != nil {
-		return err
-	}
+// SendCatchUpSyncTask queues a catch-up sync task, in the historical data queue. delay postpones its processing, up to
+// maxSQSDelay.
+func (s *SQSClient) SendCatchUpSyncTask(ctx context.Context, task CatchUpSyncTask, delay time.Duration) error {
+	return s.sendTask(ctx, s.historicalQueueURL, TaskTypeCatchUpSync, task, delay)
+}
 
-	return nil
+// SendErasureTask queues an erasure task, in the historical data queue.
+func (s *SQSClient) SendErasureTask(ctx context.Context, task ErasureTask) error {
+	return s.sendTask(ctx, s.historicalQueueURL, TaskTypeErasure, task, 0)
 }
 
-func (s *SQSClient) SendPostProcessActivityTask(ctx context.Context, task PostProcessActivityTask) error {
+func (s *SQSClient) sendTask(ctx context.Context, queueURL string, taskType SQSTaskType, task any, delay time.Duration) error {
 	taskJSON, err := json.Marshal(task)
 	if err != nil {
 		return err
 	}
 
 	message := SQSTaskMessage{
-		Type: TaskTypePostProcessActivity,
+		Type: taskType,
 		Data: taskJSON,
 	}
 
@@ -115,8 +128,9 @@ func (s *SQSClient) SendPostProcessActivityTask(ctx context.Context, task PostPr
 	}
 
 	_, err = s.client.SendMessage(ctx, &sqs.SendMessageInput{
-		QueueUrl:    aws.String(s.postProcessingQueueURL),
-		MessageBody: aws.String(string(messageJSON)),
+		QueueUrl:     aws.String(queueURL),
+		MessageBody:  aws.String(string(messageJSON)),
+		DelaySeconds: int32(min(delay, maxSQSDelay).Seconds()),
 	})
 	return err
 }
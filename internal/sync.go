@@ -0,0 +1,219 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type SyncWindowStatus string
+
+const (
+	SyncWindowStatusPending   SyncWindowStatus = "pending"
+	SyncWindowStatusRunning   SyncWindowStatus = "running"
+	SyncWindowStatusDelayed   SyncWindowStatus = "delayed"
+	SyncWindowStatusCompleted SyncWindowStatus = "completed"
+	SyncWindowStatusFailed    SyncWindowStatus = "failed"
+)
+
+// SyncWindow is the progress of the historical data task of an athlete, provider and time window.
+type SyncWindow struct {
+	ID         int              `json:"id" db:"id"`
+	AthleteID  uuid.UUID        `json:"athleteId" db:"athlete_id"`
+	ProviderID int              `json:"providerId" db:"provider_id"`
+	StartTime  time.Time        `json:"startTime" db:"start_time"`
+	EndTime    time.Time        `json:"endTime" db:"end_time"`
+	Status     SyncWindowStatus `json:"status" db:"status"`
+	// Cursor is the provider ID of the last activity processed, the task resumes after it.
+	Cursor         *string    `json:"cursor" db:"cursor"`
+	TotalCount     *int       `json:"totalCount" db:"total_count"`
+	ProcessedCount int        `json:"processedCount" db:"processed_count"`
+	LastError      *string    `json:"lastError" db:"last_error"`
+	StartedAt      *time.Time `json:"startedAt" db:"started_at"`
+	CompletedAt    *time.Time `json:"completedAt" db:"completed_at"`
+	CreatedAt      time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt      *time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// QueueSyncWindow records a window whose historical data task is being queued. A window that was synced before
+// starts over.
+func QueueSyncWindow(ctx context.Context, db sqlx.ExecerContext, task HistoricalDataTask) error {
+	_, err := db.ExecContext(ctx, `
+	INSERT INTO vo2.sync_windows (athlete_id, provider_id, start_time, end_time)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT
+		(athlete_id, provider_id, start_time)
+	DO UPDATE SET
+		end_time = $4,
+		status = 'pending',
+		"cursor" = NULL,
+		total_count = NULL,
+		processed_count = 0,
+		last_error = NULL,
+		started_at = NULL,
+		completed_at = NULL
+	`, task.AthleteID, task.ProviderID, task.StartTime, task.EndTime)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// StartSyncWindow marks the window of a historical data task as running and returns it, with the cursor to resume
+// from. Windows of tasks queued before the progress was tracked are created. Completed windows are returned as they
+// are, since the task was delivered twice.
+func StartSyncWindow(ctx context.Context, db *sqlx.DB, task HistoricalDataTask) (*SyncWindow, error) {
+	var window SyncWindow
+
+	err := db.GetContext(ctx, &window, `
+	INSERT INTO vo2.sync_windows (athlete_id, provider_id, start_time, end_time, status, started_at)
+	VALUES ($1, $2, $3, $4, 'running', NOW())
+	ON CONFLICT
+		(athlete_id, provider_id, start_time)
+	DO UPDATE SET
+		status = CASE WHEN sync_windows.status = 'completed' THEN sync_windows.status ELSE 'running' END,
+		started_at = COALESCE(sync_windows.started_at, NOW())
+	RETURNING *
+	`, task.AthleteID, task.ProviderID, task.StartTime, task.EndTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return &window, nil
+}
+
+// SetTotal records how many activities the window has, and how many of them were already processed.
+func (w *SyncWindow) SetTotal(ctx context.Context, db *sqlx.DB, total, processed int) error {
+	_, err := db.ExecContext(ctx, `
+	UPDATE vo2.sync_windows SET total_count = $2, processed_count = $3 WHERE id = $1
+	`, w.ID, total, processed)
+	if err != nil {
+		return err
+	}
+
+	w.TotalCount = &total
+	w.ProcessedCount = processed
+
+	return nil
+}
+
+// Advance moves the cursor past a processed activity.
+func (w *SyncWindow) Advance(ctx context.Context, db *sqlx.DB, providerActivityID string) error {
+	_, err := db.ExecContext(ctx, `
+	UPDATE vo2.sync_windows SET "cursor" = $2, processed_count = processed_count + 1 WHERE id = $1
+	`, w.ID, providerActivityID)
+	if err != nil {
+		return err
+	}
+
+	w.Cursor = &providerActivityID
+	w.ProcessedCount++
+
+	return nil
+}
+
+// Complete marks the window as synced.
+func (w *SyncWindow) Complete(ctx context.Context, db *sqlx.DB) error {
+	return w.setStatus(ctx, db, SyncWindowStatusCompleted, nil)
+}
+
+// Delay marks the window as waiting for the rate limit quota.
+func (w *SyncWindow) Delay(ctx context.Context, db *sqlx.DB) error {
+	return w.setStatus(ctx, db, SyncWindowStatusDelayed, nil)
+}
+
+// Fail marks the window as failed with the given error. The task is retried from the cursor.
+func (w *SyncWindow) Fail(ctx context.Context, db *sqlx.DB, cause error) error {
+	return w.setStatus(ctx, db, SyncWindowStatusFailed, cause)
+}
+
+func (w *SyncWindow) setStatus(ctx context.Context, db *sqlx.DB, status SyncWindowStatus, cause error) error {
+	var lastError sql.NullString
+	if cause != nil {
+		lastError = sql.NullString{String: cause.Error(), Valid: true}
+	}
+
+	_, err := db.ExecContext(ctx, `
+	UPDATE vo2.sync_windows
+	SET
+		status = $2,
+		last_error = $3,
+		completed_at = CASE WHEN $2 = 'completed' THEN NOW() END
+	WHERE id = $1
+	`, w.ID, status, lastError)
+	if err != nil {
+		return err
+	}
+
+	w.Status = status
+
+	return nil
+}
+
+// ListSyncWindows returns the sync windows of an athlete, the most recent first.
+func ListSyncWindows(ctx context.Context, db *sqlx.DB, athleteID uuid.UUID) ([]*SyncWindow, error) {
+	var windows []*SyncWindow
+
+	err := db.SelectContext(ctx, &windows, `
+	SELECT * FROM vo2.sync_windows
+	WHERE athlete_id = $1
+	ORDER BY provider_id, start_time DESC
+	`, athleteID)
+	if err != nil {
+		return nil, err
+	}
+
+	return windows, nil
+}
+
+// SyncProgress summarizes the sync windows of an athlete and provider.
+type SyncProgress struct {
+	ProviderID     int                      `json:"providerId"`
+	Windows        int                      `json:"windows"`
+	Statuses       map[SyncWindowStatus]int `json:"statuses"`
+	TotalCount     int                      `json:"totalCount"`
+	ProcessedCount int                      `json:"processedCount"`
+	LastError      *string                  `json:"lastError"`
+}
+
+// Done reports whether all the windows are completed.
+func (p *SyncProgress) Done() bool {
+	return p.Statuses[SyncWindowStatusCompleted] == p.Windows
+}
+
+// NewSyncProgress summarizes sync windows per provider, in the order the providers first appear.
+func NewSyncProgress(windows []*SyncWindow) []*SyncProgress {
+	var progress []*SyncProgress
+
+	byProvider := make(map[int]*SyncProgress)
+
+	for _, w := range windows {
+		p, ok := byProvider[w.ProviderID]
+		if !ok {
+			p = &SyncProgress{
+				ProviderID: w.ProviderID,
+				Statuses:   make(map[SyncWindowStatus]int),
+			}
+			byProvider[w.ProviderID] = p
+			progress = append(progress, p)
+		}
+
+		p.Windows++
+		p.Statuses[w.Status]++
+		p.ProcessedCount += w.ProcessedCount
+
+		if w.TotalCount != nil {
+			p.TotalCount += *w.TotalCount
+		}
+
+		if w.Status == SyncWindowStatusFailed && p.LastError == nil {
+			p.LastError = w.LastError
+		}
+	}
+
+	return progress
+}
@@ -0,0 +1,325 @@
+package internal
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/gabrieleangeletti/vo2/util"
+)
+
+var (
+	ErrTokenKeyNotFound = errors.New("token key not found")
+)
+
+// tokenKeyring holds the keys that encrypt the data keys of the OAuth tokens. They are set with OAUTH_TOKEN_KEYS, a
+// comma-separated list of <id>:<base64 32-byte key>. The first key encrypts, the others are only used to decrypt the
+// tokens encrypted before a rotation.
+type tokenKeyring struct {
+	currentID string
+	keys      map[string][]byte
+}
+
+// loadTokenKeyring returns the keyring of OAUTH_TOKEN_KEYS, which is parsed once.
+var loadTokenKeyring = sync.OnceValues(func() (*tokenKeyring, error) {
+	return parseTokenKeyring(util.GetSecret("OAUTH_TOKEN_KEYS", true))
+})
+
+// LoadTokenKeys parses OAUTH_TOKEN_KEYS, so that an invalid value fails at startup rather than when the first
+// credentials are read.
+func LoadTokenKeys() error {
+	_, err := loadTokenKeyring()
+	return err
+}
+
+func parseTokenKeyring(value string) (*tokenKeyring, error) {
+	k := &tokenKeyring{
+		keys: make(map[string][]byte),
+	}
+
+	for entry := range strings.SplitSeq(value, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid OAUTH_TOKEN_KEYS entry, expected <id>:<base64 key>")
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OAUTH_TOKEN_KEYS key %s: %w", id, err)
+		}
+
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid OAUTH_TOKEN_KEYS key %s: must be 32 bytes, got %d", id, len(key))
+		}
+
+		if k.currentID == "" {
+			k.currentID = id
+		}
+
+		k.keys[id] = key
+	}
+
+	return k, nil
+}
+
+// tokenAAD binds encrypted tokens to their credentials row, identified by table, provider and user, so that they
+// can't be decrypted once copied to another row.
+func tokenAAD(table string, providerID int, userID uuid.UUID) []byte {
+	return fmt.Appendf(nil, "vo2.%s:%d:%s", table, providerID, userID)
+}
+
+// encryptedTokens are tokens encrypted with a random data key, which is stored encrypted with a token key.
+type encryptedTokens struct {
+	KeyID   string
+	DataKey []byte
+	Tokens  []string
+}
+
+// encryptTokens encrypts the tokens with a new data key, and the data key with the current token key. aad is the
+// tokenAAD of the row the tokens are stored in.
+func encryptTokens(aad []byte, tokens ...string) (*encryptedTokens, error) {
+	keyring, err := loadTokenKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	return keyring.encrypt(aad, tokens...)
+}
+
+// decryptTokens decrypts tokens stored by encryptTokens, in place. Tokens stored before the encryption, without a
+// key ID, are left as they are. stale reports whether the tokens should be encrypted again, being in plaintext,
+// encrypted with a retired key or without the row as additional data.
+func decryptTokens(keyID sql.NullString, encryptedDataKey, aad []byte, tokens ...*string) (stale bool, err error) {
+	if !keyID.Valid {
+		return true, nil
+	}
+
+	keyring, err := loadTokenKeyring()
+	if err != nil {
+		return false, err
+	}
+
+	return keyring.decrypt(keyID.String, encryptedDataKey, aad, tokens...)
+}
+
+func (k *tokenKeyring) encrypt(aad []byte, tokens ...string) (*encryptedTokens, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	encryptedDataKey, err := seal(k.keys[k.currentID], dataKey, aad)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := &encryptedTokens{
+		KeyID:   k.currentID,
+		DataKey: encryptedDataKey,
+	}
+
+	for _, token := range tokens {
+		ciphertext, err := seal(dataKey, []byte(token), aad)
+		if err != nil {
+			return nil, err
+		}
+
+		encrypted.Tokens = append(encrypted.Tokens, base64.StdEncoding.EncodeToString(ciphertext))
+	}
+
+	return encrypted, nil
+}
+
+func (k *tokenKeyring) decrypt(keyID string, encryptedDataKey, aad []byte, tokens ...*string) (bool, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return false, fmt.Errorf("%w: %s", ErrTokenKeyNotFound, keyID)
+	}
+
+	stale := keyID != k.currentID
+
+	dataKey, err := open(key, encryptedDataKey, aad)
+	if err != nil {
+		// The tokens encrypted before they were bound to their row have no additional data. They are only accepted
+		// until they are encrypted again, by the key rotation.
+		dataKey, err = open(key, encryptedDataKey, nil)
+		if err != nil {
+			return false, fmt.Errorf("failed to decrypt data key: %w", err)
+		}
+
+		aad = nil
+		stale = true
+	}
+
+	plaintexts := make([]string, len(tokens))
+
+	for i, token := range tokens {
+		ciphertext, err := base64.StdEncoding.DecodeString(*token)
+		if err != nil {
+			return false, err
+		}
+
+		plaintext, err := open(dataKey, ciphertext, aad)
+		if err != nil {
+			return false, fmt.Errorf("failed to decrypt token: %w", err)
+		}
+
+		plaintexts[i] = string(plaintext)
+	}
+
+	for i, token := range tokens {
+		*token = plaintexts[i]
+	}
+
+	return stale, nil
+}
+
+// seal encrypts with AES-256-GCM, the nonce is prepended to the ciphertext.
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key, ciphertext, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// TokenKeyRotationResult is the outcome of RotateTokenKey.
+type TokenKeyRotationResult struct {
+	KeyID             string
+	OAuth2Credentials int
+	OAuth1Credentials int
+}
+
+// RotateTokenKey encrypts again the tokens of all the credentials, with a new data key encrypted with the current
+// token key. The credentials encrypted with the current key already are skipped, the ones stored in plaintext or
+// encrypted without their row as additional data are encrypted. Once it's done, the previous keys can be removed from OAUTH_TOKEN_KEYS.
+func RotateTokenKey(ctx context.Context, db *sqlx.DB) (*TokenKeyRotationResult, error) {
+	keyring, err := loadTokenKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TokenKeyRotationResult{KeyID: keyring.currentID}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var oauth2Credentials []*ProviderOAuth2Credentials
+
+	err = tx.SelectContext(ctx, &oauth2Credentials, `
+	SELECT * FROM vo2.provider_oauth2_credentials
+	FOR UPDATE
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range oauth2Credentials {
+		stale, err := c.decrypt()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt oauth2 credentials %d: %w", c.ID, err)
+		}
+
+		if !stale {
+			continue
+		}
+
+		encrypted, err := encryptTokens(c.aad(), c.AccessToken, c.RefreshToken)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		UPDATE vo2.provider_oauth2_credentials
+		SET access_token = $2, refresh_token = $3, token_key_id = $4, data_key = $5
+		WHERE id = $1
+		`, c.ID, encrypted.Tokens[0], encrypted.Tokens[1], encrypted.KeyID, encrypted.DataKey)
+		if err != nil {
+			return nil, err
+		}
+
+		result.OAuth2Credentials++
+	}
+
+	var oauth1Credentials []*ProviderOAuth1Credentials
+
+	err = tx.SelectContext(ctx, &oauth1Credentials, `
+	SELECT * FROM vo2.provider_oauth1_credentials
+	FOR UPDATE
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range oauth1Credentials {
+		stale, err := c.decrypt()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt oauth1 credentials %d: %w", c.ID, err)
+		}
+
+		if !stale {
+			continue
+		}
+
+		encrypted, err := encryptTokens(c.aad(), c.Token, c.TokenSecret)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		UPDATE vo2.provider_oauth1_credentials
+		SET token = $2, token_secret = $3, token_key_id = $4, data_key = $5
+		WHERE id = $1
+		`, c.ID, encrypted.Tokens[0], encrypted.Tokens[1], encrypted.KeyID, encrypted.DataKey)
+		if err != nil {
+			return nil, err
+		}
+
+		result.OAuth1Credentials++
+	}
+
+	return result, tx.Commit()
+}
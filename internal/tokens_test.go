@@ -0,0 +1,230 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func testTokenKey(t *testing.T) string {
+	t.Helper()
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func testTokenKeyring(t *testing.T, value string) *tokenKeyring {
+	t.Helper()
+
+	keyring, err := parseTokenKeyring(value)
+	if err != nil {
+		t.Fatalf("failed to parse keyring: %v", err)
+	}
+
+	return keyring
+}
+
+func TestParseTokenKeyring(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "one key", value: "a:" + testTokenKey(t)},
+		{name: "two keys", value: "b:" + testTokenKey(t) + ", a:" + testTokenKey(t)},
+		{name: "empty", value: "", wantErr: true},
+		{name: "missing id", value: ":" + testTokenKey(t), wantErr: true},
+		{name: "not base64", value: "a:not-base64!", wantErr: true},
+		{name: "short key", value: "a:" + base64.StdEncoding.EncodeToString(make([]byte, 16)), wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseTokenKeyring(tc.value)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestTokensRoundTrip(t *testing.T) {
+	keyring := testTokenKeyring(t, "a:"+testTokenKey(t))
+	aad := tokenAAD("provider_oauth2_credentials", 1, uuid.New())
+
+	encrypted, err := keyring.encrypt(aad, "access-token", "refresh-token")
+	if err != nil {
+		t.Fatalf("failed to encrypt tokens: %v", err)
+	}
+
+	if encrypted.KeyID != "a" {
+		t.Errorf("got key ID %q, want %q", encrypted.KeyID, "a")
+	}
+
+	access, refresh := encrypted.Tokens[0], encrypted.Tokens[1]
+	if access == "access-token" || refresh == "refresh-token" {
+		t.Fatal("tokens are stored in plaintext")
+	}
+
+	stale, err := keyring.decrypt(encrypted.KeyID, encrypted.DataKey, aad, &access, &refresh)
+	if err != nil {
+		t.Fatalf("failed to decrypt tokens: %v", err)
+	}
+
+	if access != "access-token" || refresh != "refresh-token" {
+		t.Errorf("got tokens %q and %q, want %q and %q", access, refresh, "access-token", "refresh-token")
+	}
+
+	if stale {
+		t.Error("tokens encrypted with the current key are stale")
+	}
+}
+
+func TestTokensKeyRotation(t *testing.T) {
+	oldKey, newKey := testTokenKey(t), testTokenKey(t)
+	aad := tokenAAD("provider_oauth1_credentials", 2, uuid.New())
+
+	encrypted, err := testTokenKeyring(t, "old:"+oldKey).encrypt(aad, "token")
+	if err != nil {
+		t.Fatalf("failed to encrypt tokens: %v", err)
+	}
+
+	// The new key encrypts, the old one is retired but still decrypts.
+	rotated := testTokenKeyring(t, "new:"+newKey+",old:"+oldKey)
+
+	token := encrypted.Tokens[0]
+
+	stale, err := rotated.decrypt(encrypted.KeyID, encrypted.DataKey, aad, &token)
+	if err != nil {
+		t.Fatalf("failed to decrypt tokens with the retired key: %v", err)
+	}
+
+	if token != "token" {
+		t.Errorf("got token %q, want %q", token, "token")
+	}
+
+	if !stale {
+		t.Error("tokens encrypted with a retired key are not stale")
+	}
+
+	reencrypted, err := rotated.encrypt(aad, token)
+	if err != nil {
+		t.Fatalf("failed to encrypt tokens: %v", err)
+	}
+
+	if reencrypted.KeyID != "new" {
+		t.Errorf("got key ID %q, want %q", reencrypted.KeyID, "new")
+	}
+}
+
+func TestTokensUnknownKey(t *testing.T) {
+	aad := tokenAAD("provider_oauth2_credentials", 1, uuid.New())
+
+	encrypted, err := testTokenKeyring(t, "old:"+testTokenKey(t)).encrypt(aad, "token")
+	if err != nil {
+		t.Fatalf("failed to encrypt tokens: %v", err)
+	}
+
+	// The old key was removed from OAUTH_TOKEN_KEYS before the rotation.
+	token := encrypted.Tokens[0]
+
+	_, err = testTokenKeyring(t, "new:"+testTokenKey(t)).decrypt(encrypted.KeyID, encrypted.DataKey, aad, &token)
+	if !errors.Is(err, ErrTokenKeyNotFound) {
+		t.Fatalf("got error %v, want %v", err, ErrTokenKeyNotFound)
+	}
+
+	if token != encrypted.Tokens[0] {
+		t.Error("token changed on a failed decryption")
+	}
+}
+
+func TestTokensTampered(t *testing.T) {
+	keyring := testTokenKeyring(t, "a:"+testTokenKey(t))
+	aad := tokenAAD("provider_oauth2_credentials", 1, uuid.New())
+
+	encrypted, err := keyring.encrypt(aad, "access-token", "refresh-token")
+	if err != nil {
+		t.Fatalf("failed to encrypt tokens: %v", err)
+	}
+
+	other, err := keyring.encrypt(tokenAAD("provider_oauth2_credentials", 1, uuid.New()), "other-access-token")
+	if err != nil {
+		t.Fatalf("failed to encrypt tokens: %v", err)
+	}
+
+	flip := func(s string) string {
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatalf("failed to decode token: %v", err)
+		}
+
+		b[len(b)-1] ^= 1
+
+		return base64.StdEncoding.EncodeToString(b)
+	}
+
+	tamperedDataKey := bytes.Clone(encrypted.DataKey)
+	tamperedDataKey[len(tamperedDataKey)-1] ^= 1
+
+	for _, tc := range []struct {
+		name    string
+		dataKey []byte
+		aad     []byte
+		token   string
+	}{
+		{name: "token", dataKey: encrypted.DataKey, aad: aad, token: flip(encrypted.Tokens[0])},
+		{name: "data key", dataKey: tamperedDataKey, aad: aad, token: encrypted.Tokens[0]},
+		{name: "not base64", dataKey: encrypted.DataKey, aad: aad, token: "not-base64!"},
+		{name: "truncated token", dataKey: encrypted.DataKey, aad: aad, token: base64.StdEncoding.EncodeToString([]byte("short"))},
+		// The tokens of a row copied to another, with a different provider or user.
+		{name: "other provider", dataKey: encrypted.DataKey, aad: tokenAAD("provider_oauth2_credentials", 2, uuid.New()), token: encrypted.Tokens[0]},
+		{name: "other table", dataKey: encrypted.DataKey, aad: tokenAAD("provider_oauth1_credentials", 1, uuid.New()), token: encrypted.Tokens[0]},
+		// A token of another row, with this row's data key.
+		{name: "other token", dataKey: encrypted.DataKey, aad: aad, token: other.Tokens[0]},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			token := tc.token
+
+			_, err := keyring.decrypt(encrypted.KeyID, tc.dataKey, tc.aad, &token)
+			if err == nil {
+				t.Fatalf("decrypted tampered token to %q", token)
+			}
+
+			if token != tc.token {
+				t.Error("token changed on a failed decryption")
+			}
+		})
+	}
+}
+
+// TestTokensWithoutAAD checks that the tokens encrypted before they were bound to their row are still decrypted,
+// and reported as stale to be encrypted again.
+func TestTokensWithoutAAD(t *testing.T) {
+	keyring := testTokenKeyring(t, "a:"+testTokenKey(t))
+
+	encrypted, err := keyring.encrypt(nil, "token")
+	if err != nil {
+		t.Fatalf("failed to encrypt tokens: %v", err)
+	}
+
+	token := encrypted.Tokens[0]
+
+	stale, err := keyring.decrypt(encrypted.KeyID, encrypted.DataKey, tokenAAD("provider_oauth1_credentials", 1, uuid.New()), &token)
+	if err != nil {
+		t.Fatalf("failed to decrypt tokens: %v", err)
+	}
+
+	if token != "token" {
+		t.Errorf("got token %q, want %q", token, "token")
+	}
+
+	if !stale {
+		t.Error("tokens encrypted without additional data are not stale")
+	}
+}
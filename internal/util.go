@@ -4,10 +4,23 @@ import (
 	"fmt"
 	"os/exec"
 	"runtime"
+	"time"
+
+	"github.com/gabrieleangeletti/vo2/ingest"
 )
 
 const (
 	fourYearsInMonths = 48
+
+	// activityFileURLTTL is how long presigned activity file URLs stay valid.
+	activityFileURLTTL = 15 * time.Minute
+
+	// maxActivityUploadSize is the maximum size of an uploaded activity file.
+	maxActivityUploadSize = ingest.MaxActivityFileSize
+
+	// maxMultipartOverhead is the room left in an upload body for the multipart envelope: the boundaries, the part
+	// headers and the form fields besides the file.
+	maxMultipartOverhead = 1 << 20 // 1 MiB
 )
 
 func OpenURLInBrowser(url string) error {
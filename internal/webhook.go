@@ -80,6 +80,117 @@ func verifyWebhook(ctx context.Context, db *sqlx.DB, token string) (bool, error)
 	return true, nil
 }
 
+// WebhookSubscription is a webhook subscription registered with a provider.
+type WebhookSubscription struct {
+	ID             int          `json:"id" db:"id"`
+	ProviderID     int          `json:"providerId" db:"provider_id"`
+	SubscriptionID string       `json:"subscriptionId" db:"subscription_id"`
+	CallbackURL    string       `json:"callbackUrl" db:"callback_url"`
+	CreatedAt      time.Time    `json:"createdAt" db:"created_at"`
+	UpdatedAt      sql.NullTime `json:"updatedAt" db:"updated_at"`
+	DeletedAt      sql.NullTime `json:"deletedAt" db:"deleted_at"`
+}
+
+func SaveWebhookSubscription(ctx context.Context, db sqlx.ExtContext, sub *WebhookSubscription) error {
+	_, err := db.ExecContext(ctx, `
+	INSERT INTO vo2.webhook_subscriptions (provider_id, subscription_id, callback_url)
+	VALUES ($1, $2, $3)
+	ON CONFLICT
+		(provider_id, subscription_id)
+	DO UPDATE SET
+		callback_url = $3, deleted_at = NULL
+	`, sub.ProviderID, sub.SubscriptionID, sub.CallbackURL)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func DeleteWebhookSubscription(ctx context.Context, db sqlx.ExtContext, providerID int, subscriptionID string) error {
+	_, err := db.ExecContext(ctx, `
+	UPDATE vo2.webhook_subscriptions
+	SET deleted_at = NOW()
+	WHERE provider_id = $1 AND subscription_id = $2 AND deleted_at IS NULL
+	`, providerID, subscriptionID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func ListWebhookSubscriptions(ctx context.Context, db sqlx.QueryerContext, providerID int) ([]*WebhookSubscription, error) {
+	var subs []*WebhookSubscription
+
+	err := sqlx.SelectContext(ctx, db, &subs, `
+	SELECT * FROM vo2.webhook_subscriptions
+	WHERE provider_id = $1 AND deleted_at IS NULL
+	ORDER BY created_at
+	`, providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return subs, nil
+}
+
+// ReconcileWebhookSubscriptions makes the stored subscriptions of a provider match the ones registered with it:
+// missing subscriptions are saved and the ones the provider doesn't know anymore are deleted.
+func ReconcileWebhookSubscriptions(ctx context.Context, db *sqlx.DB, providerID int, registered []*WebhookSubscription) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stored, err := ListWebhookSubscriptions(ctx, tx, providerID)
+	if err != nil {
+		return err
+	}
+
+	registeredIDs := make(map[string]bool, len(registered))
+
+	for _, sub := range registered {
+		registeredIDs[sub.SubscriptionID] = true
+
+		err = SaveWebhookSubscription(ctx, tx, sub)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, sub := range stored {
+		if registeredIDs[sub.SubscriptionID] {
+			continue
+		}
+
+		err = DeleteWebhookSubscription(ctx, tx, providerID, sub.SubscriptionID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// isValidWebhookSubscription reports whether an incoming event belongs to a subscription that we registered.
+func isValidWebhookSubscription(ctx context.Context, db *sqlx.DB, providerID int, subscriptionID string) (bool, error) {
+	var exists bool
+
+	err := db.GetContext(ctx, &exists, `
+	SELECT EXISTS (
+		SELECT 1 FROM vo2.webhook_subscriptions
+		WHERE provider_id = $1 AND subscription_id = $2 AND deleted_at IS NULL
+	)
+	`, providerID, subscriptionID)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
 func generateVerificationToken() (string, error) {
 	bytes := make([]byte, 32) // 256 bits
 	if _, err := rand.Read(bytes); err != nil {
@@ -18,6 +18,8 @@ type ConnectionType string
 
 const (
 	OAuth2ConnectionType ConnectionType = "oauth2"
+	ManualConnectionType ConnectionType = "manual"
+	OAuth1ConnectionType ConnectionType = "oauth1"
 )
 
 type Provider struct {
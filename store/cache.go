@@ -0,0 +1,538 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gabrieleangeletti/vo2/util"
+)
+
+const (
+	defaultObjectCacheMaxBytes = 1 << 30 // 1 GiB
+	defaultObjectCacheTTL      = 10 * time.Minute
+	objectCacheIndexFile       = "index.json"
+	objectCacheLockFile        = "index.lock"
+)
+
+// ObjectCacheConfig configures an ObjectCache.
+type ObjectCacheConfig struct {
+	Dir      string
+	MaxBytes int64
+	// TTL is how long a cached object is served without checking its ETag against the object store.
+	// Zero checks it on every download.
+	TTL time.Duration
+}
+
+// DefaultObjectCacheConfig reads the cache configuration from OBJECT_CACHE_DIR, OBJECT_CACHE_MAX_BYTES and
+// OBJECT_CACHE_TTL. The directory defaults to vo2/objects inside the user cache directory, the size limit to 1 GiB,
+// the TTL to 10 minutes.
+func DefaultObjectCacheConfig() (ObjectCacheConfig, error) {
+	cfg := ObjectCacheConfig{
+		Dir:      util.GetSecret("OBJECT_CACHE_DIR", false),
+		MaxBytes: defaultObjectCacheMaxBytes,
+		TTL:      defaultObjectCacheTTL,
+	}
+
+	if cfg.Dir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return ObjectCacheConfig{}, err
+		}
+
+		cfg.Dir = filepath.Join(userCacheDir, "vo2", "objects")
+	}
+
+	if maxBytes := util.GetSecret("OBJECT_CACHE_MAX_BYTES", false); maxBytes != "" {
+		v, err := strconv.ParseInt(maxBytes, 10, 64)
+		if err != nil {
+			return ObjectCacheConfig{}, fmt.Errorf("invalid OBJECT_CACHE_MAX_BYTES: %w", err)
+		}
+
+		cfg.MaxBytes = v
+	}
+
+	if ttl := util.GetSecret("OBJECT_CACHE_TTL", false); ttl != "" {
+		v, err := time.ParseDuration(ttl)
+		if err != nil {
+			return ObjectCacheConfig{}, fmt.Errorf("invalid OBJECT_CACHE_TTL: %w", err)
+		}
+
+		cfg.TTL = v
+	}
+
+	return cfg, nil
+}
+
+// ObjectCache is a read-through disk cache for downloaded objects, keyed by object URI and ETag.
+// When the cache grows over its size limit the least recently used objects are evicted.
+type ObjectCache struct {
+	dir      string
+	maxBytes int64
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*ObjectCacheEntry
+	// removed are the objects removed since the index was saved, so that merging the index of the other processes
+	// doesn't bring them back.
+	removed map[string]bool
+	// dirty is set when access times changed since the index was saved. They are saved with the next change of the
+	// index, or on Close.
+	dirty bool
+}
+
+// ObjectCacheEntry describes an object stored in the cache.
+type ObjectCacheEntry struct {
+	URI        string    `json:"uri"`
+	ETag       string    `json:"etag"`
+	Size       int64     `json:"size"`
+	LastAccess time.Time `json:"lastAccess"`
+	// CheckedAt is when the ETag was last checked against the object store.
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// ObjectCacheStats summarizes the content of the cache.
+type ObjectCacheStats struct {
+	Dir       string
+	Entries   int
+	SizeBytes int64
+	MaxBytes  int64
+}
+
+// NewObjectCache opens the cache in the configured directory, creating it if needed.
+func NewObjectCache(cfg ObjectCacheConfig) (*ObjectCache, error) {
+	err := os.MkdirAll(cfg.Dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create object cache directory: %w", err)
+	}
+
+	c := &ObjectCache{
+		dir:      cfg.Dir,
+		maxBytes: cfg.MaxBytes,
+		ttl:      cfg.TTL,
+		entries:  make(map[string]*ObjectCacheEntry),
+		removed:  make(map[string]bool),
+	}
+
+	entries, err := c.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	c.entries = entries
+
+	return c, nil
+}
+
+// Close saves the access times of the objects read since the index was last saved.
+func (c *ObjectCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	return c.saveIndex()
+}
+
+// Wrap returns an ObjectStore that serves downloads from the cache and falls back to obj on a miss.
+// The returned store implements ObjectPresigner when obj does.
+func (c *ObjectCache) Wrap(obj ObjectStore) ObjectStore {
+	cached := &cachedObjectStore{
+		ObjectStore: obj,
+		cache:       c,
+	}
+
+	if presigner, ok := obj.(ObjectPresigner); ok {
+		return &cachedPresignerObjectStore{
+			cachedObjectStore: cached,
+			ObjectPresigner:   presigner,
+		}
+	}
+
+	return cached
+}
+
+// Stats returns the number of cached objects and their total size.
+func (c *ObjectCache) Stats() ObjectCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := ObjectCacheStats{
+		Dir:      c.dir,
+		Entries:  len(c.entries),
+		MaxBytes: c.maxBytes,
+	}
+
+	for _, e := range c.entries {
+		stats.SizeBytes += e.Size
+	}
+
+	return stats
+}
+
+// Entries returns the cached objects, most recently used first.
+func (c *ObjectCache) Entries() []ObjectCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]ObjectCacheEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		entries = append(entries, *e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastAccess.After(entries[j].LastAccess)
+	})
+
+	return entries
+}
+
+// Clear removes every object from the cache.
+func (c *ObjectCache) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name := range c.entries {
+		err := os.Remove(filepath.Join(c.dir, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove cached object: %w", err)
+		}
+
+		c.removed[name] = true
+	}
+
+	c.entries = make(map[string]*ObjectCacheEntry)
+
+	return c.saveIndex()
+}
+
+// get returns the cached content of uri. An empty etag matches any cached version, a matching one marks the cached
+// version as checked.
+func (c *ObjectCache) get(uri, etag string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := objectCacheName(uri)
+
+	e, ok := c.entries[name]
+	if !ok || (etag != "" && e.ETag != etag) {
+		return nil, false
+	}
+
+	data, ok := c.read(name, e)
+	if ok && etag != "" {
+		e.CheckedAt = e.LastAccess
+	}
+
+	return data, ok
+}
+
+// getFresh returns the cached content of uri if its ETag was checked within the TTL.
+func (c *ObjectCache) getFresh(uri string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := objectCacheName(uri)
+
+	e, ok := c.entries[name]
+	if !ok || time.Since(e.CheckedAt) >= c.ttl {
+		return nil, false
+	}
+
+	return c.read(name, e)
+}
+
+// read reads a cached object and updates its access time. It must be called with the lock held.
+func (c *ObjectCache) read(name string, e *ObjectCacheEntry) ([]byte, bool) {
+	data, err := os.ReadFile(filepath.Join(c.dir, name))
+	if err != nil {
+		delete(c.entries, name)
+		c.removed[name] = true
+		return nil, false
+	}
+
+	// The access time is only saved with the next change of the index, a read doesn't write to disk.
+	e.LastAccess = time.Now()
+	c.dirty = true
+
+	return data, true
+}
+
+func (c *ObjectCache) put(uri, etag string, data []byte) error {
+	size := int64(len(data))
+	if size > c.maxBytes {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := objectCacheName(uri)
+
+	err := os.WriteFile(filepath.Join(c.dir, name), data, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write cached object: %w", err)
+	}
+
+	now := time.Now()
+
+	delete(c.removed, name)
+	c.entries[name] = &ObjectCacheEntry{
+		URI:        uri,
+		ETag:       etag,
+		Size:       size,
+		LastAccess: now,
+		CheckedAt:  now,
+	}
+
+	err = c.evict()
+	if err != nil {
+		return err
+	}
+
+	return c.saveIndex()
+}
+
+func (c *ObjectCache) remove(uri string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := objectCacheName(uri)
+	if _, ok := c.entries[name]; !ok {
+		return nil
+	}
+
+	delete(c.entries, name)
+	c.removed[name] = true
+
+	err := os.Remove(filepath.Join(c.dir, name))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove cached object: %w", err)
+	}
+
+	return c.saveIndex()
+}
+
+// evict removes the least recently used objects until the cache fits its size limit.
+// It must be called with the lock held.
+func (c *ObjectCache) evict() error {
+	var total int64
+	names := make([]string, 0, len(c.entries))
+	for name, e := range c.entries {
+		total += e.Size
+		names = append(names, name)
+	}
+
+	if total <= c.maxBytes {
+		return nil
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return c.entries[names[i]].LastAccess.Before(c.entries[names[j]].LastAccess)
+	})
+
+	for _, name := range names {
+		if total <= c.maxBytes {
+			break
+		}
+
+		err := os.Remove(filepath.Join(c.dir, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to evict cached object: %w", err)
+		}
+
+		total -= c.entries[name].Size
+		delete(c.entries, name)
+		c.removed[name] = true
+	}
+
+	return nil
+}
+
+// readIndex reads the index saved on disk.
+func (c *ObjectCache) readIndex() (map[string]*ObjectCacheEntry, error) {
+	entries := make(map[string]*ObjectCacheEntry)
+
+	data, err := os.ReadFile(filepath.Join(c.dir, objectCacheIndexFile))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read object cache index: %w", err)
+	}
+
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &entries)
+		if err != nil {
+			// A corrupted index only costs a cache miss, start from scratch.
+			slog.Warn("discarding corrupted object cache index", "error", err)
+			return make(map[string]*ObjectCacheEntry), nil
+		}
+	}
+
+	return entries, nil
+}
+
+// saveIndex persists the cache index. It must be called with the lock held.
+//
+// Several CLI processes can share the cache, so the index is saved under a file lock, merged with the one saved by
+// the other processes since it was read: the objects they cached are kept, and the most recent access time wins.
+func (c *ObjectCache) saveIndex() error {
+	unlock, err := lockFile(filepath.Join(c.dir, objectCacheLockFile))
+	if err != nil {
+		return fmt.Errorf("failed to lock object cache index: %w", err)
+	}
+	defer unlock()
+
+	saved, err := c.readIndex()
+	if err != nil {
+		return err
+	}
+
+	for name, e := range saved {
+		if c.removed[name] {
+			continue
+		}
+
+		current, ok := c.entries[name]
+		if !ok {
+			if _, err := os.Stat(filepath.Join(c.dir, name)); err == nil {
+				c.entries[name] = e
+			}
+
+			continue
+		}
+
+		// The other process rewrote the object after us, its entry describes the file.
+		if e.LastAccess.After(current.LastAccess) {
+			c.entries[name] = e
+		}
+	}
+
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a crash never leaves a truncated index behind.
+	tmp := filepath.Join(c.dir, objectCacheIndexFile+".tmp")
+
+	err = os.WriteFile(tmp, data, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write object cache index: %w", err)
+	}
+
+	err = os.Rename(tmp, filepath.Join(c.dir, objectCacheIndexFile))
+	if err != nil {
+		return err
+	}
+
+	c.removed = make(map[string]bool)
+	c.dirty = false
+
+	return nil
+}
+
+func objectCacheName(uri string) string {
+	sum := sha256.Sum256([]byte(uri))
+	return hex.EncodeToString(sum[:])
+}
+
+// cachedObjectStore is an ObjectStore whose downloads go through an ObjectCache.
+type cachedObjectStore struct {
+	ObjectStore
+	cache *ObjectCache
+}
+
+func (s *cachedObjectStore) UploadObject(ctx context.Context, key string, data []byte, opts *UploadOptions) (*UploadResult, error) {
+	res, err := s.ObjectStore.UploadObject(ctx, key, data, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, uri := range []string{key, res.Location} {
+		err = s.cache.remove(uri)
+		if err != nil {
+			slog.Warn("failed to invalidate cached object", "uri", uri, "error", err)
+		}
+	}
+
+	return res, nil
+}
+
+func (s *cachedObjectStore) DeleteObject(ctx context.Context, keyOrURL string) error {
+	err := s.ObjectStore.DeleteObject(ctx, keyOrURL)
+	if err != nil {
+		return err
+	}
+
+	err = s.cache.remove(keyOrURL)
+	if err != nil {
+		slog.Warn("failed to invalidate cached object", "uri", keyOrURL, "error", err)
+	}
+
+	return nil
+}
+
+// DownloadObject serves the cached copy of the object while its ETag was checked within the TTL. After that it checks
+// the current ETag of the object and serves the cached copy when it matches.
+// If the object store can't be reached, any cached copy is served so that analysis can run offline.
+func (s *cachedObjectStore) DownloadObject(ctx context.Context, keyOrURL string) ([]byte, error) {
+	data, ok := s.cache.getFresh(keyOrURL)
+	if ok {
+		return data, nil
+	}
+
+	info, err := s.ObjectStore.HeadObject(ctx, keyOrURL)
+	if err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			removeErr := s.cache.remove(keyOrURL)
+			if removeErr != nil {
+				slog.Warn("failed to invalidate cached object", "uri", keyOrURL, "error", removeErr)
+			}
+
+			return nil, err
+		}
+
+		data, ok := s.cache.get(keyOrURL, "")
+		if ok {
+			slog.Warn("object store unavailable, serving cached object", "uri", keyOrURL, "error", err)
+			return data, nil
+		}
+
+		return nil, err
+	}
+
+	data, ok = s.cache.get(keyOrURL, info.ETag)
+	if ok {
+		return data, nil
+	}
+
+	data, err = s.ObjectStore.DownloadObject(ctx, keyOrURL)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.cache.put(keyOrURL, info.ETag, data)
+	if err != nil {
+		slog.Warn("failed to cache object", "uri", keyOrURL, "error", err)
+	}
+
+	return data, nil
+}
+
+// cachedPresignerObjectStore is a cachedObjectStore that keeps handing out the presigned URLs of the wrapped store.
+type cachedPresignerObjectStore struct {
+	*cachedObjectStore
+	ObjectPresigner
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package store
+
+// lockFile is a no-op on the platforms without flock, the index of the cache is only safe to share between processes
+// on unix.
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,244 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestObjectCacheGetDoesNotWriteIndex(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := NewObjectCache(ObjectCacheConfig{Dir: dir, MaxBytes: 1 << 20})
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	err = c.put("mem://a", "1", []byte("a"))
+	if err != nil {
+		t.Fatalf("failed to cache object: %v", err)
+	}
+
+	index := filepath.Join(dir, objectCacheIndexFile)
+
+	before, err := os.ReadFile(index)
+	if err != nil {
+		t.Fatalf("failed to read index: %v", err)
+	}
+
+	if _, ok := c.get("mem://a", "1"); !ok {
+		t.Fatal("cached object not found")
+	}
+
+	after, err := os.ReadFile(index)
+	if err != nil {
+		t.Fatalf("failed to read index: %v", err)
+	}
+
+	if string(before) != string(after) {
+		t.Error("index rewritten on a cache hit")
+	}
+
+	err = c.Close()
+	if err != nil {
+		t.Fatalf("failed to close cache: %v", err)
+	}
+
+	reopened, err := NewObjectCache(ObjectCacheConfig{Dir: dir, MaxBytes: 1 << 20})
+	if err != nil {
+		t.Fatalf("failed to reopen cache: %v", err)
+	}
+
+	if got, want := reopened.entries[objectCacheName("mem://a")].LastAccess, c.entries[objectCacheName("mem://a")].LastAccess; !got.Equal(want) {
+		t.Errorf("got access time %v after close, want %v", got, want)
+	}
+}
+
+func TestObjectCacheMergesIndexOfOtherProcesses(t *testing.T) {
+	dir := t.TempDir()
+	cfg := ObjectCacheConfig{Dir: dir, MaxBytes: 1 << 20}
+
+	c1, err := NewObjectCache(cfg)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	c2, err := NewObjectCache(cfg)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	for _, step := range []struct {
+		c   *ObjectCache
+		uri string
+	}{{c1, "mem://a"}, {c2, "mem://b"}, {c1, "mem://c"}} {
+		err = step.c.put(step.uri, "1", []byte(step.uri))
+		if err != nil {
+			t.Fatalf("failed to cache %s: %v", step.uri, err)
+		}
+	}
+
+	err = c2.remove("mem://a")
+	if err != nil {
+		t.Fatalf("failed to remove object: %v", err)
+	}
+
+	reopened, err := NewObjectCache(cfg)
+	if err != nil {
+		t.Fatalf("failed to reopen cache: %v", err)
+	}
+
+	for uri, want := range map[string]bool{"mem://a": false, "mem://b": true, "mem://c": true} {
+		if _, ok := reopened.get(uri, ""); ok != want {
+			t.Errorf("%s cached = %v, want %v", uri, ok, want)
+		}
+	}
+}
+
+// countingObjectStore counts the calls that reach the object store.
+type countingObjectStore struct {
+	ObjectStore
+	heads     int
+	downloads int
+}
+
+func (s *countingObjectStore) HeadObject(ctx context.Context, keyOrURL string) (*ObjectInfo, error) {
+	s.heads++
+	return s.ObjectStore.HeadObject(ctx, keyOrURL)
+}
+
+func (s *countingObjectStore) DownloadObject(ctx context.Context, keyOrURL string) ([]byte, error) {
+	s.downloads++
+	return s.ObjectStore.DownloadObject(ctx, keyOrURL)
+}
+
+type fakePresigner struct {
+	ObjectStore
+}
+
+func (fakePresigner) PresignObject(ctx context.Context, keyOrURL string, filename string, ttl time.Duration) (string, error) {
+	return "https://example.com/" + keyOrURL, nil
+}
+
+func TestCachedObjectStoreTTL(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		ttl           time.Duration
+		wantHeads     int
+		wantDownloads int
+	}{
+		{"checked on every download", 0, 3, 1},
+		{"trusted within the TTL", time.Hour, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewObjectCache(ObjectCacheConfig{Dir: t.TempDir(), MaxBytes: 1 << 20, TTL: tt.ttl})
+			if err != nil {
+				t.Fatalf("failed to create cache: %v", err)
+			}
+
+			mem := NewMemoryObjectStore()
+
+			_, err = mem.UploadObject(ctx, "a", []byte("a"), nil)
+			if err != nil {
+				t.Fatalf("failed to upload object: %v", err)
+			}
+
+			counting := &countingObjectStore{ObjectStore: mem}
+			obj := c.Wrap(counting)
+
+			// The first download is a miss, it checks the ETag once more than the following ones.
+			for range 4 {
+				data, err := obj.DownloadObject(ctx, "a")
+				if err != nil {
+					t.Fatalf("failed to download object: %v", err)
+				}
+
+				if string(data) != "a" {
+					t.Fatalf("got %q, want %q", data, "a")
+				}
+			}
+
+			if counting.heads-1 != tt.wantHeads {
+				t.Errorf("got %d ETag checks on a hit, want %d", counting.heads-1, tt.wantHeads)
+			}
+
+			if counting.downloads != tt.wantDownloads {
+				t.Errorf("got %d downloads, want %d", counting.downloads, tt.wantDownloads)
+			}
+		})
+	}
+}
+
+func TestCachedObjectStoreTTLExpired(t *testing.T) {
+	ctx := context.Background()
+
+	c, err := NewObjectCache(ObjectCacheConfig{Dir: t.TempDir(), MaxBytes: 1 << 20, TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	mem := NewMemoryObjectStore()
+
+	_, err = mem.UploadObject(ctx, "a", []byte("a"), nil)
+	if err != nil {
+		t.Fatalf("failed to upload object: %v", err)
+	}
+
+	err = c.put("a", "stale", []byte("stale"))
+	if err != nil {
+		t.Fatalf("failed to cache object: %v", err)
+	}
+
+	obj := c.Wrap(mem)
+
+	data, err := obj.DownloadObject(ctx, "a")
+	if err != nil {
+		t.Fatalf("failed to download object: %v", err)
+	}
+
+	if string(data) != "stale" {
+		t.Fatalf("got %q within the TTL, want the cached copy", data)
+	}
+
+	c.entries[objectCacheName("a")].CheckedAt = time.Now().Add(-2 * time.Hour)
+
+	data, err = obj.DownloadObject(ctx, "a")
+	if err != nil {
+		t.Fatalf("failed to download object: %v", err)
+	}
+
+	if string(data) != "a" {
+		t.Errorf("got %q after the TTL, want the current object", data)
+	}
+}
+
+func TestObjectCacheWrapPresigner(t *testing.T) {
+	c, err := NewObjectCache(ObjectCacheConfig{Dir: t.TempDir(), MaxBytes: 1 << 20})
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	if _, ok := c.Wrap(NewMemoryObjectStore()).(ObjectPresigner); ok {
+		t.Error("wrapped memory store implements ObjectPresigner")
+	}
+
+	presigner, ok := c.Wrap(fakePresigner{NewMemoryObjectStore()}).(ObjectPresigner)
+	if !ok {
+		t.Fatal("wrapped presigner doesn't implement ObjectPresigner")
+	}
+
+	url, err := presigner.PresignObject(context.Background(), "a", "a.fit", time.Minute)
+	if err != nil {
+		t.Fatalf("failed to presign object: %v", err)
+	}
+
+	if url != "https://example.com/a" {
+		t.Errorf("got URL %q, want %q", url, "https://example.com/a")
+	}
+}
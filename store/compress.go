@@ -0,0 +1,55 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+const contentEncodingGzip = "gzip"
+
+// gzipMagic is the header every gzip stream starts with.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func gzipObject(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = zw.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// isGzipped reports whether data is a gzip stream.
+func isGzipped(data []byte) bool {
+	return bytes.HasPrefix(data, gzipMagic)
+}
+
+// gunzipObject decompresses data if it's a gzip stream, and returns it unchanged otherwise.
+// The content is sniffed rather than relying on the Content-Encoding metadata, since objects written before
+// compression was introduced are plain and not every backend keeps metadata.
+func gunzipObject(data []byte) ([]byte, error) {
+	if !isGzipped(data) {
+		return data, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return io.ReadAll(zr)
+}
@@ -0,0 +1,324 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/gabrieleangeletti/vo2/util"
+)
+
+const (
+	// duplicateMinTimeOverlap is the minimum overlap of two activities, as a fraction of the shortest one, for them to
+	// be the same activity.
+	duplicateMinTimeOverlap = 0.8
+	// duplicateMaxDistanceDiff is the maximum difference between the distances of two activities, as a fraction of
+	// the longest one.
+	duplicateMaxDistanceDiff = 0.1
+	// duplicateMaxRouteDistance is the maximum distance in meters between the start points and between the end points
+	// of the routes of two activities. Activities without a route are matched by time and distance only.
+	duplicateMaxRouteDistance = 500
+)
+
+// defaultDuplicateProviderPriority is the order in which the providers are preferred for the canonical activity:
+// the data recorded by the device first.
+var defaultDuplicateProviderPriority = []string{"garmin", "apple-health", "upload", "strava"}
+
+// WithDuplicateProviderPriority sets the order in which the providers are preferred for the canonical activity of a
+// group of duplicates. It defaults to DUPLICATE_PROVIDER_PRIORITY, a comma-separated list of provider slugs.
+func WithDuplicateProviderPriority(slugs []string) Option {
+	return func(s *store) {
+		s.duplicatePriority = slugs
+	}
+}
+
+func duplicateProviderPriority() []string {
+	value := util.GetSecret("DUPLICATE_PROVIDER_PRIORITY", false)
+	if value == "" {
+		return defaultDuplicateProviderPriority
+	}
+
+	var slugs []string
+	for slug := range strings.SplitSeq(value, ",") {
+		if slug = strings.TrimSpace(slug); slug != "" {
+			slugs = append(slugs, slug)
+		}
+	}
+
+	return slugs
+}
+
+// activityMatch compares an activity with another one of the same athlete.
+type activityMatch struct {
+	ActivityID    uuid.UUID       `db:"id"`
+	TimeOverlap   float64         `db:"time_overlap"`
+	DistanceDiff  float64         `db:"distance_diff"`
+	RouteDistance sql.NullFloat64 `db:"route_distance"`
+}
+
+// activityMatchQuery compares the activity $1 with the other activities of the athlete, "b".
+const activityMatchQuery = `
+	SELECT
+		b.id,
+		GREATEST(
+			EXTRACT(EPOCH FROM LEAST(a.end_time, b.end_time) - GREATEST(a.start_time, b.start_time))
+			/ GREATEST(LEAST(EXTRACT(EPOCH FROM a.end_time - a.start_time), EXTRACT(EPOCH FROM b.end_time - b.start_time)), 1),
+			0
+		)::float AS time_overlap,
+		(ABS(a.distance - b.distance)::float / GREATEST(a.distance, b.distance, 1))::float AS distance_diff,
+		GREATEST(
+			postgis.ST_Distance(postgis.ST_StartPoint(a.summary_route)::postgis.geography, postgis.ST_StartPoint(b.summary_route)::postgis.geography),
+			postgis.ST_Distance(postgis.ST_EndPoint(a.summary_route)::postgis.geography, postgis.ST_EndPoint(b.summary_route)::postgis.geography)
+		)::float AS route_distance
+	FROM vo2.activities_endurance a
+	JOIN vo2.activities_endurance b ON b.athlete_id = a.athlete_id AND b.id <> a.id
+	WHERE
+		a.id = $1
+		AND b.deleted_at IS NULL`
+
+// findDuplicateCandidates returns the activities of other providers that are the same activity as the given one.
+func findDuplicateCandidates(ctx context.Context, db sqlx.QueryerContext, activityID uuid.UUID) ([]*activityMatch, error) {
+	var matches []*activityMatch
+
+	err := sqlx.SelectContext(ctx, db, &matches, `
+	SELECT * FROM (`+activityMatchQuery+`
+		AND b.provider_id <> a.provider_id
+		AND b.start_time < a.end_time
+		AND b.end_time > a.start_time
+	) m
+	WHERE
+		m.time_overlap >= $2
+		AND m.distance_diff <= $3
+		AND (m.route_distance IS NULL OR m.route_distance <= $4)
+	`, activityID, duplicateMinTimeOverlap, duplicateMaxDistanceDiff, duplicateMaxRouteDistance)
+	if err != nil {
+		return nil, err
+	}
+
+	return matches, nil
+}
+
+// matchActivityDuplicates links a stored activity with its duplicates from other providers, replacing its previous
+// links. The activity joins the groups of its duplicates, and the group gets a canonical activity by provider
+// priority.
+//
+// The activities of an athlete are matched one transaction at a time, otherwise two activities stored concurrently,
+// e.g. by the webhooks of two providers, wouldn't see each other and would never be linked.
+func (s *store) matchActivityDuplicates(ctx context.Context, tx *sqlx.Tx, activityID uuid.UUID) error {
+	_, err := tx.ExecContext(ctx, `
+	SELECT pg_advisory_xact_lock(hashtext(athlete_id::text))
+	FROM vo2.activities_endurance
+	WHERE id = $1
+	`, activityID)
+	if err != nil {
+		return err
+	}
+
+	err = s.unlinkActivityDuplicates(ctx, tx, []uuid.UUID{activityID})
+	if err != nil {
+		return err
+	}
+
+	candidates, err := findDuplicateCandidates(ctx, tx, activityID)
+	if err != nil {
+		return err
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	ids := []uuid.UUID{activityID}
+	for _, c := range candidates {
+		ids = append(ids, c.ActivityID)
+	}
+
+	return s.linkActivityDuplicates(ctx, tx, ids)
+}
+
+// unlinkActivityDuplicates removes the given activities from their groups of duplicates, e.g. because they were
+// deleted. The remaining duplicates of a removed canonical activity get a new canonical activity.
+func (s *store) unlinkActivityDuplicates(ctx context.Context, tx *sqlx.Tx, activityIDs []uuid.UUID) error {
+	var orphans []uuid.UUID
+
+	err := tx.SelectContext(ctx, &orphans, `
+	SELECT duplicate_activity_id FROM vo2.activities_endurance_duplicates
+	WHERE
+		canonical_activity_id = ANY($1)
+		AND NOT duplicate_activity_id = ANY($1)
+		AND deleted_at IS NULL
+	`, activityIDs)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE vo2.activities_endurance_duplicates
+	SET deleted_at = NOW()
+	WHERE
+		(canonical_activity_id = ANY($1) OR duplicate_activity_id = ANY($1))
+		AND deleted_at IS NULL
+	`, activityIDs)
+	if err != nil {
+		return err
+	}
+
+	if len(orphans) < 2 {
+		return nil
+	}
+
+	return s.linkActivityDuplicates(ctx, tx, orphans)
+}
+
+// linkActivityDuplicates merges the given activities, and the groups they belong to, into a single group of
+// duplicates. The activity of the provider with the highest priority is the canonical one, the others are linked to
+// it.
+func (s *store) linkActivityDuplicates(ctx context.Context, tx *sqlx.Tx, activityIDs []uuid.UUID) error {
+	var members []struct {
+		ID           uuid.UUID `db:"id"`
+		AthleteID    uuid.UUID `db:"athlete_id"`
+		ProviderSlug string    `db:"provider_slug"`
+	}
+
+	err := tx.SelectContext(ctx, &members, `
+	WITH ids AS (
+		SELECT unnest($1::uuid[]) AS id
+	),
+	canonicals AS (
+		SELECT d.canonical_activity_id AS id
+		FROM vo2.activities_endurance_duplicates d
+		WHERE
+			(d.duplicate_activity_id IN (SELECT id FROM ids) OR d.canonical_activity_id IN (SELECT id FROM ids))
+			AND d.deleted_at IS NULL
+	),
+	members AS (
+		SELECT id FROM ids
+		UNION
+		SELECT id FROM canonicals
+		UNION
+		SELECT d.duplicate_activity_id
+		FROM vo2.activities_endurance_duplicates d
+		WHERE
+			d.canonical_activity_id IN (SELECT id FROM canonicals)
+			AND d.deleted_at IS NULL
+	)
+	SELECT a.id, a.athlete_id, p.slug AS provider_slug
+	FROM vo2.activities_endurance a
+	JOIN vo2.providers p ON a.provider_id = p.id
+	WHERE
+		a.id IN (SELECT id FROM members)
+		AND a.deleted_at IS NULL
+	ORDER BY a.id
+	`, activityIDs)
+	if err != nil {
+		return err
+	}
+
+	if len(members) < 2 {
+		return nil
+	}
+
+	priority := func(slug string) int {
+		if i := slices.Index(s.duplicatePriority, slug); i >= 0 {
+			return i
+		}
+
+		return len(s.duplicatePriority)
+	}
+
+	// The members are sorted by ID, so ties go to the activity stored first.
+	canonical := members[0]
+	for _, m := range members[1:] {
+		if priority(m.ProviderSlug) < priority(canonical.ProviderSlug) {
+			canonical = m
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE vo2.activities_endurance_duplicates
+	SET deleted_at = NOW()
+	WHERE duplicate_activity_id = $1 AND deleted_at IS NULL
+	`, canonical.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range members {
+		if m.ID == canonical.ID {
+			continue
+		}
+
+		var match activityMatch
+
+		err = tx.GetContext(ctx, &match, activityMatchQuery+`
+		AND b.id = $2
+		`, canonical.ID, m.ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO vo2.activities_endurance_duplicates
+			(athlete_id, canonical_activity_id, duplicate_activity_id, time_overlap, distance_diff, route_distance)
+		VALUES
+			($1, $2, $3, $4, $5, $6)
+		ON CONFLICT
+			(duplicate_activity_id)
+		DO UPDATE SET
+			canonical_activity_id = $2,
+			time_overlap = $4,
+			distance_diff = $5,
+			route_distance = $6,
+			deleted_at = NULL
+		`, canonical.AthleteID, canonical.ID, m.ID, match.TimeOverlap, match.DistanceDiff, match.RouteDistance)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MatchAthleteActivityDuplicates links the duplicate activities of an athlete, e.g. the ones stored before the
+// duplicates were detected. It returns the number of activities hidden as duplicates.
+func (s *store) MatchAthleteActivityDuplicates(ctx context.Context, athleteID uuid.UUID) (int, error) {
+	var ids []uuid.UUID
+
+	err := s.db.SelectContext(ctx, &ids, `
+	SELECT id FROM vo2.activities_endurance
+	WHERE athlete_id = $1 AND deleted_at IS NULL
+	ORDER BY start_time
+	`, athleteID)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		err = s.matchActivityDuplicates(ctx, tx, id)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var count int
+
+	err = tx.GetContext(ctx, &count, `
+	SELECT COUNT(*) FROM vo2.activities_endurance_duplicates
+	WHERE athlete_id = $1 AND deleted_at IS NULL
+	`, athleteID)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, tx.Commit()
+}
@@ -0,0 +1,280 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ErasureResult is the outcome of deleting the data of an athlete.
+type ErasureResult struct {
+	// Rows is the number of rows deleted per table.
+	Rows map[string]int64
+	// Objects is the number of objects deleted from the object store.
+	Objects int
+}
+
+// DeleteAthleteProviderData deletes the data an athlete has from a provider: the raw activities, the activities and
+// their analyses, tags and duplicate links, the sync windows, and the objects they reference. Soft-deleted rows are
+// deleted too. The duplicates of the deleted activities from other providers get a new canonical activity.
+func (s *store) DeleteAthleteProviderData(ctx context.Context, athleteID uuid.UUID, providerID int) (*ErasureResult, error) {
+	return s.deleteAthleteData(ctx, athleteID, sql.NullInt32{Int32: int32(providerID), Valid: true})
+}
+
+// DeleteAthlete deletes an athlete with all its data, from all the providers, and its measurements. The provider
+// athletes linked to it must be deleted first.
+func (s *store) DeleteAthlete(ctx context.Context, athleteID uuid.UUID) (*ErasureResult, error) {
+	return s.deleteAthleteData(ctx, athleteID, sql.NullInt32{})
+}
+
+// deleteAthleteData deletes the data of an athlete from the provider, or from all the providers and the athlete
+// itself if the provider is not set.
+//
+// The objects are deleted before the rows, so a failure leaves rows pointing to missing objects rather than
+// unreferenced objects, and running it again completes the erasure.
+func (s *store) deleteAthleteData(ctx context.Context, athleteID uuid.UUID, providerID sql.NullInt32) (*ErasureResult, error) {
+	res := &ErasureResult{
+		Rows: map[string]int64{},
+	}
+
+	keys, err := s.deleteAthleteObjects(ctx, athleteID, providerID, res)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var activityIDs []uuid.UUID
+
+	err = tx.SelectContext(ctx, &activityIDs, `
+	SELECT id FROM vo2.activities_endurance
+	WHERE athlete_id = $1 AND ($2::int IS NULL OR provider_id = $2)
+	`, athleteID, providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	del := func(table, query string, args ...any) error {
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to delete from %s: %w", table, err)
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		res.Rows[table] += n
+
+		return nil
+	}
+
+	if len(activityIDs) > 0 {
+		err = s.unlinkActivityDuplicates(ctx, tx, activityIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		err = del("activities_endurance_duplicates", `
+		DELETE FROM vo2.activities_endurance_duplicates
+		WHERE canonical_activity_id = ANY($1) OR duplicate_activity_id = ANY($1)
+		`, activityIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		err = del("activities_threshold_analysis", `
+		DELETE FROM vo2.activities_threshold_analysis
+		WHERE activity_endurance_id = ANY($1)
+		`, activityIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		err = del("activities_endurance_tags", `
+		DELETE FROM vo2.activities_endurance_tags
+		WHERE activity_id = ANY($1)
+		`, activityIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		err = del("activities_endurance", `
+		DELETE FROM vo2.activities_endurance
+		WHERE id = ANY($1)
+		`, activityIDs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = del("provider_activity_raw_data", `
+	DELETE FROM vo2.provider_activity_raw_data
+	WHERE athlete_id = $1 AND ($2::int IS NULL OR provider_id = $2)
+	`, athleteID, providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = del("sync_windows", `
+	DELETE FROM vo2.sync_windows
+	WHERE athlete_id = $1 AND ($2::int IS NULL OR provider_id = $2)
+	`, athleteID, providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !providerID.Valid {
+		err = del("athlete_measurement_history", `
+		DELETE FROM vo2.athlete_measurement_history
+		WHERE athlete_id = $1
+		`, athleteID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = del("athletes", `
+		DELETE FROM vo2.athletes
+		WHERE id = $1
+		`, athleteID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(keys) > 0 {
+		err = del("object_outbox", `
+		DELETE FROM vo2.object_outbox
+		WHERE object_key = ANY($1)
+		`, keys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, tx.Commit()
+}
+
+// deleteAthleteObjects deletes the objects of the athlete from the provider, or from all the providers, and returns
+// their keys.
+//
+// Besides the objects referenced by the rows, the objects named after the raw activities and activities of the athlete
+// that no row references are deleted too, e.g. a file left behind by an upload that was never committed, or by an
+// update that stored a new URI.
+func (s *store) deleteAthleteObjects(ctx context.Context, athleteID uuid.UUID, providerID sql.NullInt32, res *ErasureResult) ([]string, error) {
+	var uris []string
+
+	err := s.db.SelectContext(ctx, &uris, `
+	SELECT detailed_activity_uri AS uri FROM vo2.provider_activity_raw_data
+	WHERE athlete_id = $1 AND ($2::int IS NULL OR provider_id = $2) AND detailed_activity_uri IS NOT NULL AND detailed_activity_uri <> ''
+	UNION
+	SELECT gpx_file_uri AS uri FROM vo2.activities_endurance
+	WHERE athlete_id = $1 AND ($2::int IS NULL OR provider_id = $2) AND gpx_file_uri IS NOT NULL AND gpx_file_uri <> ''
+	UNION
+	SELECT fit_file_uri AS uri FROM vo2.activities_endurance
+	WHERE athlete_id = $1 AND ($2::int IS NULL OR provider_id = $2) AND fit_file_uri IS NOT NULL AND fit_file_uri <> ''
+	UNION
+	SELECT timeseries_uri AS uri FROM vo2.activities_endurance
+	WHERE athlete_id = $1 AND ($2::int IS NULL OR provider_id = $2) AND timeseries_uri IS NOT NULL AND timeseries_uri <> ''
+	`, athleteID, providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var owners []struct {
+		ID       uuid.UUID `db:"id"`
+		Provider string    `db:"provider"`
+	}
+
+	err = s.db.SelectContext(ctx, &owners, `
+	SELECT r.id, p.slug AS provider FROM vo2.provider_activity_raw_data r
+	JOIN vo2.providers p ON p.id = r.provider_id
+	WHERE r.athlete_id = $1 AND ($2::int IS NULL OR r.provider_id = $2)
+	UNION
+	SELECT a.id, p.slug AS provider FROM vo2.activities_endurance a
+	JOIN vo2.providers p ON p.id = a.provider_id
+	WHERE a.athlete_id = $1 AND ($2::int IS NULL OR a.provider_id = $2)
+	`, athleteID, providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := map[string]bool{}
+	keys := []string{}
+
+	// The URI is passed to the object store rather than the key, so that the cached copy of the object is evicted.
+	deleteObject := func(uri string) error {
+		key, err := s.obj.ObjectKey(uri)
+		if err != nil {
+			return fmt.Errorf("failed to resolve object URI %s: %w", uri, err)
+		}
+
+		if deleted[key] {
+			return nil
+		}
+
+		deleted[key] = true
+		keys = append(keys, key)
+
+		err = s.obj.DeleteObject(ctx, uri)
+		if err != nil {
+			if errors.Is(err, ErrObjectNotFound) {
+				return nil
+			}
+
+			return fmt.Errorf("failed to delete object %s: %w", key, err)
+		}
+
+		res.Objects++
+
+		return nil
+	}
+
+	for _, uri := range uris {
+		err = deleteObject(uri)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The objects are named activity_details/<provider>/<kind>/<id>.<ext>, by raw activity or activity ID.
+	ids := map[string]map[string]bool{}
+	for _, o := range owners {
+		if ids[o.Provider] == nil {
+			ids[o.Provider] = map[string]bool{}
+		}
+
+		ids[o.Provider][o.ID.String()] = true
+	}
+
+	for provider, providerIDs := range ids {
+		objectKeys, err := s.obj.ListObjects(ctx, fmt.Sprintf("activity_details/%s/", provider))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range objectKeys {
+			name := path.Base(key)
+			if !providerIDs[strings.TrimSuffix(name, path.Ext(name))] {
+				continue
+			}
+
+			err = deleteObject(key)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gabrieleangeletti/vo2/activity"
+	"github.com/gabrieleangeletti/vo2/ingest"
+)
+
+var (
+	ErrActivityFileNotFound        = errors.New("activity file not found")
+	ErrUnsupportedActivityFileKind = errors.New("unsupported activity file kind")
+)
+
+type ActivityFileKind string
+
+const (
+	ActivityFileKindGPX ActivityFileKind = "gpx"
+	ActivityFileKindFIT ActivityFileKind = "fit"
+	ActivityFileKindRaw ActivityFileKind = "raw"
+)
+
+var activityFileContentTypes = map[ActivityFileKind]string{
+	ActivityFileKindGPX: "application/gpx+xml",
+	ActivityFileKindFIT: "application/vnd.ant.fit",
+	ActivityFileKindRaw: "application/json",
+}
+
+// activityFileFormatContentTypes are the content types of the original files of uploaded activities.
+var activityFileFormatContentTypes = map[ingest.FileFormat]string{
+	ingest.FileFormatGPX: "application/gpx+xml",
+	ingest.FileFormatFIT: "application/vnd.ant.fit",
+	ingest.FileFormatTCX: "application/vnd.garmin.tcx+xml",
+}
+
+// rawActivityDetailsContentType returns the content type of a raw activity details object from its key, for the
+// object stores that don't keep the content type.
+func rawActivityDetailsContentType(key string) string {
+	format := ingest.FileFormat(strings.TrimPrefix(path.Ext(key), "."))
+	if contentType, ok := activityFileFormatContentTypes[format]; ok {
+		return contentType
+	}
+
+	return "application/json"
+}
+
+// ActivityFile is a downloadable activity file.
+// When the object store supports presigning, URL is a temporary download link valid until ExpiresAt.
+// Otherwise the content of the file is returned in Data.
+type ActivityFile struct {
+	Filename    string
+	ContentType string
+	URL         string
+	ExpiresAt   time.Time
+	Data        []byte
+}
+
+// GetActivityFile returns a file of the given kind for the activity, either as a presigned URL valid for ttl
+// or, for object stores that can't presign (e.g. the local backend), with its content.
+func (s *store) GetActivityFile(ctx context.Context, act *activity.EnduranceActivity, kind ActivityFileKind, ttl time.Duration) (*ActivityFile, error) {
+	uri, err := s.activityFileURI(ctx, act, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	if uri == "" {
+		return nil, fmt.Errorf("%w: %s file of activity %s", ErrActivityFileNotFound, kind, act.ID)
+	}
+
+	ext := path.Ext(uri)
+
+	file := &ActivityFile{
+		Filename:    fmt.Sprintf("%s%s", act.ID, ext),
+		ContentType: activityFileContentTypes[kind],
+	}
+
+	// The raw details of uploaded activities are the original files.
+	if contentType, ok := activityFileFormatContentTypes[ingest.FileFormat(strings.TrimPrefix(ext, "."))]; ok && kind == ActivityFileKindRaw {
+		file.ContentType = contentType
+	}
+
+	if presigner, ok := s.obj.(ObjectPresigner); ok {
+		file.URL, err = presigner.PresignObject(ctx, uri, file.Filename, ttl)
+		if err != nil {
+			return nil, err
+		}
+
+		file.ExpiresAt = time.Now().Add(ttl)
+
+		return file, nil
+	}
+
+	data, err := s.obj.DownloadObject(ctx, uri)
+	if err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil, fmt.Errorf("%w: %s file of activity %s", ErrActivityFileNotFound, kind, act.ID)
+		}
+
+		return nil, err
+	}
+
+	// Raw details are stored compressed, serve them uncompressed.
+	file.Data, err = gunzipObject(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (s *store) activityFileURI(ctx context.Context, act *activity.EnduranceActivity, kind ActivityFileKind) (string, error) {
+	switch kind {
+	case ActivityFileKindGPX:
+		return act.GpxFileURI, nil
+	case ActivityFileKindFIT:
+		return act.FitFileURI, nil
+	case ActivityFileKindRaw:
+		activityRaw, err := s.GetProviderActivityRaw(ctx, act.ProviderRawActivityID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return "", nil
+			}
+
+			return "", err
+		}
+
+		return activityRaw.DetailedActivityURI.String, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedActivityFileKind, kind)
+	}
+}
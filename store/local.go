@@ -0,0 +1,223 @@
+package store
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gabrieleangeletti/vo2/util"
+)
+
+var (
+	ErrObjectOutsideBaseDir = errors.New("object is outside of the local object store directory")
+)
+
+// localObjectStore keeps objects in a directory tree on the local filesystem.
+// Object locations are returned as file:// URLs.
+type localObjectStore struct {
+	baseDir string
+}
+
+func newLocalObjectStore() (*localObjectStore, error) {
+	baseDir, err := filepath.Abs(util.GetSecret("OBJECT_STORE_LOCAL_DIR", true))
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(baseDir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create local object store directory: %w", err)
+	}
+
+	return &localObjectStore{
+		baseDir: baseDir,
+	}, nil
+}
+
+func (s *localObjectStore) UploadObject(ctx context.Context, key string, data []byte, opts *UploadOptions) (*UploadResult, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create object directory: %w", err)
+	}
+
+	err = os.WriteFile(path, data, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write object: %w", err)
+	}
+
+	sum := md5.Sum(data)
+
+	return &UploadResult{
+		Location: s.location(path),
+		ETag:     fmt.Sprintf("%q", hex.EncodeToString(sum[:])),
+		Key:      key,
+	}, nil
+}
+
+func (s *localObjectStore) DownloadObject(ctx context.Context, keyOrURL string) ([]byte, error) {
+	path, err := s.resolve(keyOrURL)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, keyOrURL)
+		}
+
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+
+	return data, nil
+}
+
+func (s *localObjectStore) HeadObject(ctx context.Context, keyOrURL string) (*ObjectInfo, error) {
+	path, err := s.resolve(keyOrURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// The ETag is the MD5 of the content, as returned on upload, so it has to be computed from the file.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, keyOrURL)
+		}
+
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+
+	sum := md5.Sum(data)
+
+	return &ObjectInfo{
+		ETag:         fmt.Sprintf("%q", hex.EncodeToString(sum[:])),
+		Size:         int64(len(data)),
+		LastModified: stat.ModTime(),
+	}, nil
+}
+
+func (s *localObjectStore) DeleteObject(ctx context.Context, keyOrURL string) error {
+	path, err := s.resolve(keyOrURL)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrObjectNotFound, keyOrURL)
+		}
+
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+
+	return nil
+}
+
+func (s *localObjectStore) ObjectKey(keyOrURL string) (string, error) {
+	path, err := s.resolve(keyOrURL)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(s.baseDir, path)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.ToSlash(rel), nil
+}
+
+func (s *localObjectStore) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+
+	err := filepath.WalkDir(s.baseDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.baseDir, path)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list local objects: %w", err)
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// resolve maps an object key or a file:// location to a path inside the base directory.
+func (s *localObjectStore) resolve(keyOrURL string) (string, error) {
+	if !strings.HasPrefix(keyOrURL, "file://") {
+		return s.path(keyOrURL)
+	}
+
+	u, err := url.Parse(keyOrURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse file URL: %w", err)
+	}
+
+	path := filepath.Clean(filepath.FromSlash(u.Path))
+
+	rel, err := filepath.Rel(s.baseDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s", ErrObjectOutsideBaseDir, keyOrURL)
+	}
+
+	return path, nil
+}
+
+// path maps an object key to a path inside the base directory.
+func (s *localObjectStore) path(key string) (string, error) {
+	path := filepath.Join(s.baseDir, filepath.FromSlash(key))
+
+	rel, err := filepath.Rel(s.baseDir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %s", ErrObjectOutsideBaseDir, key)
+	}
+
+	return path, nil
+}
+
+func (s *localObjectStore) location(path string) string {
+	u := url.URL{
+		Scheme: "file",
+		Path:   filepath.ToSlash(path),
+	}
+
+	return u.String()
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryObjectStore is a concurrency-safe ObjectStore that keeps objects in memory.
+// Object locations are returned as mem:// URLs. It's meant to be used in tests.
+type MemoryObjectStore struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+type memoryObject struct {
+	data         []byte
+	contentType  string
+	etag         string
+	lastModified time.Time
+}
+
+func NewMemoryObjectStore() *MemoryObjectStore {
+	return &MemoryObjectStore{
+		objects: make(map[string]memoryObject),
+	}
+}
+
+func (s *MemoryObjectStore) UploadObject(ctx context.Context, key string, data []byte, opts *UploadOptions) (*UploadResult, error) {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+
+	sum := md5.Sum(data)
+	etag := fmt.Sprintf("%q", hex.EncodeToString(sum[:]))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.objects[key] = memoryObject{
+		data:         append([]byte(nil), data...),
+		contentType:  opts.ContentType,
+		etag:         etag,
+		lastModified: time.Now(),
+	}
+
+	return &UploadResult{
+		Location: "mem://" + key,
+		ETag:     etag,
+		Key:      key,
+	}, nil
+}
+
+func (s *MemoryObjectStore) DownloadObject(ctx context.Context, keyOrURL string) ([]byte, error) {
+	key := strings.TrimPrefix(keyOrURL, "mem://")
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, keyOrURL)
+	}
+
+	return append([]byte(nil), obj.data...), nil
+}
+
+func (s *MemoryObjectStore) HeadObject(ctx context.Context, keyOrURL string) (*ObjectInfo, error) {
+	key := strings.TrimPrefix(keyOrURL, "mem://")
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, keyOrURL)
+	}
+
+	return &ObjectInfo{
+		ETag:         obj.etag,
+		Size:         int64(len(obj.data)),
+		ContentType:  obj.contentType,
+		LastModified: obj.lastModified,
+	}, nil
+}
+
+func (s *MemoryObjectStore) DeleteObject(ctx context.Context, keyOrURL string) error {
+	key := strings.TrimPrefix(keyOrURL, "mem://")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.objects[key]; !ok {
+		return fmt.Errorf("%w: %s", ErrObjectNotFound, keyOrURL)
+	}
+
+	delete(s.objects, key)
+
+	return nil
+}
+
+func (s *MemoryObjectStore) ObjectKey(keyOrURL string) (string, error) {
+	return strings.TrimPrefix(keyOrURL, "mem://"), nil
+}
+
+func (s *MemoryObjectStore) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []string{}
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
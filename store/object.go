@@ -3,12 +3,14 @@ package store
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/url"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -18,45 +20,138 @@ import (
 	"github.com/gabrieleangeletti/vo2/util"
 )
 
+var (
+	ErrObjectNotFound                = errors.New("object not found")
+	ErrUnsupportedObjectStoreBackend = errors.New("unsupported object store backend")
+	ErrUnrecognizedS3URL             = errors.New("URL is not a recognized S3 URL format")
+)
+
+type ObjectStoreBackend string
+
+const (
+	ObjectStoreBackendS3    ObjectStoreBackend = "s3"
+	ObjectStoreBackendLocal ObjectStoreBackend = "local"
+)
+
 type ObjectStore interface {
-	UploadObject(ctx context.Context, key string, data []byte, opts *uploadOptions) (*uploadResult, error)
+	UploadObject(ctx context.Context, key string, data []byte, opts *UploadOptions) (*UploadResult, error)
 	DownloadObject(ctx context.Context, keyOrURL string) ([]byte, error)
 	ListObjects(ctx context.Context, prefix string) ([]string, error)
+	HeadObject(ctx context.Context, keyOrURL string) (*ObjectInfo, error)
+	DeleteObject(ctx context.Context, keyOrURL string) error
+	// ObjectKey maps an object key or a location returned by UploadObject to the object key.
+	ObjectKey(keyOrURL string) (string, error)
+}
+
+// ObjectPresigner is implemented by the object stores that can hand out temporary download URLs,
+// so that clients can download objects directly instead of through the API.
+type ObjectPresigner interface {
+	PresignObject(ctx context.Context, keyOrURL string, filename string, ttl time.Duration) (string, error)
 }
 
-type uploadOptions struct {
+// ObjectInfo describes a stored object without its content.
+type ObjectInfo struct {
+	ETag string
+	Size int64
+	// ContentType is empty when the object store doesn't keep it.
+	ContentType  string
+	LastModified time.Time
+}
+
+type UploadOptions struct {
 	ContentType          string
+	ContentEncoding      string
 	ACL                  types.ObjectCannedACL
 	Metadata             map[string]string
 	ServerSideEncryption *types.ServerSideEncryption
 }
 
-type uploadResult struct {
+type UploadResult struct {
 	Location string
 	ETag     string
 	Key      string
 }
 
+// newObjectStore creates the object store selected by the OBJECT_STORE_BACKEND setting.
+// It defaults to S3 when the setting is empty.
+func newObjectStore() (ObjectStore, error) {
+	backend := ObjectStoreBackend(util.GetSecret("OBJECT_STORE_BACKEND", false))
+
+	switch backend {
+	case "", ObjectStoreBackendS3:
+		return newS3ObjectStore()
+	case ObjectStoreBackendLocal:
+		return newLocalObjectStore()
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedObjectStoreBackend, backend)
+	}
+}
+
 type s3ObjectStore struct {
 	client     *s3.Client
 	bucketName string
+	// endpoint is the custom endpoint of an S3-compatible server (e.g. MinIO), nil when using AWS.
+	endpoint *url.URL
+	// presigner signs the download URLs handed out to clients, with the public endpoint when it's set.
+	presigner *s3.PresignClient
 }
 
+// newS3ObjectStore creates an S3 object store for the AWS_S3_BUCKET_NAME bucket.
+//
+// S3_ENDPOINT points the client to an S3-compatible server instead of AWS, and S3_FORCE_PATH_STYLE=true
+// switches to path-style addressing (http://<endpoint>/<bucket>/<key>), which most self-hosted servers need.
+// S3_PUBLIC_ENDPOINT is the URL clients reach the server at, when it differs from S3_ENDPOINT (e.g. the hostname of a
+// container on a Docker network). The presigned URLs are signed for it, since the host is part of the signature.
 func newS3ObjectStore() (*s3ObjectStore, error) {
 	cfg, err := config.LoadDefaultConfig(context.Background())
 	if err != nil {
 		return nil, err
 	}
 
-	return &s3ObjectStore{
-		client:     s3.NewFromConfig(cfg),
+	s := &s3ObjectStore{
 		bucketName: util.GetSecret("AWS_S3_BUCKET_NAME", true),
-	}, nil
+	}
+
+	endpoint := util.GetSecret("S3_ENDPOINT", false)
+	if endpoint != "" {
+		s.endpoint, err = url.Parse(endpoint)
+		if err != nil || s.endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid S3_ENDPOINT: %s", endpoint)
+		}
+	}
+
+	forcePathStyle := util.GetSecret("S3_FORCE_PATH_STYLE", false) == "true"
+
+	s.client = s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+
+		o.UsePathStyle = forcePathStyle
+	})
+
+	s.presigner = s3.NewPresignClient(s.client)
+
+	publicEndpoint := util.GetSecret("S3_PUBLIC_ENDPOINT", false)
+	if publicEndpoint != "" {
+		u, err := url.Parse(publicEndpoint)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid S3_PUBLIC_ENDPOINT: %s", publicEndpoint)
+		}
+
+		s.presigner = s3.NewPresignClient(s.client, func(o *s3.PresignOptions) {
+			o.ClientOptions = append(o.ClientOptions, func(o *s3.Options) {
+				o.BaseEndpoint = aws.String(publicEndpoint)
+			})
+		})
+	}
+
+	return s, nil
 }
 
-func (s *s3ObjectStore) UploadObject(ctx context.Context, key string, data []byte, opts *uploadOptions) (*uploadResult, error) {
+func (s *s3ObjectStore) UploadObject(ctx context.Context, key string, data []byte, opts *UploadOptions) (*UploadResult, error) {
 	if opts == nil {
-		opts = &uploadOptions{}
+		opts = &UploadOptions{}
 	}
 
 	if opts.ContentType == "" {
@@ -72,25 +167,9 @@ func (s *s3ObjectStore) UploadObject(ctx context.Context, key string, data []byt
 }
 
 func (s *s3ObjectStore) DownloadObject(ctx context.Context, keyOrURL string) ([]byte, error) {
-	var inputBucket, inputKey string
-
-	if strings.HasPrefix(keyOrURL, "https://") {
-		u, err := url.Parse(keyOrURL)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse S3 URL: %w", err)
-		}
-
-		// Assuming virtual-hosted-style URL: https://<bucket>.s3.amazonaws.com/<key>
-		if !strings.HasSuffix(u.Host, ".s3.amazonaws.com") {
-			return nil, fmt.Errorf("URL is not a recognized S3 URL format: %s", keyOrURL)
-		}
-
-		hostParts := strings.Split(u.Host, ".")
-		inputBucket = hostParts[0]
-		inputKey = strings.TrimPrefix(u.Path, "/")
-	} else {
-		inputBucket = s.bucketName
-		inputKey = keyOrURL
+	inputBucket, inputKey, err := s.bucketAndKey(keyOrURL)
+	if err != nil {
+		return nil, err
 	}
 
 	getObjectInput := &s3.GetObjectInput{
@@ -100,6 +179,11 @@ func (s *s3ObjectStore) DownloadObject(ctx context.Context, keyOrURL string) ([]
 
 	result, err := s.client.GetObject(ctx, getObjectInput)
 	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, keyOrURL)
+		}
+
 		return nil, fmt.Errorf("failed to download from S3: %w", err)
 	}
 	defer result.Body.Close()
@@ -112,6 +196,86 @@ func (s *s3ObjectStore) DownloadObject(ctx context.Context, keyOrURL string) ([]
 	return data, nil
 }
 
+func (s *s3ObjectStore) PresignObject(ctx context.Context, keyOrURL string, filename string, ttl time.Duration) (string, error) {
+	inputBucket, inputKey, err := s.bucketAndKey(keyOrURL)
+	if err != nil {
+		return "", err
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(inputBucket),
+		Key:    aws.String(inputKey),
+	}
+
+	if filename != "" {
+		input.ResponseContentDisposition = aws.String(fmt.Sprintf("attachment; filename=%q", filename))
+	}
+
+	req, err := s.presigner.PresignGetObject(ctx, input, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign S3 object: %w", err)
+	}
+
+	return req.URL, nil
+}
+
+func (s *s3ObjectStore) HeadObject(ctx context.Context, keyOrURL string) (*ObjectInfo, error) {
+	inputBucket, inputKey, err := s.bucketAndKey(keyOrURL)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(inputBucket),
+		Key:    aws.String(inputKey),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, keyOrURL)
+		}
+
+		return nil, fmt.Errorf("failed to head object from S3: %w", err)
+	}
+
+	return &ObjectInfo{
+		ETag:         aws.ToString(result.ETag),
+		Size:         aws.ToInt64(result.ContentLength),
+		ContentType:  aws.ToString(result.ContentType),
+		LastModified: aws.ToTime(result.LastModified),
+	}, nil
+}
+
+func (s *s3ObjectStore) DeleteObject(ctx context.Context, keyOrURL string) error {
+	inputBucket, inputKey, err := s.bucketAndKey(keyOrURL)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(inputBucket),
+		Key:    aws.String(inputKey),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete from S3: %w", err)
+	}
+
+	return nil
+}
+
+func (s *s3ObjectStore) ObjectKey(keyOrURL string) (string, error) {
+	inputBucket, inputKey, err := s.bucketAndKey(keyOrURL)
+	if err != nil {
+		return "", err
+	}
+
+	if inputBucket != s.bucketName {
+		return "", fmt.Errorf("object is in bucket %s, not %s: %s", inputBucket, s.bucketName, keyOrURL)
+	}
+
+	return inputKey, nil
+}
+
 func (s *s3ObjectStore) ListObjects(ctx context.Context, prefix string) ([]string, error) {
 	keys := []string{}
 	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
@@ -133,7 +297,51 @@ func (s *s3ObjectStore) ListObjects(ctx context.Context, prefix string) ([]strin
 	return keys, nil
 }
 
-func uploadReader(ctx context.Context, client *s3.Client, reader io.Reader, bucketName, key string, opts *uploadOptions) (*uploadResult, error) {
+// bucketAndKey maps an object key or an object URL to the bucket and key of the object.
+//
+// Supported URLs are s3://<bucket>/<key>, the legacy https://<bucket>.s3[.<region>].amazonaws.com/<key> locations,
+// and, when a custom endpoint is configured, both path-style and virtual-hosted-style URLs on that endpoint.
+func (s *s3ObjectStore) bucketAndKey(keyOrURL string) (string, string, error) {
+	if !strings.Contains(keyOrURL, "://") {
+		return s.bucketName, keyOrURL, nil
+	}
+
+	u, err := url.Parse(keyOrURL)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse S3 URL: %w", err)
+	}
+
+	path := strings.TrimPrefix(u.Path, "/")
+
+	switch {
+	case u.Scheme == "s3":
+		return u.Host, path, nil
+
+	case u.Scheme != "http" && u.Scheme != "https":
+		return "", "", fmt.Errorf("%w: %s", ErrUnrecognizedS3URL, keyOrURL)
+
+	case strings.HasSuffix(u.Hostname(), ".amazonaws.com") && strings.Contains(u.Hostname(), ".s3"):
+		bucket, _, _ := strings.Cut(u.Hostname(), ".s3")
+		return bucket, path, nil
+
+	case s.endpoint != nil && u.Host == s.endpoint.Host:
+		// Path-style URL: <endpoint>/<bucket>/<key>
+		bucket, key, ok := strings.Cut(path, "/")
+		if !ok {
+			return "", "", fmt.Errorf("%w: %s", ErrUnrecognizedS3URL, keyOrURL)
+		}
+
+		return bucket, key, nil
+
+	case s.endpoint != nil && strings.HasSuffix(u.Host, "."+s.endpoint.Host):
+		return strings.TrimSuffix(u.Host, "."+s.endpoint.Host), path, nil
+
+	default:
+		return "", "", fmt.Errorf("%w: %s", ErrUnrecognizedS3URL, keyOrURL)
+	}
+}
+
+func uploadReader(ctx context.Context, client *s3.Client, reader io.Reader, bucketName, key string, opts *UploadOptions) (*UploadResult, error) {
 	putObjectInput := &s3.PutObjectInput{
 		Bucket:      aws.String(bucketName),
 		Key:         aws.String(key),
@@ -141,6 +349,10 @@ func uploadReader(ctx context.Context, client *s3.Client, reader io.Reader, buck
 		ContentType: aws.String(opts.ContentType),
 	}
 
+	if opts.ContentEncoding != "" {
+		putObjectInput.ContentEncoding = aws.String(opts.ContentEncoding)
+	}
+
 	if opts.ACL != "" {
 		putObjectInput.ACL = opts.ACL
 	}
@@ -158,9 +370,11 @@ func uploadReader(ctx context.Context, client *s3.Client, reader io.Reader, buck
 		return nil, fmt.Errorf("failed to upload to S3: %w", err)
 	}
 
-	location := fmt.Sprintf("https://%s.s3.amazonaws.com/%s", bucketName, key)
+	// Locations are independent of the endpoint, so that they stay valid if the bucket is moved between
+	// AWS and an S3-compatible server.
+	location := fmt.Sprintf("s3://%s/%s", bucketName, key)
 
-	return &uploadResult{
+	return &UploadResult{
 		Location: location,
 		ETag:     aws.ToString(result.ETag),
 		Key:      key,
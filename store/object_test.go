@@ -0,0 +1,314 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gabrieleangeletti/stride"
+	"github.com/gabrieleangeletti/vo2/activity"
+)
+
+// testObjectStore checks the behaviour every ObjectStore shares.
+func testObjectStore(t *testing.T, obj ObjectStore) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	data := []byte("<gpx></gpx>")
+
+	res, err := obj.UploadObject(ctx, "activity_details/test/gpx/a.gpx", data, &UploadOptions{ContentType: "application/gpx+xml"})
+	if err != nil {
+		t.Fatalf("failed to upload object: %v", err)
+	}
+
+	if res.Key != "activity_details/test/gpx/a.gpx" {
+		t.Errorf("got key %q, want %q", res.Key, "activity_details/test/gpx/a.gpx")
+	}
+
+	// The object can be read back both by key and by location.
+	for _, keyOrURL := range []string{res.Key, res.Location} {
+		got, err := obj.DownloadObject(ctx, keyOrURL)
+		if err != nil {
+			t.Fatalf("failed to download %s: %v", keyOrURL, err)
+		}
+
+		if string(got) != string(data) {
+			t.Errorf("got %q from %s, want %q", got, keyOrURL, data)
+		}
+
+		key, err := obj.ObjectKey(keyOrURL)
+		if err != nil {
+			t.Fatalf("failed to get key of %s: %v", keyOrURL, err)
+		}
+
+		if key != res.Key {
+			t.Errorf("got key %q for %s, want %q", key, keyOrURL, res.Key)
+		}
+	}
+
+	info, err := obj.HeadObject(ctx, res.Location)
+	if err != nil {
+		t.Fatalf("failed to head object: %v", err)
+	}
+
+	if info.ETag != res.ETag || info.Size != int64(len(data)) {
+		t.Errorf("got ETag %s and size %d, want %s and %d", info.ETag, info.Size, res.ETag, len(data))
+	}
+
+	if info.LastModified.IsZero() {
+		t.Error("got no last modified time")
+	}
+
+	_, err = obj.UploadObject(ctx, "activity_details/test/fit/b.fit", []byte("fit"), nil)
+	if err != nil {
+		t.Fatalf("failed to upload object: %v", err)
+	}
+
+	keys, err := obj.ListObjects(ctx, "activity_details/test/")
+	if err != nil {
+		t.Fatalf("failed to list objects: %v", err)
+	}
+
+	if want := []string{"activity_details/test/fit/b.fit", "activity_details/test/gpx/a.gpx"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("got keys %v, want %v", keys, want)
+	}
+
+	keys, err = obj.ListObjects(ctx, "activity_details/other/")
+	if err != nil {
+		t.Fatalf("failed to list objects: %v", err)
+	}
+
+	if len(keys) != 0 {
+		t.Errorf("got keys %v for another prefix, want none", keys)
+	}
+
+	err = obj.DeleteObject(ctx, res.Location)
+	if err != nil {
+		t.Fatalf("failed to delete object: %v", err)
+	}
+
+	_, err = obj.DownloadObject(ctx, res.Location)
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("got download error %v after delete, want %v", err, ErrObjectNotFound)
+	}
+
+	_, err = obj.HeadObject(ctx, res.Key)
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("got head error %v after delete, want %v", err, ErrObjectNotFound)
+	}
+
+	err = obj.DeleteObject(ctx, res.Key)
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("got delete error %v after delete, want %v", err, ErrObjectNotFound)
+	}
+}
+
+func TestMemoryObjectStore(t *testing.T) {
+	testObjectStore(t, NewMemoryObjectStore())
+}
+
+func TestLocalObjectStore(t *testing.T) {
+	t.Setenv("OBJECT_STORE_LOCAL_DIR", t.TempDir())
+
+	obj, err := newLocalObjectStore()
+	if err != nil {
+		t.Fatalf("failed to create local object store: %v", err)
+	}
+
+	testObjectStore(t, obj)
+
+	_, err = obj.DownloadObject(context.Background(), "../outside")
+	if !errors.Is(err, ErrObjectOutsideBaseDir) {
+		t.Errorf("got error %v for a key outside the directory, want %v", err, ErrObjectOutsideBaseDir)
+	}
+}
+
+func TestMemoryObjectStoreCopiesData(t *testing.T) {
+	ctx := context.Background()
+
+	obj := NewMemoryObjectStore()
+
+	data := []byte("abc")
+
+	_, err := obj.UploadObject(ctx, "a", data, nil)
+	if err != nil {
+		t.Fatalf("failed to upload object: %v", err)
+	}
+
+	data[0] = 'x'
+
+	got, err := obj.DownloadObject(ctx, "a")
+	if err != nil {
+		t.Fatalf("failed to download object: %v", err)
+	}
+
+	got[1] = 'x'
+
+	again, err := obj.DownloadObject(ctx, "a")
+	if err != nil {
+		t.Fatalf("failed to download object: %v", err)
+	}
+
+	if string(again) != "abc" {
+		t.Errorf("got %q, want %q: the stored object was modified", again, "abc")
+	}
+}
+
+func TestMemoryObjectStoreConcurrency(t *testing.T) {
+	ctx := context.Background()
+
+	obj := NewMemoryObjectStore()
+
+	var wg sync.WaitGroup
+
+	for i := range 20 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			key := fmt.Sprintf("objects/%d", i)
+
+			if _, err := obj.UploadObject(ctx, key, []byte(key), nil); err != nil {
+				t.Errorf("failed to upload %s: %v", key, err)
+				return
+			}
+
+			if _, err := obj.ListObjects(ctx, "objects/"); err != nil {
+				t.Errorf("failed to list objects: %v", err)
+			}
+
+			got, err := obj.DownloadObject(ctx, key)
+			if err != nil {
+				t.Errorf("failed to download %s: %v", key, err)
+				return
+			}
+
+			if string(got) != key {
+				t.Errorf("got %q, want %q", got, key)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	keys, err := obj.ListObjects(ctx, "objects/")
+	if err != nil {
+		t.Fatalf("failed to list objects: %v", err)
+	}
+
+	if len(keys) != 20 {
+		t.Errorf("got %d objects, want %d", len(keys), 20)
+	}
+}
+
+// TestGetActivityTimeseriesFromObjectStore reads the timeseries of an activity from the object store the store was
+// created with, without a database.
+func TestGetActivityTimeseriesFromObjectStore(t *testing.T) {
+	ctx := context.Background()
+
+	obj := NewMemoryObjectStore()
+
+	s, err := newStore(nil, WithObjectStore(obj))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	strideActivity, ts := testActivity()
+
+	encoded, err := activity.EncodeTimeseries(ts)
+	if err != nil {
+		t.Fatalf("failed to encode timeseries: %v", err)
+	}
+
+	tsRes, err := obj.UploadObject(ctx, "activity_details/upload/timeseries/a.vo2ts", encoded, nil)
+	if err != nil {
+		t.Fatalf("failed to upload timeseries: %v", err)
+	}
+
+	gpxData, err := stride.CreateGPXFileInMemory(strideActivity, ts)
+	if err != nil {
+		t.Fatalf("failed to create GPX file: %v", err)
+	}
+
+	gpxRes, err := obj.UploadObject(ctx, "activity_details/upload/gpx/a.gpx", gpxData, nil)
+	if err != nil {
+		t.Fatalf("failed to upload GPX file: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		act  *activity.EnduranceActivity
+	}{
+		{name: "timeseries", act: &activity.EnduranceActivity{TimeseriesURI: tsRes.Location, GpxFileURI: gpxRes.Location}},
+		// The activities stored before the timeseries were kept have a GPX file only.
+		{name: "gpx", act: &activity.EnduranceActivity{GpxFileURI: gpxRes.Location}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := s.GetActivityTimeseries(ctx, tc.act)
+			if err != nil {
+				t.Fatalf("failed to get timeseries: %v", err)
+			}
+
+			assertSameTimeseries(t, ts, got)
+		})
+	}
+
+	_, err = s.GetActivityTimeseries(ctx, &activity.EnduranceActivity{})
+	if !errors.Is(err, activity.ErrNoGPXFile) {
+		t.Errorf("got error %v for an activity without files, want %v", err, activity.ErrNoGPXFile)
+	}
+
+	_, err = s.GetActivityTimeseries(ctx, &activity.EnduranceActivity{TimeseriesURI: "mem://missing"})
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("got error %v for a missing timeseries, want %v", err, ErrObjectNotFound)
+	}
+}
+
+func TestS3PresignObjectEndpoint(t *testing.T) {
+	tests := []struct {
+		name           string
+		publicEndpoint string
+		wantHost       string
+	}{
+		{"endpoint", "", "minio:9000"},
+		{"public endpoint", "http://localhost:9000", "localhost:9000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_ACCESS_KEY_ID", "minioadmin")
+			t.Setenv("AWS_SECRET_ACCESS_KEY", "minioadmin")
+			t.Setenv("AWS_REGION", "us-east-1")
+			t.Setenv("AWS_S3_BUCKET_NAME", "vo2")
+			t.Setenv("S3_ENDPOINT", "http://minio:9000")
+			t.Setenv("S3_FORCE_PATH_STYLE", "true")
+			t.Setenv("S3_PUBLIC_ENDPOINT", tt.publicEndpoint)
+
+			obj, err := newS3ObjectStore()
+			if err != nil {
+				t.Fatalf("failed to create S3 object store: %v", err)
+			}
+
+			presigned, err := obj.PresignObject(context.Background(), "activity_details/a.fit", "a.fit", time.Minute)
+			if err != nil {
+				t.Fatalf("failed to presign object: %v", err)
+			}
+
+			u, err := url.Parse(presigned)
+			if err != nil {
+				t.Fatalf("failed to parse presigned URL: %v", err)
+			}
+
+			if u.Host != tt.wantHost || u.Path != "/vo2/activity_details/a.fit" {
+				t.Errorf("got presigned URL %s, want host %s and path /vo2/activity_details/a.fit", presigned, tt.wantHost)
+			}
+		})
+	}
+}
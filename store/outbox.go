@@ -0,0 +1,169 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/gabrieleangeletti/vo2/internal/generated/models"
+)
+
+// objectOrphanGracePeriod is how long a new object is never reported as an orphan, since it may belong to an ingestion
+// in progress whose row isn't committed yet.
+const objectOrphanGracePeriod = time.Hour
+
+// OutboxReconcileResult is the outcome of a ReconcileObjectOutbox run.
+type OutboxReconcileResult struct {
+	// Committed are the keys of the uploads whose object is referenced by a row. Their entries are deleted.
+	Committed []string
+	// RolledBack are the keys of the uploads whose object was deleted because no row references it.
+	RolledBack []string
+}
+
+// objectUpload is an object uploaded with its entry in the object outbox.
+type objectUpload struct {
+	*UploadResult
+	// OutboxID is the ID of the outbox entry to commit with the rows referencing the object.
+	OutboxID uuid.UUID
+}
+
+// uploadObject uploads an object, recording it as pending in the object outbox first.
+// The outbox entry must be committed in the same transaction as the rows referencing the object, otherwise the
+// reconciler eventually rolls it back, so a crash between the upload and the database writes never leaves
+// unreferenced objects behind.
+func (s *store) uploadObject(ctx context.Context, key string, data []byte, opts *UploadOptions) (*objectUpload, error) {
+	outboxID, err := s.q.CreateObjectOutboxEntry(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record object upload: %w", err)
+	}
+
+	res, err := s.obj.UploadObject(ctx, key, data, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &objectUpload{
+		UploadResult: res,
+		OutboxID:     outboxID,
+	}, nil
+}
+
+// commitObjectUploads commits the uploads, deleting their outbox entries. Only the entries of these uploads are
+// committed, not the ones of other uploads of the same key still in progress.
+// It's meant to be called with the transaction that writes the rows referencing the objects.
+func (s *store) commitObjectUploads(ctx context.Context, q *models.Queries, uploads ...*objectUpload) error {
+	ids := make([]uuid.UUID, 0, len(uploads))
+	for _, upload := range uploads {
+		if upload == nil {
+			continue
+		}
+
+		ids = append(ids, upload.OutboxID)
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return q.CommitObjectOutboxEntries(ctx, ids)
+}
+
+// ReconcileObjectOutbox finalizes the uploads that are still pending after olderThan.
+//
+// Uploads whose object is referenced by a row are committed, deleting their entry, the others are rolled back by
+// deleting the object.
+func (s *store) ReconcileObjectOutbox(ctx context.Context, olderThan time.Duration) (*OutboxReconcileResult, error) {
+	entries, err := s.q.ListPendingObjectOutboxEntries(ctx, time.Now().UTC().Add(-olderThan))
+	if err != nil {
+		return nil, err
+	}
+
+	res := &OutboxReconcileResult{
+		Committed:  []string{},
+		RolledBack: []string{},
+	}
+
+	if len(entries) == 0 {
+		return res, nil
+	}
+
+	referenced, err := s.referencedObjectKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var committedIDs, rolledBackIDs []uuid.UUID
+
+	for _, entry := range entries {
+		if referenced[entry.ObjectKey] {
+			committedIDs = append(committedIDs, entry.ID)
+			res.Committed = append(res.Committed, entry.ObjectKey)
+			continue
+		}
+
+		err := s.obj.DeleteObject(ctx, entry.ObjectKey)
+		if err != nil && !errors.Is(err, ErrObjectNotFound) {
+			return nil, fmt.Errorf("failed to delete object %s: %w", entry.ObjectKey, err)
+		}
+
+		rolledBackIDs = append(rolledBackIDs, entry.ID)
+		res.RolledBack = append(res.RolledBack, entry.ObjectKey)
+	}
+
+	if len(committedIDs) > 0 {
+		err = s.q.CommitObjectOutboxEntries(ctx, committedIDs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(rolledBackIDs) > 0 {
+		err = s.q.SetObjectOutboxStatus(ctx, models.SetObjectOutboxStatusParams{
+			Status: models.Vo2ObjectOutboxStatusRolledBack,
+			Ids:    rolledBackIDs,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// pendingObjectKeys returns the keys of the uploads that are not committed yet.
+func (s *store) pendingObjectKeys(ctx context.Context) (map[string]bool, error) {
+	entries, err := s.q.ListPendingObjectOutboxEntries(ctx, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		keys[entry.ObjectKey] = true
+	}
+
+	return keys, nil
+}
+
+// referencedObjectKeys returns the keys of the objects referenced by a row.
+func (s *store) referencedObjectKeys(ctx context.Context) (map[string]bool, error) {
+	uris, err := s.q.ListActivityObjectURIs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]bool, len(uris))
+	for _, uri := range uris {
+		key, err := s.obj.ObjectKey(uri)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve object URI %s: %w", uri, err)
+		}
+
+		keys[key] = true
+	}
+
+	return keys, nil
+}
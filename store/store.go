@@ -3,10 +3,13 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
@@ -14,6 +17,7 @@ import (
 	"github.com/gabrieleangeletti/stride"
 	"github.com/gabrieleangeletti/vo2"
 	"github.com/gabrieleangeletti/vo2/activity"
+	"github.com/gabrieleangeletti/vo2/ingest"
 	"github.com/gabrieleangeletti/vo2/internal/generated/models"
 )
 
@@ -21,9 +25,11 @@ import (
 // It's implemented by Store.
 type Reader interface {
 	GetProviderActivityRaw(ctx context.Context, id uuid.UUID) (*activity.ProviderActivityRawData, error)
+	GetProviderActivityRawByProviderActivityID(ctx context.Context, providerID int, athleteID uuid.UUID, providerActivityID string) (*activity.ProviderActivityRawData, error)
 	GetActivityEndurance(ctx context.Context, id uuid.UUID) (*activity.EnduranceActivity, error)
 	GetActivityTimeseries(ctx context.Context, act *activity.EnduranceActivity) (*stride.ActivityTimeseries, error)
 	GetActivityRawTimeseries(ctx context.Context, activityRaw *activity.ProviderActivityRawData, ts stride.ActivityTimeseriesConvertible) error
+	GetActivityFile(ctx context.Context, act *activity.EnduranceActivity, kind ActivityFileKind, ttl time.Duration) (*ActivityFile, error)
 	ListAthleteActivitiesEndurance(ctx context.Context, providerID int, athleteID uuid.UUID) ([]*activity.EnduranceActivity, error)
 	ListAthleteActivitiesEnduranceByIDs(ctx context.Context, ids []uuid.UUID) ([]*activity.EnduranceActivity, error)
 	ListActivitiesEnduranceByTag(ctx context.Context, providerID int, athleteID uuid.UUID, tag string) ([]*activity.EnduranceActivity, error)
@@ -40,48 +46,104 @@ type Reader interface {
 type Store interface {
 	Reader
 	UpsertAthlete(ctx context.Context, arg *vo2.Athlete) (*vo2.Athlete, error)
+	UpdateAthleteProfile(ctx context.Context, athleteID uuid.UUID, profile *vo2.AthleteProfile) (*vo2.Athlete, error)
+	UpsertAthleteMeasurements(ctx context.Context, measurements []*vo2.AthleteMeasurement) error
 	UpsertActivityEndurance(ctx context.Context, arg *activity.EnduranceActivity) (*activity.EnduranceActivity, error)
 	UploadRawActivityDetails(ctx context.Context, provider stride.Provider, activityRaw *activity.ProviderActivityRawData, ts stride.ActivityTimeseriesConvertible) error
-	UploadActivityGPX(ctx context.Context, act *activity.EnduranceActivity, strideActivity *stride.Activity, timeseries *stride.ActivityTimeseries) (string, error)
+	UploadRawActivityFile(ctx context.Context, provider stride.Provider, activityRaw *activity.ProviderActivityRawData, format ingest.FileFormat, data []byte) error
 	StoreActivityEndurance(ctx context.Context, provider stride.Provider, activityRaw *activity.ProviderActivityRawData, rawAct stride.ActivityConvertible, ts stride.ActivityTimeseriesConvertible) (*activity.EnduranceActivity, error)
 	UpsertActivityThresholdAnalysis(ctx context.Context, arg *activity.ThresholdAnalysis) (*activity.ThresholdAnalysis, error)
 	UpsertTagsAndLinkActivity(ctx context.Context, a *activity.EnduranceActivity, tags []*activity.ActivityTag) error
 	SaveProviderActivityRawData(ctx context.Context, arg *activity.ProviderActivityRawData) (uuid.UUID, error)
+	DeleteProviderActivity(ctx context.Context, providerID int, athleteID uuid.UUID, providerActivityID string) error
+	MatchAthleteActivityDuplicates(ctx context.Context, athleteID uuid.UUID) (int, error)
+	DeleteAthleteProviderData(ctx context.Context, athleteID uuid.UUID, providerID int) (*ErasureResult, error)
+	DeleteAthlete(ctx context.Context, athleteID uuid.UUID) (*ErasureResult, error)
+	RecompressRawActivityDetails(ctx context.Context, key string, dryRun bool) (*RecompressResult, error)
+	ReconcileObjectOutbox(ctx context.Context, olderThan time.Duration) (*OutboxReconcileResult, error)
+	CheckObjectReferences(ctx context.Context, prefix string) (*ObjectReferenceReport, error)
+	ListObjects(ctx context.Context, prefix string) ([]string, error)
+	DeleteObject(ctx context.Context, key string) error
+}
+
+// RecompressResult is the outcome of recompressing a raw activity details object.
+type RecompressResult struct {
+	// Recompressed is false when the object was already compressed.
+	Recompressed bool
+	SizeBefore   int64
+	SizeAfter    int64
+}
+
+// ObjectReferenceReport is the result of comparing the objects in the object store with the URIs stored in the database.
+type ObjectReferenceReport struct {
+	// Orphans are the keys of the objects that no row references.
+	Orphans []string
+	// Dangling are the URIs referenced by a row whose object doesn't exist.
+	Dangling []string
 }
 
 // store provides the implementation of the Store interface.
 // It uses a pgx connection pool and sqlc-generated queries.
 type store struct {
-	db  *sqlx.DB
-	obj ObjectStore
-	q   *models.Queries
+	db    *sqlx.DB
+	obj   ObjectStore
+	cache *ObjectCache
+	q     *models.Queries
+
+	duplicatePriority []string
 }
 
-func NewReader(db *sqlx.DB) (Reader, error) {
-	obj, err := newS3ObjectStore()
-	if err != nil {
-		return nil, err
-	}
+// Option configures a store created with NewStore or NewReader.
+type Option func(*store)
 
-	return &store{
-		db:  db,
-		obj: obj,
-		q:   models.New(db),
-	}, nil
+// NewReader creates a new read-only store instance.
+func NewReader(db *sqlx.DB, options ...Option) (Reader, error) {
+	return newStore(db, options...)
 }
 
 // NewStore creates a new store instance.
-func NewStore(db *sqlx.DB) (Store, error) {
-	obj, err := newS3ObjectStore()
-	if err != nil {
-		return nil, err
+func NewStore(db *sqlx.DB, options ...Option) (Store, error) {
+	return newStore(db, options...)
+}
+
+// WithObjectStore makes the store use the given object store instead of the configured backend.
+func WithObjectStore(obj ObjectStore) Option {
+	return func(s *store) {
+		s.obj = obj
+	}
+}
+
+// WithObjectCache makes object downloads go through the given read-through cache.
+func WithObjectCache(cache *ObjectCache) Option {
+	return func(s *store) {
+		s.cache = cache
+	}
+}
+
+func newStore(db *sqlx.DB, options ...Option) (*store, error) {
+	s := &store{
+		db:                db,
+		q:                 models.New(db),
+		duplicatePriority: duplicateProviderPriority(),
+	}
+
+	for _, opt := range options {
+		opt(s)
 	}
 
-	return &store{
-		db:  db,
-		obj: obj,
-		q:   models.New(db),
-	}, nil
+	if s.obj == nil {
+		obj, err := newObjectStore()
+		if err != nil {
+			return nil, err
+		}
+		s.obj = obj
+	}
+
+	if s.cache != nil {
+		s.obj = s.cache.Wrap(s.obj)
+	}
+
+	return s, nil
 }
 
 func (s *store) UpsertAthlete(ctx context.Context, arg *vo2.Athlete) (*vo2.Athlete, error) {
@@ -93,6 +155,16 @@ func (s *store) UpsertAthlete(ctx context.Context, arg *vo2.Athlete) (*vo2.Athle
 	return newAthlete(res), nil
 }
 
+// UpdateAthleteProfile sets the given fields of the athlete profile, the empty ones are left as they are.
+func (s *store) UpdateAthleteProfile(ctx context.Context, athleteID uuid.UUID, profile *vo2.AthleteProfile) (*vo2.Athlete, error) {
+	res, err := s.q.UpdateAthleteProfile(ctx, profile.ToUpdateParams(athleteID))
+	if err != nil {
+		return nil, err
+	}
+
+	return newAthlete(res), nil
+}
+
 func (s *store) GetAthlete(ctx context.Context, athleteID uuid.UUID) (*vo2.Athlete, error) {
 	res, err := s.q.GetAthleteByID(ctx, athleteID)
 	if err != nil {
@@ -128,6 +200,27 @@ func (s *store) GetAthleteCurrentMeasurements(ctx context.Context, athleteID uui
 	return newAthleteCurrentMeasurements(res), nil
 }
 
+// UpsertAthleteMeasurements adds entries to the athlete measurement history, in a single transaction.
+// Entries measured at the same time as an existing entry of the same type replace it.
+func (s *store) UpsertAthleteMeasurements(ctx context.Context, measurements []*vo2.AthleteMeasurement) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	q := s.q.WithTx(tx.Tx)
+
+	for _, m := range measurements {
+		err = q.UpsertAthleteMeasurement(ctx, m.ToUpsertParams())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 func (s *store) UpsertActivityThresholdAnalysis(ctx context.Context, arg *activity.ThresholdAnalysis) (*activity.ThresholdAnalysis, error) {
 	res, err := s.q.UpsertActivityThresholdAnalysis(ctx, arg.ToUpsertParams())
 	if err != nil {
@@ -156,7 +249,28 @@ func (s *store) UploadRawActivityDetails(ctx context.Context, provider stride.Pr
 
 	objectKey := fmt.Sprintf("activity_details/%s/raw/%s.json", provider, activityRaw.ID)
 
-	res, err := s.obj.UploadObject(ctx, objectKey, streamData, nil)
+	return s.saveRawActivityDetails(ctx, activityRaw, objectKey, streamData, "application/json")
+}
+
+// UploadRawActivityFile uploads the original file of an uploaded activity (GPX, FIT or TCX) to the object storage,
+// as the raw details of the activity.
+func (s *store) UploadRawActivityFile(ctx context.Context, provider stride.Provider, activityRaw *activity.ProviderActivityRawData, format ingest.FileFormat, data []byte) error {
+	objectKey := fmt.Sprintf("activity_details/%s/raw/%s.%s", provider, activityRaw.ID, format)
+
+	return s.saveRawActivityDetails(ctx, activityRaw, objectKey, data, activityFileFormatContentTypes[format])
+}
+
+// saveRawActivityDetails uploads the raw details compressed and saves the raw data row referencing them.
+func (s *store) saveRawActivityDetails(ctx context.Context, activityRaw *activity.ProviderActivityRawData, objectKey string, data []byte, contentType string) error {
+	compressed, err := gzipObject(data)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.uploadObject(ctx, objectKey, compressed, &UploadOptions{
+		ContentType:     contentType,
+		ContentEncoding: contentEncodingGzip,
+	})
 	if err != nil {
 		return err
 	}
@@ -166,39 +280,80 @@ func (s *store) UploadRawActivityDetails(ctx context.Context, provider stride.Pr
 		Valid:  true,
 	}
 
-	err = activityRaw.Save(ctx, s.db)
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	err = activityRaw.Save(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	err = s.commitObjectUploads(ctx, s.q.WithTx(tx.Tx), res)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-// UploadActivityGPX generates a GPX file for an endurance activity, uploads it to the object storage, and returns its URL.
-func (s *store) UploadActivityGPX(ctx context.Context, act *activity.EnduranceActivity, strideActivity *stride.Activity, timeseries *stride.ActivityTimeseries) (string, error) {
+// uploadActivityGPX generates a GPX file for an endurance activity and uploads it to the object storage.
+func (s *store) uploadActivityGPX(ctx context.Context, act *activity.EnduranceActivity, strideActivity *stride.Activity, timeseries *stride.ActivityTimeseries) (*objectUpload, error) {
 	gpxData, err := stride.CreateGPXFileInMemory(strideActivity, timeseries)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	objectKey := fmt.Sprintf("activity_details/%s/gpx/%s.gpx", strideActivity.Provider, act.ID)
 
-	res, err := s.obj.UploadObject(ctx, objectKey, gpxData, nil)
+	return s.uploadObject(ctx, objectKey, gpxData, nil)
+}
+
+// uploadActivityFIT generates a FIT activity file for an endurance activity and uploads it to the object storage.
+func (s *store) uploadActivityFIT(ctx context.Context, act *activity.EnduranceActivity, strideActivity *stride.Activity, timeseries *stride.ActivityTimeseries) (*objectUpload, error) {
+	sport := act.Sport
+	// FIT has no dedicated gravel sport, so gravel rides are encoded as regular rides.
+	if sport == stride.SportGravelCycling {
+		sport = stride.SportCycling
+	}
+
+	fitData, err := stride.CreateFITFileInMemory(strideActivity, timeseries, sport)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return res.Location, nil
+	objectKey := fmt.Sprintf("activity_details/%s/fit/%s.fit", strideActivity.Provider, act.ID)
+
+	return s.uploadObject(ctx, objectKey, fitData, nil)
+}
+
+// uploadActivityTimeseries encodes an activity timeseries in the compact vo2 format and uploads it to the object storage.
+func (s *store) uploadActivityTimeseries(ctx context.Context, act *activity.EnduranceActivity, strideActivity *stride.Activity, timeseries *stride.ActivityTimeseries) (*objectUpload, error) {
+	data, err := activity.EncodeTimeseries(timeseries)
+	if err != nil {
+		return nil, err
+	}
+
+	objectKey := fmt.Sprintf("activity_details/%s/timeseries/%s.vo2ts", strideActivity.Provider, act.ID)
+
+	return s.uploadObject(ctx, objectKey, data, &UploadOptions{ContentType: "application/octet-stream"})
 }
 
 // StoreActivityEndurance is a higher-level function that does the e2e storing of an endurance activity.
 //
 // * Converts the raw provider activity into the standardized format.
-// * Generates and uploads the activity's GPX file.
 // * Calculates the activity's HR metrics.
+// * Generates and uploads the activity's GPX, FIT and compact timeseries files.
 // * Upserts the activity.
 func (s *store) StoreActivityEndurance(ctx context.Context, provider stride.Provider, activityRaw *activity.ProviderActivityRawData, rawAct stride.ActivityConvertible, ts stride.ActivityTimeseriesConvertible) (*activity.EnduranceActivity, error) {
-	act, err := activityRaw.ToEnduranceActivity(provider)
+	p, err := ingest.Get(string(provider))
+	if err != nil {
+		return nil, err
+	}
+
+	act, err := p.ToEnduranceActivity(activityRaw)
 	if err != nil {
 		if !(errors.Is(err, stride.ErrActivityIsNotEndurance) || errors.Is(err, stride.ErrUnsupportedSportType)) {
 			return nil, err
@@ -238,22 +393,92 @@ func (s *store) StoreActivityEndurance(ctx context.Context, provider stride.Prov
 		return nil, err
 	}
 
+	var uploads []*objectUpload
+
 	if len(timeseries.Data) > 0 {
-		gpxFileURI, err := s.UploadActivityGPX(ctx, act, strideActivity, timeseries)
+		hrMetrics, err := timeseries.HRMetrics()
+		if err != nil {
+			return nil, err
+		}
+		act.AvgHR = hrMetrics.AvgHR
+		act.MaxHR = hrMetrics.MaxHR
+
+		if hrMetrics.AvgHR > 0 {
+			strideActivity.AvgHR = stride.Optional[uint8]{Value: uint8(hrMetrics.AvgHR), Valid: true}
+		}
+
+		if hrMetrics.MaxHR > 0 {
+			strideActivity.MaxHR = stride.Optional[uint8]{Value: uint8(hrMetrics.MaxHR), Valid: true}
+		}
+
+		gpxFile, err := s.uploadActivityGPX(ctx, act, strideActivity, timeseries)
 		if err != nil {
 			return nil, err
 		}
-		act.GpxFileURI = gpxFileURI
+		act.GpxFileURI = gpxFile.Location
+		uploads = append(uploads, gpxFile)
 
-		hrMetrics, err := timeseries.HRMetrics()
+		fitFile, err := s.uploadActivityFIT(ctx, act, strideActivity, timeseries)
 		if err != nil {
 			return nil, err
 		}
-		act.AvgHR = hrMetrics.AvgHR
-		act.MaxHR = hrMetrics.MaxHR
+		act.FitFileURI = fitFile.Location
+		uploads = append(uploads, fitFile)
+
+		timeseriesFile, err := s.uploadActivityTimeseries(ctx, act, strideActivity, timeseries)
+		if err != nil {
+			return nil, err
+		}
+		act.TimeseriesURI = timeseriesFile.Location
+		uploads = append(uploads, timeseriesFile)
 	}
 
-	act, err = s.UpsertActivityEndurance(ctx, act)
+	analysis, err := s.analyzeThresholds(ctx, act, timeseries)
+	if err != nil {
+		return nil, err
+	}
+
+	// The activity row, its tags and its threshold analysis are committed together with the uploaded objects.
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	q := s.q.WithTx(tx.Tx)
+
+	res, err := q.UpsertActivityEndurance(ctx, act.ToUpsertParams())
+	if err != nil {
+		return nil, err
+	}
+
+	act = activity.NewEnduranceActivity(res)
+
+	err = upsertTagsAndLinkActivity(ctx, tx, act, act.ExtractActivityTags())
+	if err != nil {
+		return nil, err
+	}
+
+	if analysis != nil {
+		analysis.ActivityEnduranceID = act.ID
+
+		_, err = q.UpsertActivityThresholdAnalysis(ctx, analysis.ToUpsertParams())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.matchActivityDuplicates(ctx, tx, act.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.commitObjectUploads(ctx, q, uploads...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		return nil, err
 	}
@@ -261,6 +486,24 @@ func (s *store) StoreActivityEndurance(ctx context.Context, provider stride.Prov
 	return act, nil
 }
 
+// analyzeThresholds computes the threshold analysis of an activity, if the athlete has LT1 and LT2 measurements.
+func (s *store) analyzeThresholds(ctx context.Context, act *activity.EnduranceActivity, timeseries *stride.ActivityTimeseries) (*activity.ThresholdAnalysis, error) {
+	measurements, err := s.GetAthleteCurrentMeasurements(ctx, act.AthleteID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if measurements.Lt1Value <= 0 || measurements.Lt2Value <= 0 {
+		return nil, nil
+	}
+
+	return activity.AnalyzeThresholds(act, timeseries, uint8(measurements.Lt1Value), uint8(measurements.Lt2Value))
+}
+
 func (s *store) GetProviderActivityRaw(ctx context.Context, id uuid.UUID) (*activity.ProviderActivityRawData, error) {
 	res, err := s.q.GetProviderActivityRaw(ctx, id)
 	if err != nil {
@@ -270,6 +513,20 @@ func (s *store) GetProviderActivityRaw(ctx context.Context, id uuid.UUID) (*acti
 	return activity.NewProviderActivityRawData(res), nil
 }
 
+// GetProviderActivityRawByProviderActivityID retrieves the raw data of an activity by its ID in the provider.
+func (s *store) GetProviderActivityRawByProviderActivityID(ctx context.Context, providerID int, athleteID uuid.UUID, providerActivityID string) (*activity.ProviderActivityRawData, error) {
+	res, err := s.q.GetProviderActivityRawByProviderActivityID(ctx, models.GetProviderActivityRawByProviderActivityIDParams{
+		ProviderID:         int32(providerID),
+		AthleteID:          athleteID,
+		ProviderActivityID: providerActivityID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return activity.NewProviderActivityRawData(res), nil
+}
+
 // GetActivityEndurance retrieves an endurance activity by its ID.
 func (s *store) GetActivityEndurance(ctx context.Context, id uuid.UUID) (*activity.EnduranceActivity, error) {
 	res, err := s.q.GetActivityEndurance(ctx, id)
@@ -280,7 +537,28 @@ func (s *store) GetActivityEndurance(ctx context.Context, id uuid.UUID) (*activi
 	return activity.NewEnduranceActivity(res), nil
 }
 
+// GetActivityTimeseries retrieves an activity's timeseries.
+//
+// It reads the compact timeseries file when available, and falls back to parsing the GPX file for older activities.
 func (s *store) GetActivityTimeseries(ctx context.Context, act *activity.EnduranceActivity) (*stride.ActivityTimeseries, error) {
+	if act.TimeseriesURI != "" {
+		data, err := s.obj.DownloadObject(ctx, act.TimeseriesURI)
+		if err != nil {
+			return nil, err
+		}
+
+		ts, err := activity.DecodeTimeseries(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(ts.Data) == 0 {
+			return nil, stride.ErrNoTrackPoints
+		}
+
+		return ts, nil
+	}
+
 	if act.GpxFileURI == "" {
 		return nil, activity.ErrNoGPXFile
 	}
@@ -300,7 +578,8 @@ func (s *store) GetActivityTimeseries(ctx context.Context, act *activity.Enduran
 
 // GetActivityRawTimeseries retrieves an activity's raw timeseries data.
 //
-// The data is unmarshalled into the provided timeseries object, which must be a pointer.
+// Raw details are stored gzip compressed, older plain objects are read as they are. The data is unmarshalled into the provided timeseries object, which must be a pointer.
+// Timeseries implementing encoding.BinaryUnmarshaler (e.g. uploaded activity files) decode the data themselves.
 func (s *store) GetActivityRawTimeseries(ctx context.Context, activityRaw *activity.ProviderActivityRawData, ts stride.ActivityTimeseriesConvertible) error {
 	if !activityRaw.DetailedActivityURI.Valid {
 		return nil
@@ -311,6 +590,15 @@ func (s *store) GetActivityRawTimeseries(ctx context.Context, activityRaw *activ
 		return err
 	}
 
+	data, err = gunzipObject(data)
+	if err != nil {
+		return err
+	}
+
+	if u, ok := ts.(encoding.BinaryUnmarshaler); ok {
+		return u.UnmarshalBinary(data)
+	}
+
 	err = json.Unmarshal(data, ts)
 	if err != nil {
 		return err
@@ -385,6 +673,10 @@ func (s *store) GetActivityTags(ctx context.Context, activityID uuid.UUID) ([]*a
 }
 
 func (s *store) UpsertTagsAndLinkActivity(ctx context.Context, a *activity.EnduranceActivity, tags []*activity.ActivityTag) error {
+	return upsertTagsAndLinkActivity(ctx, s.db, a, tags)
+}
+
+func upsertTagsAndLinkActivity(ctx context.Context, db sqlx.ExecerContext, a *activity.EnduranceActivity, tags []*activity.ActivityTag) error {
 	if len(tags) == 0 {
 		return nil
 	}
@@ -408,9 +700,10 @@ func (s *store) UpsertTagsAndLinkActivity(ctx context.Context, a *activity.Endur
 	INSERT INTO vo2.activities_endurance_tags (activity_id, tag_id)
 	SELECT $3, ut.id
 	FROM upserted_tags ut
-	ON CONFLICT DO NOTHING`
+	ON CONFLICT (activity_id, tag_id)
+	DO UPDATE SET deleted_at = NULL`
 
-	_, err := s.db.ExecContext(ctx, query, names, descriptions, a.ID)
+	_, err := db.ExecContext(ctx, query, names, descriptions, a.ID)
 	if err != nil {
 		return err
 	}
@@ -432,7 +725,8 @@ func (s *store) SaveProviderActivityRawData(ctx context.Context, arg *activity.P
 		iana_timezone = $6,
 		utc_offset = $7,
 		data = $8,
-		detailed_activity_uri = $9
+		detailed_activity_uri = $9,
+		deleted_at = NULL
 	RETURNING id
 	`
 
@@ -455,6 +749,52 @@ func (s *store) SaveProviderActivityRawData(ctx context.Context, arg *activity.P
 	return id, nil
 }
 
+// DeleteProviderActivity soft-deletes a provider activity: the raw data, the endurance activities derived from it,
+// their tag links and their threshold analyses. It returns sql.ErrNoRows if the activity doesn't exist or is already
+// deleted. The objects are kept, since the rows still reference them.
+func (s *store) DeleteProviderActivity(ctx context.Context, providerID int, athleteID uuid.UUID, providerActivityID string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	q := s.q.WithTx(tx.Tx)
+
+	rawID, err := q.SoftDeleteProviderActivityRaw(ctx, models.SoftDeleteProviderActivityRawParams{
+		ProviderID:         int32(providerID),
+		AthleteID:          athleteID,
+		ProviderActivityID: providerActivityID,
+	})
+	if err != nil {
+		return err
+	}
+
+	activityIDs, err := q.SoftDeleteActivitiesEnduranceByRawActivity(ctx, rawID)
+	if err != nil {
+		return err
+	}
+
+	if len(activityIDs) > 0 {
+		err = q.SoftDeleteActivitiesEnduranceTags(ctx, activityIDs)
+		if err != nil {
+			return err
+		}
+
+		err = q.SoftDeleteActivitiesThresholdAnalysis(ctx, activityIDs)
+		if err != nil {
+			return err
+		}
+
+		err = s.unlinkActivityDuplicates(ctx, tx, activityIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 // GetAthleteVolume retrieves volume data for an athlete by provider, frequency, sports, and time range.
 func (s *store) GetAthleteVolume(ctx context.Context, params vo2.GetAthleteVolumeParams) (map[stride.Sport][]*vo2.AthleteVolumeData, error) {
 	sports := make([]string, len(params.Sports))
@@ -522,3 +862,138 @@ func (s *store) GetAthleteYTDVolume(ctx context.Context, params vo2.GetAthleteYT
 
 	return entry, nil
 }
+
+// RecompressRawActivityDetails rewrites a plain raw activity details object gzip compressed, under the same key.
+// Since the key doesn't change, the URI stored in the database stays valid. With dryRun the object is only compressed
+// in memory, to report the size it would take.
+func (s *store) RecompressRawActivityDetails(ctx context.Context, key string, dryRun bool) (*RecompressResult, error) {
+	data, err := s.obj.DownloadObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &RecompressResult{
+		SizeBefore: int64(len(data)),
+		SizeAfter:  int64(len(data)),
+	}
+
+	if isGzipped(data) {
+		return res, nil
+	}
+
+	compressed, err := gzipObject(data)
+	if err != nil {
+		return nil, err
+	}
+
+	res.Recompressed = true
+	res.SizeAfter = int64(len(compressed))
+
+	if dryRun {
+		return res, nil
+	}
+
+	info, err := s.obj.HeadObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// The raw details of uploaded activities are the original GPX, FIT or TCX files, not JSON.
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = rawActivityDetailsContentType(key)
+	}
+
+	_, err = s.obj.UploadObject(ctx, key, compressed, &UploadOptions{
+		ContentType:     contentType,
+		ContentEncoding: contentEncodingGzip,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// CheckObjectReferences compares the objects under prefix with the object URIs stored in the database.
+//
+// Objects with a pending upload in the object outbox are not reported as orphans, the reconciler takes care of them.
+// The pending uploads are read before the URIs, so an upload committed in between is seen in the URIs, and the objects
+// modified within objectOrphanGracePeriod of the start of the check are skipped, as their row may be committed after
+// the URIs were read.
+func (s *store) CheckObjectReferences(ctx context.Context, prefix string) (*ObjectReferenceReport, error) {
+	snapshot := time.Now()
+
+	// Objects being uploaded aren't referenced until their row is committed.
+	pending, err := s.pendingObjectKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	uris, err := s.q.ListActivityObjectURIs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := s.obj.ListObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string]string, len(uris))
+	for _, uri := range uris {
+		key, err := s.obj.ObjectKey(uri)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve object URI %s: %w", uri, err)
+		}
+
+		referenced[key] = uri
+	}
+
+	existing := make(map[string]bool, len(keys))
+	report := &ObjectReferenceReport{
+		Orphans:  []string{},
+		Dangling: []string{},
+	}
+
+	for _, key := range keys {
+		existing[key] = true
+
+		if _, ok := referenced[key]; ok || pending[key] {
+			continue
+		}
+
+		info, err := s.obj.HeadObject(ctx, key)
+		if err != nil {
+			if errors.Is(err, ErrObjectNotFound) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		if info.LastModified.After(snapshot.Add(-objectOrphanGracePeriod)) {
+			continue
+		}
+
+		report.Orphans = append(report.Orphans, key)
+	}
+
+	for key, uri := range referenced {
+		if strings.HasPrefix(key, prefix) && !existing[key] {
+			report.Dangling = append(report.Dangling, uri)
+		}
+	}
+
+	sort.Strings(report.Dangling)
+
+	return report, nil
+}
+
+func (s *store) DeleteObject(ctx context.Context, key string) error {
+	return s.obj.DeleteObject(ctx, key)
+}
+
+func (s *store) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	return s.obj.ListObjects(ctx, prefix)
+}
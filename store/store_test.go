@@ -0,0 +1,385 @@
+package store
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/gabrieleangeletti/stride"
+	"github.com/gabrieleangeletti/vo2"
+	"github.com/gabrieleangeletti/vo2/activity"
+	"github.com/gabrieleangeletti/vo2/ingest"
+	"github.com/gabrieleangeletti/vo2/provider"
+)
+
+// testDB connects to the database of VO2_TEST_DATABASE_URL, which must have the migrations applied. The tests that
+// need a database are skipped when it's not set.
+func testDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	dsn := os.Getenv("VO2_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("VO2_TEST_DATABASE_URL not set")
+	}
+
+	db, err := sqlx.Connect("pgx", dsn)
+	if err != nil {
+		t.Fatalf("failed to connect to the test database: %v", err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// newTestStore returns a store backed by the test database and an in-memory object store.
+func newTestStore(t *testing.T) (*store, *MemoryObjectStore) {
+	t.Helper()
+
+	obj := NewMemoryObjectStore()
+
+	s, err := newStore(testDB(t), WithObjectStore(obj))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	return s, obj
+}
+
+// newTestAthlete creates an athlete, with its user, deleted with all its data when the test ends.
+func newTestAthlete(t *testing.T, s *store, prov *provider.Provider) *vo2.Athlete {
+	t.Helper()
+
+	ctx := context.Background()
+
+	var userID uuid.UUID
+
+	err := s.db.GetContext(ctx, &userID, `
+	INSERT INTO vo2.users (provider_id, user_external_id) VALUES ($1, $2) RETURNING id
+	`, prov.ID, uuid.NewString())
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	athlete, err := s.UpsertAthlete(ctx, &vo2.Athlete{UserID: userID})
+	if err != nil {
+		t.Fatalf("failed to create athlete: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if _, err := s.DeleteAthlete(ctx, athlete.ID); err != nil {
+			t.Errorf("failed to delete athlete: %v", err)
+		}
+
+		if _, err := s.db.ExecContext(ctx, `DELETE FROM vo2.users WHERE id = $1`, userID); err != nil {
+			t.Errorf("failed to delete user: %v", err)
+		}
+	})
+
+	return athlete
+}
+
+// testActivity returns a 5 minutes run, with a point every 10 seconds.
+func testActivity() (*stride.Activity, *stride.ActivityTimeseries) {
+	startTime := time.Date(2025, 6, 1, 7, 0, 0, 0, time.UTC)
+
+	ts := &stride.ActivityTimeseries{StartTime: startTime}
+	for i := range 31 {
+		ts.Data = append(ts.Data, stride.ActivityTimeseriesEntry{
+			Offset:    i * 10,
+			HeartRate: stride.Optional[uint8]{Value: uint8(130 + i), Valid: true},
+			Altitude:  stride.Optional[uint16]{Value: 100, Valid: true},
+			Latitude:  stride.Optional[float64]{Value: 45.0 + float64(i)*0.0003, Valid: true},
+			Longitude: stride.Optional[float64]{Value: 9.0, Valid: true},
+		})
+	}
+
+	act := &stride.Activity{
+		Provider:    ingest.ProviderUpload,
+		Sport:       stride.SportRunning,
+		StartTime:   startTime,
+		ElapsedTime: 300,
+		MovingTime:  300,
+		Distance:    1000,
+	}
+
+	return act, ts
+}
+
+func assertSameTimeseries(t *testing.T, want, got *stride.ActivityTimeseries) {
+	t.Helper()
+
+	if len(got.Data) != len(want.Data) {
+		t.Fatalf("got %d points, want %d", len(got.Data), len(want.Data))
+	}
+
+	for i := range want.Data {
+		if got.Data[i].Offset != want.Data[i].Offset {
+			t.Errorf("point %d: got offset %d, want %d", i, got.Data[i].Offset, want.Data[i].Offset)
+		}
+
+		if got.Data[i].HeartRate != want.Data[i].HeartRate {
+			t.Errorf("point %d: got heart rate %v, want %v", i, got.Data[i].HeartRate, want.Data[i].HeartRate)
+		}
+	}
+}
+
+func TestStoreActivityEnduranceRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	s, obj := newTestStore(t)
+
+	prov, err := provider.GetBySlug(s.db, string(ingest.ProviderUpload))
+	if err != nil {
+		t.Fatalf("failed to get upload provider: %v", err)
+	}
+
+	athlete := newTestAthlete(t, s, prov)
+
+	strideActivity, ts := testActivity()
+
+	gpxData, err := stride.CreateGPXFileInMemory(strideActivity, ts)
+	if err != nil {
+		t.Fatalf("failed to create GPX file: %v", err)
+	}
+
+	fileActivity, err := ingest.ParseActivityFile("run.gpx", gpxData)
+	if err != nil {
+		t.Fatalf("failed to parse GPX file: %v", err)
+	}
+
+	rawActivity, err := fileActivity.ToRawActivity()
+	if err != nil {
+		t.Fatalf("failed to encode activity file: %v", err)
+	}
+
+	activityRaw := rawActivity.ToProviderActivityRawData(prov.ID, athlete.ID)
+
+	activityRaw.ID, err = s.SaveProviderActivityRawData(ctx, activityRaw)
+	if err != nil {
+		t.Fatalf("failed to save raw activity: %v", err)
+	}
+
+	err = s.UploadRawActivityFile(ctx, ingest.ProviderUpload, activityRaw, fileActivity.Format, fileActivity.Content)
+	if err != nil {
+		t.Fatalf("failed to upload raw activity file: %v", err)
+	}
+
+	act, err := s.StoreActivityEndurance(ctx, ingest.ProviderUpload, activityRaw, fileActivity, fileActivity.Streams())
+	if err != nil {
+		t.Fatalf("failed to store activity: %v", err)
+	}
+
+	if act == nil {
+		t.Fatal("activity not stored as an endurance activity")
+	}
+
+	for name, uri := range map[string]string{"gpx": act.GpxFileURI, "fit": act.FitFileURI, "timeseries": act.TimeseriesURI} {
+		if !strings.HasPrefix(uri, "mem://") {
+			t.Errorf("%s file URI %q not in the memory object store", name, uri)
+		}
+	}
+
+	stored, err := s.GetActivityEndurance(ctx, act.ID)
+	if err != nil {
+		t.Fatalf("failed to get activity: %v", err)
+	}
+
+	if stored.TimeseriesURI != act.TimeseriesURI {
+		t.Errorf("got timeseries URI %q, want %q", stored.TimeseriesURI, act.TimeseriesURI)
+	}
+
+	got, err := s.GetActivityTimeseries(ctx, stored)
+	if err != nil {
+		t.Fatalf("failed to get timeseries: %v", err)
+	}
+
+	assertSameTimeseries(t, ts, got)
+
+	keys, err := obj.ListObjects(ctx, "activity_details/")
+	if err != nil {
+		t.Fatalf("failed to list objects: %v", err)
+	}
+
+	// The raw file, the GPX, FIT and timeseries files.
+	if len(keys) != 4 {
+		t.Errorf("got %d objects, want 4: %v", len(keys), keys)
+	}
+}
+
+func TestUploadActivityGPXRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	s, obj := newTestStore(t)
+
+	strideActivity, ts := testActivity()
+
+	act := &activity.EnduranceActivity{
+		ID:    uuid.New(),
+		Sport: strideActivity.Sport,
+	}
+
+	upload, err := s.uploadActivityGPX(ctx, act, strideActivity, ts)
+	if err != nil {
+		t.Fatalf("failed to upload GPX file: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := s.commitObjectUploads(ctx, s.q, upload); err != nil {
+			t.Errorf("failed to commit object upload: %v", err)
+		}
+	})
+
+	uri := upload.Location
+
+	data, err := obj.DownloadObject(ctx, uri)
+	if err != nil {
+		t.Fatalf("failed to download GPX file: %v", err)
+	}
+
+	parsed, parsedTs, err := stride.ParseGPXFileFromMemory(data)
+	if err != nil {
+		t.Fatalf("failed to parse GPX file: %v", err)
+	}
+
+	if parsed.Sport != strideActivity.Sport {
+		t.Errorf("got sport %s, want %s", parsed.Sport, strideActivity.Sport)
+	}
+
+	assertSameTimeseries(t, ts, parsedTs)
+
+	// Activities without a compact timeseries file are read from the GPX file.
+	act.GpxFileURI = uri
+
+	got, err := s.GetActivityTimeseries(ctx, act)
+	if err != nil {
+		t.Fatalf("failed to get timeseries: %v", err)
+	}
+
+	assertSameTimeseries(t, ts, got)
+}
+
+func TestRecompressRawActivityDetailsKeepsContentType(t *testing.T) {
+	ctx := context.Background()
+
+	obj := NewMemoryObjectStore()
+
+	s, err := newStore(nil, WithObjectStore(obj))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	key := "activity_details/upload/raw/1.gpx"
+
+	_, err = obj.UploadObject(ctx, key, []byte("<gpx></gpx>"), &UploadOptions{ContentType: "application/gpx+xml"})
+	if err != nil {
+		t.Fatalf("failed to upload object: %v", err)
+	}
+
+	res, err := s.RecompressRawActivityDetails(ctx, key, false)
+	if err != nil {
+		t.Fatalf("failed to recompress object: %v", err)
+	}
+
+	if !res.Recompressed {
+		t.Fatal("object not recompressed")
+	}
+
+	info, err := obj.HeadObject(ctx, key)
+	if err != nil {
+		t.Fatalf("failed to head object: %v", err)
+	}
+
+	if info.ContentType != "application/gpx+xml" {
+		t.Errorf("got content type %q, want %q", info.ContentType, "application/gpx+xml")
+	}
+}
+
+func TestDeleteAthleteDeletesUnreferencedObjects(t *testing.T) {
+	ctx := context.Background()
+
+	s, obj := newTestStore(t)
+
+	prov, err := provider.GetBySlug(s.db, string(ingest.ProviderUpload))
+	if err != nil {
+		t.Fatalf("failed to get upload provider: %v", err)
+	}
+
+	athlete := newTestAthlete(t, s, prov)
+
+	strideActivity, ts := testActivity()
+
+	gpxData, err := stride.CreateGPXFileInMemory(strideActivity, ts)
+	if err != nil {
+		t.Fatalf("failed to create GPX file: %v", err)
+	}
+
+	fileActivity, err := ingest.ParseActivityFile("run.gpx", gpxData)
+	if err != nil {
+		t.Fatalf("failed to parse GPX file: %v", err)
+	}
+
+	rawActivity, err := fileActivity.ToRawActivity()
+	if err != nil {
+		t.Fatalf("failed to encode activity file: %v", err)
+	}
+
+	activityRaw := rawActivity.ToProviderActivityRawData(prov.ID, athlete.ID)
+
+	activityRaw.ID, err = s.SaveProviderActivityRawData(ctx, activityRaw)
+	if err != nil {
+		t.Fatalf("failed to save raw activity: %v", err)
+	}
+
+	// An object of the raw activity that its row doesn't reference.
+	_, err = obj.UploadObject(ctx, "activity_details/upload/raw/"+activityRaw.ID.String()+".gpx", gpxData, nil)
+	if err != nil {
+		t.Fatalf("failed to upload object: %v", err)
+	}
+
+	res, err := s.DeleteAthlete(ctx, athlete.ID)
+	if err != nil {
+		t.Fatalf("failed to delete athlete: %v", err)
+	}
+
+	if res.Objects != 1 {
+		t.Errorf("got %d objects deleted, want 1", res.Objects)
+	}
+
+	keys, err := obj.ListObjects(ctx, "activity_details/")
+	if err != nil {
+		t.Fatalf("failed to list objects: %v", err)
+	}
+
+	if len(keys) != 0 {
+		t.Errorf("objects left after the erasure: %v", keys)
+	}
+}
+
+func TestCheckObjectReferencesSkipsRecentObjects(t *testing.T) {
+	ctx := context.Background()
+
+	s, obj := newTestStore(t)
+
+	prefix := "activity_details/test/" + uuid.NewString() + "/"
+
+	_, err := obj.UploadObject(ctx, prefix+"recent.gpx", []byte("<gpx></gpx>"), nil)
+	if err != nil {
+		t.Fatalf("failed to upload object: %v", err)
+	}
+
+	report, err := s.CheckObjectReferences(ctx, prefix)
+	if err != nil {
+		t.Fatalf("failed to check object references: %v", err)
+	}
+
+	// The object may belong to an ingestion whose row isn't committed yet.
+	if len(report.Orphans) != 0 {
+		t.Errorf("got orphans %v, want none", report.Orphans)
+	}
+}
@@ -9,14 +9,14 @@ func newAthlete(m models.Vo2Athlete) *vo2.Athlete {
 	return &vo2.Athlete{
 		ID:          m.ID,
 		UserID:      m.UserID,
-		Age:         m.Age,
-		HeightCm:    m.HeightCm,
-		Country:     m.Country,
-		Gender:      vo2.Gender(m.Gender),
-		FirstName:   m.FirstName,
-		LastName:    m.LastName,
-		DisplayName: m.DisplayName,
-		Email:       m.Email,
+		BirthDate:   m.BirthDate.Time,
+		HeightCm:    m.HeightCm.Int16,
+		Country:     m.Country.String,
+		Gender:      vo2.Gender(m.Gender.Gender),
+		FirstName:   m.FirstName.String,
+		LastName:    m.LastName.String,
+		DisplayName: m.DisplayName.String,
+		Email:       m.Email.String,
 	}
 }
 
@@ -6,6 +6,7 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/gabrieleangeletti/stride"
+	"github.com/gabrieleangeletti/vo2/database"
 	"github.com/gabrieleangeletti/vo2/internal/generated/models"
 )
 
@@ -15,6 +16,17 @@ const (
 	AthleteMeasurementSourceLabTest   AthleteMeasurementSource = "lab_test"
 	AthleteMeasurementSourceFieldTest AthleteMeasurementSource = "field_test"
 	AthleteMeasurementSourceManual    AthleteMeasurementSource = "manual"
+	AthleteMeasurementSourceDevice    AthleteMeasurementSource = "device"
+)
+
+type AthleteMeasurementType string
+
+const (
+	AthleteMeasurementTypeLT1       AthleteMeasurementType = "lt1"
+	AthleteMeasurementTypeLT2       AthleteMeasurementType = "lt2"
+	AthleteMeasurementTypeVO2Max    AthleteMeasurementType = "vo2max"
+	AthleteMeasurementTypeWeight    AthleteMeasurementType = "weight"
+	AthleteMeasurementTypeRestingHR AthleteMeasurementType = "resting_hr"
 )
 
 type Gender string
@@ -25,30 +37,101 @@ const (
 	GenderOther  Gender = "other"
 )
 
+// Athlete is created from the profile of the provider the user connected. The fields the provider doesn't have stay
+// empty until the athlete completes the profile, see MissingProfileFields.
 type Athlete struct {
 	ID          uuid.UUID `json:"id"`
 	UserID      uuid.UUID `json:"userID"`
-	Age         int16     `json:"age"`
-	HeightCm    int16     `json:"heightCm"`
-	Country     string    `json:"country"`
-	Gender      Gender    `json:"gender"`
-	FirstName   string    `json:"firstName"`
-	LastName    string    `json:"lastName"`
-	DisplayName string    `json:"displayName"`
-	Email       string    `json:"email"`
+	BirthDate   time.Time `json:"birthDate,omitzero"`
+	HeightCm    int16     `json:"heightCm,omitzero"`
+	Country     string    `json:"country,omitzero"`
+	Gender      Gender    `json:"gender,omitzero"`
+	FirstName   string    `json:"firstName,omitzero"`
+	LastName    string    `json:"lastName,omitzero"`
+	DisplayName string    `json:"displayName,omitzero"`
+	Email       string    `json:"email,omitzero"`
 }
 
 func (a *Athlete) ToUpsertParams() models.UpsertAthleteParams {
 	return models.UpsertAthleteParams{
+		ID:          uuid.NullUUID{UUID: a.ID, Valid: a.ID != uuid.Nil},
 		UserID:      a.UserID,
-		Age:         a.Age,
-		HeightCm:    a.HeightCm,
-		Country:     a.Country,
-		Gender:      models.Gender(a.Gender),
-		FirstName:   a.FirstName,
-		LastName:    a.LastName,
-		DisplayName: a.DisplayName,
-		Email:       a.Email,
+		BirthDate:   database.ToNullTime(a.BirthDate),
+		HeightCm:    database.ToNullInt16(a.HeightCm),
+		Country:     database.ToNullString(a.Country),
+		Gender:      models.NullGender{Gender: models.Gender(a.Gender), Valid: a.Gender != ""},
+		FirstName:   database.ToNullString(a.FirstName),
+		LastName:    database.ToNullString(a.LastName),
+		DisplayName: database.ToNullString(a.DisplayName),
+		Email:       database.ToNullString(a.Email),
+	}
+}
+
+// MissingProfileFields returns the JSON names of the profile fields the athlete must still complete.
+func (a *Athlete) MissingProfileFields() []string {
+	missing := []string{}
+
+	if a.BirthDate.IsZero() {
+		missing = append(missing, "birthDate")
+	}
+
+	if a.HeightCm == 0 {
+		missing = append(missing, "heightCm")
+	}
+
+	if a.Gender == "" {
+		missing = append(missing, "gender")
+	}
+
+	return missing
+}
+
+// AthleteProfile updates the profile of an athlete. The empty fields are left as they are.
+type AthleteProfile struct {
+	BirthDate   time.Time
+	HeightCm    int16
+	Country     string
+	Gender      Gender
+	FirstName   string
+	LastName    string
+	DisplayName string
+	Email       string
+}
+
+func (p *AthleteProfile) ToUpdateParams(athleteID uuid.UUID) models.UpdateAthleteProfileParams {
+	return models.UpdateAthleteProfileParams{
+		BirthDate:   database.ToNullTime(p.BirthDate),
+		HeightCm:    database.ToNullInt16(p.HeightCm),
+		Country:     database.ToNullString(p.Country),
+		Gender:      models.NullGender{Gender: models.Gender(p.Gender), Valid: p.Gender != ""},
+		FirstName:   database.ToNullString(p.FirstName),
+		LastName:    database.ToNullString(p.LastName),
+		DisplayName: database.ToNullString(p.DisplayName),
+		Email:       database.ToNullString(p.Email),
+		ID:          athleteID,
+	}
+}
+
+// AthleteMeasurement is an entry of the athlete measurement history.
+type AthleteMeasurement struct {
+	AthleteID    uuid.UUID                `json:"athleteId"`
+	MeasuredAt   time.Time                `json:"measuredAt"`
+	IanaTimezone string                   `json:"ianaTimezone"`
+	MetricType   AthleteMeasurementType   `json:"metricType"`
+	Value        float64                  `json:"value"`
+	Source       AthleteMeasurementSource `json:"source"`
+	Notes        string                   `json:"notes,omitzero"`
+}
+
+func (m *AthleteMeasurement) ToUpsertParams() models.UpsertAthleteMeasurementParams {
+	return models.UpsertAthleteMeasurementParams{
+		AthleteID:    m.AthleteID,
+		MeasuredAt:   m.MeasuredAt,
+		IanaTimezone: m.IanaTimezone,
+		MetricType:   models.Vo2AthleteMeasurementType(m.MetricType),
+		Value:        m.Value,
+		Source:       models.Vo2AthleteMeasurementSource(m.Source),
+		Notes:        database.ToNullString(m.Notes),
 	}
 }
 
@@ -94,16 +177,18 @@ type ActivityTotals struct {
 }
 
 type GetAthleteVolumeParams struct {
-	Frequency    string         `json:"frequency"`
-	AthleteID    uuid.UUID      `json:"athleteId"`
+	Frequency string    `json:"frequency"`
+	AthleteID uuid.UUID `json:"athleteId"`
+	// ProviderSlug is the provider of the activities, or "all" for all the providers without duplicates.
 	ProviderSlug string         `json:"providerSlug"`
 	Sports       []stride.Sport `json:"sports"`
 	StartDate    time.Time      `json:"startDate"`
 }
 
 type GetAthleteYTDVolumeParams struct {
-	AthleteID    uuid.UUID `json:"athleteId"`
-	ProviderSlug string    `json:"providerSlug"`
+	AthleteID uuid.UUID `json:"athleteId"`
+	// ProviderSlug is the provider of the activities, or "all" for all the providers without duplicates.
+	ProviderSlug string `json:"providerSlug"`
 }
 
 type AthleteTotalRunningVolume struct {